@@ -59,16 +59,27 @@ func New(id clio.Identification) clio.Application {
 	p12.AddCommand(commands.P12AttachChain(app))
 	p12.AddCommand(commands.P12Describe(app))
 
+	lipo := commands.Lipo(app)
+	lipo.AddCommand(commands.LipoInfo(app))
+	lipo.AddCommand(commands.LipoThin(app))
+	lipo.AddCommand(commands.LipoCreate(app))
+	lipo.AddCommand(commands.LipoRemove(app))
+
 	root.AddCommand(clio.VersionCommand(id))
 	root.AddCommand(commands.Sign(app))
+	root.AddCommand(commands.Unsign(app))
+	root.AddCommand(commands.Edit(app))
 	root.AddCommand(commands.Notarize(app))
 	root.AddCommand(commands.SignAndNotarize(app))
 	root.AddCommand(commands.Test(app))
 	root.AddCommand(commands.Describe(app))
+	root.AddCommand(commands.Verify(app))
+	root.AddCommand(commands.Lint(app))
 	root.AddCommand(commands.EmbeddedCerts(app))
 	root.AddCommand(submission)
 	root.AddCommand(extract)
 	root.AddCommand(p12)
+	root.AddCommand(lipo)
 
 	return app
 }
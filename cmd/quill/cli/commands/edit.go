@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+	"github.com/anchore/quill/quill/macho"
+)
+
+type editConfig struct {
+	Path            string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Edit    `yaml:"edit" json:"edit" mapstructure:"edit"`
+	options.Signing `yaml:"sign" json:"sign" mapstructure:"sign"`
+}
+
+func Edit(app clio.Application) *cobra.Command {
+	opts := &editConfig{
+		Signing: options.DefaultSigning(),
+	}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "edit PATH",
+		Short: "edit the dylib and rpath load commands of a macho (darwin) binary (as install_name_tool does) and re-sign it",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the darwin binary (thin or universal) to edit and sign",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			edits, err := loadCommandEdits(opts.Edit)
+			if err != nil {
+				return err
+			}
+			if edits.IsEmpty() {
+				return fmt.Errorf("no edits given (see --id, --change, --change-rpath, --add-rpath and --delete-rpath)")
+			}
+
+			cfg, closer, err := newSigningConfig(opts.Path, opts.Signing)
+			defer closer()
+			if err != nil {
+				return err
+			}
+
+			return quill.Sign(*cfg.WithLoadCommandEdits(*edits))
+		},
+	}, opts)
+}
+
+func loadCommandEdits(opts options.Edit) (*macho.LoadCommandEdits, error) {
+	edits := macho.LoadCommandEdits{
+		ID:           opts.ID,
+		AddRpaths:    opts.AddRpaths,
+		DeleteRpaths: opts.DeleteRpaths,
+	}
+
+	var err error
+	if edits.ChangeDylibs, err = parsePathChanges("--change", opts.ChangeDylibs); err != nil {
+		return nil, err
+	}
+	if edits.ChangeRpaths, err = parsePathChanges("--change-rpath", opts.ChangeRpaths); err != nil {
+		return nil, err
+	}
+	return &edits, nil
+}
+
+// parsePathChanges parses values in the form OLD=NEW.
+func parsePathChanges(flag string, values []string) ([]macho.PathChange, error) {
+	var changes []macho.PathChange
+	for _, value := range values {
+		oldPath, newPath, ok := strings.Cut(value, "=")
+		if !ok || oldPath == "" || newPath == "" {
+			return nil, fmt.Errorf("invalid %s value %q (expected OLD=NEW)", flag, value)
+		}
+		changes = append(changes, macho.PathChange{Old: oldPath, New: newPath})
+	}
+	return changes, nil
+}
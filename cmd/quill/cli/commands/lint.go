@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type lintConfig struct {
+	Path           string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Format `yaml:",inline" json:",inline" mapstructure:",squash"`
+}
+
+func Lint(app clio.Application) *cobra.Command {
+	opts := &lintConfig{
+		Format: options.Format{
+			Output:           formatText,
+			AllowableFormats: []string{formatText, formatJSON},
+		},
+	}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "lint PATH",
+		Short: "check the structure of a macho (darwin) binary for problems before signing",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the darwin binary (thin or universal) to check",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			report, err := quill.Lint(opts.Path)
+			if err != nil {
+				return err
+			}
+
+			formatted, err := formatLintReport(*report, opts.Output)
+			if err != nil {
+				return err
+			}
+
+			bus.Report(formatted)
+
+			if count := report.Errors(); count > 0 {
+				return fmt.Errorf("binary cannot be signed due to %d problem(s)", count)
+			}
+			return nil
+		},
+	}, opts)
+}
+
+func formatLintReport(report quill.LintReport, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case formatText:
+		return report.String(), nil
+	case formatJSON:
+		by, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("unable to encode lint report: %w", err)
+		}
+		return string(by), nil
+	default:
+		return "", fmt.Errorf("unknown format: %s", format)
+	}
+}
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/quill"
+)
+
+func Lipo(app clio.Application) *cobra.Command {
+	return app.SetupCommand(&cobra.Command{
+		Use:   "lipo",
+		Short: "inspect, thin, merge and remove architectures of universal (fat) binaries",
+		Args:  cobra.NoArgs,
+	})
+}
+
+// lipoConfig converts the lipo options to the config for writing a binary.
+func lipoConfig(opts options.Lipo) (*quill.LipoConfig, error) {
+	cfg := quill.NewLipoConfig(opts.Output).WithFat64(opts.Fat64)
+	for _, value := range opts.Alignments {
+		arch, align, err := quill.ParseLipoAlignment(value)
+		if err != nil {
+			return nil, err
+		}
+		cfg.WithAlignment(arch, align)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type lipoCreateConfig struct {
+	Paths        []string `yaml:"paths" json:"paths" mapstructure:"-"`
+	options.Lipo `yaml:"lipo" json:"lipo" mapstructure:"lipo"`
+}
+
+func LipoCreate(app clio.Application) *cobra.Command {
+	opts := &lipoCreateConfig{}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "create PATH... --output OUTPUT",
+		Short: "merge binaries (thin or universal) into a single universal binary (keeping the signature of each architecture)",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the darwin binaries to merge, where signed binaries must share the same identity and team ID",
+			},
+		),
+		Args: chainArgs(
+			cobra.MinimumNArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Paths = args
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			cfg, err := lipoConfig(opts.Lipo)
+			if err != nil {
+				return err
+			}
+
+			return quill.LipoCreate(opts.Paths, *cfg)
+		},
+	}, opts)
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type lipoInfoConfig struct {
+	Path           string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Format `yaml:",inline" json:",inline" mapstructure:",squash"`
+}
+
+func LipoInfo(app clio.Application) *cobra.Command {
+	opts := &lipoInfoConfig{
+		Format: options.Format{
+			Output:           formatText,
+			AllowableFormats: []string{formatText, formatJSON},
+		},
+	}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "info PATH",
+		Short: "show the architectures of a macho (darwin) binary, along with the signature of each",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the darwin binary (thin or universal) to show the architectures of",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			description, err := quill.LipoInfo(opts.Path)
+			if err != nil {
+				return err
+			}
+
+			report, err := formatLipoDescription(*description, opts.Output)
+			if err != nil {
+				return err
+			}
+
+			bus.Report(report)
+
+			return nil
+		},
+	}, opts)
+}
+
+func formatLipoDescription(description quill.LipoDescription, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case formatText:
+		return description.String(), nil
+	case formatJSON:
+		by, err := json.MarshalIndent(description, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("unable to encode architectures: %w", err)
+		}
+		return string(by), nil
+	default:
+		return "", fmt.Errorf("unknown format: %s", format)
+	}
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type lipoRemoveConfig struct {
+	Path               string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Lipo       `yaml:"lipo" json:"lipo" mapstructure:"lipo"`
+	options.LipoArches `yaml:"lipo-arches" json:"lipo-arches" mapstructure:"lipo-arches"`
+}
+
+func LipoRemove(app clio.Application) *cobra.Command {
+	opts := &lipoRemoveConfig{}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "remove PATH --arch ARCH... --output OUTPUT",
+		Short: "remove architectures from a universal binary (the signatures of the remaining architectures are kept)",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the universal darwin binary to remove the architectures from",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			if len(opts.Arches) == 0 {
+				return fmt.Errorf("at least one architecture must be given (with --arch)")
+			}
+
+			cfg, err := lipoConfig(opts.Lipo)
+			if err != nil {
+				return err
+			}
+
+			return quill.LipoRemove(opts.Path, opts.Arches, *cfg)
+		},
+	}, opts)
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type lipoThinConfig struct {
+	Path               string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Lipo       `yaml:"lipo" json:"lipo" mapstructure:"lipo"`
+	options.LipoArches `yaml:"lipo-arches" json:"lipo-arches" mapstructure:"lipo-arches"`
+}
+
+func LipoThin(app clio.Application) *cobra.Command {
+	opts := &lipoThinConfig{}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "thin PATH --arch ARCH --output OUTPUT",
+		Short: "extract a single architecture from a universal binary as a thin binary (keeping its signature)",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the universal darwin binary to extract the architecture from",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			if len(opts.Arches) != 1 {
+				return fmt.Errorf("exactly one architecture must be given (with --arch)")
+			}
+
+			cfg, err := lipoConfig(opts.Lipo)
+			if err != nil {
+				return err
+			}
+
+			return quill.LipoThin(opts.Path, opts.Arches[0], *cfg)
+		},
+	}, opts)
+}
@@ -34,10 +34,10 @@ func Notarize(app clio.Application) *cobra.Command {
 
 	return app.SetupCommand(&cobra.Command{
 		Use:   "notarize PATH",
-		Short: "notarize a signed a macho binary with Apple's Notary service",
+		Short: "notarize a signed a macho binary (or an archive of signed binaries) with Apple's Notary service",
 		Example: options.FormatPositionalArgsHelp(
 			map[string]string{
-				pathArg: "the signed darwin binary to notarize",
+				pathArg: "the signed darwin binary (or tar.gz/zip archive of signed binaries) to notarize",
 			},
 		),
 		Args: chainArgs(
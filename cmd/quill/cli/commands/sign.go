@@ -2,7 +2,12 @@ package commands
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jedib0t/go-pretty/table"
 	"github.com/spf13/cobra"
 
 	"github.com/anchore/clio"
@@ -10,6 +15,8 @@ import (
 	"github.com/anchore/quill/internal/bus"
 	"github.com/anchore/quill/internal/log"
 	"github.com/anchore/quill/quill"
+	"github.com/anchore/quill/quill/archive"
+	"github.com/anchore/quill/quill/macho"
 )
 
 type signConfig struct {
@@ -24,10 +31,10 @@ func Sign(app clio.Application) *cobra.Command {
 
 	return app.SetupCommand(&cobra.Command{
 		Use:   "sign PATH",
-		Short: "sign a macho (darwin) executable binary",
+		Short: "sign a macho (darwin) executable binary, bundle (e.g. .app), directory of binaries, or the binaries within an archive",
 		Example: options.FormatPositionalArgsHelp(
 			map[string]string{
-				pathArg: "the darwin binary to sign",
+				pathArg: "the darwin binary, bundle directory, directory of binaries, or tar/tar.gz/zip archive to sign",
 			},
 		),
 		Args: chainArgs(
@@ -46,33 +53,223 @@ func Sign(app clio.Application) *cobra.Command {
 }
 
 func sign(binPath string, opts options.Signing) error {
+	cfg, closer, err := newSigningConfig(binPath, opts)
+	defer closer()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case quill.IsPlainDirectory(binPath):
+		return signMany(*cfg, opts, "a directory", quill.SignDirectory)
+	case archive.IsArchive(binPath):
+		return signMany(*cfg, opts, "an archive", quill.SignArchive)
+	}
+
+	return quill.Sign(*cfg)
+}
+
+// newSigningConfig creates the signing config for the given binary from the signing options. The returned function
+// releases the external signer (if any) and must always be called.
+func newSigningConfig(binPath string, opts options.Signing) (*quill.SigningConfig, func(), error) {
+	closer := func() {}
+
 	cfg := quill.SigningConfig{
 		Path: binPath,
 	}
 
+	externalSigner := opts.SignerCommand != "" || opts.PKCS11Module != ""
+	if externalSigner && opts.P12 != "" && !opts.AdHoc {
+		return nil, closer, fmt.Errorf("a p12 file cannot be used together with an external signer (--signer-command or --pkcs11-module)")
+	}
+
+	if externalSigner {
+		if opts.AdHoc {
+			log.Warn("ad-hoc signing is enabled, but an external signer was also provided. The external signer will be ignored.")
+		} else {
+			replacement, signerCloser, err := newExternalSignerConfig(binPath, opts)
+			if err != nil {
+				return nil, closer, err
+			}
+			closer = signerCloser
+			cfg = *replacement
+		}
+	}
+
 	if opts.P12 != "" {
 		if opts.AdHoc {
 			log.Warn("ad-hoc signing is enabled, but a p12 file was also provided. The p12 file will be ignored.")
 		} else {
 			p12Content, err := loadP12Interactively(opts.P12, opts.Password)
 			if err != nil {
-				return fmt.Errorf("unable to decode p12 file: %w", err)
+				return nil, closer, fmt.Errorf("unable to decode p12 file: %w", err)
 			}
 			if p12Content == nil {
-				return fmt.Errorf("no content found in the p12 file")
+				return nil, closer, fmt.Errorf("no content found in the p12 file")
 			}
 
 			replacement, err := quill.NewSigningConfigFromP12(binPath, *p12Content, opts.FailWithoutFullChain)
 			if err != nil {
-				return fmt.Errorf("unable to read p12: %w", err)
+				return nil, closer, fmt.Errorf("unable to read p12: %w", err)
 			}
 			cfg = *replacement
 		}
 	}
 
 	cfg.WithIdentity(opts.Identity)
-	cfg.WithTimestampServer(opts.TimestampServer)
+	cfg.WithTimestampServer(strings.Split(opts.TimestampServer, ",")...)
 	cfg.WithEntitlements(opts.Entitlements)
+	cfg.WithOutputPath(opts.Output)
+	cfg.WithDetachedPath(opts.Detached)
+
+	requirements, err := readRequirements(opts.Requirements)
+	if err != nil {
+		return nil, closer, err
+	}
+	cfg.WithRequirements(requirements)
+
+	hashTypes, err := parseDigestAlgorithms(opts.DigestAlgorithm)
+	if err != nil {
+		return nil, closer, err
+	}
+	cfg.WithDigestAlgorithms(hashTypes...)
+
+	flags, err := macho.ParseCdFlags(opts.Options)
+	if err != nil {
+		return nil, closer, err
+	}
+	cfg.WithFlags(flags)
+
+	preserved, err := preservedMetadata(opts)
+	if err != nil {
+		return nil, closer, err
+	}
+	cfg.WithPreservedMetadata(preserved)
+	cfg.WithGrowHeaderSpace(opts.GrowHeaderSpace)
+
+	// SOURCE_DATE_EPOCH is commonly set within CI environments, so it is only used as the signing time when asked to
+	// sign reproducibly
+	if opts.Reproducible {
+		signingTime, err := sourceDateEpoch()
+		if err != nil {
+			return nil, closer, err
+		}
+		if signingTime.IsZero() {
+			return nil, closer, fmt.Errorf("reproducible signing requires the SOURCE_DATE_EPOCH environment variable to be set (the signing time to record)")
+		}
+		log.WithFields("time", signingTime.UTC().Format(time.RFC3339)).Debug("using SOURCE_DATE_EPOCH as the signing time")
+		cfg.WithSigningTime(signingTime)
+		cfg.WithReproducible(true)
+	}
+
+	return &cfg, closer, nil
+}
+
+// signMany signs all binaries within a directory or archive (described by kind) with the given sign function,
+// reporting what was signed.
+func signMany(cfg quill.SigningConfig, opts options.Signing, kind string, signFn func(quill.SigningConfig) ([]quill.SignedCode, error)) error {
+	if opts.Identity != "" {
+		return fmt.Errorf("--identity cannot be used when signing %s (set the identity of each binary with rules in the configuration instead)", kind)
+	}
+
+	var rules []quill.PathRule
+	for _, r := range opts.Rules {
+		rules = append(rules, quill.PathRule{
+			Pattern:      r.Path,
+			Identity:     r.Identity,
+			Entitlements: r.Entitlements,
+		})
+	}
+	cfg.WithPathRules(rules...)
+
+	signed, err := signFn(cfg)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+
+	t.AppendHeader(table.Row{"Path", "Identity", "Entitlements"})
+
+	for _, code := range signed {
+		t.AppendRow(table.Row{code.Path, code.Identity, code.Entitlements})
+	}
+
+	bus.Report(t.Render())
+
+	return nil
+}
+
+// sourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH environment variable (see
+// https://reproducible-builds.org/specs/source-date-epoch/), or the zero time when it is not set.
+func sourceDateEpoch() (time.Time, error) {
+	value := strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH (must be a number of seconds since the unix epoch): %q", value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// preservedMetadata returns the metadata to carry over from an existing signature, leaving out anything that is
+// explicitly given on the command line (which always wins).
+func preservedMetadata(opts options.Signing) (quill.PreservedMetadata, error) {
+	preserved, err := quill.ParsePreservedMetadata(opts.PreserveMetadata)
+	if err != nil {
+		return 0, err
+	}
+
+	overrides := []struct {
+		metadata quill.PreservedMetadata
+		value    string
+	}{
+		{quill.PreserveIdentifier, opts.Identity},
+		{quill.PreserveEntitlements, opts.Entitlements},
+		{quill.PreserveRequirements, opts.Requirements},
+		{quill.PreserveFlags, opts.Options},
+	}
+	for _, o := range overrides {
+		if o.value != "" && preserved.Has(o.metadata) {
+			log.WithFields("value", o.value).Debug("not preserving metadata that was given explicitly")
+			preserved &^= o.metadata
+		}
+	}
+	return preserved, nil
+}
+
+func parseDigestAlgorithms(value string) ([]macho.HashType, error) {
+	var hashTypes []macho.HashType
+	for _, name := range strings.Split(value, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		ht, err := macho.ParseHashType(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest algorithm: %w", err)
+		}
+		hashTypes = append(hashTypes, ht)
+	}
+	return hashTypes, nil
+}
+
+// readRequirements returns the requirement text, which is either given directly or read from the given file path.
+func readRequirements(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if fi, err := os.Stat(value); err == nil && fi.Mode().IsRegular() {
+		by, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("unable to read requirements: %w", err)
+		}
+		return string(by), nil
+	}
 
-	return quill.Sign(cfg)
+	return value, nil
 }
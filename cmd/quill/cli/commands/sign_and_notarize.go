@@ -10,6 +10,7 @@ import (
 	"github.com/anchore/quill/cmd/quill/cli/options"
 	"github.com/anchore/quill/internal/bus"
 	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill"
 )
 
 var _ fangs.FlagAdder = &signAndNotarizeConfig{}
@@ -34,10 +35,10 @@ func SignAndNotarize(app clio.Application) *cobra.Command {
 
 	return app.SetupCommand(&cobra.Command{
 		Use:   "sign-and-notarize PATH",
-		Short: "sign and notarize a macho (darwin) executable binary",
+		Short: "sign and notarize a macho (darwin) executable binary, or the binaries within a tar.gz or zip archive",
 		Example: options.FormatPositionalArgsHelp(
 			map[string]string{
-				pathArg: "the darwin binary to sign and notarize",
+				pathArg: "the darwin binary (or tar.gz/zip archive of binaries) to sign and notarize",
 			},
 		),
 		Args: chainArgs(
@@ -50,6 +51,14 @@ func SignAndNotarize(app clio.Application) *cobra.Command {
 		RunE: func(_ *cobra.Command, _ []string) error {
 			defer bus.Exit()
 
+			if opts.Detached != "" {
+				return fmt.Errorf("a detached signature cannot be notarized (sign the binary with an embedded signature instead)")
+			}
+
+			if quill.IsPlainDirectory(opts.Path) {
+				return fmt.Errorf("a directory cannot be notarized (sign the directory, then archive it as a zip to notarize)")
+			}
+
 			err := sign(opts.Path, opts.Signing)
 			if err != nil {
 				return fmt.Errorf("signing failed: %w", err)
@@ -60,7 +69,13 @@ func SignAndNotarize(app clio.Application) *cobra.Command {
 				return nil
 			}
 
-			_, err = notarize(opts.Path, opts.Notary, opts.Status)
+			// notarize what was signed, which is written to the output path when given
+			signedPath := opts.Path
+			if opts.Output != "" {
+				signedPath = opts.Output
+			}
+
+			_, err = notarize(signedPath, opts.Notary, opts.Status)
 			if err != nil {
 				return fmt.Errorf("notarization failed: %w", err)
 			}
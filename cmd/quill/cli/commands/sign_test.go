@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/cmd/quill/cli/options"
+)
+
+func Test_newSigningConfig_sourceDateEpoch(t *testing.T) {
+	tests := []struct {
+		name            string
+		sourceDateEpoch string
+		reproducible    bool
+		wantTime        time.Time
+		wantErr         require.ErrorAssertionFunc
+	}{
+		{
+			name:            "ignored without --reproducible",
+			sourceDateEpoch: "1700000000",
+		},
+		{
+			name:            "invalid value is ignored without --reproducible",
+			sourceDateEpoch: "yesterday",
+		},
+		{
+			name:            "signing time with --reproducible",
+			sourceDateEpoch: "1700000000",
+			reproducible:    true,
+			wantTime:        time.Unix(1700000000, 0).UTC(),
+		},
+		{
+			name:         "required with --reproducible",
+			reproducible: true,
+			wantErr:      require.Error,
+		},
+		{
+			name:            "invalid value with --reproducible",
+			sourceDateEpoch: "yesterday",
+			reproducible:    true,
+			wantErr:         require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			t.Setenv("SOURCE_DATE_EPOCH", tt.sourceDateEpoch)
+
+			opts := options.DefaultSigning()
+			opts.AdHoc = true
+			opts.Reproducible = tt.reproducible
+
+			cfg, closer, err := newSigningConfig("hello", opts)
+			defer closer()
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantTime, cfg.SigningTime)
+			assert.Equal(t, tt.reproducible, cfg.Reproducible)
+		})
+	}
+}
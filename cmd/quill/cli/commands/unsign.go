@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+)
+
+type unsignConfig struct {
+	Path           string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Unsign `yaml:"unsign" json:"unsign" mapstructure:"unsign"`
+}
+
+func Unsign(app clio.Application) *cobra.Command {
+	opts := &unsignConfig{}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "unsign PATH",
+		Short: "remove the code signature from a macho (darwin) binary",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the darwin binary to remove the signature from",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			return quill.Unsign(*quill.NewUnsignConfig(opts.Path).WithOutputPath(opts.Output))
+		},
+	}, opts)
+}
@@ -2,15 +2,23 @@ package commands
 
 import (
 	"context"
+	"crypto"
+	"encoding/hex"
 	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
 	"software.sslmate.com/src/go-pkcs12"
 
+	"github.com/anchore/quill/cmd/quill/cli/options"
 	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
 	"github.com/anchore/quill/internal/redact"
+	"github.com/anchore/quill/quill"
+	"github.com/anchore/quill/quill/pki"
+	"github.com/anchore/quill/quill/pki/certchain"
 	"github.com/anchore/quill/quill/pki/load"
+	"github.com/anchore/quill/quill/pki/signer"
 )
 
 const pathArg = "PATH"
@@ -60,3 +68,71 @@ func chainArgs(processors ...func(cmd *cobra.Command, args []string) error) func
 		return nil
 	}
 }
+
+// newExternalSignerConfig creates a signing config for a private key that is held outside of quill (within a PKCS #11
+// token or behind a signing command), where the certificate chain is provided separately. The returned function
+// releases any resources held by the signer.
+func newExternalSignerConfig(binPath string, opts options.Signing) (*quill.SigningConfig, func(), error) {
+	noop := func() {}
+
+	if opts.Certs == "" {
+		return nil, noop, fmt.Errorf("a certificate chain (--certs) is required when using an external signer")
+	}
+
+	var s crypto.Signer
+	closer := noop
+	switch {
+	case opts.SignerCommand != "" && opts.PKCS11Module != "":
+		return nil, noop, fmt.Errorf("only one external signer may be used (--signer-command or --pkcs11-module)")
+
+	case opts.SignerCommand != "":
+		certs, err := load.Certificates(opts.Certs)
+		if err != nil {
+			return nil, noop, err
+		}
+		leaf := (&pki.SigningMaterial{Certs: certchain.Sort(certs)}).Leaf()
+		if leaf == nil {
+			return nil, noop, fmt.Errorf("no signing (leaf) certificate found in %q", opts.Certs)
+		}
+
+		s, err = signer.NewCommand(opts.SignerCommand, leaf.PublicKey)
+		if err != nil {
+			return nil, noop, err
+		}
+
+	case opts.PKCS11Module != "":
+		// the key ID is left unset (rather than empty) when not given, so that the key is only looked up by label
+		var keyID []byte
+		if opts.PKCS11KeyID != "" {
+			var err error
+			keyID, err = hex.DecodeString(opts.PKCS11KeyID)
+			if err != nil {
+				return nil, noop, fmt.Errorf("invalid PKCS #11 key ID (--pkcs11-key-id must be hex encoded): %w", err)
+			}
+		}
+
+		p11, err := signer.NewPKCS11(signer.PKCS11Config{
+			Module:     opts.PKCS11Module,
+			TokenLabel: opts.PKCS11Token,
+			PIN:        opts.PKCS11PIN,
+			KeyLabel:   opts.PKCS11KeyLabel,
+			KeyID:      keyID,
+		})
+		if err != nil {
+			return nil, noop, err
+		}
+		s = p11
+		closer = func() {
+			if err := p11.Close(); err != nil {
+				log.Warnf("unable to close PKCS #11 session: %v", err)
+			}
+		}
+	}
+
+	cfg, err := quill.NewSigningConfigFromSigner(binPath, s, opts.Certs, opts.FailWithoutFullChain)
+	if err != nil {
+		closer()
+		return nil, noop, fmt.Errorf("unable to use external signer: %w", err)
+	}
+	return cfg, closer, nil
+}
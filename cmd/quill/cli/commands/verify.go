@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anchore/clio"
+	"github.com/anchore/quill/cmd/quill/cli/options"
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/quill"
+	"github.com/anchore/quill/quill/pki/load"
+	"github.com/anchore/quill/quill/verify"
+)
+
+type verifyConfig struct {
+	Path           string `yaml:"path" json:"path" mapstructure:"-"`
+	options.Format `yaml:",inline" json:",inline" mapstructure:",squash"`
+	options.Verify `yaml:"verify" json:"verify" mapstructure:"verify"`
+}
+
+func Verify(app clio.Application) *cobra.Command {
+	opts := &verifyConfig{
+		Format: options.Format{
+			Output:           formatText,
+			AllowableFormats: []string{formatText, formatJSON},
+		},
+	}
+
+	return app.SetupCommand(&cobra.Command{
+		Use:   "verify PATH",
+		Short: "verify the code signature of a macho (darwin) binary",
+		Example: options.FormatPositionalArgsHelp(
+			map[string]string{
+				pathArg: "the signed darwin binary to verify",
+			},
+		),
+		Args: chainArgs(
+			cobra.ExactArgs(1),
+			func(_ *cobra.Command, args []string) error {
+				opts.Path = args[0]
+				return nil
+			},
+		),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			defer bus.Exit()
+
+			cfg := quill.NewVerifyConfig(opts.Path).WithAdhocAllowed(opts.AllowAdhoc).WithDetachedSignature(opts.Detached)
+
+			for _, p := range opts.Roots {
+				certs, err := load.Certificates(p)
+				if err != nil {
+					return fmt.Errorf("unable to load root certificates from %q: %w", p, err)
+				}
+				cfg.WithRoots(certs...)
+			}
+
+			result, err := quill.Verify(*cfg)
+			if err != nil {
+				return err
+			}
+
+			report, err := formatVerifyResult(*result, opts.Output)
+			if err != nil {
+				return err
+			}
+
+			bus.Report(report)
+
+			if !result.IsValid() {
+				return fmt.Errorf("signature verification failed with %d problem(s)", len(result.Failures))
+			}
+			return nil
+		},
+	}, opts)
+}
+
+func formatVerifyResult(result verify.Result, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case formatText:
+		return result.String(), nil
+	case formatJSON:
+		by, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("unable to encode verification result: %w", err)
+		}
+		return string(by), nil
+	default:
+		return "", fmt.Errorf("unknown format: %s", format)
+	}
+}
@@ -0,0 +1,47 @@
+package options
+
+import (
+	"github.com/anchore/fangs"
+)
+
+var _ fangs.FlagAdder = (*Edit)(nil)
+
+type Edit struct {
+	ID           string   `yaml:"id" json:"id" mapstructure:"id"`
+	ChangeDylibs []string `yaml:"change" json:"change" mapstructure:"change"`
+	ChangeRpaths []string `yaml:"change-rpath" json:"change-rpath" mapstructure:"change-rpath"`
+	AddRpaths    []string `yaml:"add-rpath" json:"add-rpath" mapstructure:"add-rpath"`
+	DeleteRpaths []string `yaml:"delete-rpath" json:"delete-rpath" mapstructure:"delete-rpath"`
+}
+
+func (o *Edit) AddFlags(flags fangs.FlagSet) {
+	flags.StringVarP(
+		&o.ID,
+		"id", "",
+		"install name of the dylib to set (LC_ID_DYLIB)",
+	)
+
+	flags.StringArrayVarP(
+		&o.ChangeDylibs,
+		"change", "",
+		"install name of a dylib the binary depends on to replace, in the form OLD=NEW (LC_LOAD_DYLIB)",
+	)
+
+	flags.StringArrayVarP(
+		&o.ChangeRpaths,
+		"change-rpath", "",
+		"run path search path to replace, in the form OLD=NEW (LC_RPATH)",
+	)
+
+	flags.StringArrayVarP(
+		&o.AddRpaths,
+		"add-rpath", "",
+		"run path search path to add after any existing run paths (LC_RPATH)",
+	)
+
+	flags.StringArrayVarP(
+		&o.DeleteRpaths,
+		"delete-rpath", "",
+		"run path search path to delete (LC_RPATH)",
+	)
+}
@@ -0,0 +1,47 @@
+package options
+
+import (
+	"github.com/anchore/fangs"
+)
+
+var _ fangs.FlagAdder = (*Lipo)(nil)
+
+type Lipo struct {
+	Output     string   `yaml:"output" json:"output" mapstructure:"output"`
+	Alignments []string `yaml:"align" json:"align" mapstructure:"align"`
+	Fat64      bool     `yaml:"fat64" json:"fat64" mapstructure:"fat64"`
+}
+
+func (o *Lipo) AddFlags(flags fangs.FlagSet) {
+	flags.StringVarP(
+		&o.Output,
+		"output", "",
+		"path to write the resulting binary to (may be the input binary)",
+	)
+
+	flags.StringArrayVarP(
+		&o.Alignments,
+		"align", "",
+		"alignment of the slice for an architecture in the form ARCH=ALIGN, where ALIGN is a power of 2 in bytes (e.g. 'arm64=0x4000')",
+	)
+
+	flags.BoolVarP(
+		&o.Fat64,
+		"fat64", "",
+		"write a universal binary with a 64-bit header (default is to do so only when needed)",
+	)
+}
+
+var _ fangs.FlagAdder = (*LipoArches)(nil)
+
+type LipoArches struct {
+	Arches []string `yaml:"arch" json:"arch" mapstructure:"arch"`
+}
+
+func (o *LipoArches) AddFlags(flags fangs.FlagSet) {
+	flags.StringArrayVarP(
+		&o.Arches,
+		"arch", "",
+		"architecture name as used by lipo (e.g. 'arm64', 'x86_64' or 'armv7')",
+	)
+}
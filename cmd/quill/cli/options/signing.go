@@ -18,9 +18,33 @@ type Signing struct {
 	TimestampServer      string `yaml:"timestamp-server" json:"timestamp-server" mapstructure:"timestamp-server"`
 	AdHoc                bool   `yaml:"ad-hoc" json:"ad-hoc" mapstructure:"ad-hoc"`
 	FailWithoutFullChain bool   `yaml:"fail-without-full-chain" json:"fail-without-full-chain" mapstructure:"fail-without-full-chain"`
+	DigestAlgorithm      string `yaml:"digest-algorithm" json:"digest-algorithm" mapstructure:"digest-algorithm"`
+	Options              string `yaml:"options" json:"options" mapstructure:"options"`
+	PreserveMetadata     string `yaml:"preserve-metadata" json:"preserve-metadata" mapstructure:"preserve-metadata"`
+	Reproducible         bool   `yaml:"reproducible" json:"reproducible" mapstructure:"reproducible"`
+	GrowHeaderSpace      bool   `yaml:"grow-header-space" json:"grow-header-space" mapstructure:"grow-header-space"`
+	Output               string `yaml:"output" json:"output" mapstructure:"output"`
+	Detached             string `yaml:"detached" json:"detached" mapstructure:"detached"`
+	Certs                string `yaml:"certs" json:"certs" mapstructure:"certs"`
+	SignerCommand        string `yaml:"signer-command" json:"signer-command" mapstructure:"signer-command"`
+	PKCS11Module         string `yaml:"pkcs11-module" json:"pkcs11-module" mapstructure:"pkcs11-module"`
+	PKCS11Token          string `yaml:"pkcs11-token" json:"pkcs11-token" mapstructure:"pkcs11-token"`
+	PKCS11KeyLabel       string `yaml:"pkcs11-key-label" json:"pkcs11-key-label" mapstructure:"pkcs11-key-label"`
+	PKCS11KeyID          string `yaml:"pkcs11-key-id" json:"pkcs11-key-id" mapstructure:"pkcs11-key-id"`
 
 	// unbound options
-	Password     string `yaml:"password" json:"password" mapstructure:"password"` // not a hardcoded secret
+	Password     string        `yaml:"password" json:"password" mapstructure:"password"`       // not a hardcoded secret
+	PKCS11PIN    string        `yaml:"pkcs11-pin" json:"pkcs11-pin" mapstructure:"pkcs11-pin"` // not a hardcoded secret
+	Entitlements string        `yaml:"entitlements" json:"entitlements" mapstructure:"entitlements"`
+	Requirements string        `yaml:"requirements" json:"requirements" mapstructure:"requirements"`
+	Rules        []SigningRule `yaml:"rules" json:"rules" mapstructure:"rules"`
+}
+
+// SigningRule sets the identity and entitlements of the binaries matching the path when signing a directory or archive.
+type SigningRule struct {
+	// Path is a glob matched against the path within the directory (or archive) being signed ("**" matches any directories)
+	Path         string `yaml:"path" json:"path" mapstructure:"path"`
+	Identity     string `yaml:"identity" json:"identity" mapstructure:"identity"`
 	Entitlements string `yaml:"entitlements" json:"entitlements" mapstructure:"entitlements"`
 }
 
@@ -28,11 +52,13 @@ func DefaultSigning() Signing {
 	return Signing{
 		TimestampServer:      "http://timestamp.apple.com/ts01",
 		FailWithoutFullChain: true,
+		DigestAlgorithm:      "sha256",
 	}
 }
 
 func (o *Signing) PostLoad() error {
 	redact.Add(o.Password)
+	redact.Add(o.PKCS11PIN)
 	redactNonFileOrEnvHint(o.P12)
 	return nil
 }
@@ -53,7 +79,43 @@ func (o *Signing) AddFlags(flags fangs.FlagSet) {
 	flags.StringVarP(
 		&o.TimestampServer,
 		"timestamp-server", "",
-		"URL to a timestamp server to use for timestamping the signature",
+		"comma-separated URLs of RFC3161 timestamp servers to use for timestamping the signature, tried in order until one succeeds (each is retried with backoff before falling back to the next). Set to an empty string to skip timestamping",
+	)
+
+	flags.StringVarP(
+		&o.Certs,
+		"certs", "",
+		"path to a PEM file containing the (leaf) signing certificate and remaining certificate chain, required when signing with --signer-command or --pkcs11-module",
+	)
+
+	flags.StringVarP(
+		&o.SignerCommand,
+		"signer-command", "",
+		"external command that signs with a private key held elsewhere (e.g. a KMS). The digest is written to stdin and the raw signature is read from stdout\n(the digest algorithm is given in the QUILL_SIGNER_DIGEST_ALGORITHM environment variable)",
+	)
+
+	flags.StringVarP(
+		&o.PKCS11Module,
+		"pkcs11-module", "",
+		"path to a PKCS #11 module to sign with a private key held within a token (e.g. an HSM). The PIN is read from the QUILL_SIGN_PKCS11_PIN environment variable",
+	)
+
+	flags.StringVarP(
+		&o.PKCS11Token,
+		"pkcs11-token", "",
+		"label of the PKCS #11 token holding the signing key",
+	)
+
+	flags.StringVarP(
+		&o.PKCS11KeyLabel,
+		"pkcs11-key-label", "",
+		"label of the signing key within the PKCS #11 token",
+	)
+
+	flags.StringVarP(
+		&o.PKCS11KeyID,
+		"pkcs11-key-id", "",
+		"hex-encoded ID (CKA_ID) of the signing key within the PKCS #11 token, used instead of or in addition to --pkcs11-key-label",
 	)
 
 	flags.BoolVarP(
@@ -62,14 +124,64 @@ func (o *Signing) AddFlags(flags fangs.FlagSet) {
 		"perform ad-hoc signing. No cryptographic signature is included and --p12 key and certificate input are not needed. Do NOT use this option for production builds.",
 	)
 
+	flags.StringVarP(
+		&o.DigestAlgorithm,
+		"digest-algorithm", "",
+		"comma-separated code directory digest algorithms, the first being the primary code directory (e.g. 'sha1,sha256' to match what codesign produces for older macOS releases)",
+	)
+
+	flags.StringVarP(
+		&o.Options,
+		"options", "",
+		"comma-separated code directory flags to set: runtime, kill, hard, library, restrict, expires (the runtime flag is always set for signed binaries)",
+	)
+
+	flags.StringVarP(
+		&o.PreserveMetadata,
+		"preserve-metadata", "",
+		"comma-separated metadata to carry over from an existing signature when re-signing: identifier, entitlements, requirements, flags\n(values given explicitly with --identity, --entitlements, --requirements or --options take precedence)",
+	)
+
+	flags.BoolVarP(
+		&o.Reproducible,
+		"reproducible", "",
+		"sign deterministically so that signing the same input produces identical output. The signing time is taken from the SOURCE_DATE_EPOCH environment variable (which must be set) and no RFC3161 timestamp is requested",
+	)
+
+	flags.BoolVarP(
+		&o.GrowHeaderSpace,
+		"grow-header-space", "",
+		"make room for the code signing load command in binaries where the linker left too little room after the load commands, by shifting the contents of the binary.\nBinaries whose code refers to the Mach-O header (e.g. C++ or Swift code using __dso_handle) are refused. Relinking with -headerpad is preferred",
+	)
+
+	flags.StringVarP(
+		&o.Output,
+		"output", "",
+		"path to write the signed binary (or bundle) to, leaving the input untouched (default is to replace the input)",
+	)
+
+	flags.StringVarP(
+		&o.Detached,
+		"detached", "",
+		"path to write a detached signature to, leaving the binary untouched (multi-arch binaries get a signature for each architecture)",
+	)
+
 	flags.StringVarP(
 		&o.Entitlements,
 		"entitlements", "",
 		"path to an XML file containing the entitlements for the binary being signed",
 	)
+
+	flags.StringVarP(
+		&o.Requirements,
+		"requirements", "",
+		"requirements to embed, written in Apple's requirement language (e.g. 'designated => identifier \"com.example.app\" and anchor apple generic'), or a path to a file containing them.\nA single expression is used as the designated requirement, replacing the one derived from the signing certificate",
+	)
 }
 
 func (o *Signing) DescribeFields(d fangs.FieldDescriptionSet) {
 	d.Add(&o.FailWithoutFullChain, "fail without the full certificate chain present in the p12 file")
 	d.Add(&o.Password, "password for the p12 file")
+	d.Add(&o.PKCS11PIN, "user PIN for the PKCS #11 token")
+	d.Add(&o.Rules, "when signing a directory or archive, the identity and entitlements of the binaries matching a path (e.g. '**/*.dylib'), where the first matching rule is used")
 }
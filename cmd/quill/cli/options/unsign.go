@@ -0,0 +1,19 @@
+package options
+
+import (
+	"github.com/anchore/fangs"
+)
+
+var _ fangs.FlagAdder = (*Unsign)(nil)
+
+type Unsign struct {
+	Output string `yaml:"output" json:"output" mapstructure:"output"`
+}
+
+func (o *Unsign) AddFlags(flags fangs.FlagSet) {
+	flags.StringVarP(
+		&o.Output,
+		"output", "",
+		"path to write the unsigned binary to, leaving the input untouched (default is to replace the input)",
+	)
+}
@@ -0,0 +1,33 @@
+package options
+
+import (
+	"github.com/anchore/fangs"
+)
+
+var _ fangs.FlagAdder = (*Verify)(nil)
+
+type Verify struct {
+	AllowAdhoc bool     `yaml:"allow-ad-hoc" json:"allow-ad-hoc" mapstructure:"allow-ad-hoc"`
+	Roots      []string `yaml:"root-certs" json:"root-certs" mapstructure:"root-certs"`
+	Detached   string   `yaml:"detached" json:"detached" mapstructure:"detached"`
+}
+
+func (o *Verify) AddFlags(flags fangs.FlagSet) {
+	flags.BoolVarP(
+		&o.AllowAdhoc,
+		"allow-ad-hoc", "",
+		"consider ad-hoc signatures (without a cryptographic signature) as valid",
+	)
+
+	flags.StringArrayVarP(
+		&o.Roots,
+		"root-cert", "",
+		"path to a PEM file of additional root certificates to trust (beyond the Apple certificates embedded within quill)",
+	)
+
+	flags.StringVarP(
+		&o.Detached,
+		"detached", "",
+		"path to a detached signature to verify the binary against (instead of the signature embedded within the binary)",
+	)
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Macho32 writes a minimal (unsigned) 32-bit executable for the given CPU (macho.Cpu386 or macho.CpuArm for armv7),
+// returning its path. Current toolchains can no longer build 32-bit binaries, so these are generated instead of kept
+// as fixtures. The binary has __PAGEZERO, __TEXT, __LINKEDIT, LC_SYMTAB and LC_UNIXTHREAD load commands, where the
+// __text section starts headerPadding bytes after the load commands (zero leaves no room for any new load command).
+// Segments are laid out with the page size of the platform (4K for i386, 16K for armv7), as the linker would.
+func Macho32(t testing.TB, cpu macho.Cpu, headerPadding uint32) string {
+	t.Helper()
+
+	const headerSize = 28
+
+	arch, subCPU, threadStateCount, pcIndex := "i386", uint32(3), uint32(16), 10 // CPU_SUBTYPE_I386_ALL, x86_THREAD_STATE32, eip
+	pageSize := uint32(0x1000)
+	if cpu == macho.CpuArm {
+		arch, subCPU, threadStateCount, pcIndex = "armv7", 9, 17, 15 // CPU_SUBTYPE_ARM_V7, ARM_THREAD_STATE, pc
+		pageSize = 0x4000
+	}
+
+	var cmds []byte
+	put := func(b []byte, values ...uint32) []byte {
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+		return b
+	}
+	name := func(b []byte, s string) []byte {
+		var n [16]byte
+		copy(n[:], s)
+		return append(b, n[:]...)
+	}
+
+	sizeOfCmds := uint32(56 + 56 + 68 + 56 + 24 + 16 + threadStateCount*4)
+	textOffset := headerSize + sizeOfCmds + headerPadding
+	linkeditOffset := uint32(pageSize)
+	for linkeditOffset < textOffset+16 {
+		linkeditOffset += pageSize
+	}
+	stringTable := []byte(" \x00\x00\x00\x00\x00\x00\x00")
+
+	cmds = name(put(cmds, uint32(macho.LoadCmdSegment), 56), "__PAGEZERO")
+	cmds = put(cmds, 0, pageSize, 0, 0, 0, 0, 0, 0)
+	cmds = name(put(cmds, uint32(macho.LoadCmdSegment), 56+68), "__TEXT")
+	cmds = put(cmds, pageSize, linkeditOffset, 0, linkeditOffset, 5, 5, 1, 0)
+	cmds = name(name(cmds, "__text"), "__TEXT")
+	cmds = put(cmds, pageSize+textOffset, 16, textOffset, 2, 0, 0, 0x80000400, 0, 0)
+	cmds = name(put(cmds, uint32(macho.LoadCmdSegment), 56), "__LINKEDIT")
+	cmds = put(cmds, pageSize+linkeditOffset, pageSize, linkeditOffset, uint32(len(stringTable)), 1, 1, 0, 0)
+	cmds = put(cmds, uint32(macho.LoadCmdSymtab), 24, linkeditOffset, 0, linkeditOffset, uint32(len(stringTable)))
+	cmds = put(cmds, uint32(macho.LoadCmdUnixThread), 16+threadStateCount*4, 1, threadStateCount)
+	state := make([]uint32, threadStateCount)
+	state[pcIndex] = pageSize + textOffset
+	cmds = put(cmds, state...)
+
+	b := put(nil, macho.Magic32, uint32(cpu), subCPU, uint32(macho.TypeExec), 5, sizeOfCmds,
+		uint32(macho.FlagNoUndefs|macho.FlagDyldLink|macho.FlagTwoLevel|macho.FlagPIE))
+	b = append(b, cmds...)
+	b = append(b, make([]byte, linkeditOffset-uint32(len(b)))...)
+	for i := textOffset; i < textOffset+16; i++ {
+		b[i] = 0x90
+	}
+	b = append(b, stringTable...)
+
+	p := filepath.Join(t.TempDir(), "hello_"+arch)
+	if err := os.WriteFile(p, b, 0600); err != nil { //nolint:gosec // G306: written under t.TempDir() in a test helper
+		t.Fatalf("unable to write 32-bit binary: %+v", err)
+	}
+	return p
+}
@@ -0,0 +1,441 @@
+// Package archive provides functionality for reading and rewriting the tar, tar.gz and zip archives that binaries are
+// commonly released in, without unpacking them to disk.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+)
+
+type Format string
+
+const (
+	Unknown Format = ""
+	Tar     Format = "tar"
+	TarGzip Format = "tar.gz"
+	Zip     Format = "zip"
+)
+
+// prefixSize is the number of leading bytes of each entry that are given to a MatchFunc.
+const prefixSize = 16
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	// tarMagicOffset is where the "ustar" magic is found in a tar header (both POSIX and GNU formats)
+	tarMagicOffset = 257
+	tarMagic       = []byte("ustar")
+)
+
+// Entry describes a regular file within an archive.
+type Entry struct {
+	// Name is the slash-separated path of the file within the archive
+	Name    string
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// MatchFunc reports whether the entry should be handled, given the leading bytes of its contents (which may be fewer
+// than requested for small files).
+type MatchFunc func(entry Entry, prefix []byte) bool
+
+// EntryFunc handles an entry, where the contents of the entry have been written to the file at the given path.
+type EntryFunc func(entry Entry, path string) error
+
+// IsArchive indicates if the file at the given path is an archive that can be read (or rewritten).
+func IsArchive(path string) bool {
+	format, err := Detect(path)
+	return err == nil && format != Unknown
+}
+
+// Detect returns the format of the archive at the given path by its contents (not its file extension), which is
+// Unknown for anything that is not an archive.
+func Detect(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Unknown, err
+	}
+	if !info.Mode().IsRegular() {
+		return Unknown, nil
+	}
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Unknown, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return Zip, nil
+	case isTarHeader(header):
+		return Tar, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		// only a gzip compressed tar is supported (not any other gzip compressed file)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return Unknown, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return Unknown, nil
+		}
+		defer gz.Close()
+
+		header = make([]byte, tarMagicOffset+len(tarMagic))
+		if _, err := io.ReadFull(gz, header); err != nil || !isTarHeader(header) {
+			return Unknown, nil
+		}
+		return TarGzip, nil
+	}
+	return Unknown, nil
+}
+
+func isTarHeader(header []byte) bool {
+	return len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic)
+}
+
+// Walk calls fn with each regular file within the archive at the given path that is matched (in archive order).
+func Walk(path string, match MatchFunc, fn EntryFunc) error {
+	return process(path, nil, match, fn)
+}
+
+// Rewrite writes a copy of the archive at the given path to w (in the same format). For each regular file that is
+// matched, fn is called with the file extracted to a temp file, which fn may modify in place to replace the contents
+// of the entry. All other entries are copied as-is, and the order and metadata (modes, modification times,
+// ownership, ...) of every entry are kept.
+func Rewrite(path string, w io.Writer, match MatchFunc, fn EntryFunc) error {
+	return process(path, w, match, fn)
+}
+
+// process visits the matching entries of the archive at the given path. When w is given the archive is also
+// rewritten to w, with the contents of each matching entry replaced by the file given to fn once fn returns.
+func process(path string, w io.Writer, match MatchFunc, fn EntryFunc) error {
+	format, err := Detect(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case Zip:
+		return processZip(path, w, match, fn)
+	case Tar, TarGzip:
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if format == Tar {
+			return processTar(f, w, match, fn)
+		}
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("unable to read gzip: %w", err)
+		}
+		defer gz.Close()
+
+		if w == nil {
+			return processTar(gz, nil, match, fn)
+		}
+
+		// keep the original gzip header (name, comment, modification time, ...)
+		gw := gzip.NewWriter(w)
+		gw.Header = gz.Header
+		if err := processTar(gz, gw, match, fn); err != nil {
+			return err
+		}
+		return gw.Close()
+	default:
+		return fmt.Errorf("%q is not a tar, tar.gz or zip archive", path)
+	}
+}
+
+func processTar(r io.Reader, w io.Writer, match MatchFunc, fn EntryFunc) error {
+	var tw *tar.Writer
+	if w != nil {
+		tw = tar.NewWriter(w)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar: %w", err)
+		}
+
+		var contents io.Reader = tr
+		if hdr.Typeflag == tar.TypeReg {
+			prefix, err := readPrefix(tr)
+			if err != nil {
+				return fmt.Errorf("unable to read %q: %w", hdr.Name, err)
+			}
+			contents = io.MultiReader(bytes.NewReader(prefix), tr)
+
+			if entry := tarEntry(hdr); match(entry, prefix) {
+				err := handleEntry(entry, contents, func(entry Entry, path string) error {
+					if err := fn(entry, path); err != nil {
+						return err
+					}
+					if tw == nil {
+						return nil
+					}
+					return writeTarEntry(tw, hdr, path)
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if tw == nil {
+			continue
+		}
+
+		// everything else is copied as-is (in the same order and with the same header)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("unable to write %q: %w", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, contents); err != nil {
+			return fmt.Errorf("unable to write %q: %w", hdr.Name, err)
+		}
+	}
+
+	if tw == nil {
+		return nil
+	}
+	return tw.Close()
+}
+
+// writeTarEntry writes the replacement contents of an entry, keeping the original header besides the size.
+func writeTarEntry(tw *tar.Writer, hdr *tar.Header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr.Size = info.Size()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("unable to write %q: %w", hdr.Name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("unable to write %q: %w", hdr.Name, err)
+	}
+	return nil
+}
+
+func processZip(path string, w io.Writer, match MatchFunc, fn EntryFunc) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("unable to read zip: %w", err)
+	}
+	defer r.Close()
+
+	var zw *zip.Writer
+	if w != nil {
+		zw = zip.NewWriter(w)
+		if err := zw.SetComment(r.Comment); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range r.File {
+		matched, err := handleZipEntry(f, match, func(entry Entry, path string) error {
+			if err := fn(entry, path); err != nil {
+				return err
+			}
+			if zw == nil {
+				return nil
+			}
+			return writeZipEntry(zw, f, path)
+		})
+		if err != nil {
+			return err
+		}
+
+		if matched || zw == nil {
+			continue
+		}
+
+		// everything else is copied as-is (without recompressing)
+		if err := zw.Copy(f); err != nil {
+			return fmt.Errorf("unable to write %q: %w", f.Name, err)
+		}
+	}
+
+	if zw == nil {
+		return nil
+	}
+	return zw.Close()
+}
+
+// writeZipEntry writes the replacement contents of an entry, keeping the original header besides the sizes and CRC.
+func writeZipEntry(zw *zip.Writer, original *zip.File, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fh := original.FileHeader
+	fh.CRC32 = 0
+	fh.CompressedSize = 0
+	fh.CompressedSize64 = 0
+	fh.UncompressedSize = 0
+	fh.UncompressedSize64 = 0
+
+	// the writer adds the zip64 and extended timestamp fields itself (the latter only when Modified is set), so these
+	// are dropped from the original extra fields. Without an extended timestamp the MS-DOS time is kept as-is.
+	var hasExtTime bool
+	fh.Extra, hasExtTime = withoutExtraFields(fh.Extra, zip64ExtraID, extTimeExtraID)
+	if !hasExtTime {
+		fh.Modified = time.Time{}
+	}
+
+	fw, err := zw.CreateHeader(&fh)
+	if err != nil {
+		return fmt.Errorf("unable to write %q: %w", fh.Name, err)
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return fmt.Errorf("unable to write %q: %w", fh.Name, err)
+	}
+	return nil
+}
+
+const (
+	zip64ExtraID   = 0x0001
+	extTimeExtraID = 0x5455
+)
+
+// withoutExtraFields removes the given fields from the zip extra data, indicating if the extended timestamp field was
+// present.
+func withoutExtraFields(extra []byte, ids ...uint16) ([]byte, bool) {
+	var kept []byte
+	var hasExtTime bool
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			// malformed, keep the remainder as-is
+			break
+		}
+		field := extra[:4+size]
+		extra = extra[4+size:]
+
+		if id == extTimeExtraID {
+			hasExtTime = true
+		}
+		if slices.Contains(ids, id) {
+			continue
+		}
+		kept = append(kept, field...)
+	}
+	return append(kept, extra...), hasExtTime
+}
+
+// handleZipEntry calls fn with the zip entry if it is a regular file that is matched, returning whether it was.
+func handleZipEntry(f *zip.File, match MatchFunc, fn EntryFunc) (bool, error) {
+	if !f.Mode().IsRegular() {
+		return false, nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return false, fmt.Errorf("unable to read %q: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	prefix, err := readPrefix(rc)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %q: %w", f.Name, err)
+	}
+
+	entry := zipEntry(f)
+	if !match(entry, prefix) {
+		return false, nil
+	}
+	return true, handleEntry(entry, io.MultiReader(bytes.NewReader(prefix), rc), fn)
+}
+
+// handleEntry writes the contents of the entry to a temp file and calls fn with it.
+func handleEntry(entry Entry, contents io.Reader, fn EntryFunc) error {
+	tmp, err := spool(contents)
+	if err != nil {
+		return fmt.Errorf("unable to extract %q: %w", entry.Name, err)
+	}
+	defer os.Remove(tmp)
+
+	return fn(entry, tmp)
+}
+
+// spool writes the contents to a new temp file, returning its path.
+func spool(contents io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "quill-archive-entry-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func readPrefix(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, prefixSize)
+	n, err := io.ReadFull(r, prefix)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return prefix[:n], nil
+}
+
+func tarEntry(hdr *tar.Header) Entry {
+	return Entry{
+		Name:    hdr.Name,
+		Mode:    hdr.FileInfo().Mode(),
+		ModTime: hdr.ModTime,
+	}
+}
+
+func zipEntry(f *zip.File) Entry {
+	return Entry{
+		Name:    f.Name,
+		Mode:    f.Mode(),
+		ModTime: f.Modified,
+	}
+}
@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testModTime = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+// testEntry is a file within a test archive, where only contents starting with "BIN" are matched.
+type testEntry struct {
+	name     string
+	mode     os.FileMode
+	contents string
+	linkname string
+}
+
+var testEntries = []testEntry{
+	{name: "release/", mode: os.ModeDir | 0755},
+	{name: "release/README.md", mode: 0644, contents: "read me"},
+	{name: "release/bin/cli", mode: 0755, contents: "BIN cli"},
+	{name: "release/cli", mode: os.ModeSymlink | 0777, linkname: "bin/cli"},
+	{name: "release/lib/libquill.dylib", mode: 0700, contents: "BIN lib"},
+	{name: "release/LICENSE", mode: 0600, contents: ""},
+}
+
+func matchTestBinary(_ Entry, prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte("BIN"))
+}
+
+func writeTestTar(t *testing.T, w io.Writer) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for _, e := range testEntries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     int64(e.mode.Perm()),
+			ModTime:  testModTime,
+			Uname:    "quill",
+			Gname:    "staff",
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(e.contents)),
+			Format:   tar.FormatPAX,
+		}
+		switch {
+		case e.mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case e.mode&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.linkname
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func newTestTar(t *testing.T, gzipped bool) string {
+	t.Helper()
+	name := "release.tar"
+	if gzipped {
+		name += ".gz"
+	}
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	if !gzipped {
+		writeTestTar(t, f)
+		return p
+	}
+
+	gw := gzip.NewWriter(f)
+	gw.Name = "release.tar"
+	gw.ModTime = testModTime
+	writeTestTar(t, gw)
+	require.NoError(t, gw.Close())
+	return p
+}
+
+func newTestZip(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "release.zip")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	require.NoError(t, zw.SetComment("release archive"))
+	for _, e := range testEntries {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: testModTime}
+		fh.SetMode(e.mode)
+		w, err := zw.CreateHeader(fh)
+		require.NoError(t, err)
+		contents := e.contents
+		if e.linkname != "" {
+			contents = e.linkname
+		}
+		_, err = w.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return p
+}
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	notArchive := filepath.Join(dir, "binary")
+	require.NoError(t, os.WriteFile(notArchive, []byte("\xcf\xfa\xed\xfe not an archive"), 0600))
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte("just a compressed file"))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	gzipOnly := filepath.Join(dir, "file.gz")
+	require.NoError(t, os.WriteFile(gzipOnly, gz.Bytes(), 0600))
+
+	tests := []struct {
+		name string
+		path string
+		want Format
+	}{
+		{name: "tar", path: newTestTar(t, false), want: Tar},
+		{name: "tar.gz", path: newTestTar(t, true), want: TarGzip},
+		{name: "zip", path: newTestZip(t), want: Zip},
+		{name: "gzip without a tar", path: gzipOnly, want: Unknown},
+		{name: "not an archive", path: notArchive, want: Unknown},
+		{name: "directory", path: dir, want: Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := Detect(tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, actual)
+			assert.Equal(t, tt.want != Unknown, IsArchive(tt.path))
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	for _, p := range []string{newTestTar(t, false), newTestTar(t, true), newTestZip(t)} {
+		t.Run(filepath.Base(p), func(t *testing.T) {
+			var names []string
+			err := Walk(p, matchTestBinary, func(entry Entry, path string) error {
+				contents, err := os.ReadFile(path)
+				require.NoError(t, err)
+				assert.True(t, strings.HasPrefix(string(contents), "BIN"))
+				assert.True(t, entry.ModTime.Equal(testModTime))
+				names = append(names, entry.Name)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"release/bin/cli", "release/lib/libquill.dylib"}, names)
+		})
+	}
+}
+
+// editTestBinary replaces the contents of a matched entry with something longer than the original.
+func editTestBinary(_ Entry, path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(contents, " (signed)"...), 0600)
+}
+
+func TestRewrite_tar(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		t.Run(map[bool]string{false: "tar", true: "tar.gz"}[gzipped], func(t *testing.T) {
+			src := newTestTar(t, gzipped)
+
+			var out bytes.Buffer
+			require.NoError(t, Rewrite(src, &out, matchTestBinary, editTestBinary))
+
+			var r io.Reader = &out
+			if gzipped {
+				gz, err := gzip.NewReader(&out)
+				require.NoError(t, err)
+				assert.Equal(t, "release.tar", gz.Name)
+				assert.True(t, gz.ModTime.Equal(testModTime))
+				r = gz
+			}
+
+			tr := tar.NewReader(r)
+			for _, want := range testEntries {
+				hdr, err := tr.Next()
+				require.NoError(t, err)
+
+				assert.Equal(t, want.name, hdr.Name)
+				assert.Equal(t, want.mode, hdr.FileInfo().Mode())
+				assert.True(t, hdr.ModTime.Equal(testModTime))
+				assert.Equal(t, "quill", hdr.Uname)
+				assert.Equal(t, "staff", hdr.Gname)
+				assert.Equal(t, want.linkname, hdr.Linkname)
+
+				contents, err := io.ReadAll(tr)
+				require.NoError(t, err)
+				wantContents := want.contents
+				if strings.HasPrefix(wantContents, "BIN") {
+					wantContents += " (signed)"
+				}
+				assert.Equal(t, wantContents, string(contents))
+			}
+			_, err := tr.Next()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestRewrite_zip(t *testing.T) {
+	src := newTestZip(t)
+
+	var out bytes.Buffer
+	require.NoError(t, Rewrite(src, &out, matchTestBinary, editTestBinary))
+
+	r, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, "release archive", r.Comment)
+	require.Len(t, r.File, len(testEntries))
+
+	for i, want := range testEntries {
+		f := r.File[i]
+		assert.Equal(t, want.name, f.Name)
+		assert.Equal(t, want.mode, f.Mode())
+		assert.True(t, f.Modified.Equal(testModTime), "modified time of %q: %s", f.Name, f.Modified)
+
+		var extTimeFields int
+		for extra := f.Extra; len(extra) >= 4; {
+			size := int(extra[2]) | int(extra[3])<<8
+			if extra[0] == 0x55 && extra[1] == 0x54 {
+				extTimeFields++
+			}
+			extra = extra[4+size:]
+		}
+		assert.Equal(t, 1, extTimeFields, "extended timestamp fields of %q", f.Name)
+
+		rc, err := f.Open()
+		require.NoError(t, err)
+		contents, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+
+		wantContents := want.contents
+		switch {
+		case want.linkname != "":
+			wantContents = want.linkname
+		case strings.HasPrefix(wantContents, "BIN"):
+			wantContents += " (signed)"
+		}
+		assert.Equal(t, wantContents, string(contents))
+	}
+}
+
+func TestRewrite_entryError(t *testing.T) {
+	var out bytes.Buffer
+	err := Rewrite(newTestTar(t, true), &out, matchTestBinary, func(entry Entry, _ string) error {
+		return assert.AnError
+	})
+	require.ErrorIs(t, err, assert.AnError)
+}
+
+func Test_withoutExtraFields(t *testing.T) {
+	extra := []byte{
+		0x01, 0x00, 0x02, 0x00, 0xaa, 0xbb, // zip64
+		0x55, 0x54, 0x01, 0x00, 0x01, // extended timestamp
+		0x75, 0x78, 0x01, 0x00, 0xcc, // unix uid/gid
+	}
+
+	kept, hasExtTime := withoutExtraFields(extra, zip64ExtraID, extTimeExtraID)
+	assert.True(t, hasExtTime)
+	assert.Equal(t, []byte{0x75, 0x78, 0x01, 0x00, 0xcc}, kept)
+
+	kept, hasExtTime = withoutExtraFields(kept, zip64ExtraID, extTimeExtraID)
+	assert.False(t, hasExtTime)
+	assert.Equal(t, []byte{0x75, 0x78, 0x01, 0x00, 0xcc}, kept)
+}
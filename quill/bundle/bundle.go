@@ -0,0 +1,138 @@
+// Package bundle provides functionality for inspecting and sealing the resources of macOS bundles (e.g. .app, .framework).
+package bundle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"howett.net/plist"
+)
+
+const (
+	codeSignatureDir  = "_CodeSignature"
+	codeResourcesFile = "CodeResources"
+)
+
+// Bundle is a macOS bundle directory that can be signed.
+type Bundle struct {
+	// Path is the root directory of the bundle (e.g. "My.app")
+	Path string
+	// ContentsDir is the directory that all resources are sealed relative to (e.g. "My.app/Contents")
+	ContentsDir string
+	// InfoPlistPath is the path to the Info.plist of the bundle
+	InfoPlistPath string
+	// ExecutablePath is the path to the main executable of the bundle (empty if the bundle has no executable)
+	ExecutablePath string
+	// Identifier is the CFBundleIdentifier from the Info.plist
+	Identifier string
+}
+
+type infoPlist struct {
+	Identifier string `plist:"CFBundleIdentifier"`
+	Executable string `plist:"CFBundleExecutable"`
+}
+
+// IsBundle indicates if the given path is a directory with a recognizable bundle layout.
+func IsBundle(path string) bool {
+	_, _, _, err := layout(path)
+	return err == nil
+}
+
+// Open reads the bundle layout and Info.plist for the bundle at the given path.
+func Open(path string) (*Bundle, error) {
+	contentsDir, infoPath, exeDir, err := layout(path)
+	if err != nil {
+		return nil, err
+	}
+
+	by, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Info.plist: %w", err)
+	}
+
+	var info infoPlist
+	if _, err := plist.Unmarshal(by, &info); err != nil {
+		return nil, fmt.Errorf("unable to parse Info.plist: %w", err)
+	}
+
+	if info.Identifier == "" {
+		return nil, fmt.Errorf("no CFBundleIdentifier found in %q", infoPath)
+	}
+
+	b := &Bundle{
+		Path:          path,
+		ContentsDir:   contentsDir,
+		InfoPlistPath: infoPath,
+		Identifier:    info.Identifier,
+	}
+
+	if info.Executable != "" {
+		b.ExecutablePath = filepath.Join(exeDir, info.Executable)
+		if _, err := os.Stat(b.ExecutablePath); err != nil {
+			return nil, fmt.Errorf("unable to find bundle executable: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// layout returns the contents directory, the Info.plist path, and the directory holding the main executable.
+func layout(path string) (string, string, string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !fi.IsDir() {
+		return "", "", "", fmt.Errorf("not a directory: %q", path)
+	}
+
+	// deep bundle (macOS apps, plugins, etc): My.app/Contents/{Info.plist,MacOS/...}
+	contents := filepath.Join(path, "Contents")
+	if exists(filepath.Join(contents, "Info.plist")) {
+		return contents, filepath.Join(contents, "Info.plist"), filepath.Join(contents, "MacOS"), nil
+	}
+
+	// versioned framework: My.framework/Versions/Current/{Resources/Info.plist,My}
+	current := filepath.Join(path, "Versions", "Current")
+	if exists(filepath.Join(current, "Resources", "Info.plist")) {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err != nil {
+			return "", "", "", fmt.Errorf("unable to resolve current framework version: %w", err)
+		}
+		return resolved, filepath.Join(resolved, "Resources", "Info.plist"), resolved, nil
+	}
+
+	// shallow bundle (iOS style apps): My.app/{Info.plist,My}
+	if exists(filepath.Join(path, "Info.plist")) {
+		return path, filepath.Join(path, "Info.plist"), path, nil
+	}
+
+	// unversioned framework: My.framework/{Resources/Info.plist,My}
+	if exists(filepath.Join(path, "Resources", "Info.plist")) {
+		return path, filepath.Join(path, "Resources", "Info.plist"), path, nil
+	}
+
+	return "", "", "", fmt.Errorf("no Info.plist found for bundle %q", path)
+}
+
+// CodeResourcesPath is the path the sealed resources plist is written to.
+func (b Bundle) CodeResourcesPath() string {
+	return filepath.Join(b.ContentsDir, codeSignatureDir, codeResourcesFile)
+}
+
+// WriteCodeResources writes the sealed resources plist into the _CodeSignature directory of the bundle.
+func (b Bundle) WriteCodeResources(content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(b.CodeResourcesPath()), 0755); err != nil {
+		return fmt.Errorf("unable to create code signature directory: %w", err)
+	}
+	if err := os.WriteFile(b.CodeResourcesPath(), content, 0644); err != nil { //nolint:gosec // resources are world readable within a bundle
+		return fmt.Errorf("unable to write code resources: %w", err)
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,203 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInfoPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.example.hello</string>
+	<key>CFBundleExecutable</key>
+	<string>hello</string>
+</dict>
+</plist>
+`
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for p, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0600))
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name           string
+		files          map[string]string
+		wantContents   string
+		wantExecutable string
+		wantErr        require.ErrorAssertionFunc
+	}{
+		{
+			name: "deep bundle",
+			files: map[string]string{
+				"Contents/Info.plist":  testInfoPlist,
+				"Contents/MacOS/hello": "exe",
+			},
+			wantContents:   "Contents",
+			wantExecutable: "Contents/MacOS/hello",
+		},
+		{
+			name: "shallow bundle",
+			files: map[string]string{
+				"Info.plist": testInfoPlist,
+				"hello":      "exe",
+			},
+			wantContents:   ".",
+			wantExecutable: "hello",
+		},
+		{
+			name: "unversioned framework",
+			files: map[string]string{
+				"Resources/Info.plist": testInfoPlist,
+				"hello":                "exe",
+			},
+			wantContents:   ".",
+			wantExecutable: "hello",
+		},
+		{
+			name: "missing executable",
+			files: map[string]string{
+				"Contents/Info.plist": testInfoPlist,
+			},
+			wantErr: require.Error,
+		},
+		{
+			name: "not a bundle",
+			files: map[string]string{
+				"Contents/MacOS/hello": "exe",
+			},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			root := t.TempDir()
+			writeFiles(t, root, tt.files)
+
+			b, err := Open(root)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, "com.example.hello", b.Identifier)
+			assert.Equal(t, filepath.Join(root, tt.wantContents), b.ContentsDir)
+			assert.Equal(t, filepath.Join(root, tt.wantExecutable), b.ExecutablePath)
+			assert.True(t, IsBundle(root))
+		})
+	}
+}
+
+func TestRules_Match(t *testing.T) {
+	tests := []struct {
+		path         string
+		wantWeight   int
+		wantOmit     bool
+		wantOptional bool
+		wantNested   bool
+	}{
+		{path: "Info.plist", wantWeight: 20, wantOmit: true},
+		{path: "PkgInfo", wantWeight: 20, wantOmit: true},
+		{path: "Resources/icon.icns", wantWeight: 20},
+		{path: "Resources/en.lproj/Localizable.strings", wantWeight: 1000, wantOptional: true},
+		{path: "Resources/Base.lproj/Main.nib", wantWeight: 1010},
+		{path: "Resources/.DS_Store", wantWeight: 2000, wantOmit: true},
+		{path: "Frameworks/Foo.framework", wantWeight: 10, wantNested: true},
+		{path: "MacOS/helper", wantWeight: 10, wantNested: true},
+		{path: "embedded.provisionprofile", wantWeight: 20},
+		{path: "other/file.txt", wantWeight: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r, ok := DefaultRules2().Match(tt.path)
+			require.True(t, ok)
+			assert.Equal(t, tt.wantWeight, r.Weight)
+			assert.Equal(t, tt.wantOmit, r.Omit)
+			assert.Equal(t, tt.wantOptional, r.Optional)
+			assert.Equal(t, tt.wantNested, r.Nested)
+		})
+	}
+}
+
+func TestBundle_CodeResources(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"Contents/Info.plist":                        testInfoPlist,
+		"Contents/PkgInfo":                           "APPL????",
+		"Contents/MacOS/hello":                       "exe",
+		"Contents/Resources/data.txt":                "hello world",
+		"Contents/Resources/en.lproj/Strings.txt":    "strings",
+		"Contents/Resources/.DS_Store":               "junk",
+		"Contents/_CodeSignature/CodeResources":      "stale",
+		"Contents/Frameworks/Nested.app/Info.plist":  testInfoPlist,
+		"Contents/Frameworks/Nested.app/hello":       "nested exe",
+		"Contents/Frameworks/Nested.app/other.txt":   "not visited",
+		"Contents/Library/Something/not-nested.data": "data",
+	})
+	require.NoError(t, os.Symlink("data.txt", filepath.Join(root, "Contents", "Resources", "link.txt")))
+
+	b, err := Open(root)
+	require.NoError(t, err)
+
+	nested, err := b.NestedCode()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "Contents", "Frameworks", "Nested.app")}, nested)
+
+	var resolved []string
+	content, err := b.CodeResources(func(path string) (*NestedCode, error) {
+		resolved = append(resolved, path)
+		return &NestedCode{CDHash: []byte{0xde, 0xad}, Requirement: `identifier "com.example.hello"`}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, nested, resolved)
+
+	actual := string(content)
+
+	// sealed resources
+	for _, expected := range []string{
+		"<key>files</key>",
+		"<key>files2</key>",
+		"<key>rules</key>",
+		"<key>rules2</key>",
+		"<key>Resources/data.txt</key>",
+		"<key>Resources/en.lproj/Strings.txt</key>",
+		"<key>Library/Something/not-nested.data</key>",
+		"<key>Frameworks/Nested.app</key>",
+		"<key>cdhash</key>",
+		"<data>3q0=</data>",
+		"<string>identifier &#34;com.example.hello&#34;</string>",
+		"<key>Resources/link.txt</key>",
+		"<key>symlink</key>",
+		"<string>data.txt</string>",
+		// sha256 of "hello world"
+		"<data>uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=</data>",
+	} {
+		assert.Contains(t, actual, expected)
+	}
+
+	// content that must not be sealed
+	for _, unexpected := range []string{
+		"<key>Info.plist</key>",
+		"<key>PkgInfo</key>",
+		"<key>MacOS/hello</key>",
+		".DS_Store</key>",
+		"_CodeSignature",
+		"other.txt",
+	} {
+		assert.NotContains(t, actual, unexpected)
+	}
+}
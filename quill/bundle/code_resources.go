@@ -0,0 +1,219 @@
+package bundle
+
+import (
+	"crypto/sha1" //nolint: gosec // sha1 is required for the legacy "files" section
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"howett.net/plist"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+)
+
+// NestedCode describes separately signed code within a bundle, which is sealed by its code directory hash and
+// designated requirement instead of by the content hash of the file.
+type NestedCode struct {
+	CDHash      []byte
+	Requirement string
+}
+
+// NestedCodeResolver provides the signing details of code nested within a bundle (which must already be signed).
+type NestedCodeResolver func(path string) (*NestedCode, error)
+
+type entryKind int
+
+const (
+	fileEntry entryKind = iota
+	symlinkEntry
+	nestedCodeEntry
+)
+
+type entry struct {
+	kind    entryKind
+	relPath string
+	path    string
+	rule    Rule // the matching rules2 rule
+}
+
+// NestedCode returns the paths to all bundles and Mach-O files nested within the bundle, which must be signed before
+// the bundle itself is signed. Nested bundles are returned as-is (their contents are not searched).
+func (b Bundle) NestedCode() ([]string, error) {
+	entries, err := b.entries(DefaultRules2())
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if e.kind == nestedCodeEntry {
+			paths = append(paths, e.path)
+		}
+	}
+	return paths, nil
+}
+
+// CodeResources generates the content of the _CodeSignature/CodeResources plist, which seals all resources in the
+// bundle (other than the main executable). All nested code must already be signed.
+func (b Bundle) CodeResources(resolve NestedCodeResolver) ([]byte, error) {
+	rules := DefaultRules()
+	rules2 := DefaultRules2()
+
+	entries, err := b.entries(rules2)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]any)
+	files2 := make(map[string]any)
+
+	for _, e := range entries {
+		switch e.kind {
+		case nestedCodeEntry:
+			nested, err := resolve(e.path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to describe nested code %q: %w", e.relPath, err)
+			}
+			files2[e.relPath] = map[string]any{
+				"cdhash":      nested.CDHash,
+				"requirement": nested.Requirement,
+			}
+
+		case symlinkEntry:
+			target, err := os.Readlink(e.path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read symlink %q: %w", e.relPath, err)
+			}
+			files2[e.relPath] = map[string]any{
+				"symlink": target,
+			}
+
+		case fileEntry:
+			sha1Digest, sha256Digest, err := digestFile(e.path)
+			if err != nil {
+				return nil, err
+			}
+
+			v2 := map[string]any{
+				"hash":  sha1Digest,
+				"hash2": sha256Digest,
+			}
+			if e.rule.Optional {
+				v2["optional"] = true
+			}
+			files2[e.relPath] = v2
+
+			// the legacy section only covers files selected by the legacy rules
+			if r, ok := rules.Match(e.relPath); ok && !r.Omit {
+				if r.Optional {
+					files[e.relPath] = map[string]any{
+						"hash":     sha1Digest,
+						"optional": true,
+					}
+				} else {
+					files[e.relPath] = sha1Digest
+				}
+			}
+		}
+	}
+
+	content := map[string]any{
+		"files":  files,
+		"files2": files2,
+		"rules":  rules.plistValue(),
+		"rules2": rules2.plistValue(),
+	}
+
+	by, err := plist.MarshalIndent(content, plist.XMLFormat, "\t")
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode code resources: %w", err)
+	}
+	return by, nil
+}
+
+// entries walks the bundle contents and returns all entries that should be sealed (in lexical order).
+func (b Bundle) entries(rules2 Rules) ([]entry, error) {
+	var entries []entry
+	err := filepath.WalkDir(b.ContentsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.ContentsDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel == "." {
+			return nil
+		}
+
+		if b.isExcluded(path, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rule, ok := rules2.Match(rel)
+		if !ok || rule.Omit {
+			return nil
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			entries = append(entries, entry{kind: symlinkEntry, relPath: rel, path: path, rule: rule})
+		case d.IsDir():
+			if rule.Nested && IsBundle(path) {
+				entries = append(entries, entry{kind: nestedCodeEntry, relPath: rel, path: path, rule: rule})
+				return filepath.SkipDir
+			}
+		case d.Type().IsRegular():
+			kind := fileEntry
+			if rule.Nested && isMachoFile(path) {
+				kind = nestedCodeEntry
+			}
+			entries = append(entries, entry{kind: kind, relPath: rel, path: path, rule: rule})
+		default:
+			log.WithFields("path", path).Debug("skipping irregular file within bundle")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk bundle contents: %w", err)
+	}
+	return entries, nil
+}
+
+func (b Bundle) isExcluded(path, rel string) bool {
+	switch rel {
+	case codeSignatureDir, "CodeResources":
+		return true
+	}
+	return b.ExecutablePath != "" && filepath.Clean(path) == filepath.Clean(b.ExecutablePath)
+}
+
+func isMachoFile(path string) bool {
+	isMacho, err := macho.IsMachoFile(path)
+	return err == nil && isMacho
+}
+
+func digestFile(path string) ([]byte, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open resource: %w", err)
+	}
+	defer f.Close()
+
+	h1 := sha1.New() //nolint: gosec
+	h2 := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h2), f); err != nil {
+		return nil, nil, fmt.Errorf("unable to hash resource %q: %w", path, err)
+	}
+	return h1.Sum(nil), h2.Sum(nil), nil
+}
@@ -0,0 +1,106 @@
+package bundle
+
+import (
+	"regexp"
+)
+
+// Rule is a resource rule as found within the "rules" and "rules2" sections of a CodeResources file.
+// Definitions From: https://github.com/apple-oss-distributions/Security/blob/main/OSX/libsecurity_codesigning/lib/resources.cpp
+type Rule struct {
+	Pattern  string
+	Omit     bool // the file is not sealed
+	Optional bool // the file may be absent without invalidating the signature
+	Nested   bool // the file may be nested code (signed separately and sealed by cdhash)
+	Weight   int  // rules with a higher weight take precedence
+
+	regex *regexp.Regexp
+}
+
+func newRule(pattern string, weight int, omit, optional, nested bool) Rule {
+	return Rule{
+		Pattern:  pattern,
+		Omit:     omit,
+		Optional: optional,
+		Nested:   nested,
+		Weight:   weight,
+		regex:    regexp.MustCompile(pattern),
+	}
+}
+
+// plistValue returns the representation of the rule as written within a CodeResources file.
+func (r Rule) plistValue() any {
+	if !r.Omit && !r.Optional && !r.Nested && r.Weight <= 1 {
+		return true
+	}
+
+	v := map[string]any{}
+	if r.Omit {
+		v["omit"] = true
+	}
+	if r.Optional {
+		v["optional"] = true
+	}
+	if r.Nested {
+		v["nested"] = true
+	}
+	if r.Weight > 1 {
+		v["weight"] = float64(r.Weight)
+	}
+	return v
+}
+
+type Rules []Rule
+
+// Match returns the highest weighted rule matching the given path (relative to the bundle contents directory).
+func (rs Rules) Match(relPath string) (Rule, bool) {
+	var best Rule
+	var found bool
+	for _, r := range rs {
+		if !r.regex.MatchString(relPath) {
+			continue
+		}
+		if !found || r.Weight > best.Weight {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (rs Rules) plistValue() map[string]any {
+	v := make(map[string]any)
+	for _, r := range rs {
+		v[r.Pattern] = r.plistValue()
+	}
+	return v
+}
+
+// DefaultRules are the legacy (v1) resource rules that codesign applies to macOS bundles.
+func DefaultRules() Rules {
+	return Rules{
+		newRule(`^Resources/`, 1, false, false, false),
+		newRule(`^Resources/.*\.lproj/`, 1000, false, true, false),
+		newRule(`^Resources/.*\.lproj/locversion.plist$`, 1100, true, false, false),
+		newRule(`^Resources/Base\.lproj/`, 1010, false, false, false),
+		newRule(`^version.plist$`, 1, false, false, false),
+	}
+}
+
+// DefaultRules2 are the (v2) resource rules that codesign applies to macOS bundles.
+func DefaultRules2() Rules {
+	return Rules{
+		newRule(`.*\.dSYM($|/)`, 11, false, false, false),
+		newRule(`^(.*/)?\.DS_Store$`, 2000, true, false, false),
+		newRule(`^(Frameworks|SharedFrameworks|PlugIns|Plug-ins|XPCServices|Helpers|MacOS|Library/(Automator|Spotlight|LoginItems))/`, 10, false, false, true),
+		newRule(`^.*`, 1, false, false, false),
+		newRule(`^Info\.plist$`, 20, true, false, false),
+		newRule(`^PkgInfo$`, 20, true, false, false),
+		newRule(`^Resources/`, 20, false, false, false),
+		newRule(`^Resources/.*\.lproj/`, 1000, false, true, false),
+		newRule(`^Resources/.*\.lproj/locversion.plist$`, 1100, true, false, false),
+		newRule(`^Resources/Base\.lproj/`, 1010, false, false, false),
+		newRule(`^[^/]+$`, 10, false, false, true),
+		newRule(`^embedded\.provisionprofile$`, 20, false, false, false),
+		newRule(`^version\.plist$`, 20, false, false, false),
+	}
+}
@@ -38,8 +38,8 @@ func (d SectionDigest) String() string {
 }
 
 func getCodeDirectories(m File) (cdObjs []CodeDirectoryDetails) {
-	for idx, cd := range m.blacktopFile.CodeSignature().CodeDirectories {
-		b, err := m.internalFile.CDBytes(macho.SigningOrder, idx)
+	for idx, cd := range m.codeSignature.CodeDirectories {
+		b, err := m.signature.CDBytes(macho.SigningOrder, idx)
 		if err != nil {
 			// TODO
 			panic(err)
@@ -100,7 +100,7 @@ func getCodeDirectories(m File) (cdObjs []CodeDirectoryDetails) {
 				},
 				Flags: DescribedValue{
 					Value:       cd.Header.Flags,
-					Description: cd.Header.Flags.String(),
+					Description: fmt.Sprintf("%#x (%s)", uint32(cd.Header.Flags), cd.Header.Flags.String()),
 				},
 			},
 		)
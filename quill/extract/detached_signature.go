@@ -0,0 +1,15 @@
+package extract
+
+type DetachedSignatureDetails struct {
+	CPU  string `json:"cpu"`
+	Size int    `json:"size"`
+}
+
+func (d DetachedSignatureDetails) String() (r string) {
+	return tprintf(
+		`CPU:          {{.CPU}}
+Size:         {{.Size}} bytes
+`,
+		d,
+	)
+}
@@ -1,9 +1,6 @@
 package extract
 
 import (
-	"crypto"
-	"encoding/base64"
-	"encoding/hex"
 	"fmt"
 
 	blacktopMacho "github.com/blacktop/go-macho"
@@ -13,14 +10,21 @@ import (
 )
 
 type Details struct {
-	File MachoDetails `json:"file"`
+	File *MachoDetails `json:"file,omitempty"`
+	// DetachedSignature is set when describing a detached signature (instead of a binary)
+	DetachedSignature *DetachedSignatureDetails `json:"detachedSignature,omitempty"`
 	// TODO: raw superblob info
 	SuperBlob *SuperBlobDetails `json:"superBlob,omitempty"`
 	// TODO: helper output to show if the binary is signed or not?
 }
 
 func (d Details) String(hideVerboseData bool) (r string) {
-	r += "File Details:\n" + doIndent(d.File.String(), "  ")
+	if d.File != nil {
+		r += "File Details:\n" + doIndent(d.File.String(), "  ")
+	}
+	if d.DetachedSignature != nil {
+		r += "Detached Signature:\n" + doIndent(d.DetachedSignature.String(), "  ")
+	}
 	if d.SuperBlob == nil {
 		r += "\nNo superblock found (this binary is not signed)\n"
 	} else {
@@ -44,14 +48,20 @@ func (d Details) String(hideVerboseData bool) (r string) {
 }
 
 type File struct {
-	blacktopFile *blacktopMacho.File
-	internalFile *macho.File
+	blacktopFile *blacktopMacho.File // nil when describing a detached signature
+	// codeSignature is the parsed code signature (nil when the binary is not signed)
+	codeSignature *blacktopMacho.CodeSignature
+	// signature is the raw code signature superblob (nil when the binary is not signed)
+	signature *macho.CodeSignature
+	// detached describes the detached signature file the signature was read from (if any)
+	detached *DetachedSignatureDetails
 }
 
 func ParseDetails(m File) Details {
 	return Details{
-		File:      getMachoDetails(m),
-		SuperBlob: getSuperBlobDetails(m),
+		File:              getMachoDetails(m),
+		DetachedSignature: m.detached,
+		SuperBlob:         getSuperBlobDetails(m),
 	}
 }
 
@@ -61,10 +71,10 @@ func getSignatures(m File) []SignatureDetails {
 		log.Warn("unable to get blob details for file: %v", err)
 	}
 
-	superBlob := m.blacktopFile.CodeSignature()
+	superBlob := m.codeSignature
 
 	// TODO: support multiple CDs
-	cdBytes, err := m.internalFile.CDBytes(macho.SigningOrder, 0)
+	cdBytes, err := m.signature.CDBytes(macho.SigningOrder, 0)
 	if err != nil {
 		log.Warn("unable to get code directory: %v", err)
 	}
@@ -76,21 +86,11 @@ func getSignatures(m File) []SignatureDetails {
 }
 
 func getBlobDetails(m File) (BlobDetails, error) {
-	b, err := m.internalFile.CMSBlobBytes(macho.SigningOrder)
+	b, err := m.signature.CMSBlobBytes(macho.SigningOrder)
 	if err != nil {
 		log.Warn("unable to find any signatures: %v", err)
 		return BlobDetails{}, err
 	}
 
-	hashObj := crypto.SHA256
-	hasher := hashObj.New()
-	hasher.Write(b)
-	hash := hasher.Sum(nil)
-	return BlobDetails{
-		Base64: base64.StdEncoding.EncodeToString(b),
-		Digest: Digest{
-			Algorithm: algorithmName(hashObj),
-			Value:     hex.EncodeToString(hash),
-		},
-	}, nil
+	return newBlobDetails(b), nil
 }
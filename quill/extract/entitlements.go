@@ -1,23 +1,70 @@
 package extract
 
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"howett.net/plist"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
+)
+
 type EntitlementDetails struct {
 	Blob            BlobDetails `json:"blob"`
 	Entitlements    string      `json:"entitlements,omitempty"`
 	EntitlementsDER []byte      `json:"entitlements_der,omitempty"`
+	// EntitlementsDERDecoded is the dictionary decoded from the DER entitlements slot
+	EntitlementsDERDecoded map[string]any `json:"entitlements_der_decoded,omitempty"`
 }
 
 func getEntitlements(m File) *EntitlementDetails {
-	entitlements := m.blacktopFile.CodeSignature().Entitlements
-	entitlementsDER := m.blacktopFile.CodeSignature().EntitlementsDER
+	entitlements := m.codeSignature.Entitlements
+	entitlementsDER := m.codeSignature.EntitlementsDER
 	if entitlements == "" && entitlementsDER == nil {
 		return nil
 	}
-	return &EntitlementDetails{
+
+	details := &EntitlementDetails{
 		Entitlements:    entitlements,
 		EntitlementsDER: entitlementsDER,
 	}
+
+	if b, err := m.signature.BlobBytes(macho.SigningOrder, macho.CsSlotEntitlements); err == nil {
+		details.Blob = newBlobDetails(b)
+	} else if !errors.Is(err, macho.ErrNoBlob) {
+		log.Warnf("unable to get entitlements blob: %v", err)
+	}
+
+	if entitlementsDER != nil {
+		decoded, err := sign.DecodeEntitlementsDER(entitlementsDER)
+		if err != nil {
+			log.Warnf("unable to decode DER entitlements: %v", err)
+		}
+		details.EntitlementsDERDecoded = decoded
+	}
+
+	return details
 }
 
 func (e EntitlementDetails) String() string {
-	return e.Entitlements
+	var sections []string
+	if e.Entitlements != "" {
+		sections = append(sections, strings.TrimSpace(e.Entitlements))
+	}
+
+	if e.EntitlementsDERDecoded != nil {
+		var buf bytes.Buffer
+		enc := plist.NewEncoderForFormat(&buf, plist.XMLFormat)
+		enc.Indent("\t")
+		if err := enc.Encode(e.EntitlementsDERDecoded); err != nil {
+			log.Warnf("unable to format DER entitlements: %v", err)
+		} else {
+			sections = append(sections, "DER:\n"+doIndent(strings.TrimSpace(buf.String()), "  "))
+		}
+	}
+
+	return strings.Join(sections, "\n") + "\n"
 }
@@ -1,15 +1,17 @@
 package extract
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"path"
 
 	blacktopMacho "github.com/blacktop/go-macho"
+	"github.com/blacktop/go-macho/pkg/codesign"
+	"github.com/blacktop/go-macho/types"
 
-	macholibre "github.com/anchore/go-macholibre"
 	"github.com/anchore/quill/internal/utils"
 	"github.com/anchore/quill/quill/macho"
 )
@@ -21,55 +23,117 @@ func NewFile(binPath string) ([]*File, error) {
 	}
 	defer f.Close()
 
-	if macholibre.IsUniversalMachoBinary(f) {
-		var mfs []*File
+	if isDetachedSignature(f) {
+		return newDetachedSignatureFiles(binPath)
+	}
 
-		dir, err := os.MkdirTemp("", "quill-extract-"+path.Base(binPath))
+	if macho.IsUniversalBinary(f) {
+		slices, _, err := macho.ReadFatFileSlices(binPath)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create temp directory to extract multi-arch binary: %w", err)
+			return nil, fmt.Errorf("unable to read multi-arch binary: %w", err)
 		}
-		defer os.RemoveAll(dir)
 
-		efs, err := macholibre.Extract(f, dir)
-		if err != nil {
-			return nil, fmt.Errorf("unable to extract multi-arch binary: %w", err)
-		}
-		for _, ef := range efs {
-			mf, err := newFile(ef.Path)
+		var mfs []*File
+		for _, s := range slices {
+			mf, err := newFile(bytes.NewReader(s.File.Bytes()), s.File)
 			if err != nil {
-				return nil, fmt.Errorf("unable to parse extracted multi-arch binary: %w", err)
+				return nil, fmt.Errorf("unable to parse %s slice of multi-arch binary: %w", s.Arch.Name(), err)
 			}
 			mfs = append(mfs, mf)
 		}
 		return mfs, nil
 	}
 
-	mf, err := newFile(binPath)
+	internalFile, err := macho.NewReadOnlyFile(binPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse macho formatted file: %w", err)
+	}
+	defer internalFile.Close()
+
+	mf, err := newFile(f, internalFile)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse single-arch binary: %w", err)
 	}
 	return []*File{mf}, nil
 }
 
-func newFile(path string) (*File, error) {
-	f, err := os.Open(path) // path is not from untrusted input
+// newFile describes a single-architecture binary, given both the raw content and the parsed binary.
+func newFile(r io.ReaderAt, internalFile *macho.File) (*File, error) {
+	blacktopMachoFile, err := blacktopMacho.NewFile(r)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open file: %w", err)
+		return nil, fmt.Errorf("unable to parse macho formatted file with blacktop: %w", err)
+	}
+
+	mf := &File{
+		blacktopFile:  blacktopMachoFile, // has several stringer helpers for common enum values
+		codeSignature: blacktopMachoFile.CodeSignature(),
+	}
+
+	if internalFile.HasCodeSigningCmd() {
+		// the internal representation has the ability to extract raw CD bytes
+		mf.signature, err = internalFile.CodeSignature()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read code signature: %w", err)
+		}
+	}
+
+	return mf, nil
+}
+
+// isDetachedSignature indicates if the file is a detached signature (as written by "quill sign --detached") instead
+// of a Mach-O binary.
+func isDetachedSignature(f *os.File) bool {
+	defer f.Seek(0, io.SeekStart) //nolint:errcheck
+
+	var magic uint32
+	if err := binary.Read(f, macho.SigningOrder, &magic); err != nil {
+		return false
 	}
-	blacktopMachoFile, err := blacktopMacho.NewFile(f)
+
+	switch macho.Magic(magic) {
+	case macho.MagicEmbeddedSignature, macho.MagicDetachedSignature:
+		return true
+	}
+	return false
+}
+
+func newDetachedSignatureFiles(path string) ([]*File, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse macho formatted file with blacktop: %w", err)
+		return nil, fmt.Errorf("unable to read detached signature: %w", err)
 	}
 
-	internalFile, err := macho.NewReadOnlyFile(path)
+	sigs, err := macho.ParseDetachedSignature(b)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse macho formatted file: %w", err)
+		return nil, err
 	}
 
-	return &File{
-		blacktopFile: blacktopMachoFile, // has several stringer helpers for common enum values
-		internalFile: internalFile,      // has the ability to extract raw CD bytes
-	}, nil
+	var mfs []*File
+	for _, s := range sigs {
+		raw := s.Signature.Bytes()
+		cs, err := codesign.ParseCodeSignature(raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse detached signature: %w", err)
+		}
+
+		cpu := "unknown (thin binary)"
+		if s.Cpu != 0 {
+			cpu = types.CPU(s.Cpu).String()
+		}
+
+		mfs = append(mfs, &File{
+			codeSignature: &blacktopMacho.CodeSignature{
+				CodeSignatureCmd: types.CodeSignatureCmd{Size: uint32(len(raw))},
+				CodeSignature:    *cs,
+			},
+			signature: s.Signature,
+			detached: &DetachedSignatureDetails{
+				CPU:  cpu,
+				Size: len(raw),
+			},
+		})
+	}
+	return mfs, nil
 }
 
 func ShowJSON(path string, writer io.Writer) error {
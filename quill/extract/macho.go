@@ -17,13 +17,17 @@ type MachoDetails struct {
 	UUID             string   `json:"uuid"`
 }
 
-func getMachoDetails(m File) MachoDetails {
+func getMachoDetails(m File) *MachoDetails {
+	if m.blacktopFile == nil {
+		return nil
+	}
+
 	var uuidStr string
 	uuidVal := m.blacktopFile.UUID()
 	if uuidVal != nil {
 		uuidStr = uuidVal.String()
 	}
-	return MachoDetails{
+	return &MachoDetails{
 		Magic:            m.blacktopFile.Magic.String(),
 		Type:             m.blacktopFile.Type.String(),
 		CPU:              m.blacktopFile.CPU.String(),
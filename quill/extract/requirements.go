@@ -1,42 +1,66 @@
 package extract
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
-	"github.com/blacktop/go-macho/pkg/codesign/types"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
 )
 
 type RequirementDetails struct {
-	Blob         BlobDetails         `json:"blob"`
-	Requirements []types.Requirement `json:"requirements"`
+	Blob         BlobDetails   `json:"blob"`
+	Requirements []Requirement `json:"requirements"`
+}
+
+type Requirement struct {
+	Type DescribedValue `json:"type"`
+	// Detail is the requirement expression in Apple's requirement language
+	Detail string `json:"detail"`
 }
 
 func getRequirements(m File) []RequirementDetails {
-	return []RequirementDetails{
-		{
-			// TODO: extract blob
-			Requirements: m.blacktopFile.CodeSignature().Requirements,
-		},
+	b, err := m.signature.BlobBytes(macho.SigningOrder, macho.CsSlotRequirements)
+	if err != nil {
+		if !errors.Is(err, macho.ErrNoBlob) {
+			log.Warnf("unable to get requirements blob: %v", err)
+		}
+		return nil
+	}
+
+	details := RequirementDetails{
+		Blob: newBlobDetails(b),
+	}
+
+	reqs, err := sign.DecompileRequirements(b)
+	if err != nil {
+		log.Warnf("unable to decompile requirements: %v", err)
 	}
+
+	for _, req := range reqs {
+		details.Requirements = append(details.Requirements, Requirement{
+			Type: DescribedValue{
+				Value:       req.Type,
+				Description: req.Type.String(),
+			},
+			Detail: req.Text,
+		})
+	}
+
+	return []RequirementDetails{details}
 }
 
 func (r RequirementDetails) String() string {
+	if len(r.Requirements) == 0 {
+		return "(none)\n"
+	}
+
 	var reqs []string
-	for idx, req := range r.Requirements {
-		reqs = append(reqs, fmt.Sprintf("Req %d (type=%s): %s", idx, req.Type, req.Detail))
+	for _, req := range r.Requirements {
+		reqs = append(reqs, fmt.Sprintf("%s => %s", req.Type.Description, req.Detail))
 	}
 
-	return tprintf(
-		`{{.FormattedRequirements}}
-`,
-		struct {
-			RequirementDetails
-			RequirementsCount     string
-			FormattedRequirements string
-		}{
-			RequirementDetails:    r,
-			FormattedRequirements: strings.Join(reqs, "\n"),
-		},
-	)
+	return strings.Join(reqs, "\n") + "\n"
 }
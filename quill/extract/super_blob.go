@@ -10,7 +10,7 @@ type SuperBlobDetails struct {
 }
 
 func getSuperBlobDetails(m File) *SuperBlobDetails {
-	signingLoadCmd := m.blacktopFile.CodeSignature()
+	signingLoadCmd := m.codeSignature
 	if signingLoadCmd == nil {
 		return nil
 	}
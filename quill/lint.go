@@ -0,0 +1,88 @@
+package quill
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+)
+
+// LintReport is the result of checking the structure of a binary (each slice of a universal binary is checked).
+type LintReport struct {
+	Path   string     `json:"path"`
+	Arches []LintArch `json:"arches"`
+}
+
+// LintArch holds the findings for a single architecture of a binary.
+type LintArch struct {
+	Arch     string              `json:"arch"`
+	Findings []macho.LintFinding `json:"findings"`
+}
+
+// Errors returns the number of findings that prevent the binary from being signed.
+func (r LintReport) Errors() int {
+	var count int
+	for _, a := range r.Arches {
+		count += len(macho.LintErrors(a.Findings))
+	}
+	return count
+}
+
+func (r LintReport) String() string {
+	var sb strings.Builder
+	for _, a := range r.Arches {
+		if len(a.Findings) == 0 {
+			fmt.Fprintf(&sb, "%s (%s): no problems found\n", r.Path, a.Arch)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s (%s):\n", r.Path, a.Arch)
+		for _, f := range a.Findings {
+			fmt.Fprintf(&sb, "  %s\n", f)
+		}
+	}
+	return sb.String()
+}
+
+// Lint checks the structure of the given binary (thin or universal) for problems that would prevent it from being
+// signed correctly, along with problems worth knowing about before signing (see macho.File.Lint).
+func Lint(path string) (*LintReport, error) {
+	files, err := macho.OpenThinFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lint %q: %w", path, err)
+	}
+
+	report := LintReport{Path: path}
+	for _, m := range files {
+		findings := m.Lint()
+		if findings == nil {
+			findings = []macho.LintFinding{}
+		}
+		report.Arches = append(report.Arches, LintArch{
+			Arch:     macho.ArchName(m.Cpu, m.SubCpu),
+			Findings: findings,
+		})
+		m.Close()
+	}
+	return &report, nil
+}
+
+// lintBeforeSigning fails with the structural errors found with the binary (which would otherwise surface as obscure
+// failures within signing or as a corrupted binary), logging any other findings.
+func lintBeforeSigning(m *macho.File) error {
+	var errs []string
+	for _, f := range m.Lint() {
+		switch f.Severity {
+		case macho.LintError:
+			errs = append(errs, f.Message)
+		case macho.LintWarning:
+			log.WithFields("check", f.Check).Warn(f.Message)
+		default:
+			log.WithFields("check", f.Check).Debug(f.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("binary cannot be signed: %s (see 'quill lint' for details)", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package quill
+
+import (
+	"debug/macho"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+	quillMacho "github.com/anchore/quill/quill/macho"
+)
+
+func TestLint(t *testing.T) {
+	armv7 := test.Macho32(t, macho.CpuArm, 0x100)
+	// trailing data after __LINKEDIT would be overwritten by the signature
+	f, err := os.OpenFile(armv7, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("trailing")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	universal := writeUniversalBinary(t, test.Macho32(t, macho.Cpu386, 0x100), armv7)
+
+	report, err := Lint(universal)
+	require.NoError(t, err)
+	require.Len(t, report.Arches, 2)
+	assert.Equal(t, "i386", report.Arches[0].Arch)
+	assert.Equal(t, "armv7", report.Arches[1].Arch)
+	assert.Equal(t, 1, report.Errors())
+
+	var checks []quillMacho.LintCheck
+	for _, f := range report.Arches[1].Findings {
+		checks = append(checks, f.Check)
+	}
+	assert.Equal(t, []quillMacho.LintCheck{quillMacho.LintLinkeditNotLast, quillMacho.LintMissingBuildVersion}, checks)
+	assert.Contains(t, report.String(), "(armv7):\n  error: __LINKEDIT ends at file offset")
+
+	// signing fails up front rather than corrupting the binary
+	cfg, err := NewSigningConfigFromPEMs(universal, "", "", "", false)
+	require.NoError(t, err)
+	assert.ErrorContains(t, Sign(*cfg), "binary cannot be signed: __LINKEDIT ends at file offset")
+
+	_, err = Lint(t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,416 @@
+package quill
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+)
+
+// LipoConfig configures how a binary is written when creating, thinning or removing architectures from universal
+// binaries (see LipoCreate, LipoThin and LipoRemove). Slices are copied as-is, so existing signatures are preserved.
+type LipoConfig struct {
+	// OutputPath is where the resulting binary is written
+	OutputPath string
+	// Alignments sets the alignment of slices (as a power of 2) by architecture name, replacing the alignment of the
+	// input (default is to keep the alignment of slices from universal binaries, and the page size of the platform
+	// for thin binaries)
+	Alignments map[string]uint32
+	// Fat64 writes a FAT_MAGIC_64 header (default is to write one only when an input has one, or when a slice is
+	// beyond 4 GB)
+	Fat64 bool
+}
+
+func NewLipoConfig(outputPath string) *LipoConfig {
+	return &LipoConfig{
+		OutputPath: outputPath,
+	}
+}
+
+// WithAlignment sets the alignment of the slice for the given architecture (as a power of 2).
+func (c *LipoConfig) WithAlignment(arch string, align uint32) *LipoConfig {
+	if c.Alignments == nil {
+		c.Alignments = make(map[string]uint32)
+	}
+	c.Alignments[arch] = align
+	return c
+}
+
+// WithFat64 sets if a FAT_MAGIC_64 header is always written.
+func (c *LipoConfig) WithFat64(fat64 bool) *LipoConfig {
+	c.Fat64 = fat64
+	return c
+}
+
+// ParseLipoAlignment parses an alignment in the form "ARCH=ALIGN" (e.g. "arm64=0x4000"), where the alignment is a
+// power of 2 in bytes (as with "lipo -segalign"), returning the architecture and the alignment as a power of 2.
+func ParseLipoAlignment(value string) (string, uint32, error) {
+	arch, alignValue, ok := strings.Cut(value, "=")
+	if !ok || arch == "" {
+		return "", 0, fmt.Errorf("invalid alignment %q (expected ARCH=ALIGN)", value)
+	}
+
+	align, err := strconv.ParseUint(alignValue, 0, 64)
+	if err != nil || align == 0 || bits.OnesCount64(align) != 1 {
+		return "", 0, fmt.Errorf("invalid alignment %q for %s (must be a power of 2)", alignValue, arch)
+	}
+	return arch, uint32(bits.TrailingZeros64(align)), nil
+}
+
+// LipoArch describes a single architecture of a binary.
+type LipoArch struct {
+	Arch       string `json:"arch"`
+	CPUType    uint32 `json:"cpuType"`
+	CPUSubtype uint32 `json:"cpuSubtype"`
+	// Offset, Size and Align describe the slice within a universal binary (Offset and Align are zero for a thin binary)
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+	Align  uint32 `json:"align"`
+	Signed bool   `json:"signed"`
+	ID     string `json:"id,omitempty"`
+	TeamID string `json:"teamID,omitempty"`
+}
+
+// LipoDescription describes the architectures of a binary (thin or universal).
+type LipoDescription struct {
+	Path      string     `json:"path"`
+	Universal bool       `json:"universal"`
+	Fat64     bool       `json:"fat64"`
+	Arches    []LipoArch `json:"arches"`
+}
+
+func (d LipoDescription) String() string {
+	var sb strings.Builder
+
+	switch {
+	case !d.Universal:
+		fmt.Fprintf(&sb, "%s: thin binary\n", d.Path)
+	case d.Fat64:
+		fmt.Fprintf(&sb, "%s: universal binary (fat64) with %d architecture(s)\n", d.Path, len(d.Arches))
+	default:
+		fmt.Fprintf(&sb, "%s: universal binary with %d architecture(s)\n", d.Path, len(d.Arches))
+	}
+
+	for _, a := range d.Arches {
+		signature := "unsigned"
+		if a.Signed {
+			signature = fmt.Sprintf("id=%q team-id=%q", a.ID, a.TeamID)
+		}
+		if d.Universal {
+			fmt.Fprintf(&sb, "  %s: offset=%d size=%d align=2^%d %s\n", a.Arch, a.Offset, a.Size, a.Align, signature)
+		} else {
+			fmt.Fprintf(&sb, "  %s: size=%d %s\n", a.Arch, a.Size, signature)
+		}
+	}
+	return sb.String()
+}
+
+// LipoInfo describes the architectures of the given binary (as "lipo -detailed_info" does).
+func LipoInfo(path string) (*LipoDescription, error) {
+	slices, magic, err := readLipoSlices(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := LipoDescription{
+		Path:      path,
+		Universal: magic != 0,
+		Fat64:     magic == macho.MagicFat64,
+	}
+	for _, s := range slices {
+		id, teamID, signed, err := signatureIdentity(s.file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read signature of %s slice: %w", s.arch.Name(), err)
+		}
+		d.Arches = append(d.Arches, LipoArch{
+			Arch:       s.arch.Name(),
+			CPUType:    uint32(s.arch.Cpu),
+			CPUSubtype: s.arch.SubCpu,
+			Offset:     s.arch.Offset,
+			Size:       s.arch.Size,
+			Align:      s.arch.Align,
+			Signed:     signed,
+			ID:         id,
+			TeamID:     teamID,
+		})
+	}
+	return &d, nil
+}
+
+// LipoThin writes the slice for the given architecture of a universal binary as a thin binary.
+func LipoThin(path, arch string, cfg LipoConfig) error {
+	slices, magic, err := readLipoSlices(path)
+	if err != nil {
+		return err
+	}
+	if magic == 0 {
+		return fmt.Errorf("%q is not a universal binary", path)
+	}
+
+	for _, s := range slices {
+		if s.arch.Name() == arch {
+			return writeLipoOutput(path, cfg.OutputPath, func(f *os.File) error {
+				_, err := f.Write(s.file.Bytes())
+				return err
+			})
+		}
+	}
+	return fmt.Errorf("%q has no %s slice (has %s)", path, arch, lipoArchNames(slices))
+}
+
+// LipoRemove writes a universal binary without the slices for the given architectures.
+func LipoRemove(path string, arches []string, cfg LipoConfig) error {
+	slices, magic, err := readLipoSlices(path)
+	if err != nil {
+		return err
+	}
+	if magic == 0 {
+		return fmt.Errorf("%q is not a universal binary", path)
+	}
+
+	remove := make(map[string]bool)
+	for _, arch := range arches {
+		remove[arch] = true
+	}
+
+	var kept []lipoSlice
+	for _, s := range slices {
+		if remove[s.arch.Name()] {
+			delete(remove, s.arch.Name())
+			continue
+		}
+		kept = append(kept, s)
+	}
+
+	for _, arch := range arches {
+		if remove[arch] {
+			return fmt.Errorf("%q has no %s slice (has %s)", path, arch, lipoArchNames(slices))
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("unable to remove every architecture from %q", path)
+	}
+
+	return writeLipoSlices(path, kept, cfg, cfg.Fat64 || magic == macho.MagicFat64)
+}
+
+// LipoCreate writes a universal binary holding every architecture of the given binaries (thin or universal). Signed
+// slices must share the same identity and team ID, since the architectures are otherwise signed as different code.
+func LipoCreate(paths []string, cfg LipoConfig) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no binaries to create a universal binary from")
+	}
+
+	fat64 := cfg.Fat64
+	var slices []lipoSlice
+	sources := make(map[string]string)
+	for _, p := range paths {
+		s, magic, err := readLipoSlices(p)
+		if err != nil {
+			return err
+		}
+		for _, slice := range s {
+			if other, ok := sources[slice.arch.Name()]; ok {
+				return fmt.Errorf("%q and %q both have an architecture of %s", other, p, slice.arch.Name())
+			}
+			sources[slice.arch.Name()] = p
+		}
+		slices = append(slices, s...)
+		fat64 = fat64 || magic == macho.MagicFat64
+	}
+
+	if err := checkLipoSignatures(slices); err != nil {
+		return err
+	}
+
+	return writeLipoSlices(paths[0], slices, cfg, fat64)
+}
+
+// lipoSlice is a single architecture from a binary, along with the binary it was read from.
+type lipoSlice struct {
+	arch   macho.FatArch
+	file   *macho.File
+	source string
+}
+
+// readLipoSlices reads each architecture of the given binary into memory, returning the magic number of the universal
+// binary (or zero for a thin binary).
+func readLipoSlices(path string) ([]lipoSlice, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	universal := macho.IsUniversalBinary(f)
+	f.Close()
+
+	if universal {
+		fatSlices, magic, err := macho.ReadFatFileSlices(path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to read universal binary %q: %w", path, err)
+		}
+
+		var slices []lipoSlice
+		for _, s := range fatSlices {
+			slices = append(slices, lipoSlice{arch: s.Arch, file: s.File, source: path})
+		}
+		return slices, magic, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m, err := macho.NewFileFromBytes(b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to parse %q: %w", path, err)
+	}
+
+	arch := macho.FatArch{Cpu: m.Cpu, SubCpu: m.SubCpu, Size: uint64(len(b))}
+	return []lipoSlice{{arch: arch, file: m, source: path}}, 0, nil
+}
+
+// signatureIdentity returns the identity and team ID of the code directory of the given binary, where signed is false
+// when the binary is not signed.
+func signatureIdentity(m *macho.File) (id, teamID string, signed bool, err error) {
+	if !m.HasCodeSigningCmd() {
+		return "", "", false, nil
+	}
+
+	cdBytes, err := m.CDBytes(macho.SigningOrder, 0)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	cd, err := macho.ParseCodeDirectory(cdBytes)
+	if err != nil {
+		return "", "", false, err
+	}
+	return cd.ID, cd.TeamID, true, nil
+}
+
+// checkLipoSignatures ensures that the signed slices share the same identity and team ID.
+func checkLipoSignatures(slices []lipoSlice) error {
+	var first *lipoSlice
+	var firstID, firstTeamID string
+	var unsigned []string
+	for i, s := range slices {
+		id, teamID, signed, err := signatureIdentity(s.file)
+		if err != nil {
+			return fmt.Errorf("unable to read signature of %s slice from %q: %w", s.arch.Name(), s.source, err)
+		}
+		if !signed {
+			unsigned = append(unsigned, s.arch.Name())
+			continue
+		}
+
+		if first == nil {
+			first, firstID, firstTeamID = &slices[i], id, teamID
+			continue
+		}
+		if id != firstID {
+			return fmt.Errorf("identity of %s slice (%q) does not match the identity of %s slice (%q)", s.arch.Name(), id, first.arch.Name(), firstID)
+		}
+		if teamID != firstTeamID {
+			return fmt.Errorf("team ID of %s slice (%q) does not match the team ID of %s slice (%q)", s.arch.Name(), teamID, first.arch.Name(), firstTeamID)
+		}
+	}
+
+	if first != nil && len(unsigned) > 0 {
+		log.WithFields("unsigned", strings.Join(unsigned, ",")).Warn("merging signed and unsigned architectures (sign the universal binary to sign every architecture)")
+	}
+	return nil
+}
+
+// writeLipoSlices writes the slices to a universal binary at the output path, with the file mode of the given binary.
+func writeLipoSlices(modePath string, slices []lipoSlice, cfg LipoConfig, fat64 bool) error {
+	var fatSlices []macho.FatSlice
+	for _, s := range slices {
+		align := s.arch.Align
+		if a, ok := cfg.Alignments[s.arch.Name()]; ok {
+			align = a
+		}
+
+		b := s.file.Bytes()
+		fatSlices = append(fatSlices, macho.FatSlice{
+			Cpu:     s.arch.Cpu,
+			SubCpu:  s.arch.SubCpu,
+			Align:   align,
+			Content: bytes.NewReader(b),
+			Size:    int64(len(b)),
+		})
+	}
+
+	for arch := range cfg.Alignments {
+		if !hasLipoArch(slices, arch) {
+			return fmt.Errorf("unable to set the alignment of %s slice: no such architecture (has %s)", arch, lipoArchNames(slices))
+		}
+	}
+
+	return writeLipoOutput(modePath, cfg.OutputPath, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		if _, err := macho.WriteFatFile(w, fatSlices, fat64); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// writeLipoOutput writes the output next to the destination, which atomically replaces the destination only once
+// written (the output may be one of the inputs).
+func writeLipoOutput(modePath, output string, write func(f *os.File) error) error {
+	if output == "" {
+		return fmt.Errorf("no output path given")
+	}
+
+	info, err := os.Stat(modePath)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".quill-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for %q: %w", output, err)
+	}
+	defer os.Remove(out.Name())
+
+	err = write(out)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %q: %w", output, err)
+	}
+
+	if err := os.Rename(out.Name(), output); err != nil {
+		return fmt.Errorf("unable to write %q: %w", output, err)
+	}
+	return nil
+}
+
+func hasLipoArch(slices []lipoSlice, arch string) bool {
+	for _, s := range slices {
+		if s.arch.Name() == arch {
+			return true
+		}
+	}
+	return false
+}
+
+func lipoArchNames(slices []lipoSlice) string {
+	var names []string
+	for _, s := range slices {
+		names = append(names, s.arch.Name())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,137 @@
+package quill
+
+import (
+	"debug/macho"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+func TestParseLipoAlignment(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantArch  string
+		wantAlign uint32
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{value: "arm64=0x4000", wantArch: "arm64", wantAlign: 14},
+		{value: "x86_64=4096", wantArch: "x86_64", wantAlign: 12},
+		{value: "i386=1", wantArch: "i386", wantAlign: 0},
+		{value: "arm64=0x3000", wantErr: require.Error},
+		{value: "arm64=0", wantErr: require.Error},
+		{value: "arm64", wantErr: require.Error},
+		{value: "=0x4000", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			arch, align, err := ParseLipoAlignment(tt.value)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantArch, arch)
+			assert.Equal(t, tt.wantAlign, align)
+		})
+	}
+}
+
+// adhocSigned ad-hoc signs a copy of the binary with the given identity, returning the path of the copy.
+func adhocSigned(t *testing.T, p, identity string) string {
+	t.Helper()
+
+	cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+	require.NoError(t, err)
+	cfg.WithIdentity(identity).WithOutputPath(filepath.Join(t.TempDir(), filepath.Base(p)))
+	require.NoError(t, Sign(*cfg))
+	return cfg.OutputPath
+}
+
+func TestLipo_preservesSignatures(t *testing.T) {
+	i386 := adhocSigned(t, test.Macho32(t, macho.Cpu386, 0x100), "hello")
+	armv7 := adhocSigned(t, test.Macho32(t, macho.CpuArm, 0x100), "hello")
+	dir := t.TempDir()
+
+	// create: merge the signed thin binaries
+	universal := filepath.Join(dir, "universal")
+	require.NoError(t, LipoCreate([]string{i386, armv7}, *NewLipoConfig(universal).WithAlignment("i386", 4)))
+
+	info, err := LipoInfo(universal)
+	require.NoError(t, err)
+	assert.True(t, info.Universal)
+	assert.False(t, info.Fat64)
+	require.Len(t, info.Arches, 2)
+	assert.Equal(t, "i386", info.Arches[0].Arch)
+	assert.Equal(t, uint32(4), info.Arches[0].Align)
+	assert.Equal(t, "armv7", info.Arches[1].Arch)
+	assert.Equal(t, uint32(14), info.Arches[1].Align)
+	for _, a := range info.Arches {
+		assert.True(t, a.Signed)
+		assert.Equal(t, "hello", a.ID)
+	}
+
+	result, err := Verify(*NewVerifyConfig(universal).WithAdhocAllowed(true))
+	require.NoError(t, err)
+	assert.True(t, result.IsValid(), "failures: %+v", result.Failures)
+
+	// thin: the slice is identical to the original thin binary
+	thin := filepath.Join(dir, "thin")
+	require.NoError(t, LipoThin(universal, "armv7", *NewLipoConfig(thin)))
+	expected, err := os.ReadFile(armv7)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(thin)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// remove: in place, keeping the remaining signed slice
+	require.NoError(t, LipoRemove(universal, []string{"i386"}, *NewLipoConfig(universal).WithFat64(true)))
+	info, err = LipoInfo(universal)
+	require.NoError(t, err)
+	assert.True(t, info.Fat64)
+	require.Len(t, info.Arches, 1)
+	assert.Equal(t, "armv7", info.Arches[0].Arch)
+
+	result, err = Verify(*NewVerifyConfig(universal).WithAdhocAllowed(true))
+	require.NoError(t, err)
+	assert.True(t, result.IsValid(), "failures: %+v", result.Failures)
+}
+
+func TestLipo_invalid(t *testing.T) {
+	i386 := adhocSigned(t, test.Macho32(t, macho.Cpu386, 0x100), "hello")
+	armv7 := adhocSigned(t, test.Macho32(t, macho.CpuArm, 0x100), "other")
+	unsigned := test.Macho32(t, macho.CpuArm, 0x100)
+	dir := t.TempDir()
+	universal := filepath.Join(dir, "universal")
+
+	err := LipoCreate([]string{i386, armv7}, *NewLipoConfig(universal))
+	assert.ErrorContains(t, err, "identity of armv7 slice")
+	assert.NoFileExists(t, universal)
+
+	err = LipoCreate([]string{i386, i386}, *NewLipoConfig(universal))
+	assert.ErrorContains(t, err, "both have an architecture of i386")
+
+	err = LipoCreate([]string{i386, unsigned}, *NewLipoConfig(universal).WithAlignment("arm64", 14))
+	assert.ErrorContains(t, err, "no such architecture")
+
+	// signed and unsigned slices may be merged
+	require.NoError(t, LipoCreate([]string{i386, unsigned}, *NewLipoConfig(universal)))
+
+	err = LipoThin(universal, "arm64", *NewLipoConfig(filepath.Join(dir, "thin")))
+	assert.ErrorContains(t, err, "has no arm64 slice (has i386, armv7)")
+
+	err = LipoThin(i386, "i386", *NewLipoConfig(filepath.Join(dir, "thin")))
+	assert.ErrorContains(t, err, "is not a universal binary")
+
+	err = LipoRemove(universal, []string{"i386", "armv7"}, *NewLipoConfig(universal))
+	assert.ErrorContains(t, err, "unable to remove every architecture")
+
+	err = LipoRemove(universal, []string{"i386"}, *NewLipoConfig(""))
+	assert.ErrorContains(t, err, "no output path")
+}
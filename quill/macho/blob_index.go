@@ -23,3 +23,8 @@ type BlobIndex struct {
 	Type   SlotType // type of entry
 	Offset uint32   // offset of entry (relative to superblob file offset)
 }
+
+// IsCodeDirectory indicates if the slot holds a primary or alternate code directory.
+func (t SlotType) IsCodeDirectory() bool {
+	return t == CsSlotCodedirectory || (t >= CsSlotAlternateCodedirectories && t < CsSlotAlternateCodedirectoryLimit)
+}
@@ -0,0 +1,118 @@
+package macho
+
+import (
+	"fmt"
+)
+
+const (
+	LcVersionMinMacosx   LoadCommandType = 0x24
+	LcVersionMinIphoneos LoadCommandType = 0x25
+	LcVersionMinTvos     LoadCommandType = 0x2f
+	LcVersionMinWatchos  LoadCommandType = 0x30
+	LcBuildVersion       LoadCommandType = 0x32
+)
+
+// Platform is the platform that a binary was built for (as found in LC_BUILD_VERSION).
+type Platform uint32
+
+const (
+	PlatformMacOS             Platform = 1
+	PlatformIOS               Platform = 2
+	PlatformTvOS              Platform = 3
+	PlatformWatchOS           Platform = 4
+	PlatformBridgeOS          Platform = 5
+	PlatformMacCatalyst       Platform = 6
+	PlatformIOSSimulator      Platform = 7
+	PlatformTvOSSimulator     Platform = 8
+	PlatformWatchOSSimulator  Platform = 9
+	PlatformDriverKit         Platform = 10
+	PlatformVisionOS          Platform = 11
+	PlatformVisionOSSimulator Platform = 12
+)
+
+var platformNames = map[Platform]string{
+	PlatformMacOS:             "macOS",
+	PlatformIOS:               "iOS",
+	PlatformTvOS:              "tvOS",
+	PlatformWatchOS:           "watchOS",
+	PlatformBridgeOS:          "bridgeOS",
+	PlatformMacCatalyst:       "Mac Catalyst",
+	PlatformIOSSimulator:      "iOS simulator",
+	PlatformTvOSSimulator:     "tvOS simulator",
+	PlatformWatchOSSimulator:  "watchOS simulator",
+	PlatformDriverKit:         "DriverKit",
+	PlatformVisionOS:          "visionOS",
+	PlatformVisionOSSimulator: "visionOS simulator",
+}
+
+func (p Platform) String() string {
+	if name, ok := platformNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("platform%d", uint32(p))
+}
+
+// versionMinPlatforms are the platforms of the legacy LC_VERSION_MIN_* load commands (superseded by LC_BUILD_VERSION).
+var versionMinPlatforms = map[LoadCommandType]Platform{
+	LcVersionMinMacosx:   PlatformMacOS,
+	LcVersionMinIphoneos: PlatformIOS,
+	LcVersionMinTvos:     PlatformTvOS,
+	LcVersionMinWatchos:  PlatformWatchOS,
+}
+
+// Version is a version encoded as xxxx.yy.zz in nibbles (as used by the minimum OS and SDK versions of a binary).
+type Version uint32
+
+func NewVersion(major, minor, patch uint32) Version {
+	return Version(major<<16 | (minor&0xff)<<8 | patch&0xff)
+}
+
+func (v Version) String() string {
+	major, minor, patch := uint32(v)>>16, (uint32(v)>>8)&0xff, uint32(v)&0xff
+	if patch == 0 {
+		return fmt.Sprintf("%d.%d", major, minor)
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+// BuildVersion is the platform, minimum OS version and SDK version that a binary was built for.
+type BuildVersion struct {
+	// Command is the load command the build version was read from (LC_BUILD_VERSION or one of LC_VERSION_MIN_*)
+	Command  LoadCommandType
+	Platform Platform
+	MinOS    Version
+	SDK      Version
+}
+
+// BuildVersion returns the build version of the binary from LC_BUILD_VERSION, falling back to the legacy
+// LC_VERSION_MIN_* load commands. Nil is returned when the binary has neither.
+func (m *File) BuildVersion() *BuildVersion {
+	var legacy *BuildVersion
+	for _, l := range m.Loads {
+		data := l.Raw()
+		if len(data) < 16 {
+			continue
+		}
+		cmd := LoadCommandType(m.ByteOrder.Uint32(data))
+		switch {
+		case cmd == LcBuildVersion && len(data) >= 24:
+			// platform, minos and sdk follow the command type and size (then the tools used to build the binary)
+			return &BuildVersion{
+				Command:  cmd,
+				Platform: Platform(m.ByteOrder.Uint32(data[8:])),
+				MinOS:    Version(m.ByteOrder.Uint32(data[12:])),
+				SDK:      Version(m.ByteOrder.Uint32(data[16:])),
+			}
+		case legacy == nil:
+			if platform, ok := versionMinPlatforms[cmd]; ok {
+				legacy = &BuildVersion{
+					Command:  cmd,
+					Platform: platform,
+					MinOS:    Version(m.ByteOrder.Uint32(data[8:])),
+					SDK:      Version(m.ByteOrder.Uint32(data[12:])),
+				}
+			}
+		}
+	}
+	return legacy
+}
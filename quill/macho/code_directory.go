@@ -1,5 +1,14 @@
 package macho
 
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/go-restruct/restruct"
+)
+
 // Definitions From: https://github.com/Apple-FOSS-Mirror/Security/blob/5bcad85836c8bbb383f660aaf25b555a805a48e4/OSX/sec/Security/Tool/codesign.c#L53-L89
 
 const (
@@ -58,6 +67,42 @@ const (
 	EntitlementFlags CdFlag = (GetTaskAllow | Installer | DatavaultController | NvramUnrestricted)
 )
 
+// cdFlagOptionNames are the names of the code directory flags that may be requested when signing (as accepted by
+// "codesign --options").
+var cdFlagOptionNames = map[string]CdFlag{
+	"runtime":  Runtime,
+	"kill":     Kill,
+	"hard":     Hard,
+	"library":  RequireLv,
+	"restrict": Restrict,
+	"expires":  CheckExpiration,
+}
+
+// ParseCdFlags returns the code directory flags for the given comma-separated option names (e.g. "runtime,kill,hard").
+func ParseCdFlags(value string) (CdFlag, error) {
+	var flags CdFlag
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		flag, ok := cdFlagOptionNames[name]
+		if !ok {
+			return None, fmt.Errorf("unsupported code directory option: %q", name)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// ValidateMacho returns an error if any of the flags may not be set within the code directory of a Mach-O binary.
+func (f CdFlag) ValidateMacho() error {
+	if disallowed := f &^ AllowedMacho; disallowed != 0 {
+		return fmt.Errorf("code directory flags not allowed for Mach-O binaries: %#x", uint32(disallowed))
+	}
+	return nil
+}
+
 // executable segment flags
 const (
 	ExecsegMainBinary    ExecSegFlag = 0x1   // executable segment denotes main binary
@@ -120,3 +165,107 @@ type CodeDirectoryHeader struct {
 	// Version 0x20600
 	// TODO: linkage options
 }
+
+// ParsedCodeDirectory is a decoded code directory blob along with the variable-length content referenced by the header.
+type ParsedCodeDirectory struct {
+	CodeDirectoryHeader
+	ID     string
+	TeamID string
+	// SpecialSlotHashes are keyed by the (positive) special slot type (e.g. CsSlotRequirements)
+	SpecialSlotHashes map[SlotType][]byte
+	CodeSlotHashes    [][]byte
+}
+
+// codeDirectoryHeaderSize returns the number of header bytes (not including the blob header) that are present for the given version.
+func codeDirectoryHeaderSize(v CdVersion) int {
+	switch {
+	case v >= SupportsRuntime:
+		return 88
+	case v >= SupportsExecseg:
+		return 80
+	case v >= SupportsCodelimit64:
+		return 56
+	case v >= SupportsTeamid:
+		return 44
+	case v >= SupportsScatter:
+		return 40
+	default:
+		return 36
+	}
+}
+
+// ParseCodeDirectory decodes a code directory blob (including the blob header) as found within a signing superblob.
+func ParseCodeDirectory(b []byte) (*ParsedCodeDirectory, error) {
+	blobHeaderSize := int(unsafe.Sizeof(BlobHeader{}))
+	if len(b) < blobHeaderSize+codeDirectoryHeaderSize(EarliestVersion) {
+		return nil, fmt.Errorf("code directory blob is too small (%d bytes)", len(b))
+	}
+
+	if magic := Magic(SigningOrder.Uint32(b)); magic != MagicCodedirectory {
+		return nil, fmt.Errorf("unexpected code directory magic: 0x%x", uint32(magic))
+	}
+
+	length := SigningOrder.Uint32(b[4:])
+	if int(length) > len(b) {
+		return nil, fmt.Errorf("code directory length exceeds available data (%d > %d)", length, len(b))
+	}
+	b = b[:length]
+
+	version := CdVersion(SigningOrder.Uint32(b[blobHeaderSize:]))
+
+	// only the fields present for this version are copied, the remaining fields are left zeroed
+	headerBytes := make([]byte, codeDirectoryHeaderSize(SupportsRuntime))
+	copy(headerBytes, b[blobHeaderSize:min(len(b), blobHeaderSize+codeDirectoryHeaderSize(version))])
+
+	var cd ParsedCodeDirectory
+	if err := restruct.Unpack(headerBytes, SigningOrder, &cd.CodeDirectoryHeader); err != nil {
+		return nil, fmt.Errorf("unable to decode code directory header: %w", err)
+	}
+
+	var err error
+	cd.ID, err = readCString(b, cd.IdentOffset)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read identifier: %w", err)
+	}
+
+	if cd.TeamOffset != 0 {
+		cd.TeamID, err = readCString(b, cd.TeamOffset)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read team identifier: %w", err)
+		}
+	}
+
+	hashSize := uint64(cd.HashSize)
+	hashOffset := uint64(cd.HashOffset)
+
+	if uint64(cd.NSpecialSlots)*hashSize > hashOffset {
+		return nil, fmt.Errorf("special slots extend before the start of the code directory")
+	}
+	if hashOffset+uint64(cd.NCodeSlots)*hashSize > uint64(len(b)) {
+		return nil, fmt.Errorf("code slots extend beyond the end of the code directory")
+	}
+
+	cd.SpecialSlotHashes = make(map[SlotType][]byte)
+	for i := uint64(1); i <= uint64(cd.NSpecialSlots); i++ {
+		start := hashOffset - i*hashSize
+		cd.SpecialSlotHashes[SlotType(i)] = b[start : start+hashSize]
+	}
+
+	for i := uint64(0); i < uint64(cd.NCodeSlots); i++ {
+		start := hashOffset + i*hashSize
+		cd.CodeSlotHashes = append(cd.CodeSlotHashes, b[start:start+hashSize])
+	}
+
+	return &cd, nil
+}
+
+func readCString(b []byte, offset uint32) (string, error) {
+	if int(offset) >= len(b) {
+		return "", fmt.Errorf("offset %d is beyond the end of the blob", offset)
+	}
+	end := bytes.IndexByte(b[offset:], 0)
+	if end < 0 {
+		return "", fmt.Errorf("string at offset %d is not terminated", offset)
+	}
+	return string(b[offset : int(offset)+end]), nil
+}
@@ -0,0 +1,104 @@
+package macho
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-restruct/restruct"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCodeDirectory(t *testing.T) {
+	specialHash := bytes.Repeat([]byte{0xaa}, 32)
+	pageHash1 := bytes.Repeat([]byte{0x01}, 32)
+	pageHash2 := bytes.Repeat([]byte{0x02}, 32)
+
+	// header (88) + blob header (8) = 96 bytes before the payload
+	payload := []byte("the-id\000team-id\000")
+	payload = append(payload, specialHash...)
+	payload = append(payload, make([]byte, 32)...) // empty slot 1
+	payload = append(payload, pageHash1...)
+	payload = append(payload, pageHash2...)
+
+	cd := CodeDirectory{
+		CodeDirectoryHeader: CodeDirectoryHeader{
+			Version:       SupportsRuntime,
+			Flags:         Runtime,
+			HashOffset:    96 + 15 + 64,
+			IdentOffset:   96,
+			NSpecialSlots: 2,
+			NCodeSlots:    2,
+			CodeLimit:     0x1234,
+			HashSize:      32,
+			HashType:      HashTypeSha256,
+			PageSize:      PageSizeBits,
+			TeamOffset:    96 + 7,
+		},
+		Payload: payload,
+	}
+
+	cdBytes, err := restruct.Pack(SigningOrder, &cd)
+	require.NoError(t, err)
+
+	blob := NewBlob(MagicCodedirectory, cdBytes)
+	blobBytes, err := blob.Pack()
+	require.NoError(t, err)
+
+	actual, err := ParseCodeDirectory(blobBytes)
+	require.NoError(t, err)
+
+	assert.Equal(t, cd.CodeDirectoryHeader, actual.CodeDirectoryHeader)
+	assert.Equal(t, "the-id", actual.ID)
+	assert.Equal(t, "team-id", actual.TeamID)
+	assert.Equal(t, specialHash, actual.SpecialSlotHashes[CsSlotRequirements])
+	assert.Equal(t, make([]byte, 32), actual.SpecialSlotHashes[CsSlotInfoslot])
+	assert.Equal(t, [][]byte{pageHash1, pageHash2}, actual.CodeSlotHashes)
+
+	_, err = ParseCodeDirectory(blobBytes[:len(blobBytes)-1])
+	assert.Error(t, err, "truncated code directory should not be parsable")
+
+	_, err = ParseCodeDirectory(newBlobBytes(t, MagicRequirements, cdBytes))
+	assert.Error(t, err, "wrong magic should not be parsable")
+}
+
+func newBlobBytes(t *testing.T, m Magic, payload []byte) []byte {
+	t.Helper()
+	b := NewBlob(m, payload)
+	by, err := b.Pack()
+	require.NoError(t, err)
+	return by
+}
+
+func TestParseCdFlags(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    CdFlag
+		wantErr require.ErrorAssertionFunc
+	}{
+		{value: "", want: None},
+		{value: "runtime", want: Runtime},
+		{value: "runtime,kill,hard,library,restrict", want: Runtime | Kill | Hard | RequireLv | Restrict},
+		{value: " Runtime , expires ,", want: Runtime | CheckExpiration},
+		{value: "runtime,adhoc", want: None, wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			got, err := ParseCdFlags(tt.value)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+			if err == nil {
+				assert.NoError(t, got.ValidateMacho())
+			}
+		})
+	}
+}
+
+func TestCdFlag_ValidateMacho(t *testing.T) {
+	assert.NoError(t, (Runtime | Hard | Kill | Adhoc).ValidateMacho())
+	assert.Error(t, (Runtime | PlatformBinary).ValidateMacho())
+	assert.Error(t, LinkerSigned.ValidateMacho())
+}
@@ -0,0 +1,147 @@
+package macho
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNoCodeDirectory = fmt.Errorf("unable to find code directory")
+
+var ErrNoBlob = fmt.Errorf("unable to find blob")
+
+// CodeSignature is the raw content of an embedded signature superblob, as found within the __LINKEDIT segment of a
+// signed binary or within a detached signature file.
+type CodeSignature struct {
+	data []byte
+}
+
+// NewCodeSignature wraps the raw bytes of an embedded signature superblob.
+func NewCodeSignature(data []byte) (*CodeSignature, error) {
+	if len(data) > maxSuperBlobSize {
+		return nil, fmt.Errorf("superblob size exceeds maximum (%d > %d)", len(data), maxSuperBlobSize)
+	}
+	return &CodeSignature{data: data}, nil
+}
+
+// Bytes returns the raw superblob content.
+func (s *CodeSignature) Bytes() []byte {
+	return s.data
+}
+
+func (s *CodeSignature) CDBytes(order binary.ByteOrder, ith int) (cd []byte, err error) {
+	csBlob, superBlobReader, err := s.superBlob()
+	if err != nil {
+		return nil, err
+	}
+
+	var found int
+	for _, index := range csBlob.Index {
+		if !index.Type.IsCodeDirectory() {
+			continue
+		}
+
+		found++
+		if found <= ith {
+			continue
+		}
+
+		return readBlobBytes(superBlobReader, index, order, "code directory")
+	}
+	return nil, ErrNoCodeDirectory
+}
+
+func (s *CodeSignature) CMSBlobBytes(order binary.ByteOrder) (cd []byte, err error) {
+	b, err := s.BlobBytes(order, CsSlotCmsSignature)
+	if errors.Is(err, ErrNoBlob) {
+		return nil, fmt.Errorf("unable to find CMS blob")
+	}
+	return b, err
+}
+
+// BlobBytes returns the raw bytes (including the blob header) of the first blob within the superblob for the given slot type.
+func (s *CodeSignature) BlobBytes(order binary.ByteOrder, slot SlotType) ([]byte, error) {
+	csBlob, superBlobReader, err := s.superBlob()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, index := range csBlob.Index {
+		if index.Type != slot {
+			continue
+		}
+		return readBlobBytes(superBlobReader, index, order, fmt.Sprintf("slot %d", slot))
+	}
+	return nil, ErrNoBlob
+}
+
+// BlobSlots returns the slot types of all blobs within the superblob (in the order found).
+func (s *CodeSignature) BlobSlots() ([]SlotType, error) {
+	csBlob, _, err := s.superBlob()
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []SlotType
+	for _, index := range csBlob.Index {
+		slots = append(slots, index.Type)
+	}
+	return slots, nil
+}
+
+// superBlob parses and validates the superblob header and index, returning the parsed blob and a reader.
+func (s *CodeSignature) superBlob() (*SuperBlob, *bytes.Reader, error) {
+	superBlobReader := bytes.NewReader(s.data)
+
+	csBlob := &SuperBlob{}
+	if err := binary.Read(superBlobReader, SigningOrder, &csBlob.SuperBlobHeader); err != nil {
+		return nil, nil, fmt.Errorf("unable to extract superblob header: %w", err)
+	}
+
+	if csBlob.Count > maxBlobCount {
+		return nil, nil, fmt.Errorf("blob count exceeds maximum (%d > %d)", csBlob.Count, maxBlobCount)
+	}
+
+	csBlob.Index = make([]BlobIndex, csBlob.Count)
+	if err := binary.Read(superBlobReader, SigningOrder, &csBlob.Index); err != nil {
+		return nil, nil, err
+	}
+
+	return csBlob, superBlobReader, nil
+}
+
+// readBlobBytes reads and returns the raw bytes of a blob at the given index.
+func readBlobBytes(reader *bytes.Reader, index BlobIndex, order binary.ByteOrder, blobName string) ([]byte, error) {
+	if _, err := reader.Seek(int64(index.Offset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to seek to %s blob: %w", blobName, err)
+	}
+
+	var blobHeader BlobHeader
+	if err := binary.Read(reader, SigningOrder, &blobHeader); err != nil {
+		return nil, err
+	}
+
+	if blobHeader.Length > maxBlobLength {
+		return nil, fmt.Errorf("%s blob size exceeds maximum (%d > %d)", blobName, blobHeader.Length, maxBlobLength)
+	}
+
+	// validate that the blob fits within the superblob buffer (defense in depth against malicious length values)
+	superBlobSize := reader.Size()
+	if int64(index.Offset)+int64(blobHeader.Length) > superBlobSize {
+		return nil, fmt.Errorf("%s blob extends beyond superblob (offset=%d + length=%d > %d)", blobName, index.Offset, blobHeader.Length, superBlobSize)
+	}
+
+	// seek back to the beginning of the blob to read the full content
+	if _, err := reader.Seek(int64(index.Offset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to seek to %s: %w", blobName, err)
+	}
+
+	blobBytes := make([]byte, blobHeader.Length)
+	if err := binary.Read(reader, order, &blobBytes); err != nil {
+		return nil, err
+	}
+
+	return blobBytes, nil
+}
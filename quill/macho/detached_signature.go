@@ -0,0 +1,143 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// ArchSignature is the embedded signature for a single architecture within a detached signature.
+type ArchSignature struct {
+	// Cpu is the CPU type of the binary the signature is for (zero when the detached signature is for a thin binary,
+	// in which case the architecture is not recorded)
+	Cpu macho.Cpu
+	// SubCpu is the CPU subtype of the binary the signature is for (only used for reporting, since the detached
+	// signature does not record it)
+	SubCpu    uint32
+	Signature *CodeSignature
+}
+
+// NewDetachedSignature creates a detached signature superblob (MagicDetachedSignature) holding the embedded signature
+// of each architecture of a universal binary, keyed by CPU type. Since the CPU subtype is not recorded, slices that
+// only differ by subtype (e.g. arm64 and arm64e) cannot be held within the same detached signature.
+func NewDetachedSignature(sigs []ArchSignature) ([]byte, error) {
+	seen := make(map[macho.Cpu]ArchSignature)
+	for _, s := range sigs {
+		if prev, ok := seen[s.Cpu]; ok {
+			return nil, fmt.Errorf("detached signatures are keyed by CPU type, so the %s and %s slices cannot both be signed", ArchName(prev.Cpu, prev.SubCpu), ArchName(s.Cpu, s.SubCpu))
+		}
+		seen[s.Cpu] = s
+	}
+
+	headerSize := uint32(unsafe.Sizeof(SuperBlobHeader{}))
+	indexSize := uint32(unsafe.Sizeof(BlobIndex{}))
+
+	sb := SuperBlob{
+		SuperBlobHeader: SuperBlobHeader{
+			Magic:  MagicDetachedSignature,
+			Length: headerSize + indexSize*uint32(len(sigs)),
+			Count:  uint32(len(sigs)),
+		},
+	}
+
+	var blobs bytes.Buffer
+	for _, s := range sigs {
+		sb.Index = append(sb.Index, BlobIndex{Type: SlotType(s.Cpu), Offset: sb.Length})
+		blobs.Write(s.Signature.Bytes())
+		sb.Length += uint32(len(s.Signature.Bytes()))
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, SigningOrder, sb.SuperBlobHeader); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, SigningOrder, sb.Index); err != nil {
+		return nil, err
+	}
+	buf.Write(blobs.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// ParseDetachedSignature reads the content of a detached signature file, which is either the embedded signature
+// superblob of a thin binary or a detached signature superblob (keyed by CPU type) for a universal binary.
+func ParseDetachedSignature(b []byte) ([]ArchSignature, error) {
+	if len(b) < int(unsafe.Sizeof(SuperBlobHeader{})) {
+		return nil, fmt.Errorf("detached signature is too small (%d bytes)", len(b))
+	}
+
+	switch magic := Magic(SigningOrder.Uint32(b)); magic {
+	case MagicEmbeddedSignature:
+		sig, err := NewCodeSignature(b)
+		if err != nil {
+			return nil, err
+		}
+		return []ArchSignature{{Signature: sig}}, nil
+	case MagicDetachedSignature:
+		return parseMultiArchDetachedSignature(b)
+	default:
+		return nil, fmt.Errorf("not a detached signature (magic=%#x)", uint32(magic))
+	}
+}
+
+func parseMultiArchDetachedSignature(b []byte) ([]ArchSignature, error) {
+	sig, err := NewCodeSignature(b)
+	if err != nil {
+		return nil, err
+	}
+
+	csBlob, _, err := sig.superBlob()
+	if err != nil {
+		return nil, err
+	}
+
+	var sigs []ArchSignature
+	seen := make(map[SlotType]bool)
+	for i, index := range csBlob.Index {
+		// a signature can only be found by CPU type, so a duplicate would make it ambiguous which slice it is for
+		if seen[index.Type] {
+			return nil, fmt.Errorf("multiple signatures for cpu %d", index.Type)
+		}
+		seen[index.Type] = true
+
+		// the embedded signatures are laid out back to back, so each extends to the start of the next one (or the end
+		// of the file). Note: the length recorded within an embedded signature header is not relied on here since it
+		// does not always account for the trailing padding.
+		end := uint32(len(b))
+		if i+1 < len(csBlob.Index) {
+			end = csBlob.Index[i+1].Offset
+		}
+		if uint64(index.Offset)+uint64(unsafe.Sizeof(SuperBlobHeader{})) > uint64(end) || end > uint32(len(b)) {
+			return nil, fmt.Errorf("invalid signature extent for cpu %d (offset=%d end=%d size=%d)", index.Type, index.Offset, end, len(b))
+		}
+		blob := b[index.Offset:end]
+
+		if magic := Magic(SigningOrder.Uint32(blob)); magic != MagicEmbeddedSignature {
+			return nil, fmt.Errorf("unexpected signature magic for cpu %d: %#x", index.Type, uint32(magic))
+		}
+
+		archSig, err := NewCodeSignature(blob)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, ArchSignature{Cpu: macho.Cpu(index.Type), Signature: archSig})
+	}
+
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("detached signature does not contain any signatures")
+	}
+	return sigs, nil
+}
+
+// FindArchSignature returns the signature for the given CPU type from the signatures of a detached signature file.
+// The signature of a thin binary (which does not record the architecture) matches any CPU type.
+func FindArchSignature(sigs []ArchSignature, cpu macho.Cpu) *CodeSignature {
+	for _, s := range sigs {
+		if s.Cpu == cpu || (s.Cpu == 0 && len(sigs) == 1) {
+			return s.Signature
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package macho
+
+import (
+	"debug/macho"
+	"testing"
+
+	"github.com/go-restruct/restruct"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCodeSignature(t *testing.T, payload string) *CodeSignature {
+	t.Helper()
+
+	sb := NewSuperBlob(MagicEmbeddedSignature)
+	cd := NewBlob(MagicCodedirectory, []byte(payload))
+	sb.Add(CsSlotCodedirectory, &cd)
+	sb.Finalize(0)
+
+	b, err := restruct.Pack(SigningOrder, &sb)
+	require.NoError(t, err)
+
+	sig, err := NewCodeSignature(b)
+	require.NoError(t, err)
+	return sig
+}
+
+func TestDetachedSignature_RoundTrip(t *testing.T) {
+	arm64 := newTestCodeSignature(t, "arm64 code directory")
+	amd64 := newTestCodeSignature(t, "amd64 code directory")
+
+	b, err := NewDetachedSignature([]ArchSignature{
+		{Cpu: macho.CpuArm64, Signature: arm64},
+		{Cpu: macho.CpuAmd64, Signature: amd64},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, MagicDetachedSignature, Magic(SigningOrder.Uint32(b[0:4])))
+	assert.Equal(t, uint32(len(b)), SigningOrder.Uint32(b[4:8]), "length should include the header")
+
+	sigs, err := ParseDetachedSignature(b)
+	require.NoError(t, err)
+	require.Len(t, sigs, 2)
+
+	assert.Equal(t, macho.CpuArm64, sigs[0].Cpu)
+	assert.Equal(t, arm64.Bytes(), sigs[0].Signature.Bytes())
+	assert.Equal(t, macho.CpuAmd64, sigs[1].Cpu)
+	assert.Equal(t, amd64.Bytes(), sigs[1].Signature.Bytes())
+
+	cd, err := sigs[1].Signature.CDBytes(SigningOrder, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "amd64 code directory", string(cd[8:]))
+
+	assert.Equal(t, arm64.Bytes(), FindArchSignature(sigs, macho.CpuArm64).Bytes())
+	assert.Nil(t, FindArchSignature(sigs, macho.Cpu386))
+}
+
+func TestDetachedSignature_duplicateCpu(t *testing.T) {
+	arm64 := newTestCodeSignature(t, "arm64 code directory")
+	arm64e := newTestCodeSignature(t, "arm64e code directory")
+
+	// the CPU subtype is not recorded, so arm64 and arm64e slices cannot be told apart
+	_, err := NewDetachedSignature([]ArchSignature{
+		{Cpu: macho.CpuArm64, SubCpu: 0, Signature: arm64},
+		{Cpu: macho.CpuArm64, SubCpu: 2, Signature: arm64e},
+	})
+	require.ErrorContains(t, err, "the arm64 and arm64e slices cannot both be signed")
+
+	// ...and a detached signature written elsewhere with duplicate CPU types is rejected rather than matching the
+	// first signature
+	b, err := NewDetachedSignature([]ArchSignature{
+		{Cpu: macho.CpuArm64, Signature: arm64},
+		{Cpu: macho.CpuAmd64, Signature: arm64e},
+	})
+	require.NoError(t, err)
+	SigningOrder.PutUint32(b[20:], uint32(macho.CpuArm64)) // the type of the second index entry
+
+	_, err = ParseDetachedSignature(b)
+	require.ErrorContains(t, err, "multiple signatures")
+}
+
+func TestParseDetachedSignature(t *testing.T) {
+	thin := newTestCodeSignature(t, "thin code directory")
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantCpu macho.Cpu
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:  "embedded signature of a thin binary",
+			input: thin.Bytes(),
+		},
+		{
+			name:    "too small",
+			input:   []byte{0xfa, 0xde},
+			wantErr: require.Error,
+		},
+		{
+			name:    "not a signature",
+			input:   []byte{0xcf, 0xfa, 0xed, 0xfe, 0, 0, 0, 0, 0, 0, 0, 0},
+			wantErr: require.Error,
+		},
+		{
+			name:    "empty detached signature",
+			input:   []byte{0xfa, 0xde, 0x0c, 0xc1, 0, 0, 0, 12, 0, 0, 0, 0},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			sigs, err := ParseDetachedSignature(tt.input)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			require.Len(t, sigs, 1)
+			assert.Equal(t, tt.wantCpu, sigs[0].Cpu)
+			assert.Equal(t, tt.input, sigs[0].Signature.Bytes())
+
+			// the signature of a thin binary does not record the architecture, so it matches any
+			assert.NotNil(t, FindArchSignature(sigs, macho.CpuArm64))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package macho
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	LcLoadDylib       LoadCommandType = 0xc
+	LcIDDylib         LoadCommandType = 0xd
+	LcLazyLoadDylib   LoadCommandType = 0x20
+	LcLoadWeakDylib   LoadCommandType = 0x80000018
+	LcRpath           LoadCommandType = 0x8000001c
+	LcReexportDylib   LoadCommandType = 0x8000001f
+	LcLoadUpwardDylib LoadCommandType = 0x80000023
+)
+
+// dylibLoadCommands are the load commands that reference a dylib the binary depends on.
+var dylibLoadCommands = map[LoadCommandType]struct{}{
+	LcLoadDylib:       {},
+	LcLazyLoadDylib:   {},
+	LcLoadWeakDylib:   {},
+	LcReexportDylib:   {},
+	LcLoadUpwardDylib: {},
+}
+
+// DylibID returns the install name of the dylib (from LC_ID_DYLIB), which is empty for binaries that are not dylibs.
+func (m *File) DylibID() string {
+	names := m.loadCommandStrings(func(cmd LoadCommandType) bool { return cmd == LcIDDylib })
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// LoadedDylibs returns the install names of the dylibs the binary depends on, in load order (LC_LOAD_DYLIB,
+// LC_LOAD_WEAK_DYLIB, LC_REEXPORT_DYLIB, LC_LOAD_UPWARD_DYLIB and LC_LAZY_LOAD_DYLIB).
+func (m *File) LoadedDylibs() []string {
+	return m.loadCommandStrings(func(cmd LoadCommandType) bool {
+		_, ok := dylibLoadCommands[cmd]
+		return ok
+	})
+}
+
+// Rpaths returns the run path search paths of the binary (LC_RPATH), in order.
+func (m *File) Rpaths() []string {
+	return m.loadCommandStrings(func(cmd LoadCommandType) bool { return cmd == LcRpath })
+}
+
+// loadCommandStrings returns the string of each selected load command. The dylib and rpath commands all hold the
+// offset of their string (relative to the start of the command) right after the command type and size.
+func (m *File) loadCommandStrings(selected func(LoadCommandType) bool) []string {
+	var values []string
+	for _, l := range m.Loads {
+		data := l.Raw()
+		if len(data) < 12 || !selected(LoadCommandType(m.ByteOrder.Uint32(data))) {
+			continue
+		}
+		if value, ok := loadCommandString(data, m.ByteOrder); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// loadCommandString reads the NUL-terminated string referenced by the lc_str offset at the start of the command body.
+func loadCommandString(data []byte, bo binary.ByteOrder) (string, bool) {
+	offset := bo.Uint32(data[8:12])
+	if offset < 12 || uint64(offset) >= uint64(len(data)) {
+		return "", false
+	}
+
+	value := data[offset:]
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return string(value), true
+}
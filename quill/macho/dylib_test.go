@@ -0,0 +1,68 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLoadCommand encodes a load command holding a single string (as the dylib and rpath commands do), where the
+// dylib commands have a timestamp and versions between the string offset and the string.
+func newTestLoadCommand(cmd LoadCommandType, value string) macho.LoadBytes {
+	offset := uint32(12)
+	switch cmd {
+	case LcIDDylib, LcLoadDylib, LcLoadWeakDylib, LcReexportDylib, LcLoadUpwardDylib, LcLazyLoadDylib:
+		offset = 24
+	}
+
+	size := (offset + uint32(len(value)) + 1 + 7) &^ 7
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b[0:], uint32(cmd))
+	binary.LittleEndian.PutUint32(b[4:], size)
+	binary.LittleEndian.PutUint32(b[8:], offset)
+	copy(b[offset:], value)
+	return b
+}
+
+func TestFile_dylibs(t *testing.T) {
+	m := &File{
+		File: &macho.File{
+			ByteOrder: binary.LittleEndian,
+			Loads: []macho.Load{
+				newTestLoadCommand(LcIDDylib, "@rpath/libquill.dylib"),
+				newTestLoadCommand(LcLoadDylib, "/usr/lib/libSystem.B.dylib"),
+				newTestLoadCommand(LcRpath, "@loader_path/../lib"),
+				newTestLoadCommand(LcLoadWeakDylib, "@rpath/libweak.dylib"),
+				newTestLoadCommand(LcCodeSignature, "not a string"),
+				newTestLoadCommand(LcReexportDylib, "@loader_path/libreexport.dylib"),
+				newTestLoadCommand(LcRpath, "/opt/lib"),
+				// a string offset beyond the command is ignored
+				macho.LoadBytes{0x0c, 0, 0, 0, 16, 0, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0},
+			},
+		},
+	}
+
+	assert.Equal(t, "@rpath/libquill.dylib", m.DylibID())
+	assert.Equal(t, []string{
+		"/usr/lib/libSystem.B.dylib",
+		"@rpath/libweak.dylib",
+		"@loader_path/libreexport.dylib",
+	}, m.LoadedDylibs())
+	assert.Equal(t, []string{"@loader_path/../lib", "/opt/lib"}, m.Rpaths())
+}
+
+func TestFile_dylibs_notADylib(t *testing.T) {
+	m := &File{
+		File: &macho.File{
+			ByteOrder: binary.LittleEndian,
+			Loads: []macho.Load{
+				newTestLoadCommand(LcLoadDylib, "/usr/lib/libSystem.B.dylib"),
+			},
+		},
+	}
+
+	assert.Empty(t, m.DylibID())
+	assert.Empty(t, m.Rpaths())
+}
@@ -0,0 +1,256 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/anchore/quill/internal/log"
+)
+
+const (
+	// dylibCommandSize is the size of a dylib_command without the install name (which follows the command)
+	dylibCommandSize = 24
+	// rpathCommandSize is the size of an rpath_command without the path (which follows the command)
+	rpathCommandSize = 12
+)
+
+// PathChange replaces an install name or run path search path.
+type PathChange struct {
+	Old string
+	New string
+}
+
+// LoadCommandEdits are edits of the dylib and rpath load commands of a binary (as with Apple's install_name_tool).
+// Edits are applied in the same order as install_name_tool: deleting, changing then adding run paths, followed by
+// changing the install name of the dylib and of the dylibs it depends on.
+type LoadCommandEdits struct {
+	// ID replaces the install name of the dylib (LC_ID_DYLIB)
+	ID string
+	// ChangeDylibs replaces the install names of the dylibs the binary depends on (LC_LOAD_DYLIB and friends)
+	ChangeDylibs []PathChange
+	// ChangeRpaths replaces run path search paths (LC_RPATH)
+	ChangeRpaths []PathChange
+	// AddRpaths adds run path search paths after any existing run paths (LC_RPATH)
+	AddRpaths []string
+	// DeleteRpaths removes run path search paths (LC_RPATH)
+	DeleteRpaths []string
+}
+
+// IsEmpty indicates if there are no edits to make.
+func (e LoadCommandEdits) IsEmpty() bool {
+	return e.ID == "" && len(e.ChangeDylibs) == 0 && len(e.ChangeRpaths) == 0 && len(e.AddRpaths) == 0 && len(e.DeleteRpaths) == 0
+}
+
+// EditLoadCommands rewrites the load commands with the given edits. Load commands that change size move the load
+// commands that follow, where a HeaderSpaceError is returned when the edited load commands no longer fit before the
+// first section (the binary is untouched). Any existing code signature is invalidated, so the binary should be signed
+// afterwards.
+func (m *File) EditLoadCommands(e LoadCommandEdits) error {
+	if e.IsEmpty() {
+		return nil
+	}
+
+	edited, err := e.apply(m.rawLoadCommands(), m.loadCommandAlignment(), m.ByteOrder)
+	if err != nil {
+		return err
+	}
+
+	start := m.firstCmdOffset()
+	end, err := m.loadCommandSpaceEnd()
+	if err != nil {
+		return err
+	}
+
+	size := loadCommandsSize(edited)
+	if start+size > end {
+		// the linker left too little padding after the load commands
+		return fmt.Errorf("no room for the edited load commands: %w", &HeaderSpaceError{Needed: start + size - end})
+	}
+
+	log.WithFields("commands", len(edited), "size", size).Trace("rewriting load commands")
+
+	// the header and load commands are written together so that they are always consistent with each other
+	header := make([]byte, start)
+	if _, err := m.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("unable to read macho header: %w", err)
+	}
+	m.ByteOrder.PutUint32(header[16:], uint32(len(edited)))
+	m.ByteOrder.PutUint32(header[20:], uint32(size))
+
+	// zero out what remains of the original load commands when the edited load commands are smaller
+	region := make([]byte, start+max(size, uint64(m.Cmdsz)))
+	offset := copy(region, header)
+	for _, cmd := range edited {
+		offset += copy(region[offset:], cmd)
+	}
+
+	if err := m.Patch(region, len(region), 0); err != nil {
+		return fmt.Errorf("unable to patch load commands: %w", err)
+	}
+	return nil
+}
+
+// apply returns the load commands with the edits made, where new or resized load commands are padded to the given
+// alignment.
+//
+//nolint:gocyclo
+func (e LoadCommandEdits) apply(cmds [][]byte, align uint64, bo binary.ByteOrder) ([][]byte, error) {
+	isRpath := func(cmd []byte) bool {
+		return LoadCommandType(bo.Uint32(cmd)) == LcRpath
+	}
+	value := func(cmd []byte) string {
+		s, _ := loadCommandString(cmd, bo)
+		return s
+	}
+
+	for _, p := range e.DeleteRpaths {
+		found := false
+		var kept [][]byte
+		for _, cmd := range cmds {
+			if !found && isRpath(cmd) && value(cmd) == p {
+				found = true
+				continue
+			}
+			kept = append(kept, cmd)
+		}
+		if !found {
+			return nil, fmt.Errorf("no run path %q to delete", p)
+		}
+		cmds = kept
+	}
+
+	for _, c := range e.ChangeRpaths {
+		found := false
+		for i, cmd := range cmds {
+			if isRpath(cmd) && value(cmd) == c.Old {
+				cmds[i] = withLoadCommandString(cmd, c.New, align, bo)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no run path %q to change", c.Old)
+		}
+	}
+
+	for _, p := range e.AddRpaths {
+		// new run paths are searched last, and are kept before LC_CODE_SIGNATURE (which must be the last load command)
+		at := len(cmds)
+		for i, cmd := range cmds {
+			if isRpath(cmd) && value(cmd) == p {
+				return nil, fmt.Errorf("run path %q already exists", p)
+			}
+			if isRpath(cmd) {
+				at = i + 1
+			}
+		}
+		if at == len(cmds) && at > 0 && LoadCommandType(bo.Uint32(cmds[at-1])) == LcCodeSignature {
+			at--
+		}
+
+		cmd := make([]byte, rpathCommandSize)
+		bo.PutUint32(cmd, uint32(LcRpath))
+		bo.PutUint32(cmd[8:], rpathCommandSize)
+		cmd = withLoadCommandString(cmd, p, align, bo)
+
+		cmds = append(cmds[:at], append([][]byte{cmd}, cmds[at:]...)...)
+	}
+
+	if e.ID != "" {
+		found := false
+		for i, cmd := range cmds {
+			if LoadCommandType(bo.Uint32(cmd)) == LcIDDylib {
+				cmds[i] = withLoadCommandString(cmd, e.ID, align, bo)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("binary is not a dylib (no LC_ID_DYLIB to change)")
+		}
+	}
+
+	for _, c := range e.ChangeDylibs {
+		found := false
+		for i, cmd := range cmds {
+			if _, ok := dylibLoadCommands[LoadCommandType(bo.Uint32(cmd))]; ok && value(cmd) == c.Old {
+				cmds[i] = withLoadCommandString(cmd, c.New, align, bo)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("binary does not depend on %q", c.Old)
+		}
+	}
+
+	return cmds, nil
+}
+
+// withLoadCommandString returns the dylib or rpath load command with its string replaced by the given value, keeping
+// the fields that precede the string (e.g. the dylib versions). The command is padded to the given alignment.
+func withLoadCommandString(cmd []byte, value string, align uint64, bo binary.ByteOrder) []byte {
+	offset := bo.Uint32(cmd[8:])
+	if offset < 12 || uint64(offset) > uint64(len(cmd)) {
+		offset = rpathCommandSize
+		if LoadCommandType(bo.Uint32(cmd)) != LcRpath {
+			offset = dylibCommandSize
+		}
+	}
+
+	size := alignUp(uint64(offset)+uint64(len(value))+1, align)
+	out := make([]byte, size)
+	copy(out, cmd[:min(uint64(offset), uint64(len(cmd)))])
+	copy(out[offset:], value)
+
+	bo.PutUint32(out[4:], uint32(size))
+	bo.PutUint32(out[8:], offset)
+	return out
+}
+
+func (m *File) rawLoadCommands() [][]byte {
+	var cmds [][]byte
+	for _, l := range m.Loads {
+		cmds = append(cmds, append([]byte(nil), l.Raw()...))
+	}
+	return cmds
+}
+
+// loadCommandAlignment is the alignment of the size of load commands (8 bytes for 64-bit binaries, otherwise 4 bytes).
+func (m *File) loadCommandAlignment() uint64 {
+	if m.Magic == macho.Magic64 {
+		return 8
+	}
+	return 4
+}
+
+// loadCommandSpaceEnd returns the file offset that the load commands may extend to: the start of the first section
+// (or segment) content after the header.
+func (m *File) loadCommandSpaceEnd() (uint64, error) {
+	fileSize, err := m.getFileSize()
+	if err != nil {
+		return 0, err
+	}
+
+	end := uint64(fileSize)
+
+	for _, s := range m.Sections {
+		// zero fill sections have no content within the file
+		if s.Offset > 0 && s.Size > 0 && uint64(s.Offset) < end {
+			end = uint64(s.Offset)
+		}
+	}
+	for _, l := range m.Loads {
+		if s, ok := l.(*macho.Segment); ok && s.Offset > 0 && s.Filesz > 0 && s.Offset < end {
+			end = s.Offset
+		}
+	}
+	return end, nil
+}
+
+func loadCommandsSize(cmds [][]byte) uint64 {
+	var size uint64
+	for _, cmd := range cmds {
+		size += uint64(len(cmd))
+	}
+	return size
+}
@@ -0,0 +1,222 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+func TestFile_EditLoadCommands(t *testing.T) {
+	tests := []struct {
+		name          string
+		headerPadding uint32
+	}{
+		{
+			name:          "with room for the load commands",
+			headerPadding: 0x100,
+		},
+		{
+			name:          "with just enough room for the load commands",
+			headerPadding: 36 + 24,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := test.Macho32(t, macho.Cpu386, tt.headerPadding)
+			original, err := os.ReadFile(p)
+			require.NoError(t, err)
+
+			m, err := NewFile(p)
+			require.NoError(t, err)
+			defer m.Close()
+
+			text := m.Section("__text")
+			require.NotNil(t, text)
+			code, err := text.Data()
+			require.NoError(t, err)
+			ncmd, cmdsz := m.Ncmd, m.Cmdsz
+
+			require.NoError(t, m.EditLoadCommands(LoadCommandEdits{
+				AddRpaths: []string{"@executable_path/../lib", "/opt/lib"},
+			}))
+			assert.Equal(t, []string{"@executable_path/../lib", "/opt/lib"}, m.Rpaths())
+			assert.Equal(t, ncmd+2, m.Ncmd)
+			// each rpath command is padded to 4 bytes (for a 32-bit binary)
+			assert.Equal(t, cmdsz+36+24, m.Cmdsz)
+
+			require.NoError(t, m.EditLoadCommands(LoadCommandEdits{
+				DeleteRpaths: []string{"@executable_path/../lib"},
+				ChangeRpaths: []PathChange{{Old: "/opt/lib", New: "@loader_path"}},
+			}))
+			assert.Equal(t, []string{"@loader_path"}, m.Rpaths())
+			assert.Equal(t, ncmd+1, m.Ncmd)
+			assert.Equal(t, cmdsz+28, m.Cmdsz)
+
+			// the code is untouched
+			text = m.Section("__text")
+			require.NotNil(t, text)
+			actual, err := text.Data()
+			require.NoError(t, err)
+			assert.Equal(t, code, actual)
+
+			size, err := m.getFileSize()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(original)), size)
+		})
+	}
+}
+
+func TestFile_EditLoadCommands_withoutHeaderSpace(t *testing.T) {
+	p := test.Macho32(t, macho.Cpu386, 0x10)
+	original, err := os.ReadFile(p)
+	require.NoError(t, err)
+
+	m, err := NewFile(p)
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.EditLoadCommands(LoadCommandEdits{AddRpaths: []string{"@executable_path/../lib"}})
+
+	// the header space is never grown implicitly
+	var spaceErr *HeaderSpaceError
+	require.ErrorAs(t, err, &spaceErr)
+	assert.Equal(t, uint64(36-0x10), spaceErr.Needed)
+	assert.ErrorContains(t, err, "relink with -headerpad")
+
+	actual, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, original, actual)
+}
+
+func TestLoadCommandEdits_apply(t *testing.T) {
+	bo := binary.LittleEndian
+	dylib := func(cmd LoadCommandType, name string) []byte {
+		b := make([]byte, dylibCommandSize)
+		bo.PutUint32(b, uint32(cmd))
+		bo.PutUint32(b[8:], dylibCommandSize)
+		bo.PutUint32(b[12:], 2)       // timestamp
+		bo.PutUint32(b[16:], 0x10000) // current version
+		bo.PutUint32(b[20:], 0x10000) // compatibility version
+		return withLoadCommandString(b, name, 8, bo)
+	}
+	rpath := func(p string) []byte {
+		b := make([]byte, rpathCommandSize)
+		bo.PutUint32(b, uint32(LcRpath))
+		bo.PutUint32(b[8:], rpathCommandSize)
+		return withLoadCommandString(b, p, 8, bo)
+	}
+	codeSignature := make([]byte, 16)
+	bo.PutUint32(codeSignature, uint32(LcCodeSignature))
+	bo.PutUint32(codeSignature[4:], 16)
+
+	commandStrings := func(cmds [][]byte) []string {
+		var values []string
+		for _, cmd := range cmds {
+			if LoadCommandType(bo.Uint32(cmd)) == LcCodeSignature {
+				values = append(values, "signature")
+				continue
+			}
+			s, ok := loadCommandString(cmd, bo)
+			require.True(t, ok)
+			assert.Zero(t, len(cmd)%8, "command for %q is not aligned", s)
+			assert.Equal(t, uint32(len(cmd)), bo.Uint32(cmd[4:]))
+			values = append(values, s)
+		}
+		return values
+	}
+
+	tests := []struct {
+		name    string
+		cmds    [][]byte
+		edits   LoadCommandEdits
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "change the dylib id",
+			cmds:  [][]byte{dylib(LcIDDylib, "libfoo.dylib"), dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib")},
+			edits: LoadCommandEdits{ID: "@rpath/libfoo.1.dylib"},
+			want:  []string{"@rpath/libfoo.1.dylib", "/usr/lib/libSystem.B.dylib"},
+		},
+		{
+			name: "change dependent dylibs",
+			cmds: [][]byte{dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib"), dylib(LcLoadWeakDylib, "/opt/lib/libbar.dylib")},
+			edits: LoadCommandEdits{ChangeDylibs: []PathChange{
+				{Old: "/opt/lib/libbar.dylib", New: "@rpath/libbar.dylib"},
+			}},
+			want: []string{"/usr/lib/libSystem.B.dylib", "@rpath/libbar.dylib"},
+		},
+		{
+			name:  "add run paths after existing run paths",
+			cmds:  [][]byte{rpath("/a"), dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib")},
+			edits: LoadCommandEdits{AddRpaths: []string{"/b", "/c"}},
+			want:  []string{"/a", "/b", "/c", "/usr/lib/libSystem.B.dylib"},
+		},
+		{
+			name:  "add run paths before the code signature",
+			cmds:  [][]byte{dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib"), codeSignature},
+			edits: LoadCommandEdits{AddRpaths: []string{"/b"}},
+			want:  []string{"/usr/lib/libSystem.B.dylib", "/b", "signature"},
+		},
+		{
+			name:  "run paths are deleted and changed before they are added",
+			cmds:  [][]byte{rpath("/a"), rpath("/b")},
+			edits: LoadCommandEdits{DeleteRpaths: []string{"/a"}, ChangeRpaths: []PathChange{{Old: "/b", New: "/a"}}, AddRpaths: []string{"/b"}},
+			want:  []string{"/a", "/b"},
+		},
+		{
+			name:    "not a dylib",
+			cmds:    [][]byte{dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib")},
+			edits:   LoadCommandEdits{ID: "libfoo.dylib"},
+			wantErr: "not a dylib",
+		},
+		{
+			name:    "missing dependent dylib",
+			cmds:    [][]byte{dylib(LcLoadDylib, "/usr/lib/libSystem.B.dylib")},
+			edits:   LoadCommandEdits{ChangeDylibs: []PathChange{{Old: "/missing.dylib", New: "/other.dylib"}}},
+			wantErr: "does not depend on",
+		},
+		{
+			name:    "duplicate run path",
+			cmds:    [][]byte{rpath("/a")},
+			edits:   LoadCommandEdits{AddRpaths: []string{"/a"}},
+			wantErr: "already exists",
+		},
+		{
+			name:    "missing run path to delete",
+			cmds:    [][]byte{rpath("/a")},
+			edits:   LoadCommandEdits{DeleteRpaths: []string{"/b"}},
+			wantErr: "no run path",
+		},
+		{
+			name:    "missing run path to change",
+			cmds:    [][]byte{rpath("/a")},
+			edits:   LoadCommandEdits{ChangeRpaths: []PathChange{{Old: "/b", New: "/c"}}},
+			wantErr: "no run path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := tt.edits.apply(tt.cmds, 8, bo)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, commandStrings(cmds))
+
+			// the dylib versions are kept
+			for _, cmd := range cmds {
+				if _, ok := dylibLoadCommands[LoadCommandType(bo.Uint32(cmd))]; ok || LoadCommandType(bo.Uint32(cmd)) == LcIDDylib {
+					assert.Equal(t, uint32(0x10000), bo.Uint32(cmd[16:]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,601 @@
+package macho
+
+import (
+	"bufio"
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/anchore/quill/internal/log"
+)
+
+const (
+	// MagicFat64 is the magic number of a universal binary with 64-bit slice offsets and sizes (FAT_MAGIC_64), as is
+	// needed for slices beyond 4 GB.
+	MagicFat64 uint32 = 0xcafebabf
+
+	fatHeaderSize = 8
+	fatArchSize   = 20
+	fatArch64Size = 32
+
+	// cpuArm6432 is CPU_TYPE_ARM64_32 (arm64 with 32-bit pointers, as used by watchOS)
+	cpuArm6432 = macho.CpuArm | 0x02000000
+
+	// maxFatArches bounds the number of slices of a universal binary. Java class files share the universal magic
+	// number, where the field following the magic number is the class file version (which is always larger).
+	maxFatArches = 20
+
+	// maxFatAlign bounds the alignment of a slice (2^15, as is the largest alignment lipo uses)
+	maxFatAlign = 15
+)
+
+// FatArch describes a single-architecture slice within a universal binary.
+type FatArch struct {
+	Cpu    macho.Cpu
+	SubCpu uint32
+	// Offset is the file offset of the slice within the universal binary
+	Offset uint64
+	// Size is the size of the slice in bytes
+	Size uint64
+	// Align is the alignment of the slice offset as a power of 2
+	Align uint32
+}
+
+// Name returns the architecture name of the slice as used by lipo (e.g. "arm64", "x86_64" or "armv7").
+func (a FatArch) Name() string {
+	return ArchName(a.Cpu, a.SubCpu)
+}
+
+// ArchName returns the architecture name for the given CPU type and subtype as used by lipo (e.g. "arm64", "x86_64"
+// or "armv7").
+func ArchName(cpu macho.Cpu, subCPU uint32) string {
+	// the upper bits of the subtype are capabilities (e.g. the pointer authentication ABI version for arm64e)
+	subCPU &^= 0xff000000
+
+	switch cpu {
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuAmd64:
+		if subCPU == 8 {
+			return "x86_64h"
+		}
+		return "x86_64"
+	case macho.CpuArm:
+		switch subCPU {
+		case 6:
+			return "armv6"
+		case 9:
+			return "armv7"
+		case 11:
+			return "armv7s"
+		case 12:
+			return "armv7k"
+		}
+		return "arm"
+	case macho.CpuArm64:
+		if subCPU == 2 {
+			return "arm64e"
+		}
+		return "arm64"
+	case cpuArm6432:
+		return "arm64_32"
+	case macho.CpuPpc:
+		return "ppc"
+	case macho.CpuPpc64:
+		return "ppc64"
+	}
+	return fmt.Sprintf("cpu%d", uint32(cpu))
+}
+
+// FatFile is a universal binary, holding a Mach-O binary for each architecture.
+type FatFile struct {
+	// Magic is either macho.MagicFat or MagicFat64
+	Magic  uint32
+	Arches []FatArch
+	r      io.ReaderAt
+	closer io.Closer
+}
+
+// IsUniversalBinary indicates if the given content is a universal binary (with either a 32-bit or 64-bit header).
+func IsUniversalBinary(r io.ReaderAt) bool {
+	header := make([]byte, fatHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return false
+	}
+
+	switch binary.BigEndian.Uint32(header) {
+	case macho.MagicFat, MagicFat64:
+	default:
+		return false
+	}
+
+	n := binary.BigEndian.Uint32(header[4:])
+	return n > 0 && n <= maxFatArches
+}
+
+// OpenFatFile opens the universal binary at the given path (the file is closed by FatFile.Close).
+func OpenFatFile(path string) (*FatFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ff, err := NewFatFile(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ff.closer = f
+	return ff, nil
+}
+
+// NewFatFile parses the header of the universal binary with the given content and size, ensuring each slice is within
+// the binary and that slices do not overlap.
+func NewFatFile(r io.ReaderAt, size int64) (*FatFile, error) {
+	header := make([]byte, fatHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("unable to read universal binary header: %w", err)
+	}
+
+	magic := binary.BigEndian.Uint32(header)
+	archSize := fatArchSize
+	switch magic {
+	case macho.MagicFat:
+	case MagicFat64:
+		archSize = fatArch64Size
+	default:
+		return nil, fmt.Errorf("not a universal binary (magic=0x%x)", magic)
+	}
+
+	n := binary.BigEndian.Uint32(header[4:])
+	if n == 0 || n > maxFatArches {
+		return nil, fmt.Errorf("invalid number of architectures in universal binary: %d", n)
+	}
+
+	archBytes := make([]byte, int(n)*archSize)
+	if _, err := r.ReadAt(archBytes, fatHeaderSize); err != nil {
+		return nil, fmt.Errorf("unable to read universal binary architectures: %w", err)
+	}
+
+	ff := &FatFile{Magic: magic, r: r}
+	for i := 0; i < int(n); i++ {
+		b := archBytes[i*archSize:]
+		arch := FatArch{
+			Cpu:    macho.Cpu(binary.BigEndian.Uint32(b)),
+			SubCpu: binary.BigEndian.Uint32(b[4:]),
+		}
+		if magic == MagicFat64 {
+			arch.Offset = binary.BigEndian.Uint64(b[8:])
+			arch.Size = binary.BigEndian.Uint64(b[16:])
+			arch.Align = binary.BigEndian.Uint32(b[24:])
+		} else {
+			arch.Offset = uint64(binary.BigEndian.Uint32(b[8:]))
+			arch.Size = uint64(binary.BigEndian.Uint32(b[12:]))
+			arch.Align = binary.BigEndian.Uint32(b[16:])
+		}
+		ff.Arches = append(ff.Arches, arch)
+	}
+
+	if err := ff.validate(size); err != nil {
+		return nil, err
+	}
+	return ff, nil
+}
+
+func (f *FatFile) validate(size int64) error {
+	headerEnd := uint64(fatHeaderSize + len(f.Arches)*fatArchSize)
+	if f.Magic == MagicFat64 {
+		headerEnd = uint64(fatHeaderSize + len(f.Arches)*fatArch64Size)
+	}
+
+	arches := make([]FatArch, len(f.Arches))
+	copy(arches, f.Arches)
+	sort.Slice(arches, func(i, j int) bool { return arches[i].Offset < arches[j].Offset })
+
+	end := headerEnd
+	seen := make(map[[2]uint32]bool)
+	for _, a := range arches {
+		if a.Offset < end {
+			return fmt.Errorf("%s slice overlaps the universal binary header or another slice", a.Name())
+		}
+		if a.Size > uint64(size) || a.Offset > uint64(size)-a.Size {
+			return fmt.Errorf("%s slice extends beyond the universal binary (offset=%d, size=%d)", a.Name(), a.Offset, a.Size)
+		}
+		if a.Align > maxFatAlign {
+			return fmt.Errorf("%s slice has an invalid alignment (2^%d)", a.Name(), a.Align)
+		}
+		key := [2]uint32{uint32(a.Cpu), a.SubCpu &^ 0xff000000}
+		if seen[key] {
+			return fmt.Errorf("universal binary has more than one %s slice", a.Name())
+		}
+		seen[key] = true
+		end = a.Offset + a.Size
+	}
+	return nil
+}
+
+// SliceReader returns a reader for the content of the given slice.
+func (f *FatFile) SliceReader(a FatArch) *io.SectionReader {
+	return io.NewSectionReader(f.r, int64(a.Offset), int64(a.Size))
+}
+
+// ReadSlice reads the content of the given slice into memory.
+func (f *FatFile) ReadSlice(a FatArch) ([]byte, error) {
+	b := make([]byte, a.Size)
+	if _, err := io.ReadFull(f.SliceReader(a), b); err != nil {
+		return nil, fmt.Errorf("unable to read %s slice: %w", a.Name(), err)
+	}
+	return b, nil
+}
+
+// Close closes the underlying file (for a FatFile from OpenFatFile).
+func (f *FatFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// FatSlice is a single-architecture binary to write into a universal binary (see WriteFatFile).
+type FatSlice struct {
+	Cpu    macho.Cpu
+	SubCpu uint32
+	// Align is the alignment of the slice offset as a power of 2 (zero for the default alignment of the CPU type)
+	Align   uint32
+	Content io.ReaderAt
+	Size    int64
+}
+
+// NewFatSlice creates a slice for the given thin binary content, taking the CPU type from the Mach-O header.
+func NewFatSlice(b []byte) (FatSlice, error) {
+	if len(b) < fileHeaderSize32 || !HasMachoMagic(b) || IsUniversalBinary(bytes.NewReader(b)) {
+		return FatSlice{}, fmt.Errorf("not a thin Mach-O binary")
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	switch binary.BigEndian.Uint32(b) {
+	case macho.Magic32, macho.Magic64:
+		order = binary.BigEndian
+	}
+
+	return FatSlice{
+		Cpu:     macho.Cpu(order.Uint32(b[4:])),
+		SubCpu:  order.Uint32(b[8:]),
+		Content: bytes.NewReader(b),
+		Size:    int64(len(b)),
+	}, nil
+}
+
+// DefaultFatAlign returns the alignment that lipo uses for slices of the given CPU type (as a power of 2): the page
+// size of the platform.
+func DefaultFatAlign(cpu macho.Cpu) uint32 {
+	return vmPageSizeBits(cpu)
+}
+
+// WriteFatFile writes a universal binary holding the given slices (in order), returning the resulting architectures.
+// Each slice is placed at the next offset that satisfies its alignment. A FAT_MAGIC_64 header is written when fat64
+// is set or when a slice offset or size does not fit in 32 bits.
+func WriteFatFile(w io.Writer, slices []FatSlice, fat64 bool) ([]FatArch, error) {
+	if len(slices) == 0 {
+		return nil, fmt.Errorf("no slices to write into a universal binary")
+	}
+	if len(slices) > maxFatArches {
+		return nil, fmt.Errorf("too many slices for a universal binary: %d", len(slices))
+	}
+
+	arches, err := layoutFatArches(slices, fat64)
+	if err != nil {
+		return nil, err
+	}
+	if !fat64 {
+		for _, a := range arches {
+			if a.Offset+a.Size > math.MaxUint32 {
+				fat64 = true
+			}
+		}
+		if fat64 {
+			// the larger header may shift the slices
+			if arches, err = layoutFatArches(slices, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	header := fatHeader(arches, fat64)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("unable to write universal binary header: %w", err)
+	}
+
+	written := uint64(len(header))
+	for i, s := range slices {
+		a := arches[i]
+		if _, err := w.Write(make([]byte, a.Offset-written)); err != nil {
+			return nil, fmt.Errorf("unable to write universal binary padding: %w", err)
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(s.Content, 0, s.Size)); err != nil {
+			return nil, fmt.Errorf("unable to write %s slice: %w", a.Name(), err)
+		}
+		written = a.Offset + a.Size
+	}
+
+	return arches, nil
+}
+
+// fatHeader returns the universal binary header (and architectures) for the given architectures.
+func fatHeader(arches []FatArch, fat64 bool) []byte {
+	magic, archSize := macho.MagicFat, fatArchSize
+	if fat64 {
+		magic, archSize = MagicFat64, fatArch64Size
+	}
+
+	header := make([]byte, fatHeaderSize+len(arches)*archSize)
+	binary.BigEndian.PutUint32(header, magic)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(arches)))
+	for i, a := range arches {
+		b := header[fatHeaderSize+i*archSize:]
+		binary.BigEndian.PutUint32(b, uint32(a.Cpu))
+		binary.BigEndian.PutUint32(b[4:], a.SubCpu)
+		if fat64 {
+			binary.BigEndian.PutUint64(b[8:], a.Offset)
+			binary.BigEndian.PutUint64(b[16:], a.Size)
+			binary.BigEndian.PutUint32(b[24:], a.Align)
+		} else {
+			binary.BigEndian.PutUint32(b[8:], uint32(a.Offset))
+			binary.BigEndian.PutUint32(b[12:], uint32(a.Size))
+			binary.BigEndian.PutUint32(b[16:], a.Align)
+		}
+	}
+	return header
+}
+
+func layoutFatArches(slices []FatSlice, fat64 bool) ([]FatArch, error) {
+	archSize := fatArchSize
+	if fat64 {
+		archSize = fatArch64Size
+	}
+
+	offset := uint64(fatHeaderSize + len(slices)*archSize)
+	seen := make(map[[2]uint32]bool)
+	var arches []FatArch
+	for _, s := range slices {
+		align := s.Align
+		if align == 0 {
+			align = DefaultFatAlign(s.Cpu)
+		}
+
+		a := FatArch{Cpu: s.Cpu, SubCpu: s.SubCpu, Size: uint64(s.Size), Align: align}
+		if align > maxFatAlign {
+			return nil, fmt.Errorf("invalid alignment for the %s slice (2^%d)", a.Name(), align)
+		}
+		key := [2]uint32{uint32(s.Cpu), s.SubCpu &^ 0xff000000}
+		if seen[key] {
+			return nil, fmt.Errorf("more than one %s slice", a.Name())
+		}
+		seen[key] = true
+
+		a.Offset = alignUp(offset, 1<<align)
+		arches = append(arches, a)
+		offset = a.Offset + a.Size
+	}
+	return arches, nil
+}
+
+// FatFileSlice is a slice of a universal binary that can be modified (e.g. signed), either held in memory (see
+// ReadFatFileSlices) or in place within the universal binary file (see OpenFatFileSlices).
+type FatFileSlice struct {
+	Arch FatArch
+	File *File
+}
+
+// ReadFatFileSlices reads each slice of the universal binary at the given path into memory, returning the slices
+// along with the magic number of the universal binary.
+func ReadFatFileSlices(path string) ([]FatFileSlice, uint32, error) {
+	ff, err := OpenFatFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ff.Close()
+
+	var slices []FatFileSlice
+	for _, a := range ff.Arches {
+		b, err := ff.ReadSlice(a)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		m, err := NewFileFromBytes(b)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to parse %s slice: %w", a.Name(), err)
+		}
+		slices = append(slices, FatFileSlice{Arch: a, File: m})
+	}
+	return slices, ff.Magic, nil
+}
+
+// FatFileSlices are the slices of a universal binary file, modified in place at their offsets within the file (see
+// OpenFatFileSlices).
+type FatFileSlices struct {
+	Magic  uint32
+	Slices []FatFileSlice
+	path   string
+	file   *os.File
+	// contents are the contents of each slice (in the same order as Slices)
+	contents []*sliceFile
+}
+
+// OpenFatFileSlices opens each slice of the universal binary at the given path to be modified in place, without
+// reading the slices into memory. Each slice may grow into the padding that follows it (up to the next slice), where
+// a slice that grows any further is moved to memory. Once modified, Commit updates the universal binary for the new
+// slice sizes. The slices are independent of each other, so can be modified concurrently.
+func OpenFatFileSlices(path string) (*FatFileSlices, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open universal binary: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ff, err := NewFatFile(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s := &FatFileSlices{Magic: ff.Magic, path: path, file: f}
+	for _, a := range ff.Arches {
+		content := &sliceFile{file: f, offset: int64(a.Offset), size: int64(a.Size), limit: -1}
+		// the slice may grow up to the slice that follows it within the file
+		for _, other := range ff.Arches {
+			if other.Offset > a.Offset && (content.limit < 0 || int64(other.Offset-a.Offset) < content.limit) {
+				content.limit = int64(other.Offset - a.Offset)
+			}
+		}
+
+		m := &File{content: content, fileSize: -1}
+		if err := m.refresh(true); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("unable to parse %s slice: %w", a.Name(), err)
+		}
+		s.Slices = append(s.Slices, FatFileSlice{Arch: a, File: m})
+		s.contents = append(s.contents, content)
+	}
+	return s, nil
+}
+
+// Commit updates the universal binary for the modified slices. When every slice still fits where it was, only the
+// slice sizes within the universal binary header are updated. Otherwise, the universal binary is laid out again
+// (keeping the alignment of each slice) and replaces the file. The slices must not be modified afterwards.
+func (s *FatFileSlices) Commit() error {
+	arches := make([]FatArch, len(s.Slices))
+	relayout := false
+	var end uint64
+	for i, sl := range s.Slices {
+		a := sl.Arch
+		a.Size = uint64(s.contents[i].length())
+		if s.contents[i].moved() || (s.Magic != MagicFat64 && a.Offset+a.Size > math.MaxUint32) {
+			relayout = true
+		}
+		arches[i] = a
+		end = max(end, a.Offset+a.Size)
+	}
+
+	if relayout {
+		return s.relayout(arches)
+	}
+
+	if _, err := s.file.WriteAt(fatHeader(arches, s.Magic == MagicFat64), 0); err != nil {
+		return fmt.Errorf("unable to write universal binary header: %w", err)
+	}
+	if err := s.file.Truncate(int64(end)); err != nil {
+		return fmt.Errorf("unable to resize universal binary: %w", err)
+	}
+	for i := range s.Slices {
+		s.Slices[i].Arch = arches[i]
+	}
+	return nil
+}
+
+// relayout writes a new universal binary for the slices (with the given sizes) next to the file, which then replaces
+// the file.
+func (s *FatFileSlices) relayout(arches []FatArch) error {
+	log.WithFields("path", s.path).Debug("slices no longer fit within the universal binary, laying it out again")
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var fatSlices []FatSlice
+	for i, a := range arches {
+		fatSlices = append(fatSlices, FatSlice{
+			Cpu:     a.Cpu,
+			SubCpu:  a.SubCpu,
+			Align:   a.Align,
+			Content: s.contents[i],
+			Size:    int64(a.Size),
+		})
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create universal binary: %w", err)
+	}
+	defer os.Remove(out.Name())
+
+	w := bufio.NewWriter(out)
+	written, err := WriteFatFile(w, fatSlices, s.Magic == MagicFat64)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write universal binary: %w", err)
+	}
+
+	if err := os.Rename(out.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to replace universal binary: %w", err)
+	}
+	for i := range s.Slices {
+		s.Slices[i].Arch = written[i]
+	}
+	return nil
+}
+
+// Close closes the slices and the universal binary file.
+func (s *FatFileSlices) Close() error {
+	for _, sl := range s.Slices {
+		sl.File.Close()
+	}
+	return s.file.Close()
+}
+
+// OpenThinFiles opens each single-architecture binary at the given path: either the binary itself, or each slice of a
+// universal binary (held in memory). Each File must be closed by the caller.
+func OpenThinFiles(path string) ([]*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	universal := IsUniversalBinary(f)
+	f.Close()
+
+	if !universal {
+		m, err := NewReadOnlyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*File{m}, nil
+	}
+
+	slices, _, err := ReadFatFileSlices(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, s := range slices {
+		files = append(files, s.File)
+	}
+	return files, nil
+}
@@ -0,0 +1,371 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+func fatSlicesForTest(t *testing.T) []FatSlice {
+	t.Helper()
+
+	var slices []FatSlice
+	for _, cpu := range []macho.Cpu{macho.Cpu386, macho.CpuArm} {
+		b, err := os.ReadFile(test.Macho32(t, cpu, 0x100))
+		require.NoError(t, err)
+		s, err := NewFatSlice(b)
+		require.NoError(t, err)
+		slices = append(slices, s)
+	}
+	return slices
+}
+
+func TestWriteFatFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		align      []uint32
+		fat64      bool
+		wantMagic  uint32
+		wantAlign  []uint32
+		wantOffset []uint64
+	}{
+		{
+			name:       "default alignment",
+			align:      []uint32{0, 0},
+			wantMagic:  macho.MagicFat,
+			wantAlign:  []uint32{12, 14},
+			wantOffset: []uint64{0x1000, 0x4000},
+		},
+		{
+			name:       "custom alignment",
+			align:      []uint32{4, 15},
+			wantMagic:  macho.MagicFat,
+			wantAlign:  []uint32{4, 15},
+			wantOffset: []uint64{0x30, 0x8000},
+		},
+		{
+			name:       "fat64",
+			align:      []uint32{0, 0},
+			fat64:      true,
+			wantMagic:  MagicFat64,
+			wantAlign:  []uint32{12, 14},
+			wantOffset: []uint64{0x1000, 0x4000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slices := fatSlicesForTest(t)
+			for i := range slices {
+				slices[i].Align = tt.align[i]
+			}
+
+			var buf bytes.Buffer
+			arches, err := WriteFatFile(&buf, slices, tt.fat64)
+			require.NoError(t, err)
+			require.Len(t, arches, 2)
+
+			ff, err := NewFatFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMagic, ff.Magic)
+			assert.Equal(t, arches, ff.Arches)
+
+			for i, a := range ff.Arches {
+				assert.Equal(t, tt.wantAlign[i], a.Align)
+				assert.Equal(t, tt.wantOffset[i], a.Offset)
+				assert.Equal(t, uint64(slices[i].Size), a.Size)
+
+				expected := make([]byte, slices[i].Size)
+				_, err := slices[i].Content.ReadAt(expected, 0)
+				require.NoError(t, err)
+				actual, err := ff.ReadSlice(a)
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+			}
+			assert.Equal(t, []string{"i386", "armv7"}, []string{ff.Arches[0].Name(), ff.Arches[1].Name()})
+		})
+	}
+}
+
+func TestWriteFatFile_invalid(t *testing.T) {
+	slices := fatSlicesForTest(t)
+
+	_, err := WriteFatFile(&bytes.Buffer{}, nil, false)
+	assert.ErrorContains(t, err, "no slices")
+
+	_, err = WriteFatFile(&bytes.Buffer{}, []FatSlice{slices[0], slices[0]}, false)
+	assert.ErrorContains(t, err, "more than one i386 slice")
+
+	slices[0].Align = 16
+	_, err = WriteFatFile(&bytes.Buffer{}, slices, false)
+	assert.ErrorContains(t, err, "invalid alignment")
+}
+
+func TestNewFatFile_invalid(t *testing.T) {
+	fatHeader := func(arches ...[5]uint32) []byte {
+		b := binary.BigEndian.AppendUint32(nil, macho.MagicFat)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(arches)))
+		for _, a := range arches {
+			for _, v := range a {
+				b = binary.BigEndian.AppendUint32(b, v)
+			}
+		}
+		return append(b, make([]byte, 0x3000)...)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{
+			name:    "java class file",
+			content: append([]byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34}, make([]byte, 64)...),
+			wantErr: "invalid number of architectures",
+		},
+		{
+			name:    "thin binary",
+			content: append(binary.LittleEndian.AppendUint32(nil, macho.Magic64), make([]byte, 60)...),
+			wantErr: "not a universal binary",
+		},
+		{
+			name:    "slice overlaps header",
+			content: fatHeader([5]uint32{uint32(macho.CpuAmd64), 3, 0x10, 0x100, 12}),
+			wantErr: "overlaps",
+		},
+		{
+			name: "slices overlap",
+			content: fatHeader(
+				[5]uint32{uint32(macho.CpuAmd64), 3, 0x1000, 0x1000, 12},
+				[5]uint32{uint32(macho.CpuArm64), 0, 0x1800, 0x100, 12},
+			),
+			wantErr: "overlaps",
+		},
+		{
+			name:    "slice beyond the end of the file",
+			content: fatHeader([5]uint32{uint32(macho.CpuAmd64), 3, 0x1000, 0x10000, 12}),
+			wantErr: "extends beyond",
+		},
+		{
+			name: "duplicate architecture",
+			content: fatHeader(
+				[5]uint32{uint32(macho.CpuAmd64), 3, 0x1000, 0x100, 12},
+				[5]uint32{uint32(macho.CpuAmd64), 3, 0x2000, 0x100, 12},
+			),
+			wantErr: "more than one x86_64 slice",
+		},
+		{
+			name:    "invalid alignment",
+			content: fatHeader([5]uint32{uint32(macho.CpuAmd64), 3, 0x1000, 0x100, 40}),
+			wantErr: "invalid alignment",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFatFile(bytes.NewReader(tt.content), int64(len(tt.content)))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	assert.False(t, IsUniversalBinary(bytes.NewReader(tests[0].content)))
+}
+
+func TestArchName(t *testing.T) {
+	tests := []struct {
+		cpu    macho.Cpu
+		subCPU uint32
+		want   string
+	}{
+		{cpu: macho.Cpu386, subCPU: 3, want: "i386"},
+		{cpu: macho.CpuAmd64, subCPU: 3, want: "x86_64"},
+		{cpu: macho.CpuAmd64, subCPU: 8, want: "x86_64h"},
+		{cpu: macho.CpuArm, subCPU: 9, want: "armv7"},
+		{cpu: macho.CpuArm, subCPU: 11, want: "armv7s"},
+		{cpu: macho.CpuArm64, subCPU: 0, want: "arm64"},
+		{cpu: macho.CpuArm64, subCPU: 0x80000002, want: "arm64e"},
+		{cpu: cpuArm6432, subCPU: 1, want: "arm64_32"},
+		{cpu: macho.Cpu(42), subCPU: 0, want: "cpu42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, ArchName(tt.cpu, tt.subCPU))
+		})
+	}
+}
+
+func TestReadFatFileSlices(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "hello")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	_, err = WriteFatFile(f, fatSlicesForTest(t), true)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	original, err := os.ReadFile(p)
+	require.NoError(t, err)
+
+	fileSlices, magic, err := ReadFatFileSlices(p)
+	require.NoError(t, err)
+	require.Len(t, fileSlices, 2)
+	assert.Equal(t, MagicFat64, magic)
+
+	// modifying the slices in memory leaves the universal binary untouched
+	for _, s := range fileSlices {
+		require.NoError(t, s.File.AddEmptyCodeSigningCmd())
+		assert.True(t, s.File.HasCodeSigningCmd())
+	}
+	actual, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, original, actual)
+}
+
+func TestOpenFatFileSlices(t *testing.T) {
+	tests := []struct {
+		name  string
+		align []uint32
+		fat64 bool
+		// grow is the number of bytes appended to each slice
+		grow         int
+		wantRelayout bool
+		wantOffset   []uint64
+	}{
+		{
+			name:       "slices fit within the padding",
+			align:      []uint32{0, 0},
+			grow:       0x100,
+			wantOffset: []uint64{0x1000, 0x4000},
+		},
+		{
+			name:       "slices keep a 64-bit header",
+			align:      []uint32{0, 0},
+			fat64:      true,
+			grow:       0x100,
+			wantOffset: []uint64{0x1000, 0x4000},
+		},
+		{
+			name:         "slice grows beyond the padding",
+			align:        []uint32{5, 5},
+			grow:         0x100,
+			wantRelayout: true,
+			wantOffset:   []uint64{0x40, 0x1160},
+		},
+		{
+			name:         "slice grows beyond the next slice",
+			align:        []uint32{0, 0},
+			grow:         0x4000,
+			wantRelayout: true,
+			wantOffset:   []uint64{0x1000, 0x8000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slices := fatSlicesForTest(t)
+			for i := range slices {
+				slices[i].Align = tt.align[i]
+			}
+
+			p := filepath.Join(t.TempDir(), "hello")
+			f, err := os.Create(p)
+			require.NoError(t, err)
+			_, err = WriteFatFile(f, slices, tt.fat64)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			original, err := OpenFatFile(p)
+			require.NoError(t, err)
+			originalArches := original.Arches
+			require.NoError(t, original.Close())
+
+			fileSlices, err := OpenFatFileSlices(p)
+			require.NoError(t, err)
+			defer fileSlices.Close()
+			require.Len(t, fileSlices.Slices, 2)
+
+			var want [][]byte
+			for _, s := range fileSlices.Slices {
+				size, err := s.File.getFileSize()
+				require.NoError(t, err)
+				signature := bytes.Repeat([]byte{0xfa}, tt.grow)
+				require.NoError(t, s.File.Patch(signature, len(signature), uint64(size)))
+
+				content := make([]byte, size+int64(tt.grow))
+				_, err = s.File.ReadAt(content, 0)
+				require.NoError(t, err)
+				want = append(want, content)
+			}
+
+			// the slices are modified in place unless they no longer fit
+			for i, c := range fileSlices.contents {
+				assert.Equal(t, tt.wantRelayout && i == 0, c.moved())
+			}
+
+			require.NoError(t, fileSlices.Commit())
+
+			ff, err := OpenFatFile(p)
+			require.NoError(t, err)
+			defer ff.Close()
+
+			wantMagic := macho.MagicFat
+			if tt.fat64 {
+				wantMagic = MagicFat64
+			}
+			assert.Equal(t, wantMagic, ff.Magic)
+			require.Len(t, ff.Arches, 2)
+			for i, a := range ff.Arches {
+				assert.Equal(t, tt.wantOffset[i], a.Offset)
+				assert.Equal(t, originalArches[i].Align, a.Align)
+				assert.Equal(t, uint64(len(want[i])), a.Size)
+				assert.Equal(t, a, fileSlices.Slices[i].Arch)
+
+				b, err := ff.ReadSlice(a)
+				require.NoError(t, err)
+				assert.Equal(t, want[i], b)
+			}
+		})
+	}
+}
+
+func TestOpenFatFileSlices_shrink(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "hello")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	_, err = WriteFatFile(f, fatSlicesForTest(t), false)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	original, err := os.ReadFile(p)
+	require.NoError(t, err)
+
+	fileSlices, err := OpenFatFileSlices(p)
+	require.NoError(t, err)
+	defer fileSlices.Close()
+
+	sizes := make([]int64, len(fileSlices.Slices))
+	for i, s := range fileSlices.Slices {
+		sizes[i], err = s.File.getFileSize()
+		require.NoError(t, err)
+		require.NoError(t, s.File.Patch([]byte("signature"), 9, uint64(sizes[i])))
+	}
+	require.NoError(t, fileSlices.Commit())
+
+	// removing what was added restores the universal binary, where the padding between slices is zeros again
+	fileSlices, err = OpenFatFileSlices(p)
+	require.NoError(t, err)
+	defer fileSlices.Close()
+
+	for i, s := range fileSlices.Slices {
+		require.NoError(t, s.File.truncate(sizes[i]))
+	}
+	require.NoError(t, fileSlices.Commit())
+
+	actual, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, original, actual)
+}
@@ -2,18 +2,18 @@
 package macho
 
 import (
-	"bytes"
 	"debug/macho"
 	"encoding/binary"
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"os"
+	"slices"
 	"unsafe"
 
 	"github.com/go-restruct/restruct"
 
-	macholibre "github.com/anchore/go-macholibre"
 	"github.com/anchore/quill/internal/log"
 )
 
@@ -68,11 +68,29 @@ const (
 
 type File struct {
 	path string
+	// content holds a binary that is not backed by a file of its own, either in memory (see NewFileFromBytes) or as a
+	// slice within a universal binary file (see OpenFatFileSlices)
+	content resizableContent
 	io.ReadSeekCloser
 	io.ReaderAt
 	io.WriterAt
 	*macho.File
 	fileSize int64 // cached file size, -1 if not determined
+	// pageHashes are the page hashes of the binary for each kind of hasher, kept between signing passes (where only the
+	// pages that are patched need to be hashed again)
+	pageHashes map[pageHashKey]*cachedPageHashes
+}
+
+// pageHashKey identifies a kind of hasher (where the size differs between hashers of the same type, e.g. SHA-384 and
+// SHA-512).
+type pageHashKey struct {
+	hasher string
+	size   int
+}
+
+type cachedPageHashes struct {
+	limit  uint32
+	hashes [][]byte
 }
 
 func NewFile(path string) (*File, error) {
@@ -93,6 +111,43 @@ func NewReadOnlyFile(path string) (*File, error) {
 	return m, m.refresh(false)
 }
 
+// NewFileFromBytes creates a File for binary content held in memory (e.g. a slice of a universal binary), where
+// modifications are made to the content in memory instead of to a file (see Bytes).
+func NewFileFromBytes(b []byte) (*File, error) {
+	m := &File{
+		content:  &memoryFile{b: b},
+		fileSize: -1,
+	}
+
+	return m, m.refresh(true)
+}
+
+// Bytes returns the (possibly modified) content of a binary held in memory (see NewFileFromBytes), or nil for a binary
+// backed by a file.
+func (m *File) Bytes() []byte {
+	if mf, ok := m.content.(*memoryFile); ok {
+		return mf.b
+	}
+	return nil
+}
+
+// HasMachoMagic indicates if the given leading bytes of a file start with the magic number of a Mach-O binary (thin or
+// universal). Java class files share the universal magic number, so IsMachoFile should be used to confirm.
+func HasMachoMagic(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(b) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat, MagicFat64:
+		return true
+	}
+	switch binary.LittleEndian.Uint32(b) {
+	case macho.Magic32, macho.Magic64:
+		return true
+	}
+	return false
+}
+
 func IsMachoFile(path string) (bool, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -100,7 +155,7 @@ func IsMachoFile(path string) (bool, error) {
 	}
 	defer f.Close()
 
-	if macholibre.IsUniversalMachoBinary(f) {
+	if IsUniversalBinary(f) {
 		return true, nil
 	}
 
@@ -115,14 +170,9 @@ func (m *File) refresh(withWrite bool) error {
 		}
 	}
 
-	flags := os.O_RDONLY
-	if withWrite {
-		flags = os.O_RDWR
-	}
-
-	f, err := os.OpenFile(m.path, flags, 0755)
+	f, err := m.open(withWrite)
 	if err != nil {
-		return fmt.Errorf("unable to open macho file: %w", err)
+		return err
 	}
 
 	o, err := macho.NewFile(f)
@@ -145,6 +195,37 @@ func (m *File) refresh(withWrite bool) error {
 	return nil
 }
 
+// fileContent is the content of the binary, either a file or held elsewhere (see resizableContent).
+type fileContent interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	io.WriterAt
+}
+
+// resizableContent is content that is not backed by a file of its own, so is resized directly instead of by truncating
+// a file.
+type resizableContent interface {
+	fileContent
+	truncate(size int64) error
+}
+
+func (m *File) open(withWrite bool) (fileContent, error) {
+	if m.content != nil {
+		return m.content, nil
+	}
+
+	flags := os.O_RDONLY
+	if withWrite {
+		flags = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(m.path, flags, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open macho file: %w", err)
+	}
+	return f, nil
+}
+
 func (m *File) Close() error {
 	if err := m.ReadSeekCloser.Close(); err != nil {
 		return err
@@ -201,6 +282,7 @@ func (m *File) Patch(content []byte, size int, offset uint64) (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to patch macho binary: %w", err)
 	}
+	m.invalidatePageHashes(offset, uint64(size))
 	m.fileSize = -1 // invalidate cached file size before refresh
 	return m.refresh(true)
 }
@@ -240,7 +322,8 @@ func (m *File) AddEmptyCodeSigningCmd() (err error) {
 		return fmt.Errorf("loader command already exists, cannot add another")
 	}
 	if !m.hasRoomForNewCmd() {
-		return fmt.Errorf("no room for a new loader command")
+		// the linker left no padding after the load commands
+		return fmt.Errorf("no room for a new loader command: %w", &HeaderSpaceError{Needed: uint64(unsafe.Sizeof(CodeSigningCommand{}))})
 	}
 
 	// since there is no signing command, we know that the __LINKEDIT section does not
@@ -305,15 +388,14 @@ func (m *File) UpdateSegmentHeader(h macho.SegmentHeader) (err error) {
 
 	var offset = m.firstCmdOffset()
 	for _, l := range m.Loads {
-		if s, ok := l.(*macho.Segment); ok {
-			if s.Name == h.Name {
-				break
-			}
-			offset += uint64(s.Len)
+		if s, ok := l.(*macho.Segment); ok && s.Name == h.Name {
+			return m.Patch(b, len(b), offset)
 		}
+		// all load commands (not only segments) may precede the segment
+		offset += uint64(len(l.Raw()))
 	}
 
-	return m.Patch(b, len(b), offset)
+	return fmt.Errorf("unable to find segment %q", h.Name)
 }
 
 func (m *File) HasCodeSigningCmd() bool {
@@ -321,6 +403,9 @@ func (m *File) HasCodeSigningCmd() bool {
 	return offset != 0
 }
 
+// RemoveSigningContent strips the code signature from the binary, leaving it as it was before signing: the
+// LC_CODE_SIGNATURE load command is removed, the __LINKEDIT segment no longer accounts for the signature data, and the
+// file is truncated to where the signature data began.
 func (m *File) RemoveSigningContent() error {
 	if !m.HasCodeSigningCmd() {
 		return nil
@@ -344,6 +429,15 @@ func (m *File) RemoveSigningContent() error {
 	if !m.isSigningCommandLastLoader() {
 		return fmt.Errorf("code signing command is not the last loader command, so cannot remove it (easily) without corrupting the binary")
 	}
+
+	linkEditSeg := m.Segment("__LINKEDIT")
+	if linkEditSeg == nil {
+		return fmt.Errorf("unable to find __LINKEDIT segment")
+	}
+	if uint64(cmd.DataOffset) < linkEditSeg.Offset || uint64(cmd.DataOffset) > linkEditSeg.Offset+linkEditSeg.Filesz {
+		return fmt.Errorf("code signing data is not within the __LINKEDIT segment (offset=%d)", cmd.DataOffset)
+	}
+
 	// update the macho header to reflect the removed command
 	header := m.FileHeader
 	header.Ncmd--
@@ -364,14 +458,65 @@ func (m *File) RemoveSigningContent() error {
 		return fmt.Errorf("unable to remove signing loader command: %w", err)
 	}
 
-	log.Trace("overwrite the signing superblob with zeros")
-	if err := m.Patch(make([]byte, cmd.DataSize), int(cmd.DataSize), uint64(cmd.DataOffset)); err != nil {
+	log.Trace("shrink the __LINKEDIT segment to end where the signing superblob began")
+	linkEditSeg.Filesz = uint64(cmd.DataOffset) - linkEditSeg.Offset
+	// the original VM size cannot be known for certain, so follow the convention already present: Apple's linker pads
+	// the VM size to a page boundary, while others (e.g. the go linker) use the file size as-is
+	memsz := linkEditSeg.Filesz
+	if linkEditSeg.Memsz%segmentAlignment(m.Cpu) == 0 {
+		memsz = alignUp(linkEditSeg.Filesz, segmentAlignment(m.Cpu))
+	}
+	if memsz < linkEditSeg.Memsz {
+		linkEditSeg.Memsz = memsz
+	}
+	if err := m.UpdateSegmentHeader(linkEditSeg.SegmentHeader); err != nil {
+		return fmt.Errorf("unable to update __LINKEDIT segment: %w", err)
+	}
+
+	log.Trace("truncate the signing superblob from the binary")
+	if err := m.truncate(int64(cmd.DataOffset)); err != nil {
 		return fmt.Errorf("unable to remove superblob from binary: %w", err)
 	}
 
 	return nil
 }
 
+// truncate resizes the underlying file to the given size.
+func (m *File) truncate(size int64) error {
+	if m.WriterAt == nil {
+		return fmt.Errorf("writes not allowed")
+	}
+	m.pageHashes = nil
+	if m.content != nil {
+		if err := m.content.truncate(size); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(m.path, size); err != nil {
+		return err
+	}
+	m.fileSize = -1 // invalidate cached file size before refresh
+	return m.refresh(true)
+}
+
+// vmPageSizeBits is the VM page size of the platform for the given CPU type (as a power of 2), which segments are
+// aligned to within a binary and slices are aligned to within a universal binary (see DefaultFatAlign).
+func vmPageSizeBits(cpu macho.Cpu) uint32 {
+	switch cpu {
+	case macho.CpuArm, macho.CpuArm64, cpuArm6432:
+		return 14
+	}
+	return 12
+}
+
+// segmentAlignment is the VM page size that segments are aligned to for the given CPU type.
+func segmentAlignment(cpu macho.Cpu) uint64 {
+	return 1 << vmPageSizeBits(cpu)
+}
+
+func alignUp(v, alignment uint64) uint64 {
+	return (v + alignment - 1) &^ (alignment - 1)
+}
+
 func (m *File) isSigningCommandLastLoader() bool {
 	var found bool
 	for _, l := range m.Loads {
@@ -405,6 +550,8 @@ func (m *File) CodeSigningCmd() (*CodeSigningCommand, uint64, error) {
 	return nil, 0, nil
 }
 
+// HashPages hashes each page of the binary up to the existing code signature (the LC_CODE_SIGNATURE load command
+// must already be in place).
 func (m *File) HashPages(hasher hash.Hash) (hashes [][]byte, err error) {
 	cmd, _, err := m.CodeSigningCmd()
 	if err != nil {
@@ -416,141 +563,146 @@ func (m *File) HashPages(hasher hash.Hash) (hashes [][]byte, err error) {
 		return nil, fmt.Errorf("LcCodeSignature is not present, any generated page hashes will be wrong. Bailing")
 	}
 
-	// validate DataOffset is within file bounds before allocating memory via io.ReadAll
-	if err := m.validateDataRange(0, cmd.DataOffset, "code signing data offset"); err != nil {
+	return m.HashPagesUntil(hasher, cmd.DataOffset)
+}
+
+// HashPagesUntil hashes each page of the binary up to the given offset (the code limit). The content is streamed and
+// hashed across cores, where the hashes are cached so that hashing again (e.g. on the second signing pass) only hashes
+// the pages that have been patched since.
+func (m *File) HashPagesUntil(hasher hash.Hash, limit uint32) (hashes [][]byte, err error) {
+	if err := m.validateDataRange(0, limit, "code signing data offset"); err != nil {
 		return nil, err
 	}
 
-	if _, err = m.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("unable to seek within macho binary: %w", err)
+	key := pageHashKey{hasher: fmt.Sprintf("%T", hasher), size: hasher.Size()}
+
+	// only whole pages within both the previous and current code limit can be reused (unless the limit is unchanged)
+	var cached [][]byte
+	if c, ok := m.pageHashes[key]; ok {
+		cached = c.hashes
+		if c.limit != limit {
+			cached = cached[:min(uint64(len(cached)), uint64(min(c.limit, limit))/PageSize)]
+		}
 	}
 
-	limitedReader := io.LimitReader(m, int64(cmd.DataOffset))
-	b, err := io.ReadAll(limitedReader)
+	hashes, err = hashPages(m.ReaderAt, int64(limit), PageSize, hasher, cached)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read binary: %w", err)
+		return nil, fmt.Errorf("unable to hash pages: %w", err)
 	}
 
-	hashes, err = hashChunks(hasher, PageSize, b)
+	if m.pageHashes == nil {
+		m.pageHashes = make(map[pageHashKey]*cachedPageHashes)
+	}
+	// the cache holds its own copy, since patching the binary drops cached hashes (which must not change the result)
+	m.pageHashes[key] = &cachedPageHashes{limit: limit, hashes: slices.Clone(hashes)}
 
-	log.WithFields("pages", len(hashes), "offset", int64(cmd.DataOffset)).Trace("hashed pages")
+	log.WithFields("pages", len(hashes), "cached", countCached(cached), "offset", int64(limit)).Trace("hashed pages")
 
-	return hashes, err
+	return hashes, nil
 }
 
-func (m *File) CDBytes(order binary.ByteOrder, ith int) (cd []byte, err error) {
-	csBlob, superBlobReader, err := m.readSuperBlob()
-	if err != nil {
-		return nil, err
+func countCached(hashes [][]byte) int {
+	var count int
+	for _, h := range hashes {
+		if h != nil {
+			count++
+		}
 	}
+	return count
+}
 
-	var found int
-	for _, index := range csBlob.Index {
-		if index.Type != CsSlotCodedirectory && index.Type != CsSlotAlternateCodedirectories {
-			continue
+// invalidatePageHashes drops the cached hashes of the pages that overlap the given range of the file.
+func (m *File) invalidatePageHashes(offset, size uint64) {
+	if size == 0 {
+		return
+	}
+	for _, c := range m.pageHashes {
+		for i := offset / PageSize; i <= (offset+size-1)/PageSize && i < uint64(len(c.hashes)); i++ {
+			c.hashes[i] = nil
 		}
+	}
+}
 
-		found++
-		if found <= ith {
-			continue
-		}
+// CodeLimit returns the offset up to which the binary content is covered by a code signature: the start of the
+// existing signature data, or the whole file for a binary without an LC_CODE_SIGNATURE load command (as is the case
+// when creating a detached signature).
+func (m *File) CodeLimit() (uint32, error) {
+	cmd, _, err := m.CodeSigningCmd()
+	if err != nil {
+		return 0, fmt.Errorf("unable to extract code signing cmd: %w", err)
+	}
+	if cmd != nil {
+		return cmd.DataOffset, nil
+	}
 
-		return m.readBlobBytes(superBlobReader, index, order, "code directory")
+	size, err := m.getFileSize()
+	if err != nil {
+		return 0, err
 	}
-	return nil, ErrNoCodeDirectory
+	if size > math.MaxUint32 {
+		return 0, fmt.Errorf("binary is too large to sign (%d bytes)", size)
+	}
+	return uint32(size), nil
 }
 
-// readSuperBlob reads and validates the code signing superblob, returning the parsed blob and a reader.
-func (m *File) readSuperBlob() (*SuperBlob, *bytes.Reader, error) {
+// CodeSignature returns the code signing superblob embedded within the binary.
+func (m *File) CodeSignature() (*CodeSignature, error) {
 	cmd, _, err := m.CodeSigningCmd()
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to extract code signing cmd: %w", err)
+		return nil, fmt.Errorf("unable to extract code signing cmd: %w", err)
 	}
 
 	if cmd == nil {
-		return nil, nil, fmt.Errorf("no code signing command found")
+		return nil, fmt.Errorf("no code signing command found")
 	}
 
 	if cmd.DataSize > maxSuperBlobSize {
-		return nil, nil, fmt.Errorf("superblob size exceeds maximum (%d > %d)", cmd.DataSize, maxSuperBlobSize)
+		return nil, fmt.Errorf("superblob size exceeds maximum (%d > %d)", cmd.DataSize, maxSuperBlobSize)
 	}
 	if err := m.validateDataRange(cmd.DataOffset, cmd.DataSize, "code signing superblob"); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	superBlobBytes := make([]byte, cmd.DataSize)
 	if _, err := m.ReadAt(superBlobBytes, int64(cmd.DataOffset)); err != nil {
-		return nil, nil, fmt.Errorf("unable to extract code signing block from macho binary: %w", err)
-	}
-
-	superBlobReader := bytes.NewReader(superBlobBytes)
-
-	csBlob := &SuperBlob{}
-	if err := binary.Read(superBlobReader, SigningOrder, &csBlob.SuperBlobHeader); err != nil {
-		return nil, nil, fmt.Errorf("unable to extract superblob header from macho binary: %w", err)
-	}
-
-	if csBlob.Count > maxBlobCount {
-		return nil, nil, fmt.Errorf("blob count exceeds maximum (%d > %d)", csBlob.Count, maxBlobCount)
+		return nil, fmt.Errorf("unable to extract code signing block from macho binary: %w", err)
 	}
 
-	csBlob.Index = make([]BlobIndex, csBlob.Count)
-	if err := binary.Read(superBlobReader, SigningOrder, &csBlob.Index); err != nil {
-		return nil, nil, err
-	}
-
-	return csBlob, superBlobReader, nil
+	return NewCodeSignature(superBlobBytes)
 }
 
-// readBlobBytes reads and returns the raw bytes of a blob at the given index.
-func (m *File) readBlobBytes(reader *bytes.Reader, index BlobIndex, order binary.ByteOrder, blobName string) ([]byte, error) {
-	if _, err := reader.Seek(int64(index.Offset), io.SeekStart); err != nil {
-		return nil, fmt.Errorf("unable to seek to %s blob: %w", blobName, err)
-	}
-
-	var blobHeader BlobHeader
-	if err := binary.Read(reader, SigningOrder, &blobHeader); err != nil {
+func (m *File) CDBytes(order binary.ByteOrder, ith int) (cd []byte, err error) {
+	sig, err := m.CodeSignature()
+	if err != nil {
 		return nil, err
 	}
+	return sig.CDBytes(order, ith)
+}
 
-	if blobHeader.Length > maxBlobLength {
-		return nil, fmt.Errorf("%s blob size exceeds maximum (%d > %d)", blobName, blobHeader.Length, maxBlobLength)
-	}
-
-	// validate that the blob fits within the superblob buffer (defense in depth against malicious length values)
-	superBlobSize := reader.Size()
-	if int64(index.Offset)+int64(blobHeader.Length) > superBlobSize {
-		return nil, fmt.Errorf("%s blob extends beyond superblob (offset=%d + length=%d > %d)", blobName, index.Offset, blobHeader.Length, superBlobSize)
-	}
-
-	// seek back to the beginning of the blob to read the full content
-	if _, err := reader.Seek(int64(index.Offset), io.SeekStart); err != nil {
-		return nil, fmt.Errorf("unable to seek to %s: %w", blobName, err)
-	}
-
-	blobBytes := make([]byte, blobHeader.Length)
-	if err := binary.Read(reader, order, &blobBytes); err != nil {
+func (m *File) CMSBlobBytes(order binary.ByteOrder) (cd []byte, err error) {
+	sig, err := m.CodeSignature()
+	if err != nil {
 		return nil, err
 	}
-
-	return blobBytes, nil
+	return sig.CMSBlobBytes(order)
 }
 
-var ErrNoCodeDirectory = fmt.Errorf("unable to find code directory")
-
-func (m *File) CMSBlobBytes(order binary.ByteOrder) (cd []byte, err error) {
-	csBlob, superBlobReader, err := m.readSuperBlob()
+// BlobBytes returns the raw bytes (including the blob header) of the first blob within the superblob for the given slot type.
+func (m *File) BlobBytes(order binary.ByteOrder, slot SlotType) ([]byte, error) {
+	sig, err := m.CodeSignature()
 	if err != nil {
 		return nil, err
 	}
+	return sig.BlobBytes(order, slot)
+}
 
-	for _, index := range csBlob.Index {
-		if index.Type != CsSlotCmsSignature {
-			continue
-		}
-		return m.readBlobBytes(superBlobReader, index, order, "CMS")
+// BlobSlots returns the slot types of all blobs within the superblob (in the order found).
+func (m *File) BlobSlots() ([]SlotType, error) {
+	sig, err := m.CodeSignature()
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unable to find CMS blob")
+	return sig.BlobSlots()
 }
 
 func (m *File) HashCD(hasher hash.Hash) (hash []byte, err error) {
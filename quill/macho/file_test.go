@@ -2,6 +2,7 @@ package macho
 
 import (
 	"crypto/sha256"
+	"debug/macho"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -299,6 +300,11 @@ func TestFile_UpdateSegmentHeader(t *testing.T) {
 			binaryPath: test.AssetCopy(t, "hello"),
 			segment:    "__LINKEDIT",
 		},
+		{
+			name:       "can modify single elements in a 32-bit segment header",
+			binaryPath: test.Macho32(t, macho.Cpu386, 0x100),
+			segment:    "__LINKEDIT",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -610,6 +616,57 @@ func TestFile_CMSBlobBytes_ValidationOversizedBlobCount(t *testing.T) {
 // standard library's macho.NewFile() validates command block sizes during parsing and
 // rejects malformed binaries before our validation runs. This provides defense-in-depth.
 
+func TestFile_RemoveSigningContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		binaryPath string
+	}{
+		{
+			name:       "adhoc signed binary",
+			binaryPath: test.AssetCopy(t, "hello_adhoc_signed"),
+		},
+		{
+			name:       "signed binary",
+			binaryPath: test.AssetCopy(t, "syft_signed"),
+		},
+		{
+			name:       "signed binary extracted from universal binary",
+			binaryPath: test.AssetCopy(t, "ls_x86_64_signed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewFile(tt.binaryPath)
+			require.NoError(t, err)
+
+			cmd, _, err := m.CodeSigningCmd()
+			require.NoError(t, err)
+			require.NotNil(t, cmd)
+			ncmd := m.Ncmd
+
+			require.NoError(t, m.RemoveSigningContent())
+			require.NoError(t, m.Close())
+
+			m, err = NewReadOnlyFile(tt.binaryPath)
+			require.NoError(t, err)
+			defer m.Close()
+
+			assert.False(t, m.HasCodeSigningCmd())
+			assert.Equal(t, ncmd-1, m.Ncmd)
+
+			// the signature data is removed from the end of the file and from the __LINKEDIT segment
+			info, err := os.Stat(tt.binaryPath)
+			require.NoError(t, err)
+			assert.Equal(t, int64(cmd.DataOffset), info.Size())
+
+			linkEdit := m.Segment("__LINKEDIT")
+			require.NotNil(t, linkEdit)
+			assert.Equal(t, uint64(cmd.DataOffset), linkEdit.Offset+linkEdit.Filesz)
+			assert.GreaterOrEqual(t, linkEdit.Memsz, linkEdit.Filesz)
+		})
+	}
+}
+
 func TestFile_RemoveSigningContent_ValidationOversizedDataSize(t *testing.T) {
 	// use a copy of a real signed binary and patch it with oversized DataSize
 	originalPath := test.AssetCopy(t, "hello_adhoc_signed")
@@ -692,3 +749,23 @@ func TestFile_ValidDataRangePassing(t *testing.T) {
 		})
 	}
 }
+
+func TestHasMachoMagic(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   bool
+	}{
+		{name: "64-bit little endian", prefix: []byte{0xcf, 0xfa, 0xed, 0xfe, 0x0c, 0x00, 0x00, 0x01}, want: true},
+		{name: "32-bit little endian", prefix: []byte{0xce, 0xfa, 0xed, 0xfe}, want: true},
+		{name: "64-bit big endian", prefix: []byte{0xfe, 0xed, 0xfa, 0xcf}, want: true},
+		{name: "universal", prefix: []byte{0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x02}, want: true},
+		{name: "zip", prefix: []byte("PK\x03\x04"), want: false},
+		{name: "too short", prefix: []byte{0xcf, 0xfa, 0xed}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HasMachoMagic(tt.prefix))
+		})
+	}
+}
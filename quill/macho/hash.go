@@ -1,9 +1,19 @@
 package macho
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha1" //nolint: gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
 	"hash"
 	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -17,33 +27,141 @@ const (
 
 type HashType uint8
 
-func hashChunks(hasher hash.Hash, chunkSize int, data []byte) (hashes [][]byte, err error) {
-	var dataSize = len(data)
-	var dataReader = bytes.NewReader(data)
-	var buf = make([]byte, chunkSize)
+var hashTypeNames = map[HashType]string{
+	HashTypeSha1:            "sha1",
+	HashTypeSha256:          "sha256",
+	HashTypeSha256Truncated: "sha256-truncated",
+	HashTypeSha384:          "sha384",
+	HashTypeSha512:          "sha512",
+}
 
-loop:
-	for idx := 0; idx < dataSize; {
-		bufferLen, err := io.ReadFull(dataReader, buf)
-		switch err {
-		case nil, io.ErrUnexpectedEOF:
-			break
-		case io.EOF:
-			break loop
-		default:
-			return nil, err
+// ParseHashType returns the hash type for the given name (e.g. "sha1" or "sha256"), as used by codesign.
+func ParseHashType(name string) (HashType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for t, n := range hashTypeNames {
+		if n == name {
+			return t, nil
 		}
+	}
+	return HashTypeNohash, fmt.Errorf("unsupported hash type: %q", name)
+}
+
+func (t HashType) String() string {
+	if n, ok := hashTypeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
+// Hasher returns a new hasher for the hash type. Note: truncated hash types return the full hasher, it is up to the
+// caller to truncate the digest to the hash size declared in the code directory.
+func (t HashType) Hasher() (hash.Hash, error) {
+	switch t {
+	case HashTypeSha1:
+		return sha1.New(), nil //nolint: gosec
+	case HashTypeSha256, HashTypeSha256Truncated:
+		return sha256.New(), nil
+	case HashTypeSha384:
+		return sha512.New384(), nil
+	case HashTypeSha512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash type: %d", t)
+	}
+}
+
+// pageHashWorkers bounds the number of goroutines that hash pages concurrently.
+var pageHashWorkers = runtime.GOMAXPROCS(0)
+
+// pageHashBatchSize is the number of pages that are read (with a single read) and hashed by a worker at a time.
+const pageHashBatchSize = 256
+
+// hashPages hashes each pageSize chunk of the first size bytes of the content (where the last chunk may be short).
+// The content is streamed in batches of pages that are hashed across workers, each with its own clone of the hasher
+// (hashers that cannot be cloned are used from a single worker). Pages that already have a hash in cached are not
+// read again.
+func hashPages(r io.ReaderAt, size int64, pageSize int, hasher hash.Hash, cached [][]byte) ([][]byte, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+
+	pages := int((size + int64(pageSize) - 1) / int64(pageSize))
+	hashes := make([][]byte, pages)
+	copy(hashes, cached)
+
+	batches := (pages + pageHashBatchSize - 1) / pageHashBatchSize
+	workers := min(pageHashWorkers, batches)
+	cloner, ok := hasher.(hash.Cloner)
+	if !ok || workers < 1 {
+		workers = 1
+	}
 
-		if idx+bufferLen > dataSize {
-			bufferLen = dataSize - idx
+	var next atomic.Int64
+	g, ctx := errgroup.WithContext(context.Background())
+	for w := 0; w < workers; w++ {
+		h := hasher
+		if workers > 1 {
+			clone, err := cloner.Clone()
+			if err != nil {
+				return nil, fmt.Errorf("unable to clone hasher: %w", err)
+			}
+			h = clone
 		}
-		idx += bufferLen
 
-		hasher.Reset()
-		hasher.Write(buf[:bufferLen])
-		sum := hasher.Sum(nil)
+		g.Go(func() error {
+			buf := make([]byte, pageHashBatchSize*pageSize)
+			for ctx.Err() == nil {
+				batch := int(next.Add(1) - 1)
+				if batch >= batches {
+					return nil
+				}
+				if err := hashPageBatch(r, size, pageSize, h, hashes, batch, buf); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		})
+	}
 
-		hashes = append(hashes, sum)
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 	return hashes, nil
 }
+
+// hashPageBatch reads and hashes the pages of the given batch that have no hash yet.
+func hashPageBatch(r io.ReaderAt, size int64, pageSize int, hasher hash.Hash, hashes [][]byte, batch int, buf []byte) error {
+	first := batch * pageHashBatchSize
+	last := min(first+pageHashBatchSize, len(hashes))
+
+	missing := false
+	for i := first; i < last; i++ {
+		if hashes[i] == nil {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	offset := int64(first) * int64(pageSize)
+	buf = buf[:min(int64(len(buf)), size-offset)]
+	if n, err := r.ReadAt(buf, offset); n < len(buf) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("unable to read pages at offset %d: %w", offset, err)
+		}
+		return fmt.Errorf("unable to read pages at offset %d: read %d of %d bytes", offset, n, len(buf))
+	}
+
+	for i := first; i < last; i++ {
+		if hashes[i] != nil {
+			continue
+		}
+		start := (i - first) * pageSize
+		hasher.Reset()
+		hasher.Write(buf[start:min(start+pageSize, len(buf))])
+		hashes[i] = hasher.Sum(nil)
+	}
+	return nil
+}
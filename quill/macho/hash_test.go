@@ -1,16 +1,25 @@
 package macho
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"debug/macho"
 	"fmt"
 	"hash"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
 )
 
-func Test_hashChunks(t *testing.T) {
+func Test_hashPages(t *testing.T) {
 	type args struct {
 		hasher    hash.Hash
 		chunkSize int
@@ -78,7 +87,7 @@ func Test_hashChunks(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotHashes, err := hashChunks(tt.args.hasher, tt.args.chunkSize, []byte(tt.args.data))
+			gotHashes, err := hashPages(strings.NewReader(tt.args.data), int64(len(tt.args.data)), tt.args.chunkSize, tt.args.hasher, nil)
 			require.NoError(t, err)
 			var gotHexHash []string
 			for _, b := range gotHashes {
@@ -88,3 +97,200 @@ func Test_hashChunks(t *testing.T) {
 		})
 	}
 }
+
+func TestParseHashType(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    HashType
+		wantErr require.ErrorAssertionFunc
+	}{
+		{name: "sha1", want: HashTypeSha1},
+		{name: "sha256", want: HashTypeSha256},
+		{name: " SHA256 ", want: HashTypeSha256},
+		{name: "sha384", want: HashTypeSha384},
+		{name: "md5", want: HashTypeNohash, wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			got, err := ParseHashType(tt.name)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+			if err == nil {
+				assert.Equal(t, strings.TrimSpace(strings.ToLower(tt.name)), got.String())
+			}
+		})
+	}
+}
+
+// uncloneableHasher hides the Clone method of the hasher, so that pages are hashed by a single worker.
+type uncloneableHasher struct {
+	hash.Hash
+}
+
+func Test_hashPages_parallel(t *testing.T) {
+	// several batches of pages, with a short last page
+	data := make([]byte, (3*pageHashBatchSize+10)*PageSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var expected [][]byte
+	for i := 0; i < len(data); i += PageSize {
+		sum := sha256.Sum256(data[i:min(i+PageSize, len(data))])
+		expected = append(expected, sum[:])
+	}
+
+	original := pageHashWorkers
+	t.Cleanup(func() { pageHashWorkers = original })
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			pageHashWorkers = workers
+
+			hashes, err := hashPages(bytes.NewReader(data), int64(len(data)), PageSize, sha256.New(), nil)
+			require.NoError(t, err)
+			assert.Equal(t, expected, hashes)
+
+			hashes, err = hashPages(bytes.NewReader(data), int64(len(data)), PageSize, uncloneableHasher{sha256.New()}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, expected, hashes)
+
+			// cached hashes are kept as-is (without reading the content again)
+			cached := make([][]byte, 2*pageHashBatchSize)
+			cached[1], cached[pageHashBatchSize+1] = []byte("page 1"), []byte("page 257")
+			hashes, err = hashPages(bytes.NewReader(data), int64(len(data)), PageSize, sha256.New(), cached)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("page 1"), hashes[1])
+			assert.Equal(t, []byte("page 257"), hashes[pageHashBatchSize+1])
+			assert.Equal(t, expected[2:pageHashBatchSize+1], hashes[2:pageHashBatchSize+1])
+		})
+	}
+
+	// a single worker, so that the first batch is the one to fail
+	pageHashWorkers = 1
+	_, err := hashPages(bytes.NewReader(data[:PageSize]), int64(len(data)), PageSize, sha256.New(), nil)
+	assert.EqualError(t, err, fmt.Sprintf("unable to read pages at offset 0: read %d of %d bytes", PageSize, pageHashBatchSize*PageSize))
+
+	// a short read without an error is still reported
+	_, err = hashPages(shortReaderAt{bytes.NewReader(data)}, int64(len(data)), PageSize, sha256.New(), nil)
+	assert.EqualError(t, err, fmt.Sprintf("unable to read pages at offset 0: read %d of %d bytes", pageHashBatchSize*PageSize-1, pageHashBatchSize*PageSize))
+}
+
+// shortReaderAt reads one byte less than asked for, without reporting an error.
+type shortReaderAt struct {
+	io.ReaderAt
+}
+
+func (r shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, _ := r.ReaderAt.ReadAt(p[:len(p)-1], off)
+	return n, nil
+}
+
+func TestFile_HashPagesUntil_cached(t *testing.T) {
+	b, err := os.ReadFile(test.Macho32(t, macho.Cpu386, 0x100))
+	require.NoError(t, err)
+	// the string table starts the second page
+	require.Len(t, b, PageSize+8)
+
+	m, err := NewFileFromBytes(b)
+	require.NoError(t, err)
+	limit := uint32(len(b))
+
+	hashes, err := m.HashPagesUntil(sha256.New(), limit)
+	require.NoError(t, err)
+	require.Len(t, hashes, 2)
+	original := slices.Clone(hashes)
+
+	// patching the second page leaves the hash of the first page cached
+	require.NoError(t, m.Patch([]byte("x"), 1, PageSize+2))
+	cached := m.pageHashes[pageHashKey{hasher: fmt.Sprintf("%T", sha256.New()), size: sha256.Size}]
+	require.NotNil(t, cached)
+	assert.Equal(t, [][]byte{original[0], nil}, cached.hashes)
+	// the hashes already returned are left untouched
+	assert.Equal(t, original, hashes)
+
+	patched, err := m.HashPagesUntil(sha256.New(), limit)
+	require.NoError(t, err)
+	assert.Equal(t, original[0], patched[0])
+	require.NotNil(t, patched[1])
+	assert.NotEqual(t, original[1], patched[1])
+
+	// the same as hashing the patched binary from scratch
+	fresh, err := NewFileFromBytes(m.Bytes())
+	require.NoError(t, err)
+	expected, err := fresh.HashPagesUntil(sha256.New(), limit)
+	require.NoError(t, err)
+	assert.Equal(t, expected, patched)
+
+	// a shorter code limit only reuses the whole pages within it
+	shorter, err := m.HashPagesUntil(sha256.New(), PageSize+4)
+	require.NoError(t, err)
+	sum := sha256.Sum256(m.Bytes()[PageSize : PageSize+4])
+	assert.Equal(t, [][]byte{original[0], sum[:]}, shorter)
+}
+
+// benchmarkBinary is a binary with size bytes of (random) content after a minimal 32-bit executable.
+func benchmarkBinary(b *testing.B, size int) []byte {
+	b.Helper()
+
+	content, err := os.ReadFile(test.Macho32(b, macho.Cpu386, 0x100))
+	require.NoError(b, err)
+
+	extra := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(extra)
+	return append(content, extra...)
+}
+
+func BenchmarkHashPages(b *testing.B) {
+	data := benchmarkBinary(b, 256<<20)
+
+	original := pageHashWorkers
+	b.Cleanup(func() { pageHashWorkers = original })
+
+	for name, workers := range map[string]int{"sequential": 1, "parallel": original} {
+		b.Run(name, func(b *testing.B) {
+			pageHashWorkers = workers
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if _, err := hashPages(bytes.NewReader(data), int64(len(data)), PageSize, sha256.New(), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFile_HashPagesUntil compares hashing every page with the second signing pass, where only the header is
+// patched (and so only the first page is hashed again).
+func BenchmarkFile_HashPagesUntil(b *testing.B) {
+	data := benchmarkBinary(b, 256<<20)
+	limit := uint32(len(data))
+
+	b.Run("uncached", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			m, err := NewFileFromBytes(data)
+			require.NoError(b, err)
+			if _, err := m.HashPagesUntil(sha256.New(), limit); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("header patched", func(b *testing.B) {
+		m, err := NewFileFromBytes(bytes.Clone(data))
+		require.NoError(b, err)
+		_, err = m.HashPagesUntil(sha256.New(), limit)
+		require.NoError(b, err)
+
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			// the flags of the mach header
+			require.NoError(b, m.Patch(data[24:28], 4, 24))
+			if _, err := m.HashPagesUntil(sha256.New(), limit); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
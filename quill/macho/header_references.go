@@ -0,0 +1,119 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// S_ATTR_PURE_INSTRUCTIONS | S_ATTR_SOME_INSTRUCTIONS
+	sectionAttrInstructions = 0x80000400
+	// arm64 instructions (and masks) that form addresses relative to the program counter
+	arm64AdrMask     = 0x9f000000
+	arm64Adr         = 0x10000000
+	arm64Adrp        = 0x90000000
+	arm64AddImmMask  = 0x7fc00000
+	arm64AddImm      = 0x11000000
+	arm64LdStImmMask = 0x3b000000
+	arm64LdStImm     = 0x39000000
+	// arm64PairWindow is the number of instructions after an ADRP that are searched for the instruction adding the
+	// offset within the page
+	arm64PairWindow = 8
+)
+
+// checkHeaderReferences ensures that the given code (at the given address) does not refer to the Mach-O header (or the
+// load commands) relative to the program counter, as with __dso_handle in C++ and Swift code. Growing the header space
+// moves the code away from the header, so such references can not be updated (there are no relocation entries to say
+// where they are) and would no longer point at the header. This is best effort: only the address computations emitted
+// by compilers are found, and code for which these can not be found (e.g. i386 and armv7, where code refers to
+// addresses relative to a PIC base register) is rejected.
+func (g *headerGrowth) checkHeaderReferences(code []byte, addr uint64) error {
+	header := func(target uint64) bool {
+		return target >= g.segments[g.text].addr && target < g.insertAddr
+	}
+
+	var found uint64
+	var ok bool
+	switch g.cpu {
+	case macho.CpuArm64, cpuArm6432:
+		found, ok = findArm64HeaderReference(code, addr, g.bo, header)
+	case macho.CpuAmd64:
+		found, ok = findAmd64HeaderReference(code, addr, header)
+	default:
+		return fmt.Errorf("unable to check %s code for references to the Mach-O header", ArchName(g.cpu, 0))
+	}
+
+	if ok {
+		return fmt.Errorf("code at 0x%x refers to the Mach-O header relative to the program counter (e.g. via __dso_handle)", found)
+	}
+	return nil
+}
+
+// findArm64HeaderReference returns the address of the first ADR or ADRP (paired with the instruction adding the offset
+// within the page) whose target is within the header.
+func findArm64HeaderReference(code []byte, addr uint64, bo binary.ByteOrder, header func(uint64) bool) (uint64, bool) {
+	for i := 0; i+4 <= len(code); i += 4 {
+		pc := addr + uint64(i)
+		insn := bo.Uint32(code[i:])
+
+		switch insn & arm64AdrMask {
+		case arm64Adr:
+			if header(pc + arm64AdrImmediate(insn)) {
+				return pc, true
+			}
+		case arm64Adrp:
+			page := pc&^0xfff + arm64AdrImmediate(insn)<<12
+			if !header(page) {
+				continue
+			}
+			off, paired := arm64PageOffset(code[i+4:], bo, insn&0x1f)
+			if !paired || header(page+off) {
+				// the whole page may be referenced, which can not be ruled out
+				return pc, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// arm64AdrImmediate returns the (sign extended) 21-bit immediate of an ADR or ADRP instruction.
+func arm64AdrImmediate(insn uint32) uint64 {
+	imm := int64(insn>>5&0x7ffff)<<2 | int64(insn>>29&0x3)
+	return uint64(imm << 43 >> 43)
+}
+
+// arm64PageOffset finds the instruction following an ADRP that adds the offset within the page to the given register
+// (an ADD immediate, or the offset of a load or store), returning the offset.
+func arm64PageOffset(code []byte, bo binary.ByteOrder, reg uint32) (uint64, bool) {
+	for i := 0; i+4 <= len(code) && i < arm64PairWindow*4; i += 4 {
+		insn := bo.Uint32(code[i:])
+		if insn>>5&0x1f != reg {
+			continue
+		}
+		switch {
+		case insn&arm64AddImmMask == arm64AddImm:
+			return uint64(insn >> 10 & 0xfff), true
+		case insn&arm64LdStImmMask == arm64LdStImm:
+			return uint64(insn>>10&0xfff) << (insn >> 30), true
+		}
+	}
+	return 0, false
+}
+
+// findAmd64HeaderReference returns the address of the first LEA relative to the instruction pointer whose target is
+// within the header. The code is scanned at every byte since instructions are of varying length.
+func findAmd64HeaderReference(code []byte, addr uint64, header func(uint64) bool) (uint64, bool) {
+	// REX.W prefix, LEA opcode, ModRM for [rip+disp32] and a 32-bit displacement
+	const size = 7
+	for i := 0; i+size <= len(code); i++ {
+		if code[i]&0xf8 != 0x48 || code[i+1] != 0x8d || code[i+2]&0xc7 != 0x05 {
+			continue
+		}
+		disp := int32(binary.LittleEndian.Uint32(code[i+3:]))
+		if header(addr + uint64(i) + size + uint64(int64(disp))) {
+			return addr + uint64(i), true
+		}
+	}
+	return 0, false
+}
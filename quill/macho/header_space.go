@@ -0,0 +1,1319 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/anchore/quill/internal/log"
+)
+
+// load commands that hold file offsets, addresses or offsets from the Mach-O header (besides the dylib commands in
+// dylib.go and LC_CODE_SIGNATURE)
+const (
+	lcSegment            LoadCommandType = 0x1
+	lcSymtab             LoadCommandType = 0x2
+	lcSymseg             LoadCommandType = 0x3
+	lcThread             LoadCommandType = 0x4
+	lcUnixThread         LoadCommandType = 0x5
+	lcDysymtab           LoadCommandType = 0xb
+	lcRoutines           LoadCommandType = 0x11
+	lcTwoLevelHints      LoadCommandType = 0x16
+	lcSegment64          LoadCommandType = 0x19
+	lcRoutines64         LoadCommandType = 0x1a
+	lcSegmentSplitInfo   LoadCommandType = 0x1e
+	lcEncryptionInfo     LoadCommandType = 0x21
+	lcDyldInfo           LoadCommandType = 0x22
+	lcFunctionStarts     LoadCommandType = 0x26
+	lcDataInCode         LoadCommandType = 0x29
+	lcDylibCodeSignDrs   LoadCommandType = 0x2b
+	lcEncryptionInfo64   LoadCommandType = 0x2c
+	lcLinkerOptimization LoadCommandType = 0x2e
+	lcNote               LoadCommandType = 0x31
+	lcAtomInfo           LoadCommandType = 0x36
+	lcDyldInfoOnly       LoadCommandType = 0x80000022
+	lcMain               LoadCommandType = 0x80000028
+	lcDyldExportsTrie    LoadCommandType = 0x80000033
+	lcDyldChainedFixups  LoadCommandType = 0x80000034
+	lcFilesetEntry       LoadCommandType = 0x80000035
+)
+
+const (
+	sectionTypeMask      = 0xff
+	sectionInitOffsets   = 0x16 // S_INIT_FUNC_OFFSETS: 32-bit offsets from the header to initializers
+	exportKindMask       = 0x03
+	exportKindAbsolute   = 0x02
+	exportReexport       = 0x08
+	exportStubResolver   = 0x10
+	rebaseTypePointer    = 1
+	rebaseTypeAbsolute32 = 2
+	maxTrieDepth         = 128
+)
+
+// HeaderSpaceError is returned when there is not enough room after the load commands for new (or edited) load
+// commands. The binary should be relinked with more room, or the header space grown explicitly (see GrowHeaderSpace).
+type HeaderSpaceError struct {
+	// Needed is the number of bytes needed after the load commands
+	Needed uint64
+}
+
+func (e *HeaderSpaceError) Error() string {
+	return fmt.Sprintf("not enough header space after the load commands (%d more bytes needed), relink with -headerpad to leave room for them", e.Needed)
+}
+
+// GrowHeaderSpace makes room for at least size more bytes of load commands, for binaries where the linker left no
+// padding after the load commands. Padding (a multiple of the segment alignment) is inserted right after the load
+// commands, shifting everything that follows by the same amount within both the file and the address space. Since
+// all code and data moves together, references relative to the program counter remain valid, while the file offsets,
+// addresses and header-relative offsets held by the load commands, symbol table, rebase and chained fixup targets,
+// exports, function starts, data in code, unwind info and initializer offsets are all updated. Code which refers to the
+// Mach-O header itself relative to the program counter (e.g. via __dso_handle in C++ and Swift code) can not be
+// updated, so binaries with such references are rejected (see checkHeaderReferences), as are binaries with rebases or
+// binds in __TEXT, relocation entries, or otherwise unsupported content, rather than risk producing a broken binary.
+// This is never done implicitly when signing, relinking with -headerpad (or -headerpad_max_install_names) is the
+// preferred way to make room for load commands.
+func (m *File) GrowHeaderSpace(size uint64) error {
+	fileSize, err := m.getFileSize()
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, fileSize)
+	if _, err := m.ReadAt(data, 0); err != nil {
+		return fmt.Errorf("unable to read binary: %w", err)
+	}
+
+	grown, err := growHeaderSpace(data, m.ByteOrder, m.Cpu, size)
+	if err != nil {
+		return fmt.Errorf("unable to grow the header space: %w", err)
+	}
+
+	log.WithFields("bytes", len(grown)-len(data)).Debug("grew header space for new load commands")
+
+	if m.WriterAt == nil {
+		return fmt.Errorf("writes not allowed")
+	}
+	if _, err := m.WriteAt(grown, 0); err != nil {
+		return fmt.Errorf("unable to write binary: %w", err)
+	}
+	// everything after the load commands has moved, so no page hash can be reused
+	m.pageHashes = nil
+	m.fileSize = -1 // invalidate cached file size before refresh
+	return m.refresh(true)
+}
+
+// segmentLayout is the original placement of a segment, from its load command.
+type segmentLayout struct {
+	name   string
+	addr   uint64
+	memsz  uint64
+	offset uint64
+	filesz uint64
+	// cmdOffset is the file offset of the segment load command
+	cmdOffset uint64
+}
+
+// headerGrowth tracks the insertion of padding after the load commands, where content at or after the insertion
+// point (in the original file or address space) moves by delta.
+type headerGrowth struct {
+	bo   binary.ByteOrder
+	cpu  macho.Cpu
+	is64 bool
+	// out is the binary with the padding inserted
+	out        []byte
+	insert     uint64
+	insertAddr uint64
+	delta      uint64
+	segments   []segmentLayout
+	text       int
+	linkedit   int
+	// linkeditGrowth is the number of bytes appended to __LINKEDIT (for content that no longer fits where it was)
+	linkeditGrowth uint64
+}
+
+func growHeaderSpace(data []byte, bo binary.ByteOrder, cpu macho.Cpu, size uint64) ([]byte, error) {
+	if len(data) < fileHeaderSize32 {
+		return nil, fmt.Errorf("binary is too small")
+	}
+
+	g := headerGrowth{bo: bo, cpu: cpu, text: -1, linkedit: -1}
+
+	headerSize := uint64(fileHeaderSize32)
+	switch bo.Uint32(data) {
+	case macho.Magic64:
+		g.is64 = true
+		headerSize = fileHeaderSize64
+	case macho.Magic32:
+	default:
+		return nil, fmt.Errorf("not a thin Mach-O binary")
+	}
+
+	ncmds := bo.Uint32(data[16:])
+	sizeofcmds := uint64(bo.Uint32(data[20:]))
+	g.insert = headerSize + sizeofcmds
+	if g.insert > uint64(len(data)) {
+		return nil, fmt.Errorf("load commands extend beyond the file")
+	}
+
+	cmds, err := loadCommandOffsets(data, bo, headerSize, ncmds, g.insert)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, off := range cmds {
+		cmd := LoadCommandType(bo.Uint32(data[off:]))
+		if cmd != lcSegment && cmd != lcSegment64 {
+			continue
+		}
+		seg := g.readSegment(data, off)
+		if seg.offset == 0 && seg.filesz > 0 {
+			g.text = len(g.segments)
+		}
+		if seg.name == "__LINKEDIT" {
+			g.linkedit = len(g.segments)
+		}
+		g.segments = append(g.segments, seg)
+	}
+
+	if g.text < 0 {
+		return nil, fmt.Errorf("no segment maps the Mach-O header (expected __TEXT)")
+	}
+	if g.segments[g.text].filesz < g.insert {
+		return nil, fmt.Errorf("__TEXT segment does not cover the load commands")
+	}
+
+	g.insertAddr = g.segments[g.text].addr + g.insert
+	g.delta = alignUp(size, segmentAlignment(cpu))
+
+	g.out = make([]byte, uint64(len(data))+g.delta)
+	copy(g.out, data[:g.insert])
+	copy(g.out[g.insert+g.delta:], data[g.insert:])
+
+	// the load commands are before the insertion point, so are at the same offsets within the new binary
+	for _, off := range cmds {
+		if err := g.fixLoadCommand(off); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.finishLinkedit(); err != nil {
+		return nil, err
+	}
+
+	return g.out, nil
+}
+
+// loadCommandOffsets returns the file offset of each load command, ensuring each is within the load command region.
+func loadCommandOffsets(data []byte, bo binary.ByteOrder, headerSize uint64, ncmds uint32, end uint64) ([]uint64, error) {
+	var offsets []uint64
+	off := headerSize
+	for i := uint32(0); i < ncmds; i++ {
+		if off+8 > end {
+			return nil, fmt.Errorf("load command %d extends beyond the load commands", i)
+		}
+		cmdsize := uint64(bo.Uint32(data[off+4:]))
+		if cmdsize < 8 || off+cmdsize > end {
+			return nil, fmt.Errorf("load command %d has an invalid size (%d)", i, cmdsize)
+		}
+		offsets = append(offsets, off)
+		off += cmdsize
+	}
+	return offsets, nil
+}
+
+func (g *headerGrowth) readSegment(data []byte, off uint64) segmentLayout {
+	seg := segmentLayout{
+		name:      string(bytes.TrimRight(data[off+8:off+24], "\x00")),
+		cmdOffset: off,
+	}
+	if g.is64 {
+		seg.addr = g.bo.Uint64(data[off+24:])
+		seg.memsz = g.bo.Uint64(data[off+32:])
+		seg.offset = g.bo.Uint64(data[off+40:])
+		seg.filesz = g.bo.Uint64(data[off+48:])
+	} else {
+		seg.addr = uint64(g.bo.Uint32(data[off+24:]))
+		seg.memsz = uint64(g.bo.Uint32(data[off+28:]))
+		seg.offset = uint64(g.bo.Uint32(data[off+32:]))
+		seg.filesz = uint64(g.bo.Uint32(data[off+36:]))
+	}
+	return seg
+}
+
+// shiftOffset returns the new value of a file offset (or offset from the header within the address space).
+func (g *headerGrowth) shiftOffset(v uint64) uint64 {
+	if v >= g.insert {
+		return v + g.delta
+	}
+	return v
+}
+
+// shiftAddr returns the new value of an address.
+func (g *headerGrowth) shiftAddr(v uint64) uint64 {
+	if v >= g.insertAddr {
+		return v + g.delta
+	}
+	return v
+}
+
+func (g *headerGrowth) ptrSize() uint64 {
+	if g.is64 {
+		return 8
+	}
+	return 4
+}
+
+func (g *headerGrowth) u32(off uint64) uint32 {
+	return g.bo.Uint32(g.out[off:])
+}
+
+func (g *headerGrowth) u64(off uint64) uint64 {
+	return g.bo.Uint64(g.out[off:])
+}
+
+func (g *headerGrowth) putU32(off uint64, v uint64) error {
+	if v > math.MaxUint32 {
+		return fmt.Errorf("value 0x%x at offset 0x%x does not fit in 32 bits once shifted", v, off)
+	}
+	g.bo.PutUint32(g.out[off:], uint32(v))
+	return nil
+}
+
+func (g *headerGrowth) putU64(off uint64, v uint64) {
+	g.bo.PutUint64(g.out[off:], v)
+}
+
+// shiftU32Offset updates the 32-bit file offset at the given position (when set).
+func (g *headerGrowth) shiftU32Offset(off uint64) error {
+	v := uint64(g.u32(off))
+	if v == 0 {
+		return nil
+	}
+	return g.putU32(off, g.shiftOffset(v))
+}
+
+// ptr reads an address sized for the binary.
+func (g *headerGrowth) ptr(off uint64) uint64 {
+	if g.is64 {
+		return g.u64(off)
+	}
+	return uint64(g.u32(off))
+}
+
+func (g *headerGrowth) putPtr(off uint64, v uint64) error {
+	if g.is64 {
+		g.putU64(off, v)
+		return nil
+	}
+	return g.putU32(off, v)
+}
+
+// region returns the bounds of the content at the given (new) offset and size, ensuring it is within the binary.
+func (g *headerGrowth) region(off, size uint64, what string) ([]byte, error) {
+	if off > uint64(len(g.out)) || size > uint64(len(g.out))-off {
+		return nil, fmt.Errorf("%s extends beyond the file (offset=0x%x, size=0x%x)", what, off, size)
+	}
+	return g.out[off : off+size], nil
+}
+
+//nolint:gocyclo
+func (g *headerGrowth) fixLoadCommand(off uint64) error {
+	cmd := LoadCommandType(g.u32(off))
+	cmdsize := uint64(g.u32(off + 4))
+
+	need := func(size uint64) error {
+		if cmdsize < size {
+			return fmt.Errorf("load command 0x%x is too small (%d bytes)", uint32(cmd), cmdsize)
+		}
+		return nil
+	}
+
+	switch cmd {
+	case lcSegment, lcSegment64:
+		return g.fixSegment(off)
+	case lcSymtab:
+		if err := need(24); err != nil {
+			return err
+		}
+		return g.fixSymtab(off)
+	case lcDysymtab:
+		if err := need(80); err != nil {
+			return err
+		}
+		if g.u32(off+68) != 0 || g.u32(off+76) != 0 {
+			return fmt.Errorf("external and local relocation entries are not supported")
+		}
+		for _, field := range []uint64{32, 40, 48, 56, 64, 72} {
+			if err := g.shiftU32Offset(off + field); err != nil {
+				return err
+			}
+		}
+		return nil
+	case lcDyldInfo, lcDyldInfoOnly:
+		if err := need(48); err != nil {
+			return err
+		}
+		return g.fixDyldInfo(off)
+	case LcCodeSignature, lcSegmentSplitInfo, lcDylibCodeSignDrs, lcLinkerOptimization, lcAtomInfo,
+		lcFunctionStarts, lcDataInCode, lcDyldExportsTrie, lcDyldChainedFixups:
+		if err := need(16); err != nil {
+			return err
+		}
+		return g.fixLinkeditData(off, cmd)
+	case lcMain:
+		if err := need(24); err != nil {
+			return err
+		}
+		g.putU64(off+8, g.shiftOffset(g.u64(off+8)))
+		return nil
+	case lcEncryptionInfo, lcEncryptionInfo64, lcTwoLevelHints:
+		if err := need(16); err != nil {
+			return err
+		}
+		return g.shiftU32Offset(off + 8)
+	case lcNote:
+		if err := need(40); err != nil {
+			return err
+		}
+		g.putU64(off+24, g.shiftOffset(g.u64(off+24)))
+		return nil
+	case lcRoutines:
+		if err := need(12); err != nil {
+			return err
+		}
+		return g.putU32(off+8, g.shiftAddr(uint64(g.u32(off+8))))
+	case lcRoutines64:
+		if err := need(16); err != nil {
+			return err
+		}
+		g.putU64(off+8, g.shiftAddr(g.u64(off+8)))
+		return nil
+	case lcThread, lcUnixThread:
+		return g.fixThread(off, cmdsize)
+	case lcSymseg, lcFilesetEntry:
+		return fmt.Errorf("load command 0x%x is not supported", uint32(cmd))
+	}
+	return nil
+}
+
+func (g *headerGrowth) fixSegment(off uint64) error {
+	headerSize, sectionSize := uint64(56), uint64(68)
+	if g.is64 {
+		headerSize, sectionSize = 72, 80
+	}
+
+	cmdsize := uint64(g.u32(off + 4))
+	if cmdsize < headerSize {
+		return fmt.Errorf("segment load command is too small (%d bytes)", cmdsize)
+	}
+
+	seg := g.readSegment(g.out, off)
+	isText := seg.offset == 0 && seg.filesz > 0
+
+	if g.is64 {
+		if isText {
+			g.putU64(off+32, seg.memsz+g.delta)
+			g.putU64(off+48, seg.filesz+g.delta)
+		} else {
+			g.putU64(off+24, g.shiftAddr(seg.addr))
+			g.putU64(off+40, g.shiftOffset(seg.offset))
+		}
+	} else {
+		var err error
+		if isText {
+			err = g.putU32(off+28, seg.memsz+g.delta)
+			if err == nil {
+				err = g.putU32(off+36, seg.filesz+g.delta)
+			}
+		} else {
+			err = g.putU32(off+24, g.shiftAddr(seg.addr))
+			if err == nil {
+				err = g.putU32(off+32, g.shiftOffset(seg.offset))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("segment %s: %w", seg.name, err)
+		}
+	}
+
+	nsects := uint64(g.u32(off + headerSize - 8))
+	if headerSize+nsects*sectionSize > cmdsize {
+		return fmt.Errorf("segment %s has more sections than fit in its load command", seg.name)
+	}
+
+	for i := uint64(0); i < nsects; i++ {
+		if err := g.fixSection(off+headerSize+i*sectionSize, seg.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *headerGrowth) fixSection(off uint64, segName string) error {
+	sectName := string(bytes.TrimRight(g.out[off:off+16], "\x00"))
+
+	// field offsets within section / section_64
+	addrField, sizeField, offsetField, nrelocField, flagsField := uint64(32), uint64(36), uint64(40), uint64(52), uint64(56)
+	if g.is64 {
+		addrField, sizeField, offsetField, nrelocField, flagsField = 32, 40, 48, 60, 64
+	}
+
+	if g.u32(off+nrelocField) != 0 {
+		return fmt.Errorf("section %s,%s has relocation entries, which are not supported", segName, sectName)
+	}
+
+	var addr, size uint64
+	if g.is64 {
+		addr, size = g.u64(off+addrField), g.u64(off+sizeField)
+		g.putU64(off+addrField, g.shiftAddr(addr))
+	} else {
+		addr, size = uint64(g.u32(off+addrField)), uint64(g.u32(off+sizeField))
+		if err := g.putU32(off+addrField, g.shiftAddr(addr)); err != nil {
+			return err
+		}
+	}
+
+	if err := g.shiftU32Offset(off + offsetField); err != nil {
+		return err
+	}
+
+	contentOffset := uint64(g.u32(off + offsetField))
+	if contentOffset == 0 {
+		// zero fill
+		return nil
+	}
+
+	switch {
+	case g.u32(off+flagsField)&sectionAttrInstructions != 0:
+		content, err := g.region(contentOffset, size, "code")
+		if err != nil {
+			return err
+		}
+		if err := g.checkHeaderReferences(content, addr); err != nil {
+			return fmt.Errorf("section %s,%s: %w", segName, sectName, err)
+		}
+	case g.u32(off+flagsField)&sectionTypeMask == sectionInitOffsets:
+		content, err := g.region(contentOffset, size, "initializer offsets")
+		if err != nil {
+			return err
+		}
+		for i := 0; i+4 <= len(content); i += 4 {
+			if err := g.putU32(contentOffset+uint64(i), g.shiftOffset(uint64(g.bo.Uint32(content[i:])))); err != nil {
+				return err
+			}
+		}
+	case segName == "__TEXT" && sectName == "__unwind_info":
+		content, err := g.region(contentOffset, size, "unwind info")
+		if err != nil {
+			return err
+		}
+		if err := g.fixUnwindInfo(content); err != nil {
+			return fmt.Errorf("unable to update unwind info: %w", err)
+		}
+	}
+	return nil
+}
+
+func (g *headerGrowth) fixSymtab(off uint64) error {
+	for _, field := range []uint64{8, 16} {
+		if err := g.shiftU32Offset(off + field); err != nil {
+			return err
+		}
+	}
+
+	symoff, nsyms := uint64(g.u32(off+8)), uint64(g.u32(off+12))
+	entrySize, valueField := uint64(12), uint64(8)
+	if g.is64 {
+		entrySize = 16
+	}
+
+	if _, err := g.region(symoff, nsyms*entrySize, "symbol table"); err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < nsyms; i++ {
+		entry := symoff + i*entrySize
+		if g.out[entry+5] == 0 {
+			// NO_SECT: undefined, absolute and indirect symbols have no address
+			continue
+		}
+		if g.is64 {
+			g.putU64(entry+valueField, g.shiftAddr(g.u64(entry+valueField)))
+		} else if err := g.putU32(entry+valueField, g.shiftAddr(uint64(g.u32(entry+valueField)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *headerGrowth) fixDyldInfo(off uint64) error {
+	for _, field := range []uint64{8, 16, 24, 32, 40} {
+		if err := g.shiftU32Offset(off + field); err != nil {
+			return err
+		}
+	}
+
+	rebase, err := g.region(uint64(g.u32(off+8)), uint64(g.u32(off+12)), "rebase info")
+	if err != nil {
+		return err
+	}
+	if err := g.fixRebases(rebase); err != nil {
+		return fmt.Errorf("unable to update rebases: %w", err)
+	}
+
+	for _, field := range []uint64{16, 24, 32} {
+		binds, err := g.region(uint64(g.u32(off+field)), uint64(g.u32(off+field+4)), "bind info")
+		if err != nil {
+			return err
+		}
+		if err := g.checkBinds(binds); err != nil {
+			return err
+		}
+	}
+
+	exportOff, exportSize := uint64(g.u32(off+40)), uint64(g.u32(off+44))
+	if exportSize == 0 {
+		return nil
+	}
+	return g.fixExportTrie(off+40, exportOff, exportSize)
+}
+
+func (g *headerGrowth) fixLinkeditData(off uint64, cmd LoadCommandType) error {
+	if err := g.shiftU32Offset(off + 8); err != nil {
+		return err
+	}
+
+	dataOff, dataSize := uint64(g.u32(off+8)), uint64(g.u32(off+12))
+	if dataSize == 0 {
+		return nil
+	}
+
+	content, err := g.region(dataOff, dataSize, "linkedit data")
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case lcFunctionStarts:
+		return g.fixFunctionStarts(off+8, content)
+	case lcDataInCode:
+		// data_in_code_entry: offset from the header (32 bits), length (16 bits), kind (16 bits)
+		for i := uint64(0); i+8 <= dataSize; i += 8 {
+			if err := g.putU32(dataOff+i, g.shiftOffset(uint64(g.u32(dataOff+i)))); err != nil {
+				return err
+			}
+		}
+	case lcDyldExportsTrie:
+		return g.fixExportTrie(off+8, dataOff, dataSize)
+	case lcDyldChainedFixups:
+		if err := g.fixChainedFixups(content); err != nil {
+			return fmt.Errorf("unable to update chained fixups: %w", err)
+		}
+	}
+	return nil
+}
+
+// fixFunctionStarts updates the offset of the first function (the rest are deltas from the previous function).
+func (g *headerGrowth) fixFunctionStarts(field uint64, content []byte) error {
+	first, pos, err := readULEB(content, 0)
+	if err != nil {
+		return fmt.Errorf("unable to read function starts: %w", err)
+	}
+	if first == 0 {
+		return nil
+	}
+
+	// find the end of the deltas (a zero delta)
+	end := pos
+	for {
+		delta, next, err := readULEB(content, end)
+		if err != nil {
+			return fmt.Errorf("unable to read function starts: %w", err)
+		}
+		end = next
+		if delta == 0 {
+			break
+		}
+	}
+
+	encoded := appendULEB(nil, g.shiftOffset(first))
+	encoded = append(encoded, content[pos:end]...)
+	return g.replaceLinkeditData(field, content, encoded)
+}
+
+// replaceLinkeditData writes the new content of a linkedit blob in place when it fits (padded with zeros), otherwise
+// appends it to the end of __LINKEDIT and updates the offset and size fields (at field and field+4).
+func (g *headerGrowth) replaceLinkeditData(field uint64, content, replacement []byte) error {
+	if len(replacement) <= len(content) {
+		copy(content, replacement)
+		clear(content[len(replacement):])
+		return nil
+	}
+
+	padded := make([]byte, alignUp(uint64(len(replacement)), g.ptrSize()))
+	copy(padded, replacement)
+
+	off, err := g.appendLinkedit(padded)
+	if err != nil {
+		return err
+	}
+	if err := g.putU32(field, off); err != nil {
+		return err
+	}
+	return g.putU32(field+4, uint64(len(padded)))
+}
+
+// appendLinkedit appends content to the end of __LINKEDIT (which must be at the end of the file), returning its offset.
+func (g *headerGrowth) appendLinkedit(content []byte) (uint64, error) {
+	if g.linkedit < 0 {
+		return 0, fmt.Errorf("no __LINKEDIT segment")
+	}
+	seg := g.segments[g.linkedit]
+	end := g.shiftOffset(seg.offset+seg.filesz) + g.linkeditGrowth
+	if end != uint64(len(g.out)) {
+		return 0, fmt.Errorf("__LINKEDIT segment is not at the end of the file")
+	}
+
+	g.out = append(g.out, content...)
+	g.linkeditGrowth += uint64(len(content))
+	return end, nil
+}
+
+// finishLinkedit grows the __LINKEDIT segment to account for any appended content.
+func (g *headerGrowth) finishLinkedit() error {
+	if g.linkeditGrowth == 0 {
+		return nil
+	}
+	seg := g.segments[g.linkedit]
+	filesz := seg.filesz + g.linkeditGrowth
+	memsz := seg.memsz
+	if memsz < filesz {
+		memsz = alignUp(filesz, segmentAlignment(g.cpu))
+	}
+
+	off := seg.cmdOffset
+	if g.is64 {
+		g.putU64(off+32, memsz)
+		g.putU64(off+48, filesz)
+		return nil
+	}
+	if err := g.putU32(off+28, memsz); err != nil {
+		return err
+	}
+	return g.putU32(off+36, filesz)
+}
+
+func (g *headerGrowth) fixRebases(ops []byte) error {
+	var seg, typ int
+	var off uint64
+	ptrSize := g.ptrSize()
+
+	rebase := func() error {
+		if seg < 0 || seg >= len(g.segments) {
+			return fmt.Errorf("rebase refers to segment %d which does not exist", seg)
+		}
+		if seg == g.text {
+			return fmt.Errorf("rebases within __TEXT are not supported")
+		}
+		pos := g.shiftOffset(g.segments[seg].offset + off)
+		switch typ {
+		case rebaseTypePointer:
+			if _, err := g.region(pos, ptrSize, "rebase"); err != nil {
+				return err
+			}
+			return g.putPtr(pos, g.shiftAddr(g.ptr(pos)))
+		case rebaseTypeAbsolute32:
+			if _, err := g.region(pos, 4, "rebase"); err != nil {
+				return err
+			}
+			return g.putU32(pos, g.shiftAddr(uint64(g.u32(pos))))
+		default:
+			return fmt.Errorf("rebase type %d is not supported", typ)
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		op, imm := ops[i]&0xf0, ops[i]&0x0f
+		i++
+
+		var err error
+		var count, skip uint64
+		switch op {
+		case 0x00: // REBASE_OPCODE_DONE
+			return nil
+		case 0x10: // REBASE_OPCODE_SET_TYPE_IMM
+			typ = int(imm)
+		case 0x20: // REBASE_OPCODE_SET_SEGMENT_AND_OFFSET_ULEB
+			seg = int(imm)
+			off, i, err = readULEB(ops, i)
+		case 0x30: // REBASE_OPCODE_ADD_ADDR_ULEB
+			var v uint64
+			v, i, err = readULEB(ops, i)
+			off += v
+		case 0x40: // REBASE_OPCODE_ADD_ADDR_IMM_SCALED
+			off += uint64(imm) * ptrSize
+		case 0x50, 0x60, 0x70, 0x80:
+			switch op {
+			case 0x50: // REBASE_OPCODE_DO_REBASE_IMM_TIMES
+				count = uint64(imm)
+			case 0x60: // REBASE_OPCODE_DO_REBASE_ULEB_TIMES
+				count, i, err = readULEB(ops, i)
+			case 0x70: // REBASE_OPCODE_DO_REBASE_ADD_ADDR_ULEB
+				count = 1
+				skip, i, err = readULEB(ops, i)
+			case 0x80: // REBASE_OPCODE_DO_REBASE_ULEB_TIMES_SKIPPING_ULEB
+				count, i, err = readULEB(ops, i)
+				if err == nil {
+					skip, i, err = readULEB(ops, i)
+				}
+			}
+			for j := uint64(0); err == nil && j < count; j++ {
+				err = rebase()
+				off += skip + ptrSize
+			}
+		default:
+			return fmt.Errorf("unknown rebase opcode 0x%x", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBinds ensures that nothing is bound within __TEXT (where the locations would move within the segment). The
+// values at the bound locations are not addresses within the binary, so need no update.
+func (g *headerGrowth) checkBinds(ops []byte) error {
+	seg := -1
+	doBind := func() error {
+		if seg == g.text {
+			return fmt.Errorf("binds within __TEXT are not supported")
+		}
+		return nil
+	}
+
+	for i := 0; i < len(ops); {
+		op, imm := ops[i]&0xf0, ops[i]&0x0f
+		i++
+
+		var err error
+		switch op {
+		case 0x00, 0x10, 0x30, 0x50: // DONE (which separates lazy binds), SET_DYLIB_ORDINAL_IMM, SET_DYLIB_SPECIAL_IMM, SET_TYPE_IMM
+		case 0x20, 0x80: // SET_DYLIB_ORDINAL_ULEB, ADD_ADDR_ULEB
+			_, i, err = readULEB(ops, i)
+		case 0x40: // SET_SYMBOL_TRAILING_FLAGS_IMM
+			end := bytes.IndexByte(ops[i:], 0)
+			if end < 0 {
+				return fmt.Errorf("truncated bind symbol name")
+			}
+			i += end + 1
+		case 0x60: // SET_ADDEND_SLEB
+			_, i, err = readSLEB(ops, i)
+		case 0x70: // SET_SEGMENT_AND_OFFSET_ULEB
+			seg = int(imm)
+			_, i, err = readULEB(ops, i)
+		case 0x90, 0xb0: // DO_BIND, DO_BIND_ADD_ADDR_IMM_SCALED
+			err = doBind()
+		case 0xa0: // DO_BIND_ADD_ADDR_ULEB
+			_, i, err = readULEB(ops, i)
+			if err == nil {
+				err = doBind()
+			}
+		case 0xc0: // DO_BIND_ULEB_TIMES_SKIPPING_ULEB
+			_, i, err = readULEB(ops, i)
+			if err == nil {
+				_, i, err = readULEB(ops, i)
+			}
+			if err == nil {
+				err = doBind()
+			}
+		case 0xd0: // THREADED
+			return fmt.Errorf("threaded binds are not supported")
+		default:
+			return fmt.Errorf("unknown bind opcode 0x%x", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trieNode is a node of an export trie, where the terminal info (if any) is kept encoded.
+type trieNode struct {
+	terminal []byte
+	edges    []trieEdge
+	offset   uint64
+}
+
+type trieEdge struct {
+	label string
+	child *trieNode
+}
+
+// fixExportTrie updates the offsets of the exported symbols, rebuilding the trie (the offsets are variable-length
+// encoded, so the trie may change size).
+func (g *headerGrowth) fixExportTrie(field, off, size uint64) error {
+	content, err := g.region(off, size, "export trie")
+	if err != nil {
+		return err
+	}
+
+	root, err := g.parseTrieNode(content, 0, 0, make(map[uint64]bool))
+	if err != nil {
+		return fmt.Errorf("unable to read export trie: %w", err)
+	}
+
+	return g.replaceLinkeditData(field, content, serializeTrie(root))
+}
+
+func (g *headerGrowth) parseTrieNode(content []byte, pos uint64, depth int, seen map[uint64]bool) (*trieNode, error) {
+	if depth > maxTrieDepth || seen[pos] {
+		return nil, fmt.Errorf("invalid export trie structure")
+	}
+	seen[pos] = true
+
+	if pos >= uint64(len(content)) {
+		return nil, fmt.Errorf("export trie node beyond the trie")
+	}
+
+	terminalSize, i, err := readULEB(content, int(pos))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(i)+terminalSize >= uint64(len(content)) {
+		return nil, fmt.Errorf("export trie node beyond the trie")
+	}
+
+	node := &trieNode{}
+	if terminalSize > 0 {
+		node.terminal, err = g.shiftExportInfo(content[i : uint64(i)+terminalSize])
+		if err != nil {
+			return nil, err
+		}
+	}
+	i += int(terminalSize)
+
+	children := int(content[i])
+	i++
+	for c := 0; c < children; c++ {
+		end := bytes.IndexByte(content[i:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("truncated export trie edge")
+		}
+		label := string(content[i : i+end])
+		i += end + 1
+
+		var childOff uint64
+		childOff, i, err = readULEB(content, i)
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := g.parseTrieNode(content, childOff, depth+1, seen)
+		if err != nil {
+			return nil, err
+		}
+		node.edges = append(node.edges, trieEdge{label: label, child: child})
+	}
+	return node, nil
+}
+
+// shiftExportInfo re-encodes the terminal info of an exported symbol with its offset (and resolver offset) shifted.
+func (g *headerGrowth) shiftExportInfo(info []byte) ([]byte, error) {
+	flags, i, err := readULEB(info, 0)
+	if err != nil {
+		return nil, err
+	}
+	if flags&exportReexport != 0 {
+		// re-exports refer to a symbol in another dylib by ordinal and name
+		return info, nil
+	}
+
+	addr, i, err := readULEB(info, i)
+	if err != nil {
+		return nil, err
+	}
+
+	shift := g.shiftOffset
+	if flags&exportKindMask == exportKindAbsolute {
+		shift = func(v uint64) uint64 { return v }
+	}
+
+	out := appendULEB(nil, flags)
+	out = appendULEB(out, shift(addr))
+	if flags&exportStubResolver != 0 {
+		var resolver uint64
+		resolver, i, err = readULEB(info, i)
+		if err != nil {
+			return nil, err
+		}
+		out = appendULEB(out, shift(resolver))
+	}
+	return append(out, info[i:]...), nil
+}
+
+// serializeTrie encodes the trie (root first, then depth first), iterating until the node offsets are stable since
+// the size of each node depends on the (variable-length encoded) offsets of its children.
+func serializeTrie(root *trieNode) []byte {
+	var nodes []*trieNode
+	var walk func(n *trieNode)
+	walk = func(n *trieNode) {
+		nodes = append(nodes, n)
+		for _, e := range n.edges {
+			walk(e.child)
+		}
+	}
+	walk(root)
+
+	for changed := true; changed; {
+		changed = false
+		var off uint64
+		for _, n := range nodes {
+			if n.offset != off {
+				n.offset = off
+				changed = true
+			}
+			off += n.size()
+		}
+	}
+
+	var out []byte
+	for _, n := range nodes {
+		out = appendULEB(out, uint64(len(n.terminal)))
+		out = append(out, n.terminal...)
+		out = append(out, byte(len(n.edges)))
+		for _, e := range n.edges {
+			out = append(out, e.label...)
+			out = append(out, 0)
+			out = appendULEB(out, e.child.offset)
+		}
+	}
+	return out
+}
+
+func (n *trieNode) size() uint64 {
+	size := uint64(ulebSize(uint64(len(n.terminal))) + len(n.terminal) + 1)
+	for _, e := range n.edges {
+		size += uint64(len(e.label) + 1 + ulebSize(e.child.offset))
+	}
+	return size
+}
+
+// chained fixup pointer formats
+const (
+	chainedPtrArm64e           = 1
+	chainedPtr64               = 2
+	chainedPtr32               = 3
+	chainedPtr64Offset         = 6
+	chainedPtrArm64eUserland   = 9
+	chainedPtrArm64eUserland24 = 12
+	chainedPtrStartNone        = 0xffff
+	chainedPtrStartMulti       = 0x8000
+	chainedPtrStartLast        = 0x8000
+)
+
+// fixChainedFixups updates the segment offsets of the fixup chains and the targets of each rebase within the chains.
+//
+//nolint:funlen
+func (g *headerGrowth) fixChainedFixups(content []byte) error {
+	if len(content) < 28 {
+		return fmt.Errorf("truncated header")
+	}
+	startsOffset := uint64(g.bo.Uint32(content[4:]))
+	if startsOffset+4 > uint64(len(content)) {
+		return fmt.Errorf("starts beyond the fixups")
+	}
+
+	segCount := uint64(g.bo.Uint32(content[startsOffset:]))
+	if startsOffset+4+segCount*4 > uint64(len(content)) {
+		return fmt.Errorf("segment starts beyond the fixups")
+	}
+
+	for i := uint64(0); i < segCount; i++ {
+		segInfoOffset := uint64(g.bo.Uint32(content[startsOffset+4+i*4:]))
+		if segInfoOffset == 0 {
+			continue
+		}
+		if int(i) >= len(g.segments) {
+			return fmt.Errorf("fixups refer to segment %d which does not exist", i)
+		}
+
+		starts := startsOffset + segInfoOffset
+		if starts+22 > uint64(len(content)) {
+			return fmt.Errorf("segment starts beyond the fixups")
+		}
+		pageSize := uint64(g.bo.Uint16(content[starts+4:]))
+		format := g.bo.Uint16(content[starts+6:])
+		maxValidPointer := uint64(g.bo.Uint32(content[starts+16:]))
+		pageCount := uint64(g.bo.Uint16(content[starts+20:]))
+		if starts+22+pageCount*2 > uint64(len(content)) {
+			return fmt.Errorf("page starts beyond the fixups")
+		}
+
+		hasFixups := false
+		for p := uint64(0); p < pageCount; p++ {
+			if g.bo.Uint16(content[starts+22+p*2:]) != chainedPtrStartNone {
+				hasFixups = true
+			}
+		}
+		if !hasFixups {
+			continue
+		}
+		if int(i) == g.text {
+			return fmt.Errorf("fixups within __TEXT are not supported")
+		}
+
+		// the offset of the segment from the header within the address space
+		g.bo.PutUint64(content[starts+8:], g.shiftOffset(g.bo.Uint64(content[starts+8:])))
+
+		segOffset := g.shiftOffset(g.segments[i].offset)
+		for p := uint64(0); p < pageCount; p++ {
+			start := uint64(g.bo.Uint16(content[starts+22+p*2:]))
+			if start == chainedPtrStartNone {
+				continue
+			}
+
+			chainStarts := []uint64{start}
+			if format == chainedPtr32 && start&chainedPtrStartMulti != 0 {
+				// the page has multiple chains, listed in the overflow entries after the page starts
+				chainStarts = nil
+				for idx := start &^ chainedPtrStartMulti; ; idx++ {
+					if starts+22+idx*2+2 > uint64(len(content)) {
+						return fmt.Errorf("chain starts beyond the fixups")
+					}
+					s := uint64(g.bo.Uint16(content[starts+22+idx*2:]))
+					chainStarts = append(chainStarts, s&^chainedPtrStartLast)
+					if s&chainedPtrStartLast != 0 {
+						break
+					}
+				}
+			}
+
+			for _, s := range chainStarts {
+				if err := g.fixChain(segOffset+p*pageSize+s, format, maxValidPointer); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fixChain walks a chain of fixups, updating the target of each rebase (binds refer to other images).
+func (g *headerGrowth) fixChain(pos uint64, format uint16, maxValidPointer uint64) error {
+	for {
+		var next, stride uint64
+		switch format {
+		case chainedPtr64, chainedPtr64Offset:
+			if _, err := g.region(pos, 8, "chained fixup"); err != nil {
+				return err
+			}
+			v := g.u64(pos)
+			next, stride = (v>>51)&0xfff, 4
+			if v>>63 == 0 {
+				const targetMask = 1<<36 - 1
+				target := v & targetMask
+				if format == chainedPtr64 {
+					target = g.shiftAddr(target)
+				} else {
+					target = g.shiftOffset(target)
+				}
+				if target > targetMask {
+					return fmt.Errorf("rebase target 0x%x does not fit once shifted", target)
+				}
+				g.putU64(pos, v&^targetMask|target)
+			}
+		case chainedPtrArm64e, chainedPtrArm64eUserland, chainedPtrArm64eUserland24:
+			if _, err := g.region(pos, 8, "chained fixup"); err != nil {
+				return err
+			}
+			v := g.u64(pos)
+			next, stride = (v>>51)&0x7ff, 8
+			auth, bind := v>>63 == 1, (v>>62)&1 == 1
+			if !bind {
+				var targetMask uint64 = 1<<43 - 1
+				shift := g.shiftOffset
+				if auth {
+					// authenticated rebases hold an offset from the header
+					targetMask = 1<<32 - 1
+				} else if format == chainedPtrArm64e {
+					// plain rebases hold an address (for the userland formats, an offset from the header)
+					shift = g.shiftAddr
+				}
+				target := shift(v & targetMask)
+				if target > targetMask {
+					return fmt.Errorf("rebase target 0x%x does not fit once shifted", target)
+				}
+				g.putU64(pos, v&^targetMask|target)
+			}
+		case chainedPtr32:
+			if _, err := g.region(pos, 4, "chained fixup"); err != nil {
+				return err
+			}
+			v := uint64(g.u32(pos))
+			next, stride = (v>>26)&0x1f, 4
+			const targetMask = 1<<26 - 1
+			// targets beyond the max valid pointer are not pointers (but values encoded to fit the chain)
+			if v>>31 == 0 && v&targetMask <= maxValidPointer {
+				target := g.shiftAddr(v & targetMask)
+				if target > targetMask || target > maxValidPointer {
+					return fmt.Errorf("rebase target 0x%x does not fit once shifted", target)
+				}
+				if err := g.putU32(pos, v&^targetMask|target); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("chained pointer format %d is not supported", format)
+		}
+
+		if next == 0 {
+			return nil
+		}
+		pos += next * stride
+	}
+}
+
+// fixUnwindInfo updates the function (and personality and LSDA) offsets within the __unwind_info section, which are
+// offsets from the header. Compressed second level pages hold offsets relative to their first level entry, so need no
+// update.
+//
+//nolint:funlen
+func (g *headerGrowth) fixUnwindInfo(content []byte) error {
+	u32 := func(off uint64) (uint64, error) {
+		if off+4 > uint64(len(content)) {
+			return 0, fmt.Errorf("truncated section")
+		}
+		return uint64(g.bo.Uint32(content[off:])), nil
+	}
+	shift := func(off uint64) error {
+		v, err := u32(off)
+		if err != nil {
+			return err
+		}
+		shifted := g.shiftOffset(v)
+		if shifted > math.MaxUint32 {
+			return fmt.Errorf("offset 0x%x does not fit once shifted", shifted)
+		}
+		g.bo.PutUint32(content[off:], uint32(shifted))
+		return nil
+	}
+
+	if len(content) < 28 {
+		return fmt.Errorf("truncated header")
+	}
+	if version := g.bo.Uint32(content); version != 1 {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+
+	personalityOffset, personalityCount := uint64(g.bo.Uint32(content[12:])), uint64(g.bo.Uint32(content[16:]))
+	for i := uint64(0); i < personalityCount; i++ {
+		if err := shift(personalityOffset + i*4); err != nil {
+			return err
+		}
+	}
+
+	indexOffset, indexCount := uint64(g.bo.Uint32(content[20:])), uint64(g.bo.Uint32(content[24:]))
+	if indexCount == 0 {
+		return nil
+	}
+
+	for i := uint64(0); i < indexCount; i++ {
+		entry := indexOffset + i*12
+		if err := shift(entry); err != nil {
+			return err
+		}
+
+		pageOffset, err := u32(entry + 4)
+		if err != nil {
+			return err
+		}
+		if pageOffset == 0 {
+			// the last entry marks the end of the functions
+			continue
+		}
+
+		kind, err := u32(pageOffset)
+		if err != nil {
+			return err
+		}
+		if kind != 2 {
+			// UNWIND_SECOND_LEVEL_COMPRESSED
+			continue
+		}
+
+		// UNWIND_SECOND_LEVEL_REGULAR: kind, entry page offset (16 bits), entry count (16 bits)
+		if pageOffset+8 > uint64(len(content)) {
+			return fmt.Errorf("truncated section")
+		}
+		entriesOffset := pageOffset + uint64(g.bo.Uint16(content[pageOffset+4:]))
+		entryCount := uint64(g.bo.Uint16(content[pageOffset+6:]))
+		for j := uint64(0); j < entryCount; j++ {
+			if err := shift(entriesOffset + j*8); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the LSDA entries (function offset, LSDA offset) are between those referenced by the first and last index entries
+	lsdaStart, err := u32(indexOffset + 8)
+	if err != nil {
+		return err
+	}
+	lsdaEnd, err := u32(indexOffset + (indexCount-1)*12 + 8)
+	if err != nil {
+		return err
+	}
+	for off := lsdaStart; off+8 <= lsdaEnd; off += 8 {
+		if err := shift(off); err != nil {
+			return err
+		}
+		if err := shift(off + 4); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixThread updates the program counter of the initial thread state (LC_UNIXTHREAD, as used instead of LC_MAIN by
+// some linkers).
+func (g *headerGrowth) fixThread(off, cmdsize uint64) error {
+	for pos := off + 8; pos+8 <= off+cmdsize; {
+		flavor, count := g.u32(pos), uint64(g.u32(pos+4))
+		state := pos + 8
+		if state+count*4 > off+cmdsize {
+			return fmt.Errorf("thread state extends beyond its load command")
+		}
+
+		// the index of the program counter within the state (in 32-bit words) for the supported flavors
+		var pcField uint64
+		switch {
+		case g.cpu == macho.CpuAmd64 && flavor == 4: // x86_THREAD_STATE64: rip follows 16 64-bit registers
+			pcField = 16 * 2
+		case g.cpu == macho.Cpu386 && flavor == 1: // x86_THREAD_STATE32: eip follows 10 32-bit registers
+			pcField = 10
+		case g.cpu == macho.CpuArm64 && flavor == 6: // ARM_THREAD_STATE64: pc follows x0-x28, fp, lr and sp
+			pcField = 32 * 2
+		case g.cpu == macho.CpuArm && flavor == 1: // ARM_THREAD_STATE: pc is r15
+			pcField = 15
+		default:
+			return fmt.Errorf("thread state flavor %d is not supported", flavor)
+		}
+		if pcField >= count {
+			return fmt.Errorf("thread state is too small")
+		}
+
+		pc := state + pcField*4
+		if g.is64 {
+			g.putU64(pc, g.shiftAddr(g.u64(pc)))
+		} else if err := g.putU32(pc, g.shiftAddr(uint64(g.u32(pc)))); err != nil {
+			return err
+		}
+
+		pos = state + count*4
+	}
+	return nil
+}
@@ -0,0 +1,490 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+const (
+	testTextAddr     = 0x100000000
+	testPageSize     = 0x4000
+	testDataOffset   = testPageSize
+	testLinkedit     = 2 * testPageSize
+	testLoadCmdsSize = 72 + 232 + 152 + 72 + 24 + 24 + 16 + 16 + 16
+	// testInsert is where the __text section starts, right after the load commands (so there is no header space)
+	testInsert = fileHeaderSize64 + testLoadCmdsSize
+)
+
+// testBinary is a minimal arm64 executable with no room after its load commands, where the offsets of the content
+// that is checked by the tests is recorded.
+type testBinary struct {
+	data []byte
+	// file offsets of the interesting load commands
+	textCmd, dataCmd, linkeditCmd, mainCmd, symtabCmd, functionStartsCmd, exportsCmd, fixupsCmd int
+	// file offset of the chained fixups segment starts for __DATA
+	dataStarts int
+}
+
+func (b *testBinary) u32(off int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b.data[off:]))
+}
+
+func (b *testBinary) u64(off int) uint64 {
+	return binary.LittleEndian.Uint64(b.data[off:])
+}
+
+// newTestBinaryWithoutHeaderSpace builds an executable with __PAGEZERO, __TEXT (with code directly after the load
+// commands, and initializer offsets), __DATA (with two rebases in a chained fixup chain) and __LINKEDIT (with function
+// starts, chained fixups, a symbol table and an exports trie which is the last content in the file).
+//
+//nolint:funlen
+func newTestBinaryWithoutHeaderSpace() *testBinary {
+	le := binary.LittleEndian
+	b := &testBinary{data: make([]byte, testLinkedit)}
+	put32 := func(off int, v uint64) { le.PutUint32(b.data[off:], uint32(v)) }
+	put64 := func(off int, v uint64) { le.PutUint64(b.data[off:], v) }
+
+	put32(0, uint64(macho.Magic64))
+	put32(4, uint64(macho.CpuArm64))
+	put32(12, uint64(macho.TypeExec))
+	put32(16, 9)
+	put32(20, testLoadCmdsSize)
+
+	off := fileHeaderSize64
+	segment := func(name string, addr, memsz, offset, filesz uint64, nsects int) int {
+		start := off
+		put32(off, uint64(lcSegment64))
+		put32(off+4, uint64(72+80*nsects))
+		copy(b.data[off+8:], name)
+		put64(off+24, addr)
+		put64(off+32, memsz)
+		put64(off+40, offset)
+		put64(off+48, filesz)
+		put32(off+64, uint64(nsects))
+		off += 72
+		return start
+	}
+	section := func(name, seg string, addr, size, offset, flags uint64) {
+		copy(b.data[off:], name)
+		copy(b.data[off+16:], seg)
+		put64(off+32, addr)
+		put64(off+40, size)
+		put32(off+48, offset)
+		put32(off+64, flags)
+		off += 80
+	}
+	linkeditData := func(cmd LoadCommandType) int {
+		start := off
+		put32(off, uint64(cmd))
+		put32(off+4, 16)
+		off += 16
+		return start
+	}
+
+	segment("__PAGEZERO", 0, testTextAddr, 0, 0, 0)
+	b.textCmd = segment("__TEXT", testTextAddr, testPageSize, 0, testPageSize, 2)
+	section("__text", "__TEXT", testTextAddr+testInsert, 16, testInsert, 0x80000400)
+	section("__init_offsets", "__TEXT", testTextAddr+testInsert+16, 4, testInsert+16, sectionInitOffsets)
+	b.dataCmd = segment("__DATA", testTextAddr+testDataOffset, testPageSize, testDataOffset, testPageSize, 1)
+	section("__data", "__DATA", testTextAddr+testDataOffset, 16, testDataOffset, 0)
+	b.linkeditCmd = segment("__LINKEDIT", testTextAddr+testLinkedit, testPageSize, testLinkedit, 0, 0)
+
+	b.mainCmd = off
+	put32(off, uint64(lcMain))
+	put32(off+4, 24)
+	put64(off+8, testInsert)
+	off += 24
+
+	b.symtabCmd = off
+	put32(off, uint64(lcSymtab))
+	put32(off+4, 24)
+	off += 24
+
+	b.functionStartsCmd = linkeditData(lcFunctionStarts)
+	b.exportsCmd = linkeditData(lcDyldExportsTrie)
+	b.fixupsCmd = linkeditData(lcDyldChainedFixups)
+
+	if off != testInsert {
+		panic("unexpected load command size")
+	}
+
+	// code (nops) and the offset of the initializer
+	for i := 0; i < 4; i++ {
+		put32(testInsert+i*4, 0xd503201f)
+	}
+	put32(testInsert+16, testInsert)
+
+	// a chain of two rebases (DYLD_CHAINED_PTR_64_OFFSET) to __text and to __DATA
+	put64(testDataOffset, testInsert|2<<51)
+	put64(testDataOffset+8, testDataOffset)
+
+	appendLinkedit := func(cmd int, content []byte) {
+		put32(cmd+8, uint64(len(b.data)))
+		put32(cmd+12, uint64(len(content)))
+		b.data = append(b.data, content...)
+	}
+
+	fixups := make([]byte, 80)
+	le.PutUint32(fixups[4:], 28) // starts offset
+	le.PutUint32(fixups[8:], 80) // imports offset
+	le.PutUint32(fixups[12:], 80)
+	le.PutUint32(fixups[20:], 1)
+	le.PutUint32(fixups[28:], 4) // segment count
+	le.PutUint32(fixups[28+4+2*4:], 24)
+	starts := 28 + 24
+	le.PutUint32(fixups[starts:], 24)
+	le.PutUint16(fixups[starts+4:], testPageSize)
+	le.PutUint16(fixups[starts+6:], chainedPtr64Offset)
+	le.PutUint64(fixups[starts+8:], testDataOffset)
+	le.PutUint16(fixups[starts+20:], 1)
+	b.dataStarts = len(b.data) + starts
+	appendLinkedit(b.fixupsCmd, fixups)
+
+	appendLinkedit(b.functionStartsCmd, []byte{0x90, 0x05, 0x00, 0, 0, 0, 0, 0}) // testInsert, end
+
+	put32(b.symtabCmd+8, uint64(len(b.data)))
+	put32(b.symtabCmd+12, 1)
+	symbol := make([]byte, 16)
+	le.PutUint32(symbol, 1)
+	symbol[4], symbol[5] = 0x0f, 1 // N_SECT | N_EXT, in __text
+	le.PutUint64(symbol[8:], testTextAddr+testInsert)
+	b.data = append(b.data, symbol...)
+	put32(b.symtabCmd+16, uint64(len(b.data)))
+	put32(b.symtabCmd+20, 8)
+	b.data = append(b.data, "\x00_main\x00\x00"...)
+
+	// the root node has an edge to the "_main" node, whose offset grows to three bytes once shifted (so the trie no
+	// longer fits where it was)
+	appendLinkedit(b.exportsCmd, []byte{0, 1, '_', 'm', 'a', 'i', 'n', 0, 9, 3, 0, 0x90, 0x05, 0})
+
+	put64(b.linkeditCmd+48, uint64(len(b.data)-testLinkedit))
+	return b
+}
+
+// code replaces the instructions at the start of __text.
+func (b *testBinary) code(instructions ...uint32) {
+	for i, insn := range instructions {
+		binary.LittleEndian.PutUint32(b.data[testInsert+i*4:], insn)
+	}
+}
+
+func (b *testBinary) write(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "bin")
+	require.NoError(t, os.WriteFile(p, b.data, 0600))
+	return p
+}
+
+func TestFile_AddEmptyCodeSigningCmd_withoutHeaderSpace(t *testing.T) {
+	b := newTestBinaryWithoutHeaderSpace()
+	p := b.write(t)
+
+	m, err := NewFile(p)
+	require.NoError(t, err)
+	defer m.Close()
+
+	// the header space is never grown implicitly
+	err = m.AddEmptyCodeSigningCmd()
+	var spaceErr *HeaderSpaceError
+	require.ErrorAs(t, err, &spaceErr)
+	assert.Equal(t, uint64(16), spaceErr.Needed)
+	assert.False(t, m.HasCodeSigningCmd())
+
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, b.data, data)
+}
+
+func TestFile_GrowHeaderSpace(t *testing.T) {
+	original := newTestBinaryWithoutHeaderSpace()
+	p := original.write(t)
+
+	m, err := NewFile(p)
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.False(t, m.hasRoomForNewCmd())
+	require.NoError(t, m.GrowHeaderSpace(16))
+	require.True(t, m.hasRoomForNewCmd())
+	require.NoError(t, m.AddEmptyCodeSigningCmd())
+	assert.True(t, m.HasCodeSigningCmd())
+
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	b := &testBinary{data: data}
+	const delta = testPageSize
+	shifted := uint64(testInsert + delta)
+
+	// __TEXT grows, the rest of the segments move
+	assert.Equal(t, uint64(2*testPageSize), b.u64(original.textCmd+32))
+	assert.Equal(t, uint64(2*testPageSize), b.u64(original.textCmd+48))
+	assert.Equal(t, uint64(testTextAddr+testDataOffset+delta), b.u64(original.dataCmd+24))
+	assert.Equal(t, uint64(testDataOffset+delta), b.u64(original.dataCmd+40))
+	assert.Equal(t, uint64(testLinkedit+delta), b.u64(original.linkeditCmd+40))
+
+	// the sections move with their content
+	text := original.textCmd + 72
+	assert.Equal(t, testTextAddr+shifted, b.u64(text+32))
+	assert.Equal(t, shifted, b.u32(text+48))
+	assert.Equal(t, original.data[testInsert:testInsert+16], data[shifted:shifted+16])
+	assert.Equal(t, shifted, b.u32(int(shifted)+16), "initializer offset")
+
+	assert.Equal(t, shifted, b.u64(original.mainCmd+8), "entry point")
+
+	symoff := b.u32(original.symtabCmd + 8)
+	assert.Equal(t, original.u32(original.symtabCmd+8)+delta, symoff)
+	assert.Equal(t, testTextAddr+shifted, b.u64(int(symoff)+8), "symbol address")
+
+	functionStarts := b.u32(original.functionStartsCmd + 8)
+	assert.Equal(t, []byte{0x90, 0x85, 0x01, 0x00}, data[functionStarts:functionStarts+4])
+
+	// the rebase targets are updated, keeping the chain intact
+	assert.Equal(t, shifted|2<<51, b.u64(testDataOffset+delta))
+	assert.Equal(t, uint64(testDataOffset+delta), b.u64(testDataOffset+delta+8))
+	assert.Equal(t, uint64(testDataOffset+delta), b.u64(original.dataStarts+delta+8), "chained fixups segment offset")
+
+	// the exports trie no longer fits, so is appended to __LINKEDIT (before the code signature)
+	exportsOff, exportsSize := b.u32(original.exportsCmd+8), b.u32(original.exportsCmd+12)
+	assert.Equal(t, uint64(len(original.data)+delta), exportsOff)
+	assert.Equal(t, uint64(16), exportsSize)
+	assert.Equal(t, []byte{0, 1, '_', 'm', 'a', 'i', 'n', 0, 9, 4, 0, 0x90, 0x85, 0x01, 0, 0}, data[exportsOff:exportsOff+exportsSize])
+	assert.Equal(t, exportsOff+exportsSize-testLinkedit-delta, b.u64(original.linkeditCmd+48))
+
+	cmd, _, err := m.CodeSigningCmd()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(exportsOff+exportsSize), cmd.DataOffset)
+}
+
+func TestFile_GrowHeaderSpace_unsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(b *testBinary)
+		wantErr string
+	}{
+		{
+			name: "section relocations",
+			modify: func(b *testBinary) {
+				binary.LittleEndian.PutUint32(b.data[b.dataCmd+72+60:], 1)
+			},
+			wantErr: "relocation entries",
+		},
+		{
+			name: "fixups within __TEXT",
+			modify: func(b *testBinary) {
+				// point the __TEXT entry of the segment starts at those of __DATA
+				fixups := int(b.u32(b.fixupsCmd + 8))
+				binary.LittleEndian.PutUint32(b.data[fixups+28+4+4:], 24)
+			},
+			wantErr: "fixups within __TEXT",
+		},
+		{
+			name: "unsupported chained pointer format",
+			modify: func(b *testBinary) {
+				binary.LittleEndian.PutUint16(b.data[b.dataStarts+6:], 4)
+			},
+			wantErr: "chained pointer format 4",
+		},
+		{
+			name: "address of the header",
+			modify: func(b *testBinary) {
+				b.code(0x90000000, 0x91000000) // adrp x0, header@PAGE; add x0, x0, header@PAGEOFF
+			},
+			wantErr: "code at 0x100000290 refers to the Mach-O header",
+		},
+		{
+			name: "address of the header via a load",
+			modify: func(b *testBinary) {
+				b.code(0x90000001, 0xd503201f, 0xf9400821) // adrp x1, header@PAGE; nop; ldr x1, [x1, #0x10]
+			},
+			wantErr: "code at 0x100000290 refers to the Mach-O header",
+		},
+		{
+			name: "address of the load commands relative to the program counter",
+			modify: func(b *testBinary) {
+				b.code(0xd503201f, 0x10fffe00) // nop; adr x0, .-0x40
+			},
+			wantErr: "code at 0x100000294 refers to the Mach-O header",
+		},
+		{
+			name: "page of the header without an offset",
+			modify: func(b *testBinary) {
+				b.code(0x90000000) // adrp x0, header@PAGE (the offset within the page is unknown)
+			},
+			wantErr: "code at 0x100000290 refers to the Mach-O header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBinaryWithoutHeaderSpace()
+			tt.modify(b)
+			p := b.write(t)
+
+			m, err := NewFile(p)
+			require.NoError(t, err)
+			defer m.Close()
+
+			require.ErrorContains(t, m.GrowHeaderSpace(16), tt.wantErr)
+
+			// the binary is untouched
+			data, err := os.ReadFile(p)
+			require.NoError(t, err)
+			assert.Equal(t, b.data, data)
+		})
+	}
+}
+
+func TestFile_GrowHeaderSpace_codeReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		code []uint32
+	}{
+		{
+			name: "address within the code",
+			code: []uint32{0x90000000, 0x910a6000}, // adrp x0, __text@PAGE; add x0, x0, __text@PAGEOFF
+		},
+		{
+			name: "address of another page",
+			code: []uint32{0xb0000000}, // adrp x0, .+0x1000@PAGE (without an offset)
+		},
+		{
+			name: "adrp pairs with the instruction using its register",
+			code: []uint32{0x90000000, 0x91000021, 0x910a6000}, // adrp x0, __text@PAGE; add x1, x1, #0; add x0, x0, __text@PAGEOFF
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBinaryWithoutHeaderSpace()
+			b.code(tt.code...)
+			p := b.write(t)
+
+			m, err := NewFile(p)
+			require.NoError(t, err)
+			defer m.Close()
+
+			require.NoError(t, m.GrowHeaderSpace(16))
+		})
+	}
+}
+
+func Test_findAmd64HeaderReference(t *testing.T) {
+	const addr = 0x100000400
+	header := func(target uint64) bool {
+		return target >= 0x100000000 && target < addr
+	}
+
+	tests := []struct {
+		name      string
+		code      []byte
+		wantFound bool
+		wantAddr  uint64
+	}{
+		{
+			name:      "lea of the header",
+			code:      []byte{0x90, 0x48, 0x8d, 0x3d, 0xf8, 0xfb, 0xff, 0xff}, // nop; lea rdi, [rip-0x408]
+			wantFound: true,
+			wantAddr:  addr + 1,
+		},
+		{
+			name:      "lea of the header to an extended register",
+			code:      []byte{0x4c, 0x8d, 0x05, 0xf9, 0xfb, 0xff, 0xff}, // lea r8, [rip-0x407]
+			wantFound: true,
+			wantAddr:  addr,
+		},
+		{
+			name: "lea within the code",
+			code: []byte{0x48, 0x8d, 0x3d, 0x10, 0x00, 0x00, 0x00}, // lea rdi, [rip+0x10]
+		},
+		{
+			name: "lea relative to another register",
+			code: []byte{0x48, 0x8d, 0x7d, 0x00, 0x00, 0xfc, 0xff, 0xff}, // lea rdi, [rbp+0]; ...
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, ok := findAmd64HeaderReference(tt.code, addr, header)
+			require.Equal(t, tt.wantFound, ok)
+			assert.Equal(t, tt.wantAddr, found)
+		})
+	}
+}
+
+func TestFile_GrowHeaderSpace_32bit(t *testing.T) {
+	// the flags of the __text section follow the header, the __PAGEZERO and __TEXT segment commands and the start of
+	// the section
+	const textFlags = 28 + 56 + 56 + 56
+
+	tests := []struct {
+		cpu macho.Cpu
+		// the header grows by a whole page of the platform
+		delta uint64
+	}{
+		{cpu: macho.Cpu386, delta: 0x1000},
+		{cpu: macho.CpuArm, delta: 0x4000},
+	}
+	for _, tt := range tests {
+		cpu, delta := tt.cpu, tt.delta
+		t.Run(cpu.String(), func(t *testing.T) {
+			p := test.Macho32(t, cpu, 0)
+
+			// references to the header can not be found within 32-bit code, so binaries with code are refused...
+			m, err := NewFile(p)
+			require.NoError(t, err)
+			require.ErrorContains(t, m.GrowHeaderSpace(16), "code for references to the Mach-O header")
+			require.NoError(t, m.Close())
+
+			// ...though the layout is the same for binaries without code
+			data, err := os.ReadFile(p)
+			require.NoError(t, err)
+			require.Equal(t, uint32(0x80000400), binary.LittleEndian.Uint32(data[textFlags:]))
+			binary.LittleEndian.PutUint32(data[textFlags:], 0)
+			require.NoError(t, os.WriteFile(p, data, 0600))
+
+			original, err := macho.Open(p)
+			require.NoError(t, err)
+			defer original.Close()
+
+			m, err = NewFile(p)
+			require.NoError(t, err)
+			defer m.Close()
+
+			require.False(t, m.hasRoomForNewCmd())
+			require.NoError(t, m.GrowHeaderSpace(16))
+			require.NoError(t, m.AddEmptyCodeSigningCmd())
+			assert.True(t, m.HasCodeSigningCmd())
+
+			text, originalText := m.Section("__text"), original.Section("__text")
+			assert.Equal(t, originalText.Offset+uint32(delta), text.Offset)
+			assert.Equal(t, originalText.Addr+delta, text.Addr)
+			assert.Equal(t, original.Segment("__TEXT").Filesz+delta, m.Segment("__TEXT").Filesz)
+			assert.Equal(t, original.Segment("__LINKEDIT").Addr+delta, m.Segment("__LINKEDIT").Addr)
+
+			// the entry point (the program counter of the initial thread state) moves with the code
+			pcIndex := 10
+			if cpu == macho.CpuArm {
+				pcIndex = 15
+			}
+			thread, originalThread := threadState(t, m.File), threadState(t, original)
+			assert.Equal(t, uint32(originalText.Addr), m.ByteOrder.Uint32(originalThread[16+pcIndex*4:]))
+			assert.Equal(t, uint32(text.Addr), m.ByteOrder.Uint32(thread[16+pcIndex*4:]))
+		})
+	}
+}
+
+func threadState(t *testing.T, f *macho.File) []byte {
+	t.Helper()
+	for _, l := range f.Loads {
+		if raw := l.Raw(); LoadCommandType(f.ByteOrder.Uint32(raw)) == lcUnixThread {
+			return raw
+		}
+	}
+	t.Fatal("no LC_UNIXTHREAD load command")
+	return nil
+}
@@ -0,0 +1,74 @@
+package macho
+
+import "fmt"
+
+// readULEB decodes the unsigned LEB128 value at the given position, returning the value and the position after it.
+func readULEB(b []byte, pos int) (uint64, int, error) {
+	var value uint64
+	var shift uint
+	for {
+		if pos >= len(b) {
+			return 0, 0, fmt.Errorf("truncated uleb128 value")
+		}
+		c := b[pos]
+		pos++
+		if shift >= 64 && c&0x7f != 0 {
+			return 0, 0, fmt.Errorf("uleb128 value overflows 64 bits")
+		}
+		if shift < 64 {
+			value |= uint64(c&0x7f) << shift
+		}
+		shift += 7
+		if c&0x80 == 0 {
+			return value, pos, nil
+		}
+	}
+}
+
+// readSLEB decodes the signed LEB128 value at the given position, returning the value and the position after it.
+func readSLEB(b []byte, pos int) (int64, int, error) {
+	var value int64
+	var shift uint
+	for {
+		if pos >= len(b) {
+			return 0, 0, fmt.Errorf("truncated sleb128 value")
+		}
+		c := b[pos]
+		pos++
+		if shift < 64 {
+			value |= int64(c&0x7f) << shift
+		}
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				value |= -1 << shift
+			}
+			return value, pos, nil
+		}
+	}
+}
+
+// appendULEB appends the unsigned LEB128 encoding of the value.
+func appendULEB(b []byte, value uint64) []byte {
+	for {
+		c := byte(value & 0x7f)
+		value >>= 7
+		if value != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if value == 0 {
+			return b
+		}
+	}
+}
+
+// ulebSize is the number of bytes in the unsigned LEB128 encoding of the value.
+func ulebSize(value uint64) int {
+	n := 1
+	for value >= 0x80 {
+		value >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package macho
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_uleb128(t *testing.T) {
+	tests := []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{value: 0, encoded: []byte{0x00}},
+		{value: 0x7f, encoded: []byte{0x7f}},
+		{value: 0x80, encoded: []byte{0x80, 0x01}},
+		{value: 0x4290, encoded: []byte{0x90, 0x85, 0x01}},
+		{value: 624485, encoded: []byte{0xe5, 0x8e, 0x26}},
+		{value: 1<<64 - 1, encoded: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%x", tt.value), func(t *testing.T) {
+			assert.Equal(t, tt.encoded, appendULEB(nil, tt.value))
+			assert.Equal(t, len(tt.encoded), ulebSize(tt.value))
+
+			value, pos, err := readULEB(append([]byte{0xaa}, tt.encoded...), 1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, value)
+			assert.Equal(t, len(tt.encoded)+1, pos)
+		})
+	}
+}
+
+func Test_readSLEB(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		want    int64
+	}{
+		{encoded: []byte{0x02}, want: 2},
+		{encoded: []byte{0x7e}, want: -2},
+		{encoded: []byte{0xff, 0x00}, want: 127},
+		{encoded: []byte{0x81, 0x7f}, want: -127},
+		{encoded: []byte{0xc0, 0xbb, 0x78}, want: -123456},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.want), func(t *testing.T) {
+			value, pos, err := readSLEB(tt.encoded, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, value)
+			assert.Equal(t, len(tt.encoded), pos)
+		})
+	}
+}
+
+func Test_readULEB_invalid(t *testing.T) {
+	_, _, err := readULEB([]byte{0x80, 0x80}, 0)
+	require.ErrorContains(t, err, "truncated")
+
+	_, _, err = readULEB([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 0)
+	require.ErrorContains(t, err, "overflows")
+}
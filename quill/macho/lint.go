@@ -0,0 +1,212 @@
+package macho
+
+import (
+	"debug/macho"
+	"fmt"
+
+	"github.com/anchore/quill/internal/log"
+)
+
+// LintSeverity is how serious a lint finding is: errors are structural problems that prevent the binary from being
+// signed correctly, while warnings (and info) are worth knowing about but do not stop signing.
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+	LintInfo    LintSeverity = "info"
+)
+
+// LintCheck identifies the check that raised a lint finding.
+type LintCheck string
+
+const (
+	LintOverlappingSegments LintCheck = "overlapping-segments"
+	LintLinkeditNotLast     LintCheck = "linkedit-not-last"
+	LintSignatureNotAtEnd   LintCheck = "signature-not-at-end"
+	LintMissingBuildVersion LintCheck = "missing-build-version"
+	LintMinOSTooOld         LintCheck = "min-os-too-old"
+	LintLinkerSigned        LintCheck = "linker-signed"
+)
+
+// HardenedRuntimeMinMacOS is the earliest macOS release that enforces the hardened runtime.
+var HardenedRuntimeMinMacOS = NewVersion(10, 14, 0)
+
+// LintFinding is a problem found with the structure of a binary.
+type LintFinding struct {
+	Check    LintCheck    `json:"check"`
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s [%s]", f.Severity, f.Message, f.Check)
+}
+
+// Lint checks the structure of the binary for problems that would otherwise surface deep within signing (or once the
+// signed binary is run): overlapping segments, a __LINKEDIT segment that is not last in the file, an existing
+// signature that is not at the end of the file, a missing LC_BUILD_VERSION, a minimum macOS version that predates the
+// hardened runtime, and a signature made by the linker (which signing replaces).
+func (m *File) Lint() []LintFinding {
+	var findings []LintFinding
+	for _, check := range []func() []LintFinding{m.lintSegments, m.lintLinkedit, m.lintSignature, m.lintBuildVersion} {
+		findings = append(findings, check()...)
+	}
+	return findings
+}
+
+// LintErrors returns the findings that are errors.
+func LintErrors(findings []LintFinding) []LintFinding {
+	var errs []LintFinding
+	for _, f := range findings {
+		if f.Severity == LintError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+func (m *File) segments() []*macho.Segment {
+	var segments []*macho.Segment
+	for _, l := range m.Loads {
+		if s, ok := l.(*macho.Segment); ok {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+func (m *File) lintSegments() []LintFinding {
+	overlaps := func(start1, size1, start2, size2 uint64) bool {
+		return size1 > 0 && size2 > 0 && start1 < start2+size2 && start2 < start1+size1
+	}
+
+	var findings []LintFinding
+	segments := m.segments()
+	for i, a := range segments {
+		for _, b := range segments[i+1:] {
+			if overlaps(a.Offset, a.Filesz, b.Offset, b.Filesz) {
+				findings = append(findings, LintFinding{
+					Check:    LintOverlappingSegments,
+					Severity: LintError,
+					Message: fmt.Sprintf("segment %s (file offset 0x%x-0x%x) overlaps segment %s (file offset 0x%x-0x%x)",
+						a.Name, a.Offset, a.Offset+a.Filesz, b.Name, b.Offset, b.Offset+b.Filesz),
+				})
+			}
+			if overlaps(a.Addr, a.Memsz, b.Addr, b.Memsz) {
+				findings = append(findings, LintFinding{
+					Check:    LintOverlappingSegments,
+					Severity: LintError,
+					Message: fmt.Sprintf("segment %s (address 0x%x-0x%x) overlaps segment %s (address 0x%x-0x%x)",
+						a.Name, a.Addr, a.Addr+a.Memsz, b.Name, b.Addr, b.Addr+b.Memsz),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// lintLinkedit checks that __LINKEDIT is the last segment in the file, since the code signature is written at the end
+// of __LINKEDIT (and so at the end of the file).
+func (m *File) lintLinkedit() []LintFinding {
+	finding := func(format string, args ...any) []LintFinding {
+		return []LintFinding{{Check: LintLinkeditNotLast, Severity: LintError, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	linkedit := m.Segment("__LINKEDIT")
+	if linkedit == nil {
+		return finding("no __LINKEDIT segment to hold the code signature")
+	}
+
+	for _, s := range m.segments() {
+		if s != linkedit && s.Filesz > 0 && s.Offset >= linkedit.Offset+linkedit.Filesz {
+			return finding("segment %s (file offset 0x%x) follows __LINKEDIT, which must be the last segment in the file", s.Name, s.Offset)
+		}
+	}
+
+	if m.HasCodeSigningCmd() {
+		// the signature is expected to be at the end of __LINKEDIT, which is checked with the signature itself
+		return nil
+	}
+
+	fileSize, err := m.getFileSize()
+	if err != nil {
+		log.WithFields("error", err).Debug("unable to determine file size")
+		return nil
+	}
+	if end := linkedit.Offset + linkedit.Filesz; end < uint64(fileSize) {
+		return finding("__LINKEDIT ends at file offset 0x%x, before the end of the file (0x%x bytes), so the trailing data would be overwritten by the code signature", end, fileSize)
+	}
+	return nil
+}
+
+// lintSignature checks that any existing signature can be removed when re-signing: the signature must be at the end
+// of the file (and LC_CODE_SIGNATURE the last load command).
+func (m *File) lintSignature() []LintFinding {
+	cmd, _, err := m.CodeSigningCmd()
+	if err != nil || cmd == nil {
+		return nil
+	}
+
+	var findings []LintFinding
+	finding := func(check LintCheck, severity LintSeverity, format string, args ...any) {
+		findings = append(findings, LintFinding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !m.isSigningCommandLastLoader() {
+		finding(LintSignatureNotAtEnd, LintError, "LC_CODE_SIGNATURE is not the last load command")
+	}
+
+	fileSize, err := m.getFileSize()
+	if err != nil {
+		log.WithFields("error", err).Debug("unable to determine file size")
+		return findings
+	}
+	if end := uint64(cmd.DataOffset) + uint64(cmd.DataSize); end != uint64(fileSize) {
+		finding(LintSignatureNotAtEnd, LintError, "the existing code signature (file offset 0x%x-0x%x) is not at the end of the file (0x%x bytes)", cmd.DataOffset, end, fileSize)
+		return findings
+	}
+
+	cdBytes, err := m.CDBytes(SigningOrder, 0)
+	if err != nil {
+		log.WithFields("error", err).Debug("unable to read existing code directory")
+		return findings
+	}
+	cd, err := ParseCodeDirectory(cdBytes)
+	if err != nil {
+		log.WithFields("error", err).Debug("unable to parse existing code directory")
+		return findings
+	}
+	if cd.Flags&LinkerSigned != 0 {
+		finding(LintLinkerSigned, LintInfo, "the binary has an ad hoc signature from the linker, which is replaced when signing")
+	}
+	return findings
+}
+
+func (m *File) lintBuildVersion() []LintFinding {
+	bv := m.BuildVersion()
+	if bv == nil {
+		return []LintFinding{{
+			Check:    LintMissingBuildVersion,
+			Severity: LintWarning,
+			Message:  "no LC_BUILD_VERSION (or LC_VERSION_MIN_*) load command, so the platform and minimum OS version are unknown",
+		}}
+	}
+
+	var findings []LintFinding
+	if bv.Command != LcBuildVersion {
+		findings = append(findings, LintFinding{
+			Check:    LintMissingBuildVersion,
+			Severity: LintWarning,
+			Message:  fmt.Sprintf("no LC_BUILD_VERSION load command (only the legacy LC_VERSION_MIN_* command, for %s %s)", bv.Platform, bv.MinOS),
+		})
+	}
+	if bv.Platform == PlatformMacOS && bv.MinOS < HardenedRuntimeMinMacOS {
+		findings = append(findings, LintFinding{
+			Check:    LintMinOSTooOld,
+			Severity: LintWarning,
+			Message:  fmt.Sprintf("the minimum macOS version (%s) is older than %s, so the hardened runtime is not enforced on every supported release", bv.MinOS, HardenedRuntimeMinMacOS),
+		})
+	}
+	return findings
+}
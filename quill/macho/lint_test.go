@@ -0,0 +1,139 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+func TestFile_Lint(t *testing.T) {
+	le := binary.LittleEndian
+	// addCmd appends a load command into the header padding of the (32-bit) binary
+	addCmd := func(b []byte, values ...uint32) []byte {
+		ncmds, sizeOfCmds := le.Uint32(b[16:]), le.Uint32(b[20:])
+		cmd := make([]byte, 0, len(values)*4)
+		for _, v := range values {
+			cmd = le.AppendUint32(cmd, v)
+		}
+		le.PutUint32(cmd[4:], uint32(len(cmd)))
+		copy(b[28+sizeOfCmds:], cmd)
+		le.PutUint32(b[16:], ncmds+1)
+		le.PutUint32(b[20:], sizeOfCmds+uint32(len(cmd)))
+		return b
+	}
+	buildVersion := func(minOS Version) func([]byte) []byte {
+		return func(b []byte) []byte {
+			return addCmd(b, uint32(LcBuildVersion), 0, uint32(PlatformMacOS), uint32(minOS), uint32(NewVersion(14, 0, 0)), 0)
+		}
+	}
+	const (
+		linkeditCmd    = 28 + 56 + 56 + 68 // after the header, __PAGEZERO and __TEXT (with one section)
+		linkeditOffset = 0x1000
+	)
+
+	tests := []struct {
+		name   string
+		modify []func([]byte) []byte
+		want   []LintCheck
+	}{
+		{
+			name:   "well formed",
+			modify: []func([]byte) []byte{buildVersion(NewVersion(11, 0, 0))},
+		},
+		{
+			name: "missing build version",
+			want: []LintCheck{LintMissingBuildVersion},
+		},
+		{
+			name: "legacy minimum version",
+			modify: []func([]byte) []byte{func(b []byte) []byte {
+				return addCmd(b, uint32(LcVersionMinMacosx), 0, uint32(NewVersion(10, 9, 0)), uint32(NewVersion(10, 15, 0)))
+			}},
+			want: []LintCheck{LintMissingBuildVersion, LintMinOSTooOld},
+		},
+		{
+			name:   "minimum macOS version predates the hardened runtime",
+			modify: []func([]byte) []byte{buildVersion(NewVersion(10, 13, 0))},
+			want:   []LintCheck{LintMinOSTooOld},
+		},
+		{
+			name: "overlapping segments",
+			modify: []func([]byte) []byte{buildVersion(NewVersion(11, 0, 0)), func(b []byte) []byte {
+				// map __LINKEDIT over __TEXT
+				le.PutUint32(b[linkeditCmd+24:], 0x1000)
+				return b
+			}},
+			want: []LintCheck{LintOverlappingSegments},
+		},
+		{
+			name: "trailing data after __LINKEDIT",
+			modify: []func([]byte) []byte{buildVersion(NewVersion(11, 0, 0)), func(b []byte) []byte {
+				return append(b, []byte("trailing")...)
+			}},
+			want: []LintCheck{LintLinkeditNotLast},
+		},
+		{
+			name: "signature not at the end of the file",
+			modify: []func([]byte) []byte{buildVersion(NewVersion(11, 0, 0)), func(b []byte) []byte {
+				return addCmd(b, uint32(LcCodeSignature), 0, linkeditOffset, 4)
+			}},
+			want: []LintCheck{LintSignatureNotAtEnd},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := test.Macho32(t, macho.Cpu386, 0x100)
+			b, err := os.ReadFile(p)
+			require.NoError(t, err)
+			require.Equal(t, "__LINKEDIT", string(b[linkeditCmd+8:linkeditCmd+18]))
+			for _, modify := range tt.modify {
+				b = modify(b)
+			}
+
+			m, err := NewFileFromBytes(b)
+			require.NoError(t, err)
+
+			var checks []LintCheck
+			for _, f := range m.Lint() {
+				checks = append(checks, f.Check)
+			}
+			assert.Equal(t, tt.want, checks)
+		})
+	}
+}
+
+func TestFile_BuildVersion(t *testing.T) {
+	p := test.Macho32(t, macho.CpuArm, 0x100)
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+
+	m, err := NewFileFromBytes(b)
+	require.NoError(t, err)
+	assert.Nil(t, m.BuildVersion())
+
+	// LC_BUILD_VERSION (with no tools) in the header padding
+	le := binary.LittleEndian
+	sizeOfCmds := le.Uint32(b[20:])
+	cmd := make([]byte, 24)
+	for i, v := range []uint32{uint32(LcBuildVersion), 24, uint32(PlatformIOS), uint32(NewVersion(9, 3, 5)), uint32(NewVersion(10, 0, 0))} {
+		le.PutUint32(cmd[i*4:], v)
+	}
+	copy(b[28+sizeOfCmds:], cmd)
+	le.PutUint32(b[16:], le.Uint32(b[16:])+1)
+	le.PutUint32(b[20:], sizeOfCmds+24)
+
+	m, err = NewFileFromBytes(b)
+	require.NoError(t, err)
+	bv := m.BuildVersion()
+	require.NotNil(t, bv)
+	assert.Equal(t, LcBuildVersion, bv.Command)
+	assert.Equal(t, "iOS", bv.Platform.String())
+	assert.Equal(t, "9.3.5", bv.MinOS.String())
+	assert.Equal(t, "10.0", bv.SDK.String())
+}
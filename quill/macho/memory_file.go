@@ -0,0 +1,82 @@
+package macho
+
+import (
+	"fmt"
+	"io"
+)
+
+// memoryFile is binary content held in memory, supporting the same operations as the os.File that otherwise backs
+// a File (writes beyond the end grow the content).
+type memoryFile struct {
+	b   []byte
+	pos int64
+}
+
+func (f *memoryFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(len(f.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.b[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= int64(len(f.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(f.b)) {
+		f.resize(end)
+	}
+	return copy(f.b[off:], p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.b))
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close does nothing, since the content must remain available after the File is refreshed.
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+// truncate resizes the content, where growing the content pads with zeros.
+func (f *memoryFile) truncate(size int64) error {
+	f.resize(size)
+	return nil
+}
+
+func (f *memoryFile) resize(size int64) {
+	if size <= int64(len(f.b)) {
+		f.b = f.b[:size]
+		return
+	}
+	f.b = append(f.b, make([]byte, size-int64(len(f.b)))...)
+}
@@ -1,15 +1,34 @@
 package macho
 
+import "fmt"
+
 type RequirementType uint32
 
 const (
 	HostRequirementType       RequirementType = 1 /* what hosts may run us */
 	GuestRequirementType      RequirementType = 2 /* what guests we may run */
-	DesignatedRequirementType RequirementType = 3 /* designated requirement */ // this is the only supported type
+	DesignatedRequirementType RequirementType = 3 /* designated requirement */
 	LibraryRequirementType    RequirementType = 4 /* what libraries we may link against */
 	PluginRequirementType     RequirementType = 5 /* what plug-ins we may load */
 )
 
+// String returns the requirement type name as used in the requirement language (e.g. "designated").
+func (t RequirementType) String() string {
+	switch t {
+	case HostRequirementType:
+		return "host"
+	case GuestRequirementType:
+		return "guest"
+	case DesignatedRequirementType:
+		return "designated"
+	case LibraryRequirementType:
+		return "library"
+	case PluginRequirementType:
+		return "plugin"
+	}
+	return fmt.Sprintf("type%d", uint32(t))
+}
+
 type RequirementsHeader struct {
 	Count  uint32 // TODO: what is this field?? ("count" is inferred)
 	Type   RequirementType
@@ -0,0 +1,140 @@
+package macho
+
+import (
+	"fmt"
+	"io"
+)
+
+// sliceFile is a slice of a universal binary, modified in place at its offset within the universal binary file. The
+// slice may grow into the padding that follows it (up to limit bytes, unbounded when negative), beyond which its
+// content moves to memory (so that the universal binary must be laid out again, see FatFileSlices.Commit).
+type sliceFile struct {
+	file interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+	offset int64
+	size   int64
+	limit  int64
+	pos    int64
+	// memory holds the content once the slice grows beyond its limit
+	memory *memoryFile
+}
+
+// moved indicates if the slice grew beyond its limit, so is no longer within the universal binary file.
+func (f *sliceFile) moved() bool {
+	return f.memory != nil
+}
+
+// length returns the size of the slice.
+func (f *sliceFile) length() int64 {
+	if f.memory != nil {
+		return int64(len(f.memory.b))
+	}
+	return f.size
+}
+
+func (f *sliceFile) Read(p []byte) (int, error) {
+	if f.memory != nil {
+		return f.memory.Read(p)
+	}
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *sliceFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.memory != nil {
+		return f.memory.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), f.size-off)
+	n, err := f.file.ReadAt(p[:want], f.offset+off)
+	if err == nil && int64(n) < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *sliceFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	end := off + int64(len(p))
+	if f.memory == nil && f.limit >= 0 && end > f.limit {
+		if err := f.moveToMemory(); err != nil {
+			return 0, err
+		}
+	}
+	if f.memory != nil {
+		return f.memory.WriteAt(p, off)
+	}
+
+	n, err := f.file.WriteAt(p, f.offset+off)
+	if off+int64(n) > f.size {
+		f.size = off + int64(n)
+	}
+	return n, err
+}
+
+func (f *sliceFile) Seek(offset int64, whence int) (int64, error) {
+	if f.memory != nil {
+		return f.memory.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close does nothing, since the universal binary file is shared between slices (see FatFileSlices.Close).
+func (f *sliceFile) Close() error {
+	return nil
+}
+
+// truncate resizes the slice, where the content beyond the new size (when shrinking) is zeroed within the universal
+// binary file so that the padding between slices remains zeros.
+func (f *sliceFile) truncate(size int64) error {
+	if f.memory == nil && f.limit >= 0 && size > f.limit {
+		if err := f.moveToMemory(); err != nil {
+			return err
+		}
+	}
+	if f.memory != nil {
+		return f.memory.truncate(size)
+	}
+
+	start, end := min(size, f.size), max(size, f.size)
+	if _, err := f.file.WriteAt(make([]byte, end-start), f.offset+start); err != nil {
+		return fmt.Errorf("unable to resize slice: %w", err)
+	}
+	f.size = size
+	return nil
+}
+
+func (f *sliceFile) moveToMemory() error {
+	b := make([]byte, f.size)
+	if _, err := f.file.ReadAt(b, f.offset); err != nil {
+		return fmt.Errorf("unable to read slice: %w", err)
+	}
+	f.memory = &memoryFile{b: b, pos: f.pos}
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/klauspost/compress/zip"
 
 	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/archive"
 	"github.com/anchore/quill/quill/macho"
 )
 
@@ -31,11 +32,11 @@ func NewPayload(path string) (*Payload, error) {
 	switch contentType {
 	case "application/zip":
 		return prepareZip(path)
+	case "application/gzip", "application/x-tar":
+		return prepareTarball(path)
 	default:
 		return prepareBinary(path)
 	}
-
-	// TODO: support repackaging tar.gz for easy with goreleaser
 }
 
 func prepareZip(path string) (*Payload, error) {
@@ -110,6 +111,71 @@ func prepareBinary(path string) (*Payload, error) {
 	}, nil
 }
 
+// prepareTarball repackages the binaries within a tar (or tar.gz) archive as a zip, since tarballs are not accepted by
+// the notary service (e.g. for the archives created by goreleaser).
+func prepareTarball(path string) (*Payload, error) {
+	log.Trace("repackaging binaries within tarball as a zip payload")
+
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+
+	var count int
+	isBinary := func(_ archive.Entry, prefix []byte) bool {
+		return macho.HasMachoMagic(prefix)
+	}
+	err := archive.Walk(path, isBinary, func(entry archive.Entry, binPath string) error {
+		if isMacho, err := macho.IsMachoFile(binPath); err != nil || !isMacho {
+			return nil
+		}
+
+		fh := &zip.FileHeader{
+			Name:     strings.TrimPrefix(entry.Name, "./"),
+			Method:   zip.Deflate,
+			Modified: entry.ModTime,
+		}
+		fh.SetMode(entry.Mode)
+
+		f, err := os.Open(binPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		zf, err := w.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(zf, f); err != nil {
+			return fmt.Errorf("unable to add %q to zip: %w", entry.Name, err)
+		}
+
+		log.WithFields("name", fh.Name).Trace("added binary from tarball to zip payload")
+		count++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to repackage tarball: %w", err)
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("no darwin macho executable files found in tarball")
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(buf.Bytes())
+
+	log.WithFields("bytes", buf.Len(), "binaries", count, "digest", hex.EncodeToString(digest[:])).Trace("wrote tarball payload to zip")
+
+	return &Payload{
+		Reader: bytes.NewReader(buf.Bytes()),
+		Path:   path,
+		Digest: hex.EncodeToString(digest[:]),
+	}, nil
+}
+
 func createZip(name string, reader io.Reader) (*bytes.Buffer, error) {
 	buf := bytes.Buffer{}
 
@@ -0,0 +1,88 @@
+package notary
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// minimalMacho returns the header of an (empty) 64-bit Mach-O executable without any load commands.
+func minimalMacho() []byte {
+	b := make([]byte, 32)
+	binary.LittleEndian.PutUint32(b[0:], macho.Magic64)
+	binary.LittleEndian.PutUint32(b[4:], uint32(macho.CpuArm64))
+	binary.LittleEndian.PutUint32(b[12:], uint32(macho.TypeExec))
+	return b
+}
+
+func writeTarball(t *testing.T, files map[string][]byte, order ...string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "release.tar.gz")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, name := range order {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0755,
+			ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			Size:    int64(len(files[name])),
+		}))
+		_, err := tw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return p
+}
+
+func TestNewPayload_tarball(t *testing.T) {
+	bin := minimalMacho()
+	p := writeTarball(t, map[string][]byte{
+		"./README.md": []byte("read me"),
+		"./bin/cli":   bin,
+	}, "./README.md", "./bin/cli")
+
+	payload, err := NewPayload(p)
+	require.NoError(t, err)
+	assert.Equal(t, p, payload.Path)
+	assert.NotEmpty(t, payload.Digest)
+
+	contents, err := io.ReadAll(payload)
+	require.NoError(t, err)
+
+	// only the binaries are repackaged into the zip
+	r, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	require.NoError(t, err)
+	require.Len(t, r.File, 1)
+	assert.Equal(t, "bin/cli", r.File[0].Name)
+	assert.Equal(t, os.FileMode(0755), r.File[0].Mode())
+
+	rc, err := r.File[0].Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	actual, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, bin, actual)
+}
+
+func TestNewPayload_tarballWithoutBinaries(t *testing.T) {
+	p := writeTarball(t, map[string][]byte{"README.md": []byte("read me")}, "README.md")
+
+	_, err := NewPayload(p)
+	require.ErrorContains(t, err, "no darwin macho executable files found")
+}
@@ -2,6 +2,7 @@ package notary
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"fmt"
 	"time"
 
@@ -19,7 +20,16 @@ type TokenConfig struct {
 }
 
 func NewSignedToken(cfg TokenConfig) (string, error) {
-	method := jwt.SigningMethodES256 // TODO: add more methods
+	key, err := loadPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	method, err := signingMethod(key)
+	if err != nil {
+		return "", err
+	}
+
 	token := &jwt.Token{
 		Header: map[string]any{
 			"alg": method.Alg(),
@@ -36,14 +46,24 @@ func NewSignedToken(cfg TokenConfig) (string, error) {
 		Method: method,
 	}
 
-	key, err := loadPrivateKey(cfg.PrivateKey)
-	if err != nil {
-		return "", err
-	}
-
 	return token.SignedString(key)
 }
 
+// signingMethod selects the JWT signing method matching the curve of the given key (ES256 for P-256, ES384 for
+// P-384, and ES512 for P-521).
+func signingMethod(key *ecdsa.PrivateKey) (jwt.SigningMethod, error) {
+	switch key.Curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	default:
+		return nil, fmt.Errorf("unsupported curve for JWT private key: %s", key.Curve.Params().Name)
+	}
+}
+
 func loadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
 	log.Debug("loading private key for notary")
 
@@ -0,0 +1,85 @@
+package notary
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeECKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.p8")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return path
+}
+
+func TestNewSignedToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		curve   elliptic.Curve
+		wantAlg string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "P-256",
+			curve:   elliptic.P256(),
+			wantAlg: "ES256",
+		},
+		{
+			name:    "P-384",
+			curve:   elliptic.P384(),
+			wantAlg: "ES384",
+		},
+		{
+			name:    "P-521",
+			curve:   elliptic.P521(),
+			wantAlg: "ES512",
+		},
+		{
+			name:    "unsupported curve",
+			curve:   elliptic.P224(),
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			key, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+			require.NoError(t, err)
+
+			signed, err := NewSignedToken(TokenConfig{
+				Issuer:        "the-issuer",
+				PrivateKeyID:  "the-key-id",
+				TokenLifetime: time.Minute,
+				PrivateKey:    writeECKey(t, key),
+			})
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			token, err := jwt.Parse(signed, func(token *jwt.Token) (any, error) {
+				return &key.PublicKey, nil
+			})
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, tt.wantAlg, token.Header["alg"])
+			assert.Equal(t, "the-key-id", token.Header["kid"])
+		})
+	}
+}
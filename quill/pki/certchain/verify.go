@@ -3,6 +3,7 @@ package certchain
 import (
 	"crypto/x509"
 	"fmt"
+	"time"
 
 	"github.com/anchore/quill/internal/log"
 )
@@ -54,6 +55,47 @@ func VerifyForCodeSigning(certs []*x509.Certificate, failWithoutFullChain bool)
 		},
 	}
 
+	ignoreAppleCriticalExtensions(leaf)
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("failed to verify certificate chain: %w", err)
+	}
+	return nil
+}
+
+// VerifyWithStore verifies that the leaf certificate chains up to a root certificate within the given store. Any
+// intermediate certificates from the store as well as the given intermediates may be used to build the chain.
+func VerifyWithStore(leaf *x509.Certificate, intermediates []*x509.Certificate, store Enumerator, at time.Time) ([][]*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	for _, p := range store.RootPEMs() {
+		roots.AppendCertsFromPEM(p)
+	}
+
+	intermediatePool := x509.NewCertPool()
+	for _, p := range store.IntermediatePEMs() {
+		intermediatePool.AppendCertsFromPEM(p)
+	}
+	for _, c := range intermediates {
+		intermediatePool.AddCert(c)
+	}
+
+	ignoreAppleCriticalExtensions(leaf)
+
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediatePool,
+		CurrentTime:   at,
+		KeyUsages: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageCodeSigning,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify certificate chain: %w", err)
+	}
+	return chains, nil
+}
+
+func ignoreAppleCriticalExtensions(leaf *x509.Certificate) {
 	// ignore "devid_execute" and "devid_kernel" critical extensions
 	temp := leaf.UnhandledCriticalExtensions[:0]
 	for _, ex := range leaf.UnhandledCriticalExtensions {
@@ -71,9 +113,4 @@ func VerifyForCodeSigning(certs []*x509.Certificate, failWithoutFullChain bool)
 	if len(leaf.UnhandledCriticalExtensions) > 0 {
 		log.Warnf("certificate has unhandled critical extensions: %v", leaf.UnhandledCriticalExtensions)
 	}
-
-	if _, err := leaf.Verify(opts); err != nil {
-		return fmt.Errorf("failed to verify certificate chain: %w", err)
-	}
-	return nil
 }
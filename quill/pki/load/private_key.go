@@ -2,6 +2,7 @@ package load
 
 import (
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -10,6 +11,8 @@ import (
 	"github.com/anchore/quill/internal/log"
 )
 
+// PrivateKey loads a PEM encoded RSA or ECDSA private key (PKCS #1, SEC 1, or PKCS #8), decrypting it with the given
+// password if needed.
 func PrivateKey(path string, password string) (crypto.PrivateKey, error) {
 	log.Debug("loading private key")
 
@@ -24,10 +27,10 @@ func PrivateKey(path string, password string) (crypto.PrivateKey, error) {
 	}
 
 	switch pemObj.Type {
-	case "RSA PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
 		// pass
 	default:
-		return nil, fmt.Errorf("RSA private key is of the wrong type: %q", pemObj.Type)
+		return nil, fmt.Errorf("private key is of the wrong type: %q", pemObj.Type)
 	}
 
 	var privPemBytes []byte
@@ -54,17 +57,29 @@ func PrivateKey(path string, password string) (crypto.PrivateKey, error) {
 		privPemBytes = pemObj.Bytes
 	}
 
-	var parsedKey any
-	if parsedKey, err = x509.ParsePKCS1PrivateKey(privPemBytes); err != nil {
-		if parsedKey, err = x509.ParsePKCS8PrivateKey(privPemBytes); err != nil {
-			return nil, fmt.Errorf("unable to parse RSA private key: %w", err)
-		}
+	parsedKey, err := parsePrivateKey(privPemBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	privateKey, ok := parsedKey.(*rsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("unable to find RSA private key after parsing: %w", err)
+	switch parsedKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return parsedKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T (only RSA and ECDSA keys are supported)", parsedKey)
 	}
+}
 
-	return privateKey, nil
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+	return key, nil
 }
@@ -1,6 +1,16 @@
 package load
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,3 +49,74 @@ func Test_loadPrivateKeyFromFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_loadPrivateKey_keyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8 := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		return der
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		block   pem.Block
+		want    any
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:  "RSA PKCS #1",
+			block: pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			want:  rsaKey,
+		},
+		{
+			name:  "RSA PKCS #8",
+			block: pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(rsaKey)},
+			want:  rsaKey,
+		},
+		{
+			name:  "ECDSA SEC 1",
+			block: pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+			want:  ecKey,
+		},
+		{
+			name:  "ECDSA PKCS #8",
+			block: pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(ecKey)},
+			want:  ecKey,
+		},
+		{
+			name:    "ed25519 is not supported",
+			block:   pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(edKey)},
+			wantErr: require.Error,
+		},
+		{
+			name:    "wrong PEM type",
+			block:   pem.Block{Type: "CERTIFICATE", Bytes: pkcs8(ecKey)},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			path := filepath.Join(t.TempDir(), "key.pem")
+			require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&tt.block), 0600))
+
+			got, err := PrivateKey(path, "")
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.True(t, tt.want.(interface{ Equal(crypto.PrivateKey) bool }).Equal(got))
+		})
+	}
+}
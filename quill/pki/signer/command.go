@@ -0,0 +1,126 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anchore/quill/internal/log"
+)
+
+// DigestAlgorithmEnv is the environment variable that tells the external signing command which digest algorithm
+// was used to create the digest (e.g. "sha256").
+const DigestAlgorithmEnv = "QUILL_SIGNER_DIGEST_ALGORITHM"
+
+var _ crypto.Signer = (*Command)(nil)
+
+// Command is a crypto.Signer that delegates signing to an external program (similar to git's gpg.program), so the
+// private key never needs to be available to quill (e.g. it lives within a KMS or HSM). The digest to sign is written
+// to the program's stdin and the program must write the raw signature to stdout:
+//   - RSA keys: a PKCS #1 v1.5 signature of the digest (the program is responsible for adding the DigestInfo prefix)
+//   - ECDSA keys: an ASN.1 DER encoded signature of the digest
+//
+// The digest algorithm name is provided to the program via the QUILL_SIGNER_DIGEST_ALGORITHM environment variable.
+type Command struct {
+	Path string
+	Args []string
+	// PublicKey is the public key of the signing key, typically taken from the signing certificate.
+	PublicKey crypto.PublicKey
+}
+
+// NewCommand creates a signer for the given command line (the program followed by any arguments, separated by
+// whitespace) for the signing key with the given public key.
+func NewCommand(commandLine string, publicKey crypto.PublicKey) (*Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no signing command given")
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("no public key given for signing command %q", fields[0])
+	}
+
+	return &Command{
+		Path:      fields[0],
+		Args:      fields[1:],
+		PublicKey: publicKey,
+	}, nil
+}
+
+func (c *Command) Public() crypto.PublicKey {
+	return c.PublicKey
+}
+
+func (c *Command) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("RSA-PSS signatures are not supported by the signing command")
+	}
+
+	algorithm, err := digestAlgorithmName(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields("command", c.Path, "digest", algorithm).Debug("signing with external command")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.Env = append(os.Environ(), DigestAlgorithmEnv+"="+algorithm)
+	cmd.Stdin = bytes.NewReader(digest)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("signing command %q failed: %w: %s", c.Path, err, msg)
+		}
+		return nil, fmt.Errorf("signing command %q failed: %w", c.Path, err)
+	}
+
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("signing command %q returned an empty signature", c.Path)
+	}
+
+	signature := stdout.Bytes()
+	if err := verifySignature(c.PublicKey, opts.HashFunc(), digest, signature); err != nil {
+		return nil, fmt.Errorf("signing command %q returned an invalid signature (does the key match the certificate?): %w", c.Path, err)
+	}
+
+	return signature, nil
+}
+
+// verifySignature checks the signature against the public key, catching a mismatch between the signing key and the
+// certificate before it ends up within a code signature.
+func verifySignature(pub crypto.PublicKey, h crypto.Hash, digest, signature []byte) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, h, digest, signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, signature) {
+			return fmt.Errorf("ECDSA verification failure")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
+
+func digestAlgorithmName(h crypto.Hash) (string, error) {
+	switch h {
+	case crypto.SHA1:
+		return "sha1", nil
+	case crypto.SHA256:
+		return "sha256", nil
+	case crypto.SHA384:
+		return "sha384", nil
+	case crypto.SHA512:
+		return "sha512", nil
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm for signing: %s", h)
+	}
+}
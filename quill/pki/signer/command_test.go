@@ -0,0 +1,190 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helperProcessEnv = "QUILL_TEST_SIGNER_HELPER"
+	helperKeyEnv     = "QUILL_TEST_SIGNER_KEY"
+)
+
+// TestMain allows the test binary to act as an external signing command: when invoked with the helper environment
+// variable set it signs the digest on stdin with the PKCS #8 key at the path given by QUILL_TEST_SIGNER_KEY.
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperProcessEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "sign":
+		if err := helperSign(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "fail":
+		fmt.Fprintln(os.Stderr, "token is locked")
+		os.Exit(3)
+	case "empty":
+		os.Exit(0)
+	}
+}
+
+func helperSign() error {
+	if got := os.Getenv(DigestAlgorithmEnv); got != "sha256" {
+		return fmt.Errorf("unexpected digest algorithm: %q", got)
+	}
+
+	keyBytes, err := os.ReadFile(os.Getenv(helperKeyEnv))
+	if err != nil {
+		return err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBytes)
+	if err != nil {
+		return err
+	}
+
+	digest, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	sig, err := key.(crypto.Signer).Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(sig)
+	return err
+}
+
+func writeKey(t *testing.T, key crypto.PrivateKey) string {
+	t.Helper()
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.der")
+	require.NoError(t, os.WriteFile(path, b, 0600))
+	return path
+}
+
+func TestCommand_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		mode        string
+		key         crypto.PrivateKey
+		publicKey   crypto.PublicKey
+		opts        crypto.SignerOpts
+		wantErr     require.ErrorAssertionFunc
+		errContains string
+	}{
+		{
+			name:      "RSA signature",
+			mode:      "sign",
+			key:       rsaKey,
+			publicKey: rsaKey.Public(),
+			opts:      crypto.SHA256,
+		},
+		{
+			name:      "ECDSA signature",
+			mode:      "sign",
+			key:       ecKey,
+			publicKey: ecKey.Public(),
+			opts:      crypto.SHA256,
+		},
+		{
+			name:        "signature does not match the certificate key",
+			mode:        "sign",
+			key:         otherRSAKey,
+			publicKey:   rsaKey.Public(),
+			opts:        crypto.SHA256,
+			wantErr:     require.Error,
+			errContains: "does the key match the certificate?",
+		},
+		{
+			name:        "command fails",
+			mode:        "fail",
+			key:         rsaKey,
+			publicKey:   rsaKey.Public(),
+			opts:        crypto.SHA256,
+			wantErr:     require.Error,
+			errContains: "token is locked",
+		},
+		{
+			name:        "command returns nothing",
+			mode:        "empty",
+			key:         rsaKey,
+			publicKey:   rsaKey.Public(),
+			opts:        crypto.SHA256,
+			wantErr:     require.Error,
+			errContains: "empty signature",
+		},
+		{
+			name:        "PSS is not supported",
+			mode:        "sign",
+			key:         rsaKey,
+			publicKey:   rsaKey.Public(),
+			opts:        &rsa.PSSOptions{Hash: crypto.SHA256},
+			wantErr:     require.Error,
+			errContains: "RSA-PSS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			t.Setenv(helperProcessEnv, tt.mode)
+			t.Setenv(helperKeyEnv, writeKey(t, tt.key))
+
+			s, err := NewCommand(os.Args[0], tt.publicKey)
+			require.NoError(t, err)
+			assert.Equal(t, tt.publicKey, s.Public())
+
+			digest := sha256.Sum256([]byte("code directory"))
+			sig, err := s.Sign(rand.Reader, digest[:], tt.opts)
+			tt.wantErr(t, err)
+			if err != nil {
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+
+			require.NoError(t, verifySignature(tt.publicKey, crypto.SHA256, digest[:], sig))
+		})
+	}
+}
+
+func TestNewCommand(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	s, err := NewCommand("  kms-sign --key  projects/p/keys/k ", key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, "kms-sign", s.Path)
+	assert.Equal(t, []string{"--key", "projects/p/keys/k"}, s.Args)
+
+	_, err = NewCommand("   ", key.Public())
+	require.Error(t, err)
+
+	_, err = NewCommand("kms-sign", nil)
+	require.Error(t, err)
+}
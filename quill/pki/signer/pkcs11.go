@@ -0,0 +1,64 @@
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+)
+
+// PKCS11Config identifies a signing key within a PKCS #11 token (e.g. an HSM, or SoftHSM for local testing).
+type PKCS11Config struct {
+	// Module is the path to the PKCS #11 module (e.g. /usr/lib/softhsm/libsofthsm2.so)
+	Module string
+	// TokenLabel is the label of the token holding the key
+	TokenLabel string
+	// PIN is the user PIN for the token
+	PIN string
+	// KeyLabel is the label of the key pair (CKA_LABEL)
+	KeyLabel string
+	// KeyID is the ID of the key pair (CKA_ID), used instead of or in addition to the label
+	KeyID []byte
+}
+
+var _ interface {
+	crypto.Signer
+	io.Closer
+} = (*PKCS11)(nil)
+
+// PKCS11 is a crypto.Signer backed by a private key within a PKCS #11 token. The key never leaves the token.
+type PKCS11 struct {
+	crypto.Signer
+	closer io.Closer
+}
+
+// NewPKCS11 opens a session with the configured token and finds the signing key. Note: PKCS #11 support requires
+// quill to be built with cgo.
+func NewPKCS11(cfg PKCS11Config) (*PKCS11, error) {
+	if cfg.Module == "" {
+		return nil, fmt.Errorf("no PKCS #11 module given")
+	}
+	if cfg.TokenLabel == "" {
+		return nil, fmt.Errorf("no PKCS #11 token label given")
+	}
+	if cfg.KeyLabel == "" && len(cfg.KeyID) == 0 {
+		return nil, fmt.Errorf("no PKCS #11 key label or ID given")
+	}
+
+	signer, closer, err := openPKCS11(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PKCS11{
+		Signer: signer,
+		closer: closer,
+	}, nil
+}
+
+// Close ends the session with the token.
+func (p *PKCS11) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
@@ -0,0 +1,39 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+
+	"github.com/ThalesGroup/crypto11"
+)
+
+func openPKCS11(cfg PKCS11Config) (crypto.Signer, io.Closer, error) {
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       cfg.Module,
+		TokenLabel: cfg.TokenLabel,
+		Pin:        cfg.PIN,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open PKCS #11 token %q: %w", cfg.TokenLabel, err)
+	}
+
+	var label []byte
+	if cfg.KeyLabel != "" {
+		label = []byte(cfg.KeyLabel)
+	}
+
+	signer, err := ctx.FindKeyPair(cfg.KeyID, label)
+	if err != nil {
+		ctx.Close()
+		return nil, nil, fmt.Errorf("unable to find PKCS #11 key: %w", err)
+	}
+	if signer == nil {
+		ctx.Close()
+		return nil, nil, fmt.Errorf("no PKCS #11 key found with label %q (id=%x) in token %q", cfg.KeyLabel, cfg.KeyID, cfg.TokenLabel)
+	}
+
+	return signer, ctx, nil
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+)
+
+func openPKCS11(PKCS11Config) (crypto.Signer, io.Closer, error) {
+	return nil, nil, fmt.Errorf("PKCS #11 signing is not supported by this build of quill (requires cgo)")
+}
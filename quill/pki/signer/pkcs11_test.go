@@ -0,0 +1,58 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPKCS11_Sign runs against a real token and is skipped unless one is configured, e.g. with SoftHSM:
+//
+//	softhsm2-util --init-token --free --label quill --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label quill --login --pin 1234 \
+//	    --keypairgen --key-type rsa:2048 --label signing-key
+//
+//	QUILL_TEST_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so QUILL_TEST_PKCS11_TOKEN=quill \
+//	    QUILL_TEST_PKCS11_PIN=1234 QUILL_TEST_PKCS11_KEY_LABEL=signing-key go test ./quill/pki/signer/...
+func TestPKCS11_Sign(t *testing.T) {
+	cfg := PKCS11Config{
+		Module:     os.Getenv("QUILL_TEST_PKCS11_MODULE"),
+		TokenLabel: os.Getenv("QUILL_TEST_PKCS11_TOKEN"),
+		PIN:        os.Getenv("QUILL_TEST_PKCS11_PIN"),
+		KeyLabel:   os.Getenv("QUILL_TEST_PKCS11_KEY_LABEL"),
+	}
+	if cfg.Module == "" {
+		t.Skip("QUILL_TEST_PKCS11_MODULE not set")
+	}
+
+	s, err := NewPKCS11(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, s.Close()) })
+
+	digest := sha256.Sum256([]byte("code directory"))
+	sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	require.NoError(t, verifySignature(s.Public(), crypto.SHA256, digest[:], sig))
+}
+
+func TestNewPKCS11_invalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PKCS11Config
+	}{
+		{name: "missing module", cfg: PKCS11Config{TokenLabel: "t", KeyLabel: "k"}},
+		{name: "missing token", cfg: PKCS11Config{Module: "m.so", KeyLabel: "k"}},
+		{name: "missing key", cfg: PKCS11Config{Module: "m.so", TokenLabel: "t"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPKCS11(tt.cfg)
+			require.Error(t, err)
+		})
+	}
+}
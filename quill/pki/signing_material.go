@@ -1,7 +1,11 @@
 package pki
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
 	"fmt"
@@ -12,9 +16,11 @@ import (
 )
 
 type SigningMaterial struct {
-	Signer          crypto.Signer
-	Certs           []*x509.Certificate
-	TimestampServer string
+	Signer crypto.Signer
+	Certs  []*x509.Certificate
+	// TimestampServers are the RFC3161 timestamp servers to request a timestamp from, tried in order until one succeeds
+	// (no timestamp is requested when empty)
+	TimestampServers []string
 }
 
 func NewSigningMaterialFromPEMs(certFile, privateKeyPath, password string, failWithoutFullChain bool) (*SigningMaterial, error) {
@@ -49,6 +55,10 @@ func NewSigningMaterialFromPEMs(certFile, privateKeyPath, password string, failW
 		return nil, fmt.Errorf("unable to derive signer from private key")
 	}
 
+	if err := checkSigningKey(signer.Public()); err != nil {
+		return nil, err
+	}
+
 	return &SigningMaterial{
 		Signer: signer,
 		Certs:  certchain.Sort(certs),
@@ -71,20 +81,13 @@ func NewSigningMaterialFromP12(p12Content load.P12Contents, failWithoutFullChain
 		return nil, fmt.Errorf("unable to derive signer from private key")
 	}
 
-	if len(allCerts) > 0 {
-		if err := certchain.VerifyForCodeSigning(allCerts, failWithoutFullChain); err != nil {
-			store := certchain.NewCollection().WithStores(apple.GetEmbeddedCertStore())
+	if err := checkSigningKey(signer.Public()); err != nil {
+		return nil, err
+	}
 
-			// verification failed, try again but attempt to find more certs from the embedded certs in quill
-			remainingCerts, err := certchain.Find(store, p12Content.Certificate)
-			if err != nil {
-				return nil, fmt.Errorf("unable to find remaining chain certificates: %w", err)
-			}
-			allCerts = append(allCerts, remainingCerts...)
-			if err := certchain.VerifyForCodeSigning(allCerts, failWithoutFullChain); err != nil {
-				return nil, err
-			}
-		}
+	allCerts, err := completeChain(p12Content.Certificate, allCerts, failWithoutFullChain)
+	if err != nil {
+		return nil, err
 	}
 
 	return &SigningMaterial{
@@ -93,6 +96,101 @@ func NewSigningMaterialFromP12(p12Content load.P12Contents, failWithoutFullChain
 	}, nil
 }
 
+// NewSigningMaterialFromSigner creates signing material for a signer whose private key is held elsewhere (e.g. an
+// HSM, KMS, or external signing command), where the certificate chain is read from the given PEM file. The chain
+// must include the certificate for the signer's public key.
+func NewSigningMaterialFromSigner(signer crypto.Signer, certFile string, failWithoutFullChain bool) (*SigningMaterial, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("no signer given")
+	}
+
+	if certFile == "" {
+		return nil, fmt.Errorf("a certificate chain is required when using an external signer")
+	}
+
+	if err := checkSigningKey(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	certs, err := load.Certificates(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := certificateForKey(certs, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err = completeChain(leaf, certs, failWithoutFullChain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningMaterial{
+		Signer: signer,
+		Certs:  certchain.Sort(certs),
+	}, nil
+}
+
+// checkSigningKey ensures the key is one that can be used for code signing: RSA, or ECDSA on the P-256 or P-384 curves.
+func checkSigningKey(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384():
+			return nil
+		}
+		return fmt.Errorf("unsupported ECDSA curve for code signing: %s (only P-256 and P-384 are supported)", key.Curve.Params().Name)
+	default:
+		return fmt.Errorf("unsupported signing key type: %T (only RSA and ECDSA keys are supported)", pub)
+	}
+}
+
+// certificateForKey returns the certificate with the given public key.
+func certificateForKey(certs []*x509.Certificate, pub crypto.PublicKey) (*x509.Certificate, error) {
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode signer public key: %w", err)
+	}
+
+	for _, cert := range certs {
+		certPubBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(pubBytes, certPubBytes) {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no certificate found for the signer's public key")
+}
+
+// completeChain verifies the certificate chain for code signing, adding any missing certificates for the given leaf
+// from the Apple certificates embedded within quill when needed.
+func completeChain(leaf *x509.Certificate, certs []*x509.Certificate, failWithoutFullChain bool) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return certs, nil
+	}
+
+	if err := certchain.VerifyForCodeSigning(certs, failWithoutFullChain); err != nil {
+		store := certchain.NewCollection().WithStores(apple.GetEmbeddedCertStore())
+
+		// verification failed, try again but attempt to find more certs from the embedded certs in quill
+		remainingCerts, err := certchain.Find(store, leaf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find remaining chain certificates: %w", err)
+		}
+		certs = append(certs, remainingCerts...)
+		if err := certchain.VerifyForCodeSigning(certs, failWithoutFullChain); err != nil {
+			return nil, err
+		}
+	}
+	return certs, nil
+}
+
 func (sm *SigningMaterial) HasCertWithOrg(org string) bool {
 	for _, cert := range sm.Certs {
 		if len(cert.Subject.Organization) == 0 {
@@ -1,15 +1,25 @@
 package quill
 
 import (
+	"context"
+	"crypto"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	blacktopMacho "github.com/blacktop/go-macho"
+	"golang.org/x/sync/errgroup"
 
-	macholibre "github.com/anchore/go-macholibre"
 	"github.com/anchore/quill/internal/bus"
 	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/archive"
+	"github.com/anchore/quill/quill/bundle"
 	"github.com/anchore/quill/quill/event"
 	"github.com/anchore/quill/quill/macho"
 	"github.com/anchore/quill/quill/pki"
@@ -17,11 +27,46 @@ import (
 	"github.com/anchore/quill/quill/sign"
 )
 
+// maxParallelSlices bounds the number of universal binary slices that are signed concurrently.
+var maxParallelSlices = runtime.NumCPU()
+
 type SigningConfig struct {
 	SigningMaterial pki.SigningMaterial
 	Identity        string
 	Path            string
-	Entitlements    string
+	// OutputPath is where the signed binary (or bundle) is written, leaving Path untouched (default is to replace Path)
+	OutputPath string
+	// DetachedPath is where a detached signature is written, leaving the binary untouched (optional)
+	DetachedPath     string
+	Entitlements     string
+	Requirements     string
+	DigestAlgorithms []macho.HashType
+	Flags            macho.CdFlag
+	// PreserveMetadata is the metadata carried over from an existing signature when re-signing, replacing the
+	// corresponding values from this config (optional)
+	PreserveMetadata PreservedMetadata
+	// SigningTime pins the CMS signing-time attribute (default is the current time)
+	SigningTime time.Time
+	// Reproducible makes signing the same input produce identical output: the signing time must be pinned and no
+	// RFC3161 timestamp is requested (since the timestamp token differs each time)
+	Reproducible bool
+	// TimestampOptions controls how timestamps are requested from the timestamp servers (default retries each
+	// server with backoff)
+	TimestampOptions sign.TimestampOptions
+	// PathRules set the identity and entitlements by path when signing a directory or archive (see SignDirectory and
+	// SignArchive)
+	PathRules []PathRule
+	// Edits are made to the dylib and rpath load commands of the binary (each slice of a universal binary) before it
+	// is signed (optional)
+	Edits macho.LoadCommandEdits
+	// GrowHeaderSpace allows making room for the code signing (and edited) load commands when the linker left too
+	// little padding after the load commands, by shifting the contents of the binary (see macho.File.GrowHeaderSpace).
+	// Without this, such binaries are rejected and should be relinked with -headerpad instead.
+	GrowHeaderSpace bool
+
+	// updateOffsets finalizes the references to the superblob within the binary between the signing passes (default
+	// is sign.UpdateSuperBlobOffsetReferences, only set when testing failures mid-signing)
+	updateOffsets func(m *macho.File, numSbBytes uint64) error
 }
 
 func NewSigningConfigFromPEMs(binaryPath, certificate, privateKey, password string, failWithoutFullChain bool) (*SigningConfig, error) {
@@ -55,6 +100,22 @@ func NewSigningConfigFromP12(binaryPath string, p12Content load.P12Contents, fai
 	}, nil
 }
 
+// NewSigningConfigFromSigner creates a signing config for a signer whose private key is held elsewhere (e.g. an HSM via
+// signer.PKCS11 or an external signing command via signer.Command), with the certificate chain read from the given
+// PEM file.
+func NewSigningConfigFromSigner(binaryPath string, signer crypto.Signer, certificates string, failWithoutFullChain bool) (*SigningConfig, error) {
+	signingMaterial, err := pki.NewSigningMaterialFromSigner(signer, certificates, failWithoutFullChain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningConfig{
+		Path:            binaryPath,
+		Identity:        path.Base(binaryPath),
+		SigningMaterial: *signingMaterial,
+	}, nil
+}
+
 func (c *SigningConfig) WithIdentity(id string) *SigningConfig {
 	if id != "" {
 		c.Identity = id
@@ -62,8 +123,30 @@ func (c *SigningConfig) WithIdentity(id string) *SigningConfig {
 	return c
 }
 
-func (c *SigningConfig) WithTimestampServer(url string) *SigningConfig {
-	c.SigningMaterial.TimestampServer = url
+// WithTimestampServer sets the RFC3161 timestamp servers, which are tried in order until one returns a valid
+// timestamp. Empty URLs are ignored (no timestamp is requested when there are no servers).
+func (c *SigningConfig) WithTimestampServer(urls ...string) *SigningConfig {
+	var servers []string
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url != "" {
+			servers = append(servers, url)
+		}
+	}
+	c.SigningMaterial.TimestampServers = servers
+	return c
+}
+
+// WithPathRules sets the identity and entitlements by path when signing a directory or archive (the first matching
+// rule is used).
+func (c *SigningConfig) WithPathRules(rules ...PathRule) *SigningConfig {
+	c.PathRules = rules
+	return c
+}
+
+// WithTimestampOptions sets how timestamps are requested from the timestamp servers (attempts, backoff, and the
+// tolerance and trust used to validate the returned tokens).
+func (c *SigningConfig) WithTimestampOptions(opts sign.TimestampOptions) *SigningConfig {
+	c.TimestampOptions = opts
 	return c
 }
 
@@ -72,15 +155,174 @@ func (c *SigningConfig) WithEntitlements(path string) *SigningConfig {
 	return c
 }
 
+// WithRequirements sets the requirements (in Apple's requirement language) to embed, replacing the designated
+// requirement that is otherwise derived from the identity and signing material.
+func (c *SigningConfig) WithRequirements(text string) *SigningConfig {
+	c.Requirements = text
+	return c
+}
+
+// WithDigestAlgorithms sets the code directory digest algorithms, where the first is the primary code directory
+// (e.g. sign.CodesignHashTypes for a SHA-1 primary and SHA-256 alternate code directory).
+func (c *SigningConfig) WithDigestAlgorithms(hashTypes ...macho.HashType) *SigningConfig {
+	if len(hashTypes) > 0 {
+		c.DigestAlgorithms = hashTypes
+	}
+	return c
+}
+
+// WithFlags sets additional code directory flags (e.g. macho.Kill | macho.Hard, see macho.ParseCdFlags), which must
+// be within macho.AllowedMacho. The runtime flag is always set for signed binaries.
+func (c *SigningConfig) WithFlags(flags macho.CdFlag) *SigningConfig {
+	c.Flags = flags
+	return c
+}
+
+// WithPreservedMetadata carries over the given metadata from an existing signature when re-signing. The preserved
+// values take precedence over the corresponding values within the config (e.g. the identity or entitlements).
+func (c *SigningConfig) WithPreservedMetadata(p PreservedMetadata) *SigningConfig {
+	c.PreserveMetadata = p
+	return c
+}
+
+// WithSigningTime pins the CMS signing-time attribute instead of using the current time (e.g. to SOURCE_DATE_EPOCH).
+func (c *SigningConfig) WithSigningTime(t time.Time) *SigningConfig {
+	c.SigningTime = t
+	return c
+}
+
+// WithReproducible makes signing deterministic, so that signing the same input with the same signing material and
+// signing time produces identical output. No RFC3161 timestamp is requested in this mode.
+func (c *SigningConfig) WithReproducible(reproducible bool) *SigningConfig {
+	c.Reproducible = reproducible
+	return c
+}
+
+// WithLoadCommandEdits sets edits to make to the dylib and rpath load commands before signing (as with Apple's
+// install_name_tool), so that editing and re-signing the binary is a single step.
+func (c *SigningConfig) WithLoadCommandEdits(edits macho.LoadCommandEdits) *SigningConfig {
+	c.Edits = edits
+	return c
+}
+
+// WithGrowHeaderSpace allows growing the header space of binaries without room for the code signing load command,
+// which is refused for binaries whose code refers to the Mach-O header (relinking with -headerpad is preferred).
+func (c *SigningConfig) WithGrowHeaderSpace(grow bool) *SigningConfig {
+	c.GrowHeaderSpace = grow
+	return c
+}
+
+// WithOutputPath sets where the signed binary (or bundle) is written to instead of replacing the input.
+func (c *SigningConfig) WithOutputPath(p string) *SigningConfig {
+	c.OutputPath = p
+	return c
+}
+
+// WithDetachedPath sets where a detached signature for the binary is written to, instead of embedding the signature
+// within the binary (which is left untouched).
+func (c *SigningConfig) WithDetachedPath(p string) *SigningConfig {
+	c.DetachedPath = p
+	return c
+}
+
+// Sign signs the binary, bundle (e.g. .app), directory or archive (tar, tar.gz or zip) found at the configured path,
+// writing the result to the output path (or replacing the input when there is no output path). Binaries are signed as
+// a copy that replaces the destination only once signing succeeds, so a failed sign never leaves a partially signed
+// binary behind.
 func Sign(cfg SigningConfig) error {
-	f, err := os.Open(cfg.Path)
+	if !cfg.Edits.IsEmpty() && (IsPlainDirectory(cfg.Path) || archive.IsArchive(cfg.Path) || bundle.IsBundle(cfg.Path)) {
+		return fmt.Errorf("load commands can only be edited when signing a single binary")
+	}
+
+	if IsPlainDirectory(cfg.Path) {
+		_, err := SignDirectory(cfg)
+		return err
+	}
+
+	if archive.IsArchive(cfg.Path) {
+		_, err := SignArchive(cfg)
+		return err
+	}
+
+	if cfg.DetachedPath != "" {
+		return signDetached(cfg)
+	}
+
+	if bundle.IsBundle(cfg.Path) {
+		return signBundle(cfg)
+	}
+
+	return signBinary(cfg, sealedResources{})
+}
+
+func signBinary(cfg SigningConfig, sealed sealedResources) error {
+	output := cfg.Path
+	if cfg.OutputPath != "" {
+		output = cfg.OutputPath
+	}
+
+	// sign a copy next to the destination, which atomically replaces the destination only once signing succeeds
+	tmpPath, err := copyToSiblingTempFile(cfg.Path, output)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(tmpPath)
+
+	if err := signBinaryFile(cfg, tmpPath, sealed); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, output); err != nil {
+		return fmt.Errorf("unable to write signed binary to %q: %w", output, err)
+	}
+	return nil
+}
+
+// copyToSiblingTempFile copies the given file to a new temp file within the same directory as the destination path
+// (so that it can be renamed over the destination), preserving the file mode of the source.
+func copyToSiblingTempFile(src, dest string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".quill-*")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temp file for signing: %w", err)
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("unable to copy binary for signing: %w", err)
+	}
+
+	return out.Name(), nil
+}
+
+// signBinaryFile signs the given file in place, where cfg.Path is the binary being signed (as reported to the user).
+func signBinaryFile(cfg SigningConfig, file string, sealed sealedResources) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	universal := macho.IsUniversalBinary(f)
+	f.Close()
 
-	if macholibre.IsUniversalMachoBinary(f) {
-		return signMultiarchBinary(cfg)
+	if universal {
+		return signMultiarchBinary(cfg, file, sealed)
 	}
 
 	mon := bus.PublishTask(
@@ -93,7 +335,7 @@ func Sign(cfg SigningConfig) error {
 		-1,
 	)
 
-	err = signSingleBinary(cfg)
+	err = signSingleBinary(context.Background(), cfg, file, sealed)
 	if err != nil {
 		mon.SetError(err)
 	} else {
@@ -102,50 +344,21 @@ func Sign(cfg SigningConfig) error {
 	return err
 }
 
-//nolint:funlen
-func signMultiarchBinary(cfg SigningConfig) error {
+// signMultiarchBinary signs each slice of the given universal binary file in place (the universal binary is only laid
+// out again when a signed slice no longer fits where it was), where cfg.Path is the binary being signed (as reported to
+// the user).
+func signMultiarchBinary(cfg SigningConfig, file string, sealed sealedResources) error {
 	log.WithFields("binary", cfg.Path).Info("signing multi-arch binary")
 
-	f, err := os.Open(cfg.Path)
+	fatSlices, err := macho.OpenFatFileSlices(file)
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to read multi-arch binary: %w", err)
 	}
-	defer f.Close()
+	defer fatSlices.Close()
 
-	dir, err := os.MkdirTemp("", "quill-extract-"+path.Base(cfg.Path))
-	if err != nil {
-		return fmt.Errorf("unable to create temp directory to extract multi-arch binary: %w", err)
-	}
-	defer os.RemoveAll(dir)
+	slices := fatSlices.Slices
 
-	extractMon := bus.PublishTask(
-		event.Title{
-			Default:      "Extract universal binary",
-			WhileRunning: "Extracting universal binary",
-			OnSuccess:    "Extracted universal binary",
-		},
-		cfg.Path,
-		-1,
-	)
-
-	extractedFiles, err := macholibre.Extract(f, dir)
-	if err != nil {
-		extractMon.SetError(err)
-		return fmt.Errorf("unable to extract multi-arch binary: %w", err)
-	}
-
-	extractMon.Stage.Current = fmt.Sprintf("%d nested binaries", len(extractedFiles))
-
-	extractMon.SetCompleted()
-
-	log.WithFields("binary", cfg.Path, "arches", len(extractedFiles)).Trace("discovered nested binaries within multi-arch binary")
-
-	var cfgs []SigningConfig
-	for _, ef := range extractedFiles {
-		c := cfg
-		c.Path = ef.Path
-		cfgs = append(cfgs, c)
-	}
+	log.WithFields("binary", cfg.Path, "arches", len(slices)).Trace("discovered nested binaries within multi-arch binary")
 
 	signMon := bus.PublishTask(
 		event.Title{
@@ -154,57 +367,97 @@ func signMultiarchBinary(cfg SigningConfig) error {
 			OnSuccess:    "Signed binaries",
 		},
 		cfg.Path,
-		len(cfgs),
+		len(slices),
 	)
 
 	defer signMon.SetCompleted()
 
-	for _, c := range cfgs {
-		signMon.Stage.Current = path.Base(c.Path)
-		if err := signSingleBinary(c); err != nil {
-			signMon.SetError(err)
-			return err
-		}
-		signMon.Increment()
+	if err := signSlices(cfg, slices, sealed, signMon); err != nil {
+		signMon.SetError(err)
+		return err
 	}
 
-	signMon.Stage.Current = ""
+	log.WithFields("binary", cfg.Path, "arches", len(slices)).Info("updating multi-arch binary with the signed binaries")
 
-	var paths []string
-	for _, c := range cfgs {
-		paths = append(paths, c.Path)
+	if err := fatSlices.Commit(); err != nil {
+		signMon.SetError(err)
+		return fmt.Errorf("unable to write multi-arch binary: %w", err)
 	}
 
-	log.WithFields("binary", cfg.Path, "arches", len(cfgs)).Info("packaging signed binaries into single multi-arch binary")
-
-	packMon := bus.PublishTask(
-		event.Title{
-			Default:      "Repack universal binary",
-			WhileRunning: "Repacking universal binary",
-			OnSuccess:    "Repacked universal binary",
-		},
-		cfg.Path,
-		-1,
-	)
+	return nil
+}
 
-	defer packMon.SetCompleted()
+// signSlices signs the slices of a universal binary concurrently, bounded by maxParallelSlices. The first
+// failure cancels the remaining slices.
+func signSlices(cfg SigningConfig, slices []macho.FatFileSlice, sealed sealedResources, mon *event.ManualStagedProgress) error {
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(maxParallelSlices)
+
+	for _, s := range slices {
+		g.Go(func() error {
+			// don't start signing once another slice has failed
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
-	if err := macholibre.Package(cfg.Path, paths...); err != nil {
-		packMon.SetError(err)
-		return err
+			log.WithFields("binary", cfg.Path, "arch", s.Arch.Name()).Info("signing binary")
+
+			// each slice reports its own progress (the stage of the shared monitor is not safe to set concurrently)
+			sliceMon := bus.PublishTask(
+				event.Title{
+					Default:      "Sign slice",
+					WhileRunning: "Signing slice",
+					OnSuccess:    "Signed slice",
+				},
+				fmt.Sprintf("%s (%s)", cfg.Path, s.Arch.Name()),
+				-1,
+			)
+
+			if err := signMachoFile(ctx, cfg, s.File, sealed); err != nil {
+				err = fmt.Errorf("unable to sign %s slice: %w", s.Arch.Name(), err)
+				sliceMon.SetError(err)
+				return err
+			}
+			sliceMon.SetCompleted()
+			mon.Increment()
+			return nil
+		})
 	}
 
-	return nil
+	return g.Wait()
 }
 
-func signSingleBinary(cfg SigningConfig) error {
+// signSingleBinary signs the single-architecture binary file in place, where cfg.Path is the binary being signed (as
+// reported to the user).
+func signSingleBinary(ctx context.Context, cfg SigningConfig, file string, sealed sealedResources) error {
 	log.WithFields("binary", cfg.Path).Info("signing binary")
 
-	m, err := macho.NewFile(cfg.Path)
+	m, err := macho.NewFile(file)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return signMachoFile(ctx, cfg, m, sealed)
+}
+
+// signMachoFile signs a single-architecture binary (either a file or held in memory). The context is checked between
+// the signing passes (which include the timestamp request) so that signing can be abandoned early.
+func signMachoFile(ctx context.Context, cfg SigningConfig, m *macho.File, sealed sealedResources) error {
+	opts, err := signingOptions(cfg, sealed)
 	if err != nil {
 		return err
 	}
 
+	if err := lintBeforeSigning(m); err != nil {
+		return err
+	}
+
+	// carry over metadata from the existing signature before it is removed
+	if err := applyPreservedMetadata(&opts, m, cfg.PreserveMetadata); err != nil {
+		return err
+	}
+
 	// check there already isn't a LcCodeSignature loader already (if there is, bail)
 	if m.HasCodeSigningCmd() {
 		log.Debug("binary already signed, removing signature...")
@@ -213,45 +466,54 @@ func signSingleBinary(cfg SigningConfig) error {
 		}
 	}
 
-	if cfg.SigningMaterial.Signer == nil {
-		bus.Notify("Warning: performed ad-hoc sign, which means that anyone can alter the binary contents without you knowing (there is no cryptographic signature)")
-		log.Warnf("only ad-hoc signing, which means that anyone can alter the binary contents without you knowing (there is no cryptographic signature)")
+	if err := withHeaderSpace(m, cfg.GrowHeaderSpace, func() error { return m.EditLoadCommands(cfg.Edits) }); err != nil {
+		return fmt.Errorf("unable to edit load commands: %w", err)
 	}
 
-	entitlementsXML := ""
-	if cfg.Entitlements != "" {
-		log.Infof("Loading entitlements from %s", cfg.Entitlements)
-		data, err := os.ReadFile(cfg.Entitlements)
-		if err != nil {
-			return err
-		}
-		entitlementsXML = string(data)
+	// (patch) add empty LcCodeSignature loader (offset and size references are not set)
+	if err = withHeaderSpace(m, cfg.GrowHeaderSpace, m.AddEmptyCodeSigningCmd); err != nil {
+		return err
 	}
 
-	// (patch) add empty LcCodeSignature loader (offset and size references are not set)
-	if err = m.AddEmptyCodeSigningCmd(); err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
 	// first pass: add the signed data with the dummy loader
 	log.Debugf("estimating signing material size")
-	superBlobSize, sbBytes, err := sign.GenerateSigningSuperBlob(cfg.Identity, m, cfg.SigningMaterial, entitlementsXML, 0)
+	estimate, err := sign.GenerateSigningSuperBlob(ctx, m, opts, 0)
 	if err != nil {
 		return fmt.Errorf("failed to add signing data on pass=1: %w", err)
 	}
 
 	// (patch) make certain offset and size references to the superblob are finalized in the binary
 	log.Debugf("patching binary with updated superblob offsets")
-	if err = sign.UpdateSuperBlobOffsetReferences(m, uint64(len(sbBytes))); err != nil {
-		return nil
+	updateOffsets := sign.UpdateSuperBlobOffsetReferences
+	if cfg.updateOffsets != nil {
+		updateOffsets = cfg.updateOffsets
+	}
+	if err = updateOffsets(m, uint64(len(estimate.Bytes))); err != nil {
+		return fmt.Errorf("unable to update superblob offset references: %w", err)
+	}
+
+	// prefer the timestamp server that was used for the estimate, so that the final timestamp token is of a similar size
+	if estimate.Timestamp != nil {
+		opts.SigningMaterial.TimestampServers = preferTimestampServer(opts.SigningMaterial.TimestampServers, estimate.Timestamp.Server)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	// second pass: now that all of the sizing is right, let's do it again with the final contents (replacing the hashes and signature)
 	log.Debug("creating signature for binary")
-	_, sbBytes, err = sign.GenerateSigningSuperBlob(cfg.Identity, m, cfg.SigningMaterial, entitlementsXML, superBlobSize)
+	sb, err := sign.GenerateSigningSuperBlob(ctx, m, opts, estimate.Size)
 	if err != nil {
 		return fmt.Errorf("failed to add signing data on pass=2: %w", err)
 	}
+	sbBytes := sb.Bytes
+
+	reportTimestamp(sb.Timestamp)
 
 	// (patch) append the superblob to the __LINKEDIT section
 	log.Debugf("patching binary with signature")
@@ -268,30 +530,141 @@ func signSingleBinary(cfg SigningConfig) error {
 	return nil
 }
 
+// reportTimestamp records which timestamp server timestamped the signature (if any).
+func reportTimestamp(ts *sign.Timestamp) {
+	if ts == nil {
+		return
+	}
+	genTime := ts.Time.UTC().Format(time.RFC3339)
+	log.WithFields("server", ts.Server, "time", genTime).Info("timestamped signature")
+	bus.Notify(fmt.Sprintf("Timestamped signature with %s (%s)", ts.Server, genTime))
+}
+
+// preferTimestampServer returns the timestamp servers with the given server moved to the front.
+func preferTimestampServer(servers []string, preferred string) []string {
+	ordered := []string{preferred}
+	for _, s := range servers {
+		if s != preferred {
+			ordered = append(ordered, s)
+		}
+	}
+	return ordered
+}
+
+// withHeaderSpace makes a change to the load commands, growing the header space and trying again when there is not
+// enough room for the change (only when allowed to grow).
+func withHeaderSpace(m *macho.File, allowGrowth bool, change func() error) error {
+	err := change()
+
+	var spaceErr *macho.HeaderSpaceError
+	if !allowGrowth || !errors.As(err, &spaceErr) {
+		return err
+	}
+
+	if err := m.GrowHeaderSpace(spaceErr.Needed); err != nil {
+		return err
+	}
+	// growing the header space moves the content referred to by the load commands, so the change is made again
+	return change()
+}
+
+// signingOptions prepares the superblob content for the given config: the compiled requirements, the entitlements,
+// and any content sealed by the enclosing bundle.
+func signingOptions(cfg SigningConfig, sealed sealedResources) (sign.Options, error) {
+	if err := cfg.Flags.ValidateMacho(); err != nil {
+		return sign.Options{}, err
+	}
+
+	var requirements sign.RequirementSet
+	if cfg.Requirements != "" {
+		var err error
+		requirements, err = sign.CompileRequirements(cfg.Requirements)
+		if err != nil {
+			return sign.Options{}, err
+		}
+	}
+
+	signingMaterial := cfg.SigningMaterial
+	if cfg.Reproducible {
+		if cfg.SigningTime.IsZero() {
+			return sign.Options{}, fmt.Errorf("reproducible signing requires a pinned signing time")
+		}
+		if len(signingMaterial.TimestampServers) > 0 {
+			log.WithFields("servers", strings.Join(signingMaterial.TimestampServers, ",")).Info("not requesting an RFC3161 timestamp since signing is reproducible")
+			signingMaterial.TimestampServers = nil
+		}
+	}
+
+	if cfg.SigningMaterial.Signer == nil {
+		bus.Notify("Warning: performed ad-hoc sign, which means that anyone can alter the binary contents without you knowing (there is no cryptographic signature)")
+		log.Warnf("only ad-hoc signing, which means that anyone can alter the binary contents without you knowing (there is no cryptographic signature)")
+	}
+
+	entitlementsXML := ""
+	if cfg.Entitlements != "" {
+		log.Infof("Loading entitlements from %s", cfg.Entitlements)
+		data, err := os.ReadFile(cfg.Entitlements)
+		if err != nil {
+			return sign.Options{}, err
+		}
+		entitlementsXML = string(data)
+	}
+
+	return sign.Options{
+		Identity:        cfg.Identity,
+		SigningMaterial: signingMaterial,
+		Entitlements:    entitlementsXML,
+		InfoPlist:       sealed.InfoPlist,
+		CodeResources:   sealed.CodeResources,
+		Requirements:    requirements,
+		HashTypes:       cfg.DigestAlgorithms,
+		Flags:           cfg.Flags,
+		SigningTime:     cfg.SigningTime,
+		Deterministic:   cfg.Reproducible,
+		Timestamp:       cfg.TimestampOptions,
+	}, nil
+}
+
+// IsSigned indicates if the binary at the given path is signed (for a universal binary, every slice), or for an
+// archive, if every binary within the archive is signed.
 func IsSigned(path string) (bool, error) {
+	if archive.IsArchive(path) {
+		return isArchiveSigned(path)
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return false, err
 	}
 	defer f.Close()
 
-	if macholibre.IsUniversalMachoBinary(f) {
+	if macho.IsUniversalBinary(f) {
 		log.WithFields("binary", path).Trace("binary is a universal binary")
-		mf, err := blacktopMacho.NewFatFile(f)
-		if mf == nil || err != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+
+		ff, err := macho.NewFatFile(f, info.Size())
+		if err != nil {
 			return false, fmt.Errorf("failed to parse universal macho binary: %w", err)
 		}
-		defer mf.Close()
 
 		success := true
-		for _, arch := range mf.Arches {
-			sig := arch.CodeSignature()
+		for _, arch := range ff.Arches {
+			mf, err := blacktopMacho.NewFile(ff.SliceReader(arch))
+			if mf == nil || err != nil {
+				return false, fmt.Errorf("failed to parse %s slice: %w", arch.Name(), err)
+			}
+
+			sig := mf.CodeSignature()
+			mf.Close()
 			if sig == nil {
-				log.WithFields("binary", path, "arch", arch.String()).Trace("no code signature block found")
+				log.WithFields("binary", path, "arch", arch.Name()).Trace("no code signature block found")
 
 				return false, nil
 			}
-			log.WithFields("length", len(sig.CMSSignature), "arch", arch.String()).Trace("CMS signature found")
+			log.WithFields("length", len(sig.CMSSignature), "arch", arch.Name()).Trace("CMS signature found")
 
 			success = success && len(sig.CMSSignature) > 0
 		}
@@ -1,50 +1,337 @@
 package sign
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"fmt"
+	"sort"
+	"time"
 
-	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"howett.net/plist"
 
 	"github.com/anchore/quill/quill/macho"
 	"github.com/anchore/quill/quill/pki"
 )
 
-func generateCMS(signingMaterial pki.SigningMaterial, cdBlob *macho.Blob) (*macho.Blob, error) {
-	cdBlobBytes, err := cdBlob.Pack()
+var (
+	// oidAppleCDHashes is the signed attribute holding a plist of the (truncated) hashes of all code directories
+	oidAppleCDHashes = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 1}
+	// oidAppleCDHashes2 is the "hash agility v2" signed attribute holding the full digest of all code directories
+	oidAppleCDHashes2 = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 9, 2}
+)
+
+// cdHashSize is the length of the (truncated) code directory hashes within the CDHashes plist
+const cdHashSize = 20
+
+// codeDirectory is a generated code directory blob along with the digest algorithm used to create it.
+type codeDirectory struct {
+	HashType macho.HashType
+	Blob     *macho.Blob
+}
+
+func (cd codeDirectory) digest() ([]byte, error) {
+	by, err := cd.Blob.Pack()
+	if err != nil {
+		return nil, err
+	}
+	h, err := cd.HashType.Hasher()
 	if err != nil {
 		return nil, err
 	}
+	h.Write(by)
+	return h.Sum(nil), nil
+}
+
+// cmsOptions controls how the CMS signature is created.
+type cmsOptions struct {
+	// signingTime is the value of the signing-time attribute (default is the current time)
+	signingTime time.Time
+	// deterministic produces the same signature for the same input (e.g. ECDSA uses an RFC 6979 nonce instead of a
+	// random one)
+	deterministic bool
+	// timestamp controls how RFC3161 timestamps are requested (when there are timestamp servers)
+	timestamp TimestampOptions
+}
+
+// generateCMS signs the primary (first) code directory, attesting to all other code directories via Apple's CDHashes
+// signed attributes. The returned timestamp is nil when the signature is not timestamped.
+func generateCMS(ctx context.Context, signingMaterial pki.SigningMaterial, cds []codeDirectory, opts cmsOptions) (*macho.Blob, *Timestamp, error) {
+	cdBlobBytes, err := cds[0].Blob.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var cmsBytes []byte
+	var ts *Timestamp
 	if signingMaterial.Signer != nil {
-		cmsBytes, err = signDetached(cdBlobBytes, signingMaterial)
+		attrs, err := cdHashesAttributes(cds)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to create code directory hash attributes: %w", err)
+		}
+
+		cmsBytes, ts, err = signDetached(ctx, cdBlobBytes, signingMaterial, opts, attrs...)
 		if err != nil {
-			return nil, fmt.Errorf("unable to sign code directory: %w", err)
+			return nil, nil, fmt.Errorf("unable to sign code directory: %w", err)
 		}
 	}
 
 	blob := macho.NewBlob(macho.MagicBlobwrapper, cmsBytes)
 
-	return &blob, nil
+	return &blob, ts, nil
 }
 
-func signDetached(data []byte, signingMaterial pki.SigningMaterial) ([]byte, error) {
-	sd, err := cms.NewSignedData(data)
+// cdHashesAttributes creates the CDHashes (plist) and CDHashes2 (hash agility v2) signed attributes that bind all
+// code directories to the signature.
+func cdHashesAttributes(cds []codeDirectory) ([]protocol.Attribute, error) {
+	var truncated [][]byte
+	var digests []asn1.RawValue
+	for _, cd := range cds {
+		digest, err := cd.digest()
+		if err != nil {
+			return nil, err
+		}
+		truncated = append(truncated, digest[:cdHashSize])
+
+		algorithm, err := digestAlgorithm(cd.HashType)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := asn1.Marshal(struct {
+			Algorithm asn1.ObjectIdentifier
+			Digest    []byte
+		}{algorithm, digest})
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, asn1.RawValue{FullBytes: der})
+	}
+
+	cdHashesPlist, err := plist.MarshalIndent(map[string]any{"cdhashes": truncated}, plist.XMLFormat, "\t")
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode cdhashes plist: %w", err)
+	}
+
+	cdHashesAttr, err := protocol.NewAttribute(oidAppleCDHashes, cdHashesPlist)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = sd.Sign(signingMaterial.Certs, signingMaterial.Signer); err != nil {
+	// note: this attribute has one value per code directory (not a single value with all digests)
+	cdHashes2Attr := protocol.Attribute{Type: oidAppleCDHashes2}
+	if err := protocol.NewAnySet(digests...).Encode(&cdHashes2Attr.RawValue); err != nil {
+		return nil, err
+	}
+
+	return []protocol.Attribute{cdHashesAttr, cdHashes2Attr}, nil
+}
+
+func digestAlgorithm(ht macho.HashType) (asn1.ObjectIdentifier, error) {
+	switch ht {
+	case macho.HashTypeSha1:
+		return oid.DigestAlgorithmSHA1, nil
+	case macho.HashTypeSha256, macho.HashTypeSha256Truncated:
+		return oid.DigestAlgorithmSHA256, nil
+	case macho.HashTypeSha384:
+		return oid.DigestAlgorithmSHA384, nil
+	case macho.HashTypeSha512:
+		return oid.DigestAlgorithmSHA512, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash type: %s", ht)
+	}
+}
+
+// signDetached creates a detached CMS signature over the given data. This mirrors the smimesign implementation,
+// but allows for adding additional signed attributes, pinning the signing time, and falling back between timestamp
+// servers. The returned timestamp is nil when there are no timestamp servers.
+func signDetached(ctx context.Context, data []byte, signingMaterial pki.SigningMaterial, opts cmsOptions, extraAttrs ...protocol.Attribute) ([]byte, *Timestamp, error) {
+	eci, err := protocol.NewDataEncapsulatedContentInfo(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sd, err := protocol.NewSignedData(eci)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	si, err := newSignerInfo(sd, data, signingMaterial, opts, extraAttrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the timestamp is over the signature value, so is added as an unsigned attribute once the signature exists
+	var ts *Timestamp
+	if len(signingMaterial.TimestampServers) > 0 {
+		var attr protocol.Attribute
+		attr, ts, err = requestTimestamp(ctx, *si, signingMaterial.TimestampServers, opts.timestamp)
+		if err != nil {
+			return nil, nil, err
+		}
+		si.UnsignedAttrs = append(si.UnsignedAttrs, attr)
+	}
+
+	sd.DigestAlgorithms = append(sd.DigestAlgorithms, si.DigestAlgorithm)
+	sd.SignerInfos = append(sd.SignerInfos, *si)
+
+	// detach the content (the code directory is already within the superblob)
+	sd.EncapContentInfo.EContent = asn1.RawValue{}
+
+	der, err := sd.ContentInfoDER()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return der, ts, nil
+}
+
+func newSignerInfo(sd *protocol.SignedData, data []byte, signingMaterial pki.SigningMaterial, opts cmsOptions, extraAttrs []protocol.Attribute) (*protocol.SignerInfo, error) {
+	signer := signingMaterial.Signer
+
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
 		return nil, err
 	}
 
-	sd.Detached()
+	// figure out which certificate is associated with signer
+	var cert *x509.Certificate
+	for _, c := range signingMaterial.Certs {
+		if err = sd.AddCertificate(c); err != nil {
+			return nil, err
+		}
+
+		certPub, err := x509.MarshalPKIXPublicKey(c.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(pub, certPub) {
+			cert = c
+		}
+	}
+	if cert == nil {
+		return nil, protocol.ErrNoCertificate
+	}
+
+	sid, err := protocol.NewIssuerAndSerialNumber(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlgorithmID := digestAlgorithmForPublicKey(signer.Public())
+
+	signatureAlgorithmOID, ok := oid.X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm[cert.PublicKeyAlgorithm][digestAlgorithmID.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported certificate public key algorithm: %s", cert.PublicKeyAlgorithm)
+	}
+
+	si := protocol.SignerInfo{
+		Version:            1,
+		SID:                sid,
+		DigestAlgorithm:    digestAlgorithmID,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithmOID},
+	}
+
+	h, err := si.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	md := h.New()
+	md.Write(data)
+
+	signingTime := opts.signingTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	stAttr, err := protocol.NewAttribute(oid.AttributeSigningTime, signingTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	mdAttr, err := protocol.NewAttribute(oid.AttributeMessageDigest, md.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	ctAttr, err := protocol.NewAttribute(oid.AttributeContentType, sd.EncapContentInfo.EContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if si.SignedAttrs, err = sortAttributes(append([]protocol.Attribute{ctAttr, stAttr, mdAttr}, extraAttrs...)); err != nil {
+		return nil, err
+	}
+
+	// the signature is over the marshaled signed attributes
+	sm, err := si.SignedAttrs.MarshaledForSigning()
+	if err != nil {
+		return nil, err
+	}
+
+	smd := h.New()
+	smd.Write(sm)
+
+	// note: without a random source ECDSA signatures use a deterministic nonce (RFC 6979), while RSA PKCS #1 v1.5
+	// signatures are deterministic regardless
+	random := rand.Reader
+	if opts.deterministic {
+		random = nil
+	}
+
+	if si.Signature, err = signer.Sign(random, smd.Sum(nil), h); err != nil {
+		return nil, err
+	}
+
+	return &si, nil
+}
+
+// sortAttributes sorts attributes by their encoding, as required for the DER encoding of a SET OF (X690 11.6). This
+// keeps the attribute order deterministic regardless of the order the attributes were created in.
+func sortAttributes(attrs []protocol.Attribute) (protocol.Attributes, error) {
+	type encodedAttribute struct {
+		attr protocol.Attribute
+		der  []byte
+	}
+
+	encoded := make([]encodedAttribute, 0, len(attrs))
+	for _, attr := range attrs {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode signed attribute %s: %w", attr.Type, err)
+		}
+		encoded = append(encoded, encodedAttribute{attr: attr, der: der})
+	}
+
+	sort.SliceStable(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i].der, encoded[j].der) < 0
+	})
+
+	sorted := make(protocol.Attributes, 0, len(encoded))
+	for _, e := range encoded {
+		sorted = append(sorted, e.attr)
+	}
+	return sorted, nil
+}
 
-	if signingMaterial.TimestampServer != "" {
-		if err = sd.AddTimestamps(signingMaterial.TimestampServer); err != nil {
-			return nil, fmt.Errorf("unable to add timestamps (RFC3161): %w", err)
+// digestAlgorithmForPublicKey selects the CMS digest algorithm for the signing key (same selection as smimesign).
+func digestAlgorithmForPublicKey(pub crypto.PublicKey) pkix.AlgorithmIdentifier {
+	if ecPub, ok := pub.(*ecdsa.PublicKey); ok {
+		switch ecPub.Curve {
+		case elliptic.P384():
+			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA384}
+		case elliptic.P521():
+			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512}
 		}
 	}
 
-	return sd.ToDER()
+	return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256}
 }
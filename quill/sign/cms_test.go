@@ -0,0 +1,286 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint: gosec
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/pki"
+)
+
+func newTestSigningMaterial(t *testing.T) pki.SigningMaterial {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return newTestSigningMaterialWithKey(t, key)
+}
+
+func newTestSigningMaterialWithKey(t *testing.T, key crypto.Signer) pki.SigningMaterial {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signer", OrganizationalUnit: []string{"TEAMID"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return pki.SigningMaterial{
+		Signer: key,
+		Certs:  []*x509.Certificate{cert},
+	}
+}
+
+func Test_generateCMS_cdHashes(t *testing.T) {
+	sm := newTestSigningMaterial(t)
+
+	primary := macho.NewBlob(macho.MagicCodedirectory, []byte("primary code directory"))
+	alternate := macho.NewBlob(macho.MagicCodedirectory, []byte("alternate code directory"))
+	cds := []codeDirectory{
+		{HashType: macho.HashTypeSha1, Blob: &primary},
+		{HashType: macho.HashTypeSha256, Blob: &alternate},
+	}
+
+	blob, _, err := generateCMS(context.Background(), sm, cds, cmsOptions{})
+	require.NoError(t, err)
+
+	ci, err := protocol.ParseContentInfo(blob.Payload)
+	require.NoError(t, err)
+	sd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+	require.Len(t, sd.SignerInfos, 1)
+	si := sd.SignerInfos[0]
+
+	primaryBytes, err := primary.Pack()
+	require.NoError(t, err)
+	alternateBytes, err := alternate.Pack()
+	require.NoError(t, err)
+
+	sha1Digest := sha1.Sum(primaryBytes) //nolint: gosec
+	sha256Digest := sha256.Sum256(alternateBytes)
+
+	// the message digest covers the primary code directory
+	md, err := si.GetMessageDigestAttribute()
+	require.NoError(t, err)
+	primarySha256 := sha256.Sum256(primaryBytes)
+	assert.Equal(t, primarySha256[:], md)
+
+	// CDHashes: a plist with the truncated hash of each code directory (in order)
+	rv, err := si.SignedAttrs.GetOnlyAttributeValueBytes(oidAppleCDHashes)
+	require.NoError(t, err)
+	var plistBytes []byte
+	_, err = asn1.Unmarshal(rv.FullBytes, &plistBytes)
+	require.NoError(t, err)
+
+	var cdHashes struct {
+		CDHashes [][]byte `plist:"cdhashes"`
+	}
+	_, err = plist.Unmarshal(plistBytes, &cdHashes)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sha1Digest[:], sha256Digest[:20]}, cdHashes.CDHashes)
+
+	// CDHashes2: one value per code directory with the full digest
+	values, err := si.SignedAttrs.GetValues(oidAppleCDHashes2)
+	require.NoError(t, err)
+	require.Len(t, values, 1)
+	require.Len(t, values[0].Elements, 2)
+
+	type digest struct {
+		Algorithm asn1.ObjectIdentifier
+		Digest    []byte
+	}
+	var actual []digest
+	for _, e := range values[0].Elements {
+		var d digest
+		_, err := asn1.Unmarshal(e.FullBytes, &d)
+		require.NoError(t, err)
+		actual = append(actual, d)
+	}
+	assert.Equal(t, []digest{
+		{Algorithm: oid.DigestAlgorithmSHA1, Digest: sha1Digest[:]},
+		{Algorithm: oid.DigestAlgorithmSHA256, Digest: sha256Digest[:]},
+	}, actual)
+
+	// the signature must be valid over the signed attributes
+	signedBytes, err := si.SignedAttrs.MarshaledForVerification()
+	require.NoError(t, err)
+	require.NoError(t, sm.Certs[0].CheckSignature(si.X509SignatureAlgorithm(), signedBytes, si.Signature))
+
+	// the content is detached
+	assert.Nil(t, sd.EncapContentInfo.EContent.Bytes)
+}
+
+func Test_generateCMS_keyTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		newKey        func() (crypto.Signer, error)
+		wantDigest    asn1.ObjectIdentifier
+		wantSignature x509.SignatureAlgorithm
+	}{
+		{
+			name:          "RSA",
+			newKey:        func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+			wantDigest:    oid.DigestAlgorithmSHA256,
+			wantSignature: x509.SHA256WithRSA,
+		},
+		{
+			name:          "ECDSA P-256",
+			newKey:        func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+			wantDigest:    oid.DigestAlgorithmSHA256,
+			wantSignature: x509.ECDSAWithSHA256,
+		},
+		{
+			name:          "ECDSA P-384",
+			newKey:        func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+			wantDigest:    oid.DigestAlgorithmSHA384,
+			wantSignature: x509.ECDSAWithSHA384,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.newKey()
+			require.NoError(t, err)
+			sm := newTestSigningMaterialWithKey(t, key)
+
+			cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+			blob, _, err := generateCMS(context.Background(), sm, []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}, cmsOptions{})
+			require.NoError(t, err)
+
+			ci, err := protocol.ParseContentInfo(blob.Payload)
+			require.NoError(t, err)
+			sd, err := ci.SignedDataContent()
+			require.NoError(t, err)
+			require.Len(t, sd.SignerInfos, 1)
+			si := sd.SignerInfos[0]
+
+			assert.Equal(t, tt.wantDigest, si.DigestAlgorithm.Algorithm)
+			assert.Equal(t, tt.wantSignature, si.X509SignatureAlgorithm())
+
+			signedBytes, err := si.SignedAttrs.MarshaledForVerification()
+			require.NoError(t, err)
+			require.NoError(t, sm.Certs[0].CheckSignature(si.X509SignatureAlgorithm(), signedBytes, si.Signature))
+
+			// the designated requirement is derived from the certificate, regardless of the key type
+			slot, err := generateRequirements("com.acme.agent", sha256.New(), sm, nil)
+			require.NoError(t, err)
+			blobBytes, err := slot.Blob.Pack()
+			require.NoError(t, err)
+			reqs, err := DecompileRequirements(blobBytes)
+			require.NoError(t, err)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, `identifier "com.acme.agent" and certificate leaf[subject.OU] = TEAMID`, reqs[0].Text)
+		})
+	}
+}
+
+func Test_generateCMS_adhoc(t *testing.T) {
+	cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+
+	blob, _, err := generateCMS(context.Background(), pki.SigningMaterial{}, []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}, cmsOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, macho.MagicBlobwrapper, blob.Magic)
+	assert.Empty(t, blob.Payload)
+}
+
+func Test_generateCMS_deterministic(t *testing.T) {
+	signingTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		newKey func() (crypto.Signer, error)
+	}{
+		{
+			name:   "RSA",
+			newKey: func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+		},
+		{
+			name:   "ECDSA P-256",
+			newKey: func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.newKey()
+			require.NoError(t, err)
+			sm := newTestSigningMaterialWithKey(t, key)
+
+			cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+			cds := []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}
+			opts := cmsOptions{signingTime: signingTime, deterministic: true}
+
+			first, _, err := generateCMS(context.Background(), sm, cds, opts)
+			require.NoError(t, err)
+			second, _, err := generateCMS(context.Background(), sm, cds, opts)
+			require.NoError(t, err)
+
+			assert.Equal(t, first.Payload, second.Payload, "signing the same input should produce identical output")
+
+			ci, err := protocol.ParseContentInfo(first.Payload)
+			require.NoError(t, err)
+			sd, err := ci.SignedDataContent()
+			require.NoError(t, err)
+			require.Len(t, sd.SignerInfos, 1)
+			si := sd.SignerInfos[0]
+
+			actualTime, err := si.GetSigningTimeAttribute()
+			require.NoError(t, err)
+			assert.True(t, signingTime.Equal(actualTime), "signing time: %s", actualTime)
+
+			signedBytes, err := si.SignedAttrs.MarshaledForVerification()
+			require.NoError(t, err)
+			require.NoError(t, sm.Certs[0].CheckSignature(si.X509SignatureAlgorithm(), signedBytes, si.Signature))
+		})
+	}
+}
+
+func Test_sortAttributes(t *testing.T) {
+	ct, err := protocol.NewAttribute(oid.AttributeContentType, oid.ContentTypeData)
+	require.NoError(t, err)
+	st, err := protocol.NewAttribute(oid.AttributeSigningTime, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+	md, err := protocol.NewAttribute(oid.AttributeMessageDigest, []byte("digest"))
+	require.NoError(t, err)
+
+	first, err := sortAttributes([]protocol.Attribute{st, md, ct})
+	require.NoError(t, err)
+	second, err := sortAttributes([]protocol.Attribute{md, ct, st})
+	require.NoError(t, err)
+
+	// the order does not depend on the order the attributes were given in...
+	assert.Equal(t, first, second)
+
+	// ...and follows the DER encoding of each attribute (as required for a SET OF)
+	var previous []byte
+	for _, attr := range first {
+		der, err := asn1.Marshal(attr)
+		require.NoError(t, err)
+		assert.True(t, previous == nil || bytes.Compare(previous, der) < 0, "attributes are not sorted by encoding")
+		previous = der
+	}
+}
@@ -42,19 +42,13 @@ func packCodeDirectory(cd *macho.CodeDirectory, order binary.ByteOrder) (*macho.
 func newCodeDirectoryFromMacho(id, teamID string, hasher hash.Hash, m *macho.File, flags macho.CdFlag, specialSlots []SpecialSlot) (*macho.CodeDirectory, error) {
 	textSeg := m.Segment("__TEXT")
 
-	var codeSize uint32
-	if m.HasCodeSigningCmd() {
-		signCmd, _, err := m.CodeSigningCmd()
-		if err != nil {
-			return nil, fmt.Errorf("unable to locate existing signing loader command: %w", err)
-		}
-		codeSize = signCmd.DataOffset
-	} else {
-		linkEditSeg := m.Segment("__LINKEDIT")
-		codeSize = uint32(linkEditSeg.Offset + linkEditSeg.Filesz)
+	// the code limit is the start of the signature data (or the end of the file when creating a detached signature)
+	codeSize, err := m.CodeLimit()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine code limit: %w", err)
 	}
 
-	hashes, err := m.HashPages(hasher)
+	hashes, err := m.HashPagesUntil(hasher, codeSize)
 	if err != nil {
 		return nil, err
 	}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-restruct/restruct"
 
+	"github.com/anchore/quill/internal/log"
 	"github.com/anchore/quill/quill/macho"
 )
 
@@ -28,3 +29,27 @@ func generateEntitlements(h hash.Hash, entitlementsXML string) (*SpecialSlot, er
 
 	return &SpecialSlot{macho.CsSlotEntitlements, &blob, h.Sum(nil)}, nil
 }
+
+func generateEntitlementsDER(h hash.Hash, entitlementsXML string) (*SpecialSlot, error) {
+	if entitlementsXML == "" {
+		return nil, nil
+	}
+
+	der, err := EncodeEntitlementsDER(entitlementsXML)
+	if err != nil {
+		// the XML entitlements are still signed, so only leave out the DER form (as older signatures do)
+		log.WithFields("error", err).Warn("unable to encode the entitlements in DER form, leaving out the DER entitlements slot")
+		return nil, nil
+	}
+
+	blob := macho.NewBlob(macho.MagicEmbeddedEntitlementsDer, der)
+	blobBytes, err := restruct.Pack(macho.SigningOrder, &blob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode DER entitlements blob: %w", err)
+	}
+
+	// as with the XML entitlements, the hash is against the entire blob
+	h.Write(blobBytes)
+
+	return &SpecialSlot{macho.CsSlotEntitlementsDer, &blob, h.Sum(nil)}, nil
+}
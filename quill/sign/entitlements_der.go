@@ -0,0 +1,225 @@
+package sign
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"howett.net/plist"
+)
+
+// Apple's DER entitlements schema (as used for CsSlotEntitlementsDer):
+//
+//	Entitlements ::= [APPLICATION 16] SEQUENCE { version INTEGER (1), dict Dictionary }
+//	Dictionary   ::= [CONTEXT 16] SET OF SEQUENCE { key UTF8String, value Value }  -- sorted by key
+//	Value        ::= BOOLEAN | INTEGER | UTF8String | OCTET STRING | GeneralizedTime | SEQUENCE OF Value | Dictionary
+//
+// Data and date values are encoded as OCTET STRING and GeneralizedTime (as codesign does), while real values have no
+// DER form.
+const (
+	entitlementsDERTag     = 16
+	entitlementsDERVersion = 1
+)
+
+// EncodeEntitlementsDER converts an XML entitlements plist into Apple's DER entitlements form.
+func EncodeEntitlementsDER(entitlementsXML string) ([]byte, error) {
+	var entitlements map[string]any
+	if _, err := plist.Unmarshal([]byte(entitlementsXML), &entitlements); err != nil {
+		return nil, fmt.Errorf("unable to parse entitlements plist: %w", err)
+	}
+
+	dict, err := encodeEntitlementsDERDict(entitlements)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := asn1.Marshal(entitlementsDERVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        entitlementsDERTag,
+		IsCompound: true,
+		Bytes:      append(version, dict...),
+	})
+}
+
+func encodeEntitlementsDERDict(dict map[string]any) ([]byte, error) {
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var entries []byte
+	for _, k := range keys {
+		key, err := asn1.MarshalWithParams(k, "utf8")
+		if err != nil {
+			return nil, err
+		}
+		value, err := encodeEntitlementsDERValue(dict[k])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entitlement %q: %w", k, err)
+		}
+		entry, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(key, value...)})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry...)
+	}
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        entitlementsDERTag,
+		IsCompound: true,
+		Bytes:      entries,
+	})
+}
+
+func encodeEntitlementsDERValue(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case bool:
+		return asn1.Marshal(v)
+	case int64:
+		return asn1.Marshal(v)
+	case uint64:
+		return asn1.Marshal(new(big.Int).SetUint64(v))
+	case string:
+		return asn1.MarshalWithParams(v, "utf8")
+	case []byte:
+		return asn1.Marshal(v)
+	case time.Time:
+		return asn1.MarshalWithParams(v.UTC(), "generalized")
+	case []any:
+		var elements []byte
+		for i, e := range v {
+			b, err := encodeEntitlementsDERValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("array element %d: %w", i, err)
+			}
+			elements = append(elements, b...)
+		}
+		return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: elements})
+	case map[string]any:
+		return encodeEntitlementsDERDict(v)
+	default:
+		return nil, fmt.Errorf("unsupported value type %T (only booleans, integers, strings, data, dates, arrays and dictionaries are allowed)", value)
+	}
+}
+
+// DecodeEntitlementsDER converts Apple's DER entitlements form back into an entitlements dictionary.
+func DecodeEntitlementsDER(der []byte) (map[string]any, error) {
+	var root asn1.RawValue
+	if err := unmarshalEntitlementsDER(der, &root); err != nil {
+		return nil, err
+	}
+	if root.Class != asn1.ClassApplication || root.Tag != entitlementsDERTag {
+		return nil, fmt.Errorf("unexpected DER entitlements tag: class=%d tag=%d", root.Class, root.Tag)
+	}
+
+	var version int
+	rest, err := asn1.Unmarshal(root.Bytes, &version)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read DER entitlements version: %w", err)
+	}
+	if version != entitlementsDERVersion {
+		return nil, fmt.Errorf("unsupported DER entitlements version: %d", version)
+	}
+
+	var dict asn1.RawValue
+	if err := unmarshalEntitlementsDER(rest, &dict); err != nil {
+		return nil, err
+	}
+
+	value, err := decodeEntitlementsDERValue(dict)
+	if err != nil {
+		return nil, err
+	}
+
+	entitlements, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("DER entitlements are not a dictionary")
+	}
+	return entitlements, nil
+}
+
+// unmarshalEntitlementsDER reads exactly one value from the given bytes.
+func unmarshalEntitlementsDER(b []byte, v *asn1.RawValue) error {
+	rest, err := asn1.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("unable to decode DER entitlements: %w", err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected trailing data in DER entitlements (%d bytes)", len(rest))
+	}
+	return nil
+}
+
+//nolint:gocyclo
+func decodeEntitlementsDERValue(v asn1.RawValue) (any, error) {
+	switch {
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagBoolean:
+		var b bool
+		_, err := asn1.Unmarshal(v.FullBytes, &b)
+		return b, err
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagInteger:
+		var i *big.Int
+		if _, err := asn1.Unmarshal(v.FullBytes, &i); err != nil {
+			return nil, err
+		}
+		if i.IsInt64() {
+			return i.Int64(), nil
+		}
+		if i.IsUint64() {
+			return i.Uint64(), nil
+		}
+		return nil, fmt.Errorf("integer out of range: %s", i)
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagUTF8String:
+		return string(v.Bytes), nil
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOctetString:
+		return v.Bytes, nil
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagGeneralizedTime:
+		var t time.Time
+		_, err := asn1.Unmarshal(v.FullBytes, &t)
+		return t, err
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagSequence:
+		elements := []any{}
+		for rest := v.Bytes; len(rest) > 0; {
+			var e asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &e); err != nil {
+				return nil, err
+			}
+			value, err := decodeEntitlementsDERValue(e)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, value)
+		}
+		return elements, nil
+	case v.Class == asn1.ClassContextSpecific && v.Tag == entitlementsDERTag:
+		dict := map[string]any{}
+		for rest := v.Bytes; len(rest) > 0; {
+			var entry struct {
+				Key   string `asn1:"utf8"`
+				Value asn1.RawValue
+			}
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &entry); err != nil {
+				return nil, fmt.Errorf("invalid dictionary entry: %w", err)
+			}
+			value, err := decodeEntitlementsDERValue(entry.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entitlement %q: %w", entry.Key, err)
+			}
+			dict[entry.Key] = value
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported DER entitlements value: class=%d tag=%d", v.Class, v.Tag)
+	}
+}
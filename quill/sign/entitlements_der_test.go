@@ -0,0 +1,147 @@
+package sign
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entitlementsPlist(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>` + body + `</dict>
+</plist>`
+}
+
+func TestEncodeEntitlementsDER(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		wantHex string
+		want    map[string]any
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "single boolean",
+			xml:  entitlementsPlist(`<key>com.apple.security.get-task-allow</key><true/>`),
+			// [APPLICATION 16] { INTEGER 1, [CONTEXT 16] { SEQUENCE { UTF8String key, BOOLEAN true } } }
+			wantHex: "702d020101b0283026" + "0c21" + hex.EncodeToString([]byte("com.apple.security.get-task-allow")) + "0101ff",
+			want:    map[string]any{"com.apple.security.get-task-allow": true},
+		},
+		{
+			name: "empty",
+			xml:  entitlementsPlist(``),
+			// [APPLICATION 16] { INTEGER 1, [CONTEXT 16] {} }
+			wantHex: "7005020101b000",
+			want:    map[string]any{},
+		},
+		{
+			name: "nested values",
+			xml: entitlementsPlist(`
+				<key>com.apple.security.app-sandbox</key><true/>
+				<key>com.apple.developer.team-identifier</key><string>ABCDE12345</string>
+				<key>com.apple.security.application-groups</key><array><string>group.a</string><string>group.b</string></array>
+				<key>com.apple.developer.nested</key><dict><key>count</key><integer>-3</integer><key>big</key><integer>300</integer><key>off</key><false/></dict>
+			`),
+			want: map[string]any{
+				"com.apple.security.app-sandbox":        true,
+				"com.apple.developer.team-identifier":   "ABCDE12345",
+				"com.apple.security.application-groups": []any{"group.a", "group.b"},
+				"com.apple.developer.nested": map[string]any{
+					"count": int64(-3),
+					"big":   int64(300),
+					"off":   false,
+				},
+			},
+		},
+		{
+			name:    "real values are not supported",
+			xml:     entitlementsPlist(`<key>ratio</key><real>1.5</real>`),
+			wantErr: require.Error,
+		},
+		{
+			name: "data and date values",
+			xml:  entitlementsPlist(`<key>blob</key><data>AAEC</data><key>when</key><date>2024-01-02T03:04:05Z</date>`),
+			// [APPLICATION 16] { INTEGER 1, [CONTEXT 16] { SEQUENCE { "blob", OCTET STRING }, SEQUENCE { "when", GeneralizedTime } } }
+			wantHex: "702b020101b026" + "300b0c04" + hex.EncodeToString([]byte("blob")) + "0403000102" +
+				"30170c04" + hex.EncodeToString([]byte("when")) + "180f" + hex.EncodeToString([]byte("20240102030405Z")),
+			want: map[string]any{
+				"blob": []byte{0, 1, 2},
+				"when": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+		},
+		{
+			name:    "invalid plist",
+			xml:     `<plist><dict><key>unterminated`,
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			der, err := EncodeEntitlementsDER(tt.xml)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			if tt.wantHex != "" {
+				assert.Equal(t, tt.wantHex, hex.EncodeToString(der))
+			}
+
+			got, err := DecodeEntitlementsDER(der)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeEntitlementsDER_sortedKeys(t *testing.T) {
+	der, err := EncodeEntitlementsDER(entitlementsPlist(`<key>b</key><true/><key>a</key><true/><key>aa</key><true/>`))
+	require.NoError(t, err)
+
+	// entries are ordered by key: a, aa, b
+	assert.Equal(t, "701e020101b019"+"30060c01610101ff"+"30070c0261610101ff"+"30060c01620101ff", hex.EncodeToString(der))
+}
+
+func TestDecodeEntitlementsDER_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{name: "not DER", hex: "ffff"},
+		{name: "wrong root tag", hex: "3005020101b000"},
+		{name: "unsupported version", hex: "7005020102b000"},
+		{name: "trailing data", hex: "7005020101b00000"},
+		{name: "unsupported value", hex: "700c020101b00730050c01610500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := hex.DecodeString(tt.hex)
+			require.NoError(t, err)
+
+			_, err = DecodeEntitlementsDER(der)
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_generateEntitlementsDER_unsupported(t *testing.T) {
+	// real values have no DER form, so only the XML entitlements are signed (rather than failing to sign)
+	xml := entitlementsPlist(`<key>com.apple.security.app-sandbox</key><true/><key>ratio</key><real>1.5</real>`)
+
+	slot, err := generateEntitlementsDER(sha256.New(), xml)
+	require.NoError(t, err)
+	assert.Nil(t, slot)
+
+	slot, err = generateEntitlements(sha256.New(), xml)
+	require.NoError(t, err)
+	assert.NotNil(t, slot)
+}
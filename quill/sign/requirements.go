@@ -15,6 +15,9 @@ import (
 
 const (
 	sizeOfUint32 = 4
+
+	// requirementExprForm is the kind of requirement blob that holds an expression (the only kind in use)
+	requirementExprForm = 1
 )
 
 type reqStatement []uint32
@@ -40,6 +43,8 @@ const (
 	opCertPolicy                       // Certificate policy by OID [cert index; oid; match suffix]
 	opNamedAnchor                      // named anchor type
 	opNamedCode                        // named subroutine
+	opPlatform                         // platform constraint [integer]
+	opNotarized                        // has a developer id+ ticket
 	exprOpCount                        // (total opcode count in use)
 )
 
@@ -53,6 +58,12 @@ const (
 	matchGreaterThan                // greater than (string with numeric comparison)
 	matchLessEqual                  // less or equal (string with numeric comparison)
 	matchGreaterEqual               // greater or equal (string with numeric comparison)
+	matchOn                         // on (timestamp comparison)
+	matchBefore                     // before (timestamp comparison)
+	matchAfter                      // after (timestamp comparison)
+	matchOnOrBefore                 // on or before (timestamp comparison)
+	matchOnOrAfter                  // on or after (timestamp comparison)
+	matchAbsent                     // not present (kCFNull)
 )
 
 const (
@@ -61,21 +72,32 @@ const (
 	anchorCertIndex        = ^uint32(0) // index for anchor (last in chain), equiv to -1
 )
 
-func generateRequirements(id string, h hash.Hash, signingMaterial pki.SigningMaterial) (*SpecialSlot, error) {
+// generateRequirements creates the requirements slot from the given requirement set (compiled from requirement text).
+// When no designated requirement is given, one is derived from the identity and signing material.
+func generateRequirements(id string, h hash.Hash, signingMaterial pki.SigningMaterial, custom RequirementSet) (*SpecialSlot, error) {
+	set := make(RequirementSet)
+	for t, expr := range custom {
+		set[t] = expr
+	}
+
+	if _, ok := set[macho.DesignatedRequirementType]; !ok && signingMaterial.Signer != nil {
+		expr, err := buildRequirementStatements(id, signingMaterial)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build requirement statements from signing material: %w", err)
+		}
+		set[macho.DesignatedRequirementType] = expr
+	}
+
 	var reqBytes []byte
-	if signingMaterial.Signer == nil {
+	if len(set) == 0 {
 		log.Trace("skipping adding designated requirement because no signer was found")
 		reqBytes = []byte{0, 0, 0, 0}
 	} else {
-		req, err := newRequirements(id, signingMaterial)
+		var err error
+		reqBytes, err = encodeRequirementSet(set)
 		if err != nil {
 			return nil, err
 		}
-
-		reqBytes, err = restruct.Pack(macho.SigningOrder, req)
-		if err != nil {
-			return nil, fmt.Errorf("unable to encode requirement: %w", err)
-		}
 	}
 
 	blob := macho.NewBlob(macho.MagicRequirements, reqBytes)
@@ -94,33 +116,82 @@ func generateRequirements(id string, h hash.Hash, signingMaterial pki.SigningMat
 	return &SpecialSlot{macho.CsSlotRequirements, &blob, h.Sum(nil)}, nil
 }
 
-func newRequirements(id string, signingMaterial pki.SigningMaterial) (*macho.Requirements, error) {
-	requirementBytes, err := buildRequirementStatements(id, signingMaterial)
+// encodeRequirementSet encodes the payload of a requirements blob: the count, an index of (type, offset) pairs, and a
+// requirement blob for each type (offsets are relative to the start of the enclosing requirements blob).
+func encodeRequirementSet(set RequirementSet) ([]byte, error) {
+	types := set.Types()
+
+	headerSize := uint32(unsafe.Sizeof(macho.BlobHeader{})) + sizeOfUint32 + uint32(len(types))*2*sizeOfUint32
+
+	index := []uint32{uint32(len(types))}
+	var blobs []byte
+	for _, t := range types {
+		// each requirement starts with the kind of requirement, which is always an expression
+		reqBlob := macho.NewBlob(macho.MagicRequirement, append([]byte{0, 0, 0, requirementExprForm}, set[t]...))
+
+		reqBlobBytes, err := restruct.Pack(macho.SigningOrder, &reqBlob)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode requirement blob: %w", err)
+		}
+
+		index = append(index, uint32(t), headerSize+uint32(len(blobs)))
+		blobs = append(blobs, reqBlobBytes...)
+	}
+
+	indexBytes, err := restruct.Pack(macho.SigningOrder, index)
 	if err != nil {
-		return nil, fmt.Errorf("unable to build requirement statements from signing material: %w", err)
+		return nil, fmt.Errorf("unable to encode requirements index: %w", err)
 	}
 
-	// TODO: what is this field??
-	requirementBytes = append([]byte{0, 0, 0, 1}, requirementBytes...)
+	return append(indexBytes, blobs...), nil
+}
 
-	reqBlob := macho.NewBlob(macho.MagicRequirement, requirementBytes)
+// ParseRequirementSet decodes a requirements blob (including the blob header, as stored in the signing superblob) into
+// the compiled expression of each requirement, which is the inverse of how the requirements slot is generated.
+func ParseRequirementSet(blob []byte) (RequirementSet, error) {
+	order := macho.SigningOrder
+	if len(blob) < 12 {
+		return nil, fmt.Errorf("requirements blob too short: %d bytes", len(blob))
+	}
+	if magic := macho.Magic(order.Uint32(blob)); magic != macho.MagicRequirements {
+		return nil, fmt.Errorf("unexpected requirements blob magic: %#x", uint32(magic))
+	}
 
-	reqBlobBytes, err := restruct.Pack(macho.SigningOrder, &reqBlob)
-	if err != nil {
-		return nil, fmt.Errorf("unable to encode requirements blob: %w", err)
+	length := order.Uint32(blob[4:])
+	if int(length) > len(blob) {
+		return nil, fmt.Errorf("requirements blob length exceeds data (%d > %d)", length, len(blob))
 	}
+	blob = blob[:length]
 
-	offsetFromStartOfBlob := uint32(unsafe.Sizeof(macho.BlobHeader{}) + unsafe.Sizeof(macho.RequirementsHeader{}))
+	count := order.Uint32(blob[8:])
+	if uint64(12)+uint64(count)*8 > uint64(len(blob)) {
+		return nil, fmt.Errorf("requirements index exceeds blob (count=%d)", count)
+	}
 
-	return &macho.Requirements{
+	set := make(RequirementSet)
+	for i := uint32(0); i < count; i++ {
+		entry := blob[12+i*8:]
+		reqType := macho.RequirementType(order.Uint32(entry))
+		offset := order.Uint32(entry[4:])
 
-		RequirementsHeader: macho.RequirementsHeader{
-			Count:  1, // we only support a single requirement at this time
-			Type:   macho.DesignatedRequirementType,
-			Offset: offsetFromStartOfBlob,
-		},
-		Payload: reqBlobBytes,
-	}, nil
+		if uint64(offset)+12 > uint64(len(blob)) {
+			return nil, fmt.Errorf("%s requirement offset exceeds blob (%d)", reqType, offset)
+		}
+		r := blob[offset:]
+		if magic := macho.Magic(order.Uint32(r)); magic != macho.MagicRequirement {
+			return nil, fmt.Errorf("unexpected %s requirement blob magic: %#x", reqType, uint32(magic))
+		}
+		reqLength := order.Uint32(r[4:])
+		if reqLength < 12 || int(reqLength) > len(r) {
+			return nil, fmt.Errorf("invalid %s requirement blob length: %d", reqType, reqLength)
+		}
+		if kind := order.Uint32(r[8:]); kind != requirementExprForm {
+			return nil, fmt.Errorf("unsupported %s requirement kind: %d", reqType, kind)
+		}
+
+		set[reqType] = append([]byte(nil), r[12:reqLength]...)
+	}
+	return set, nil
 }
 
 func buildRequirementStatements(id string, signingMaterial pki.SigningMaterial) ([]byte, error) {
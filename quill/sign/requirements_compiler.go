@@ -0,0 +1,615 @@
+package sign
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-restruct/restruct"
+
+	"github.com/anchore/quill/quill/macho"
+)
+
+// RequirementSet is a set of compiled requirement expressions keyed by the requirement type.
+type RequirementSet map[macho.RequirementType][]byte
+
+var requirementTypeNames = map[string]macho.RequirementType{
+	"host":       macho.HostRequirementType,
+	"guest":      macho.GuestRequirementType,
+	"designated": macho.DesignatedRequirementType,
+	"library":    macho.LibraryRequirementType,
+	"plugin":     macho.PluginRequirementType,
+}
+
+// CompileRequirements compiles text in Apple's code requirement language (as accepted by "codesign -r" and "csreq")
+// into the binary expression form. The text may either be a single expression, which is treated as the designated
+// requirement, or a set of requirements such as:
+//
+//	designated => identifier "com.example.app" and anchor apple generic
+//	library => anchor apple or certificate leaf[subject.OU] = "ABCDE12345"
+func CompileRequirements(text string) (RequirementSet, error) {
+	tokens, err := tokenizeRequirements(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &requirementParser{tokens: tokens}
+
+	set := make(RequirementSet)
+	if !p.peekRequirementType() {
+		ops, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.done() {
+			return nil, p.errorf("unexpected %q", p.peek().value)
+		}
+		set[macho.DesignatedRequirementType], err = packOps(ops)
+		return set, err
+	}
+
+	for !p.done() {
+		if !p.peekRequirementType() {
+			return nil, p.errorf("expected a requirement type (e.g. 'designated =>') but found %q", p.peek().value)
+		}
+		name := p.next().value
+		reqType := requirementTypeNames[name]
+		if _, exists := set[reqType]; exists {
+			return nil, p.errorf("duplicate %q requirement", name)
+		}
+		p.next() // =>
+
+		ops, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		set[reqType], err = packOps(ops)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// Types returns the requirement types within the set in ascending order.
+func (s RequirementSet) Types() []macho.RequirementType {
+	var types []macho.RequirementType
+	for t := range s {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func packOps(ops []uint32) ([]byte, error) {
+	return restruct.Pack(macho.SigningOrder, ops)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenHash
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// tokenizeRequirements splits requirement text into words, quoted strings, hash constants (H"...") and punctuation.
+//
+//nolint:funlen,gocognit
+func tokenizeRequirements(text string) ([]token, error) {
+	var tokens []token
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			start := i
+			for i += 2; i+1 < len(rs) && (rs[i] != '*' || rs[i+1] != '/'); i++ {
+			}
+			if i+1 >= len(rs) {
+				return nil, fmt.Errorf("unterminated comment at position %d", start)
+			}
+			i += 2
+
+		case (c == '/' && i+1 < len(rs) && rs[i+1] == '/') || c == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+
+		case c == 'H' && i+1 < len(rs) && rs[i+1] == '"':
+			start := i
+			s, n, err := readQuoted(rs[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash constant at position %d: %w", start, err)
+			}
+			tokens = append(tokens, token{kind: tokenHash, value: s, pos: start})
+			i += 1 + n
+
+		case c == '"':
+			start := i
+			s, n, err := readQuoted(rs[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", start, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: s, pos: start})
+			i += n
+
+		case c == '=' && i+1 < len(rs) && rs[i+1] == '>',
+			c == '<' && i+1 < len(rs) && rs[i+1] == '=',
+			c == '>' && i+1 < len(rs) && rs[i+1] == '=':
+			tokens = append(tokens, token{kind: tokenPunct, value: string(rs[i : i+2]), pos: i})
+			i += 2
+
+		case strings.ContainsRune("()[]=<>!*~", c):
+			tokens = append(tokens, token{kind: tokenPunct, value: string(c), pos: i})
+			i++
+
+		case isWordRune(c):
+			start := i
+			for i < len(rs) && isWordRune(rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: string(rs[start:i]), pos: start})
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("._-/:@+$", c)
+}
+
+// readQuoted reads a double-quoted string (with backslash escapes) returning the value and number of runes consumed.
+func readQuoted(rs []rune) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\':
+			i++
+			if i >= len(rs) {
+				return "", 0, fmt.Errorf("unterminated escape")
+			}
+			sb.WriteRune(rs[i])
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(rs[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+type requirementParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *requirementParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *requirementParser) peek() token {
+	if p.done() {
+		return token{kind: tokenPunct, value: "<end of input>", pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *requirementParser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return token{kind: tokenPunct, value: "<end of input>", pos: -1}
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *requirementParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *requirementParser) peekWord(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokenWord {
+		return false
+	}
+	for _, w := range words {
+		if t.value == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *requirementParser) peekPunct(value string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.value == value
+}
+
+func (p *requirementParser) expectPunct(value string) error {
+	if !p.peekPunct(value) {
+		return p.errorf("expected %q but found %q", value, p.peek().value)
+	}
+	p.next()
+	return nil
+}
+
+func (p *requirementParser) peekRequirementType() bool {
+	_, isType := requirementTypeNames[p.peek().value]
+	next := p.peekAt(1)
+	return p.peek().kind == tokenWord && isType && next.kind == tokenPunct && next.value == "=>"
+}
+
+func (p *requirementParser) errorf(format string, args ...any) error {
+	pos := p.peek().pos
+	if pos < 0 {
+		return fmt.Errorf("invalid requirement: "+format+" (at end of input)", args...)
+	}
+	return fmt.Errorf("invalid requirement: "+format+" (at position %d)", append(args, pos)...)
+}
+
+// parseExpression parses: andExpr ("or" andExpr)*
+func (p *requirementParser) parseExpression() ([]uint32, error) {
+	return p.parseBinary("or", opOr, p.parseAnd)
+}
+
+// parseAnd parses: unary ("and" unary)*
+func (p *requirementParser) parseAnd() ([]uint32, error) {
+	return p.parseBinary("and", opAnd, p.parseUnary)
+}
+
+// parseBinary parses a left associative chain of operands joined by the given keyword (in polish notation, as codesign does).
+func (p *requirementParser) parseBinary(keyword string, op uint32, operand func() ([]uint32, error)) ([]uint32, error) {
+	ops, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord(keyword) {
+		p.next()
+		rhs, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(append([]uint32{op}, ops...), rhs...)
+	}
+	return ops, nil
+}
+
+func (p *requirementParser) parseUnary() ([]uint32, error) {
+	if p.peekPunct("!") {
+		p.next()
+		ops, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint32{opNot}, ops...), nil
+	}
+
+	if p.peekPunct("(") {
+		p.next()
+		ops, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return ops, p.expectPunct(")")
+	}
+
+	return p.parsePrimary()
+}
+
+//nolint:funlen
+func (p *requirementParser) parsePrimary() ([]uint32, error) {
+	t := p.peek()
+	if t.kind != tokenWord {
+		return nil, p.errorf("unexpected %q", t.value)
+	}
+	p.next()
+
+	switch t.value {
+	case "always", "true":
+		return []uint32{opTrue}, nil
+
+	case "never", "false":
+		return []uint32{opFalse}, nil
+
+	case "identifier":
+		if p.peekPunct("=") {
+			p.next()
+		}
+		id, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint32{opIdent}, encodeBytes([]byte(id))...), nil
+
+	case "cdhash":
+		if p.peekPunct("=") {
+			p.next()
+		}
+		h, err := p.parseHash()
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint32{opCDHash}, encodeBytes(h)...), nil
+
+	case "platform":
+		if err := p.expectPunct("="); err != nil {
+			return nil, err
+		}
+		v, err := p.parseInteger()
+		if err != nil {
+			return nil, err
+		}
+		return []uint32{opPlatform, uint32(v)}, nil
+
+	case "notarized":
+		return []uint32{opNotarized}, nil
+
+	case "info":
+		return p.parseKeyedMatch(opInfoKeyField)
+
+	case "entitlement":
+		return p.parseKeyedMatch(opEntitlementField)
+
+	case "anchor":
+		return p.parseAnchor()
+
+	case "certificate", "cert":
+		slot, err := p.parseCertSlot()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseCertificate(slot)
+	}
+
+	return nil, fmt.Errorf("invalid requirement: unknown term %q (at position %d)", t.value, t.pos)
+}
+
+func (p *requirementParser) parseAnchor() ([]uint32, error) {
+	switch {
+	case p.peekWord("apple"):
+		p.next()
+		if p.peekWord("generic") {
+			p.next()
+			return []uint32{opAppleGenericAnchor}, nil
+		}
+		return []uint32{opAppleAnchor}, nil
+
+	case p.peekWord("trusted"):
+		p.next()
+		return []uint32{opTrustedCerts}, nil
+	}
+
+	// "anchor" is shorthand for the root certificate
+	return p.parseCertificate(anchorCertIndex)
+}
+
+// parseCertificate parses the remainder of a certificate term after the slot: a hash, "trusted" or a [field] match.
+func (p *requirementParser) parseCertificate(slot uint32) ([]uint32, error) {
+	switch {
+	case p.peekWord("trusted"):
+		p.next()
+		return []uint32{opTrustedCert, slot}, nil
+
+	case p.peekPunct("=") || p.peek().kind == tokenHash:
+		if p.peekPunct("=") {
+			p.next()
+		}
+		h, err := p.parseHash()
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint32{opAnchorHash, slot}, encodeBytes(h)...), nil
+
+	case p.peekPunct("["):
+		p.next()
+		field, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return nil, err
+		}
+
+		match, err := p.parseMatch()
+		if err != nil {
+			return nil, err
+		}
+
+		var ops []uint32
+		switch {
+		case strings.HasPrefix(field, "field."):
+			oid, err := parseOID(strings.TrimPrefix(field, "field."))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			ops = append([]uint32{opCertGeneric, slot}, encodeBytes(encodeOID(oid))...)
+		case strings.HasPrefix(field, "policy."):
+			oid, err := parseOID(strings.TrimPrefix(field, "policy."))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			ops = append([]uint32{opCertPolicy, slot}, encodeBytes(encodeOID(oid))...)
+		default:
+			ops = append([]uint32{opCertField, slot}, encodeBytes([]byte(field))...)
+		}
+		return append(ops, match...), nil
+	}
+
+	return nil, p.errorf("expected a certificate hash, 'trusted' or [field] but found %q", p.peek().value)
+}
+
+func (p *requirementParser) parseCertSlot() (uint32, error) {
+	t := p.peek()
+	if t.kind != tokenWord {
+		return 0, p.errorf("expected a certificate position (leaf, root, or an index) but found %q", t.value)
+	}
+	p.next()
+
+	switch t.value {
+	case "leaf":
+		return leafCertIndex, nil
+	case "root", "anchor":
+		return anchorCertIndex, nil
+	}
+
+	v, err := strconv.ParseInt(t.value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid requirement: invalid certificate position %q (at position %d)", t.value, t.pos)
+	}
+	// note: negative positions count from the anchor (-1 is the anchor)
+	return uint32(int32(v)), nil
+}
+
+// parseKeyedMatch parses: "[" key "]" match (as used by info and entitlement terms)
+func (p *requirementParser) parseKeyedMatch(op uint32) ([]uint32, error) {
+	if err := p.expectPunct("["); err != nil {
+		return nil, err
+	}
+	key, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("]"); err != nil {
+		return nil, err
+	}
+	match, err := p.parseMatch()
+	if err != nil {
+		return nil, err
+	}
+	ops := append([]uint32{op}, encodeBytes([]byte(key))...)
+	return append(ops, match...), nil
+}
+
+// parseMatch parses an optional match suffix: (nothing) | "exists" | "absent" | ("=" | "<" | ">" | "<=" | ">=") value,
+// where an equality value may have a leading and/or trailing "*" wildcard.
+func (p *requirementParser) parseMatch() ([]uint32, error) {
+	switch {
+	case p.peekWord("exists"):
+		p.next()
+		return []uint32{matchExists}, nil
+	case p.peekWord("absent"):
+		p.next()
+		return []uint32{matchAbsent}, nil
+	}
+
+	comparisons := map[string]uint32{
+		"<":  matchLessThan,
+		">":  matchGreaterThan,
+		"<=": matchLessEqual,
+		">=": matchGreaterEqual,
+		"~":  matchContains,
+	}
+
+	t := p.peek()
+	if t.kind != tokenPunct {
+		return []uint32{matchExists}, nil
+	}
+
+	if op, ok := comparisons[t.value]; ok {
+		p.next()
+		v, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return append([]uint32{op}, encodeBytes([]byte(v))...), nil
+	}
+
+	if t.value != "=" {
+		return []uint32{matchExists}, nil
+	}
+	p.next()
+
+	leading := p.peekPunct("*")
+	if leading {
+		p.next()
+	}
+	v, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	trailing := p.peekPunct("*")
+	if trailing {
+		p.next()
+	}
+
+	op := matchEqual
+	switch {
+	case leading && trailing:
+		op = matchContains
+	case leading:
+		op = matchEndsWith
+	case trailing:
+		op = matchBeginsWith
+	}
+	return append([]uint32{op}, encodeBytes([]byte(v))...), nil
+}
+
+// parseString parses a quoted string or a bare word.
+func (p *requirementParser) parseString() (string, error) {
+	t := p.peek()
+	if t.kind != tokenString && t.kind != tokenWord {
+		return "", p.errorf("expected a string but found %q", t.value)
+	}
+	p.next()
+	return t.value, nil
+}
+
+func (p *requirementParser) parseHash() ([]byte, error) {
+	t := p.peek()
+	if t.kind != tokenHash {
+		return nil, p.errorf("expected a hash constant (H\"...\") but found %q", t.value)
+	}
+	p.next()
+	h, err := hex.DecodeString(t.value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid requirement: invalid hash constant %q (at position %d): %w", t.value, t.pos, err)
+	}
+	return h, nil
+}
+
+func (p *requirementParser) parseInteger() (int64, error) {
+	t := p.peek()
+	if t.kind != tokenWord {
+		return 0, p.errorf("expected an integer but found %q", t.value)
+	}
+	p.next()
+	v, err := strconv.ParseInt(t.value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid requirement: invalid integer %q (at position %d)", t.value, t.pos)
+	}
+	return v, nil
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, v)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
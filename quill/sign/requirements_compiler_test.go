@@ -0,0 +1,181 @@
+package sign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/blacktop/go-macho/pkg/codesign/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/quill/macho"
+)
+
+func TestCompileRequirements(t *testing.T) {
+	tests := []struct {
+		name            string
+		text            string
+		wantBytes       string
+		wantDescription string
+		wantErr         require.ErrorAssertionFunc
+	}{
+		{
+			name:            "identifier",
+			text:            `identifier "the-id"`,
+			wantBytes:       "00000002000000067468652d69640000",
+			wantDescription: `identifier "the-id"`,
+		},
+		{
+			name:            "matches the default designated requirement encoding",
+			text:            `identifier "the-id" and anchor apple generic`,
+			wantBytes:       "0000000600000002000000067468652d696400000000000f",
+			wantDescription: `identifier "the-id" and anchor apple generic`,
+		},
+		{
+			name:            "leaf certificate subject field",
+			text:            `certificate leaf[subject.OU] = "MATCHME"`,
+			wantBytes:       "0000000b000000000000000a7375626a6563742e4f55000000000001000000074d415443484d4500",
+			wantDescription: `certificate leaf[subject.OU]  = "MATCHME"`,
+		},
+		{
+			name:            "certificate extension exists",
+			text:            `certificate 1[field.1.2.840.113635.100.6.2.6] /* exists */`,
+			wantBytes:       "0000000e000000010000000a2a864886f76364060206000000000000",
+			wantDescription: `certificate 1[field.1.2.840.113635.100.6.2.6]  /* exists */`,
+		},
+		{
+			name:            "explicit designated requirement type",
+			text:            `designated => identifier com.acme.agent and anchor apple generic and certificate leaf[subject.OU] = ABCDE12345`,
+			wantDescription: `identifier "com.acme.agent" and anchor apple generic and certificate leaf[subject.OU]  = "ABCDE12345"`,
+		},
+		{
+			name:            "or clauses for key rotation",
+			text:            `anchor apple generic and (certificate leaf[subject.OU] = "OLDTEAM" or certificate leaf[subject.OU] = "NEWTEAM")`,
+			wantDescription: `anchor apple generic and (certificate leaf[subject.OU]  = "OLDTEAM" or certificate leaf[subject.OU]  = "NEWTEAM")`,
+		},
+		{
+			name:      "info, entitlement and policy fields",
+			text:      `info[CFBundleVersion] >= "1.2" and entitlement["com.apple.security.app-sandbox"] exists and certificate root[policy.1.2.3]`,
+			wantBytes: "00000006000000060000000a0000000f434642756e646c6556657273696f6e000000000800000003312e3200000000100000001e636f6d2e6170706c652e73656375726974792e6170702d73616e64626f7800000000000000000011ffffffff000000022a03000000000000",
+		},
+		{
+			name:            "wildcards",
+			text:            `info[Name] = "com.acme."* or info[Name] = *".agent" or info[Name] = *acme*`,
+			wantDescription: `info[Name]  = com.acme.* or info[Name]  = *.agent or info[Name]  ~ acme`,
+		},
+		{
+			name:      "negation, hashes and anchors",
+			text:      `!cdhash H"0102030405060708090a0b0c0d0e0f1011121314" and anchor = H"aabb" and anchor trusted and certificate 1 trusted and anchor apple`,
+			wantBytes: "000000060000000600000006000000060000000900000008000000140102030405060708090a0b0c0d0e0f101112131400000004ffffffff00000002aabb00000000000d0000000c0000000100000003",
+		},
+		{
+			name:            "constants",
+			text:            `always or never`,
+			wantBytes:       "000000070000000100000000",
+			wantDescription: `always or never`,
+		},
+		{
+			name:    "unknown term",
+			text:    `identifer "typo"`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "unbalanced parens",
+			text:    `(anchor apple`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "trailing garbage",
+			text:    `anchor apple generic )`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "unterminated string",
+			text:    `identifier "com.acme`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "duplicate requirement types",
+			text:    `designated => always designated => never`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid hash",
+			text:    `cdhash H"xyz"`,
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			set, err := CompileRequirements(tt.text)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			require.Len(t, set, 1)
+			by := set[macho.DesignatedRequirementType]
+			require.NotNil(t, by)
+
+			if tt.wantBytes != "" {
+				assert.Equal(t, tt.wantBytes, hex.EncodeToString(by))
+			}
+
+			if tt.wantDescription == "" {
+				return
+			}
+
+			description, err := types.ParseRequirements(bytes.NewReader(by), types.Requirements{
+				Type:   types.DesignatedRequirementType,
+				Offset: 0,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDescription, description)
+		})
+	}
+}
+
+func TestCompileRequirements_set(t *testing.T) {
+	set, err := CompileRequirements(`
+		# requirements for all supported types
+		designated => identifier "com.acme.agent"
+		library => anchor apple or anchor apple generic
+		host => anchor apple
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []macho.RequirementType{
+		macho.HostRequirementType,
+		macho.DesignatedRequirementType,
+		macho.LibraryRequirementType,
+	}, set.Types())
+
+	assert.Equal(t, "00000007000000030000000f", hex.EncodeToString(set[macho.LibraryRequirementType]))
+	assert.Equal(t, "00000003", hex.EncodeToString(set[macho.HostRequirementType]))
+
+	payload, err := encodeRequirementSet(set)
+	require.NoError(t, err)
+
+	// the payload is preceded by the requirements blob header (magic + length)
+	blob := append(make([]byte, 8), payload...)
+
+	count := binary.BigEndian.Uint32(blob[8:])
+	require.Equal(t, uint32(3), count)
+
+	for i, wantType := range set.Types() {
+		entry := blob[12+i*8:]
+		assert.Equal(t, uint32(wantType), binary.BigEndian.Uint32(entry))
+
+		offset := binary.BigEndian.Uint32(entry[4:])
+		assert.Equal(t, uint32(macho.MagicRequirement), binary.BigEndian.Uint32(blob[offset:]))
+		length := binary.BigEndian.Uint32(blob[offset+4:])
+		// the blob holds the expression form kind followed by the compiled expression
+		assert.Equal(t, uint32(requirementExprForm), binary.BigEndian.Uint32(blob[offset+8:]))
+		assert.Equal(t, set[wantType], blob[offset+12:offset+length])
+	}
+}
@@ -0,0 +1,424 @@
+package sign
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/anchore/quill/quill/macho"
+)
+
+// Requirement is a single decompiled requirement from a requirements blob.
+type Requirement struct {
+	Type macho.RequirementType
+	// Text is the requirement expression in Apple's requirement language
+	Text string
+}
+
+func (r Requirement) String() string {
+	return fmt.Sprintf("%s => %s", r.Type, r.Text)
+}
+
+const (
+	// opFlagMask masks the flag bits of an opcode
+	opFlagMask uint32 = 0xFF000000
+	// opGenericSkip indicates that an unknown opcode is followed by length-prefixed data that can be skipped
+	opGenericSkip uint32 = 0x40000000
+)
+
+// syntax levels used to decide when parentheses are needed (lower binds tighter)
+const (
+	levelPrimary = iota
+	levelAnd
+	levelOr
+	levelTop
+)
+
+// DecompileRequirements decodes a requirements blob (including the blob header, as stored in the signing superblob)
+// into requirement language text for each requirement within the set.
+func DecompileRequirements(blob []byte) ([]Requirement, error) {
+	if len(blob) < 12 {
+		return nil, fmt.Errorf("requirements blob too short: %d bytes", len(blob))
+	}
+
+	order := macho.SigningOrder
+	if magic := macho.Magic(order.Uint32(blob)); magic != macho.MagicRequirements {
+		return nil, fmt.Errorf("unexpected requirements blob magic: %#x", uint32(magic))
+	}
+
+	length := order.Uint32(blob[4:])
+	if int(length) > len(blob) {
+		return nil, fmt.Errorf("requirements blob length exceeds data (%d > %d)", length, len(blob))
+	}
+	blob = blob[:length]
+
+	count := order.Uint32(blob[8:])
+	if uint64(12)+uint64(count)*8 > uint64(len(blob)) {
+		return nil, fmt.Errorf("requirements index exceeds blob (count=%d)", count)
+	}
+
+	var reqs []Requirement
+	for i := uint32(0); i < count; i++ {
+		entry := blob[12+i*8:]
+		reqType := macho.RequirementType(order.Uint32(entry))
+		offset := order.Uint32(entry[4:])
+
+		text, err := decompileRequirementBlob(blob, offset)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompile %s requirement: %w", reqType, err)
+		}
+		reqs = append(reqs, Requirement{Type: reqType, Text: text})
+	}
+	return reqs, nil
+}
+
+func decompileRequirementBlob(blob []byte, offset uint32) (string, error) {
+	order := macho.SigningOrder
+	if uint64(offset)+12 > uint64(len(blob)) {
+		return "", fmt.Errorf("requirement offset exceeds blob (%d)", offset)
+	}
+
+	r := blob[offset:]
+	if magic := macho.Magic(order.Uint32(r)); magic != macho.MagicRequirement {
+		return "", fmt.Errorf("unexpected requirement blob magic: %#x", uint32(magic))
+	}
+
+	length := order.Uint32(r[4:])
+	if length < 12 || int(length) > len(r) {
+		return "", fmt.Errorf("invalid requirement blob length: %d", length)
+	}
+
+	if kind := order.Uint32(r[8:]); kind != requirementExprForm {
+		return "", fmt.Errorf("unsupported requirement kind: %d", kind)
+	}
+
+	return DecompileRequirement(r[12:length])
+}
+
+// DecompileRequirement decodes a single compiled requirement expression into requirement language text.
+func DecompileRequirement(expr []byte) (string, error) {
+	d := &requirementDecompiler{expr: expr}
+	var sb strings.Builder
+	if err := d.expression(&sb, levelTop); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+type requirementDecompiler struct {
+	expr []byte
+	pos  int
+}
+
+var errRequirementTruncated = errors.New("requirement expression is truncated")
+
+func (d *requirementDecompiler) uint32() (uint32, error) {
+	if d.pos+sizeOfUint32 > len(d.expr) {
+		return 0, errRequirementTruncated
+	}
+	v := binary.BigEndian.Uint32(d.expr[d.pos:])
+	d.pos += sizeOfUint32
+	return v, nil
+}
+
+// bytes reads length-prefixed data (padded to a 4 byte boundary).
+func (d *requirementDecompiler) bytes() ([]byte, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	aligned := roundUp(uint64(length), sizeOfUint32)
+	if uint64(d.pos)+aligned > uint64(len(d.expr)) {
+		return nil, errRequirementTruncated
+	}
+	b := d.expr[d.pos : d.pos+int(length)]
+	d.pos += int(aligned)
+	return b, nil
+}
+
+//nolint:funlen,gocyclo
+func (d *requirementDecompiler) expression(sb *strings.Builder, level int) error {
+	op, err := d.uint32()
+	if err != nil {
+		return err
+	}
+
+	switch op & ^opFlagMask {
+	case opFalse:
+		sb.WriteString("never")
+	case opTrue:
+		sb.WriteString("always")
+	case opIdent:
+		sb.WriteString("identifier ")
+		return d.data(sb, false)
+	case opAppleAnchor:
+		sb.WriteString("anchor apple")
+	case opAppleGenericAnchor:
+		sb.WriteString("anchor apple generic")
+	case opAnchorHash:
+		sb.WriteString("certificate ")
+		if err := d.certSlot(sb); err != nil {
+			return err
+		}
+		sb.WriteString(" = ")
+		return d.hash(sb)
+	case opInfoKeyValue:
+		// legacy form: always an exact match
+		sb.WriteString("info[")
+		if err := d.data(sb, true); err != nil {
+			return err
+		}
+		sb.WriteString("] = ")
+		return d.data(sb, false)
+	case opAnd:
+		return d.binary(sb, level, levelAnd, " and ")
+	case opOr:
+		return d.binary(sb, level, levelOr, " or ")
+	case opNot:
+		sb.WriteString("! ")
+		return d.expression(sb, levelPrimary)
+	case opCDHash:
+		sb.WriteString("cdhash ")
+		return d.hash(sb)
+	case opInfoKeyField:
+		sb.WriteString("info[")
+		if err := d.data(sb, true); err != nil {
+			return err
+		}
+		sb.WriteString("]")
+		return d.match(sb)
+	case opEntitlementField:
+		sb.WriteString("entitlement[")
+		if err := d.data(sb, true); err != nil {
+			return err
+		}
+		sb.WriteString("]")
+		return d.match(sb)
+	case opCertField:
+		sb.WriteString("certificate ")
+		if err := d.certSlot(sb); err != nil {
+			return err
+		}
+		sb.WriteString("[")
+		if err := d.data(sb, true); err != nil {
+			return err
+		}
+		sb.WriteString("]")
+		return d.match(sb)
+	case opCertGeneric, opCertPolicy:
+		prefix := "field"
+		if op&^opFlagMask == opCertPolicy {
+			prefix = "policy"
+		}
+		sb.WriteString("certificate ")
+		if err := d.certSlot(sb); err != nil {
+			return err
+		}
+		oid, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		decoded, err := decodeOID(oid)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(fmt.Sprintf("[%s.%s]", prefix, decoded))
+		return d.match(sb)
+	case opTrustedCert:
+		sb.WriteString("certificate ")
+		if err := d.certSlot(sb); err != nil {
+			return err
+		}
+		sb.WriteString(" trusted")
+	case opTrustedCerts:
+		sb.WriteString("anchor trusted")
+	case opNamedAnchor:
+		sb.WriteString("anchor apple ")
+		return d.data(sb, false)
+	case opNamedCode:
+		sb.WriteString("(")
+		if err := d.data(sb, false); err != nil {
+			return err
+		}
+		sb.WriteString(")")
+	case opPlatform:
+		v, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		sb.WriteString(fmt.Sprintf("platform = %d", v))
+	case opNotarized:
+		sb.WriteString("notarized")
+	default:
+		if op&opGenericSkip == 0 {
+			return fmt.Errorf("unsupported requirement opcode: %#x", op)
+		}
+		// unknown opcodes with the skip flag carry length-prefixed data that can be ignored
+		if _, err := d.bytes(); err != nil {
+			return err
+		}
+		sb.WriteString(fmt.Sprintf("/* opcode %#x */", op))
+	}
+	return nil
+}
+
+func (d *requirementDecompiler) binary(sb *strings.Builder, level, opLevel int, separator string) error {
+	if level < opLevel {
+		sb.WriteString("(")
+	}
+	if err := d.expression(sb, opLevel); err != nil {
+		return err
+	}
+	sb.WriteString(separator)
+	if err := d.expression(sb, opLevel); err != nil {
+		return err
+	}
+	if level < opLevel {
+		sb.WriteString(")")
+	}
+	return nil
+}
+
+func (d *requirementDecompiler) certSlot(sb *strings.Builder) error {
+	slot, err := d.uint32()
+	if err != nil {
+		return err
+	}
+	switch slot {
+	case leafCertIndex:
+		sb.WriteString("leaf")
+	case anchorCertIndex:
+		sb.WriteString("root")
+	default:
+		sb.WriteString(strconv.Itoa(int(int32(slot))))
+	}
+	return nil
+}
+
+//nolint:gocyclo
+func (d *requirementDecompiler) match(sb *strings.Builder) error {
+	op, err := d.uint32()
+	if err != nil {
+		return err
+	}
+
+	prefixes := map[uint32]string{
+		matchEqual:        " = ",
+		matchContains:     " ~ ",
+		matchBeginsWith:   " = ",
+		matchEndsWith:     " = *",
+		matchLessThan:     " < ",
+		matchGreaterThan:  " > ",
+		matchLessEqual:    " <= ",
+		matchGreaterEqual: " >= ",
+	}
+
+	switch op {
+	case matchExists:
+		sb.WriteString(" /* exists */")
+		return nil
+	case matchAbsent:
+		sb.WriteString(" absent")
+		return nil
+	}
+
+	prefix, ok := prefixes[op]
+	if !ok {
+		return fmt.Errorf("unsupported requirement match operation: %d", op)
+	}
+
+	sb.WriteString(prefix)
+	if err := d.data(sb, false); err != nil {
+		return err
+	}
+	if op == matchBeginsWith {
+		sb.WriteString("*")
+	}
+	return nil
+}
+
+// data writes a string value: bare when it is a simple word, quoted when printable, otherwise as a hex constant.
+func (d *requirementDecompiler) data(sb *strings.Builder, dotOkay bool) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+
+	simple, printable := len(b) > 0, true
+	for _, c := range string(b) {
+		switch {
+		case !unicode.IsPrint(c) || c > unicode.MaxASCII:
+			simple, printable = false, false
+		case !(unicode.IsLetter(c) || unicode.IsDigit(c) || (dotOkay && c == '.')):
+			simple = false
+		}
+	}
+
+	// words with meaning in the language must be quoted to be read back as strings
+	if _, reserved := reservedRequirementWords[string(b)]; reserved {
+		simple = false
+	}
+
+	switch {
+	case simple:
+		sb.Write(b)
+	case printable:
+		sb.WriteString(`"`)
+		for _, c := range string(b) {
+			if c == '"' || c == '\\' {
+				sb.WriteString(`\`)
+			}
+			sb.WriteRune(c)
+		}
+		sb.WriteString(`"`)
+	default:
+		sb.WriteString("0x" + hex.EncodeToString(b))
+	}
+	return nil
+}
+
+func (d *requirementDecompiler) hash(sb *strings.Builder) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	sb.WriteString(`H"` + hex.EncodeToString(b) + `"`)
+	return nil
+}
+
+var reservedRequirementWords = map[string]struct{}{
+	"always": {}, "never": {}, "true": {}, "false": {}, "and": {}, "or": {}, "identifier": {}, "cdhash": {},
+	"anchor": {}, "apple": {}, "generic": {}, "certificate": {}, "cert": {}, "trusted": {}, "info": {},
+	"entitlement": {}, "exists": {}, "absent": {}, "leaf": {}, "root": {}, "platform": {}, "notarized": {},
+	"host": {}, "guest": {}, "designated": {}, "library": {}, "plugin": {},
+}
+
+// decodeOID decodes a DER encoded object identifier (without tag and length) into dotted form.
+func decodeOID(b []byte) (string, error) {
+	var parts []string
+	var v uint64
+	for i, c := range b {
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return "", fmt.Errorf("invalid OID encoding")
+			}
+			continue
+		}
+		if len(parts) == 0 {
+			first := min(v/40, 2)
+			parts = append(parts, strconv.FormatUint(first, 10), strconv.FormatUint(v-first*40, 10))
+		} else {
+			parts = append(parts, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("empty OID")
+	}
+	return strings.Join(parts, "."), nil
+}
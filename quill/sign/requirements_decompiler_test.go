@@ -0,0 +1,149 @@
+package sign
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/quill/macho"
+)
+
+func TestDecompileRequirement(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "default designated requirement",
+			text: `identifier "com.acme.agent" and anchor apple generic`,
+			want: `identifier "com.acme.agent" and anchor apple generic`,
+		},
+		{
+			name: "developer ID style requirement",
+			text: `identifier com.acme.agent and anchor apple generic and certificate 1[field.1.2.840.113635.100.6.2.6] exists and certificate leaf[field.1.2.840.113635.100.6.1.13] and certificate leaf[subject.OU] = "ABCDE12345"`,
+			want: `identifier "com.acme.agent" and anchor apple generic and certificate 1[field.1.2.840.113635.100.6.2.6] /* exists */ and certificate leaf[field.1.2.840.113635.100.6.1.13] /* exists */ and certificate leaf[subject.OU] = ABCDE12345`,
+		},
+		{
+			name: "parentheses follow precedence",
+			text: `anchor apple generic and (certificate leaf[subject.OU] = OLDTEAM or certificate leaf[subject.OU] = NEWTEAM) or always and never`,
+			want: `anchor apple generic and (certificate leaf[subject.OU] = OLDTEAM or certificate leaf[subject.OU] = NEWTEAM) or always and never`,
+		},
+		{
+			name: "negation binds tightest",
+			text: `!(anchor apple or anchor trusted) and ! cdhash H"0102030405060708090A0B0C0D0E0F1011121314"`,
+			want: `! (anchor apple or anchor trusted) and ! cdhash H"0102030405060708090a0b0c0d0e0f1011121314"`,
+		},
+		{
+			name: "info and entitlement matches",
+			text: `info[CFBundleVersion] >= "1.2" and info[CFBundleVersion] < 2 and info[A] <= B and info[A] > B and entitlement["com.apple.security.app-sandbox"] exists and info[Missing] absent`,
+			want: `info[CFBundleVersion] >= "1.2" and info[CFBundleVersion] < 2 and info[A] <= B and info[A] > B and entitlement["com.apple.security.app-sandbox"] /* exists */ and info[Missing] absent`,
+		},
+		{
+			name: "wildcards",
+			text: `info[Name] = "com.acme."* or info[Name] = *".agent" or info[Name] = *acme* or info[Name] ~ acme`,
+			want: `info[Name] = "com.acme."* or info[Name] = *".agent" or info[Name] ~ acme or info[Name] ~ acme`,
+		},
+		{
+			name: "certificates and anchors",
+			text: `anchor = H"aabb" and certificate 2 = H"ccdd" and certificate 1 trusted and certificate root[policy.1.2.3] and anchor apple`,
+			want: `certificate root = H"aabb" and certificate 2 = H"ccdd" and certificate 1 trusted and certificate root[policy.1.2.3] /* exists */ and anchor apple`,
+		},
+		{
+			name: "platform and notarization",
+			text: `platform = 1 and notarized`,
+			want: `platform = 1 and notarized`,
+		},
+		{
+			name: "reserved words and quotes are quoted",
+			text: `identifier "anchor" and info[Key] = "say \"hi\""`,
+			want: `identifier "anchor" and info[Key] = "say \"hi\""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := CompileRequirements(tt.text)
+			require.NoError(t, err)
+
+			got, err := DecompileRequirement(set[macho.DesignatedRequirementType])
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// the canonical text must compile to the same expression
+			again, err := CompileRequirements(got)
+			require.NoError(t, err)
+			assert.Equal(t, set, again)
+		})
+	}
+}
+
+func TestDecompileRequirement_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{
+			name: "truncated",
+			expr: "00000006000000020000000674",
+		},
+		{
+			name: "unknown opcode",
+			expr: "000000ff",
+		},
+		{
+			name: "unknown match operation",
+			expr: "0000000b00000000000000027878000000000063",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := hex.DecodeString(tt.expr)
+			require.NoError(t, err)
+
+			_, err = DecompileRequirement(expr)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDecompileRequirements(t *testing.T) {
+	set, err := CompileRequirements(`
+		designated => identifier "com.acme.agent" and anchor apple generic
+		library => anchor apple or anchor apple generic
+		host => anchor apple
+	`)
+	require.NoError(t, err)
+
+	payload, err := encodeRequirementSet(set)
+	require.NoError(t, err)
+	blob := macho.NewBlob(macho.MagicRequirements, payload)
+	blobBytes, err := blob.Pack()
+	require.NoError(t, err)
+
+	reqs, err := DecompileRequirements(blobBytes)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Requirement{
+		{Type: macho.HostRequirementType, Text: "anchor apple"},
+		{Type: macho.DesignatedRequirementType, Text: `identifier "com.acme.agent" and anchor apple generic`},
+		{Type: macho.LibraryRequirementType, Text: "anchor apple or anchor apple generic"},
+	}, reqs)
+
+	assert.Equal(t, `designated => identifier "com.acme.agent" and anchor apple generic`, reqs[1].String())
+
+	// an empty requirement set (as used for ad-hoc signatures) has no requirements
+	empty, err := encodeRequirementSet(nil)
+	require.NoError(t, err)
+	emptyBlob := macho.NewBlob(macho.MagicRequirements, empty)
+	emptyBytes, err := emptyBlob.Pack()
+	require.NoError(t, err)
+
+	reqs, err = DecompileRequirements(emptyBytes)
+	require.NoError(t, err)
+	assert.Empty(t, reqs)
+
+	_, err = DecompileRequirements(payload)
+	require.Error(t, err)
+}
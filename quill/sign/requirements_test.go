@@ -326,3 +326,56 @@ func Test_buildRequirementStatements(t *testing.T) {
 		})
 	}
 */
+
+func TestParseRequirementSet(t *testing.T) {
+	set, err := CompileRequirements(`designated => identifier "com.example.app" and anchor apple generic
+library => anchor apple or certificate leaf[subject.OU] = "ABCDE12345"`)
+	require.NoError(t, err)
+
+	payload, err := encodeRequirementSet(set)
+	require.NoError(t, err)
+	blob := macho.NewBlob(macho.MagicRequirements, payload)
+	blobBytes, err := blob.Pack()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    RequirementSet
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:  "round trip",
+			input: blobBytes,
+			want:  set,
+		},
+		{
+			name:  "empty requirements",
+			input: []byte{0xfa, 0xde, 0x0c, 0x01, 0, 0, 0, 12, 0, 0, 0, 0},
+			want:  RequirementSet{},
+		},
+		{
+			name:    "wrong magic",
+			input:   append([]byte{0xfa, 0xde, 0x0c, 0x02}, blobBytes[4:]...),
+			wantErr: require.Error,
+		},
+		{
+			name:    "truncated",
+			input:   blobBytes[:len(blobBytes)-4],
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			got, err := ParseRequirementSet(tt.input)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
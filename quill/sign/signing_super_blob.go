@@ -1,8 +1,10 @@
 package sign
 
 import (
-	"crypto/sha256"
+	"context"
 	"fmt"
+	"hash"
+	"time"
 
 	"github.com/go-restruct/restruct"
 
@@ -12,37 +14,77 @@ import (
 
 type SpecialSlot struct {
 	Type      macho.SlotType
-	Blob      *macho.Blob
+	Blob      *macho.Blob // nil when the content lives outside the superblob (e.g. Info.plist)
 	HashBytes []byte
 }
 
-func GenerateSigningSuperBlob(id string, m *macho.File, signingMaterial pki.SigningMaterial, entitlementsData string, paddingTarget int) (int, []byte, error) {
-	var cdFlags macho.CdFlag
+// Options describes the content to include in a signing superblob.
+type Options struct {
+	Identity        string
+	SigningMaterial pki.SigningMaterial
+	// Entitlements is the XML entitlements content (optional)
+	Entitlements string
+	// InfoPlist is the raw Info.plist content of the enclosing bundle, bound to CsSlotInfoslot (optional)
+	InfoPlist []byte
+	// CodeResources is the raw _CodeSignature/CodeResources content of the enclosing bundle, bound to CsSlotResourcedir (optional)
+	CodeResources []byte
+	// Requirements are the compiled requirements to embed (optional). The designated requirement is derived from the
+	// identity and signing material when not provided.
+	Requirements RequirementSet
+	// HashTypes are the digest algorithms to generate code directories for. The first is the primary code directory
+	// and the remaining are written as alternate code directories (default is sha256 only).
+	HashTypes []macho.HashType
+	// Flags are additional code directory flags to set (e.g. macho.Kill | macho.Hard), which must be within
+	// macho.AllowedMacho. The runtime flag is always set for signed binaries (as required for notarization) and the
+	// adhoc flag is set when there is no signer.
+	Flags macho.CdFlag
+	// SigningTime is the value of the CMS signing-time attribute (default is the current time)
+	SigningTime time.Time
+	// Deterministic produces the same signature for the same input and signing time, so that signing is reproducible
+	// (this does not cover RFC3161 timestamps, which should not be requested)
+	Deterministic bool
+	// Timestamp controls how RFC3161 timestamps are requested from the timestamp servers of the signing material
+	Timestamp TimestampOptions
+}
+
+// SigningSuperBlob is the result of generating a signing superblob.
+type SigningSuperBlob struct {
+	// Size is the length of the superblob (without padding), which is the padding target for a later pass
+	Size int
+	// Bytes is the encoded superblob (including padding)
+	Bytes []byte
+	// Timestamp describes the RFC3161 timestamp within the signature (nil when the signature is not timestamped)
+	Timestamp *Timestamp
+}
+
+// DefaultHashTypes is the digest set used when no hash types are specified.
+var DefaultHashTypes = []macho.HashType{macho.HashTypeSha256}
+
+// CodesignHashTypes is the digest set that codesign produces for binaries targeting older macOS releases (a SHA-1
+// primary code directory and a SHA-256 alternate code directory).
+var CodesignHashTypes = []macho.HashType{macho.HashTypeSha1, macho.HashTypeSha256}
+
+func GenerateSigningSuperBlob(ctx context.Context, m *macho.File, opts Options, paddingTarget int) (*SigningSuperBlob, error) {
+	signingMaterial := opts.SigningMaterial
+	if err := opts.Flags.ValidateMacho(); err != nil {
+		return nil, err
+	}
+
+	cdFlags := opts.Flags
 	if signingMaterial.Signer != nil {
-		// TODO: add options to enable more strict rules (such as macho.Hard)
 		// note: we must at least support the runtime option for notarization (requirement introduced in macOS 10.14 / Mojave).
-		// cdFlags = macho.Runtime | macho.Hard
-		cdFlags = macho.Runtime
+		cdFlags |= macho.Runtime
 	} else {
-		cdFlags = macho.Adhoc
+		cdFlags |= macho.Adhoc
 	}
 
-	specialSlots := []SpecialSlot{}
-
-	entitlements, err := generateEntitlements(sha256.New(), entitlementsData)
-	if err != nil {
-		return 0, nil, fmt.Errorf("unable to create entitlements: %w", err)
-	}
-	if entitlements != nil {
-		specialSlots = append(specialSlots, *entitlements)
+	hashTypes := opts.HashTypes
+	if len(hashTypes) == 0 {
+		hashTypes = DefaultHashTypes
 	}
 
-	requirements, err := generateRequirements(id, sha256.New(), signingMaterial)
-	if err != nil {
-		return 0, nil, fmt.Errorf("unable to create requirements: %w", err)
-	}
-	if requirements != nil {
-		specialSlots = append(specialSlots, *requirements)
+	if len(hashTypes) > macho.CsSlotAlternateCodedirectoryMax+1 {
+		return nil, fmt.Errorf("too many code directory hash types: %d", len(hashTypes))
 	}
 
 	// derive team ID from the leaf certificate's Organizational Unit (OU) field
@@ -51,32 +93,115 @@ func GenerateSigningSuperBlob(id string, m *macho.File, signingMaterial pki.Sign
 		teamID = leaf.Subject.OrganizationalUnit[0]
 	}
 
-	cdBlob, err := generateCodeDirectory(id, teamID, sha256.New(), m, cdFlags, specialSlots)
-	if err != nil {
-		return 0, nil, fmt.Errorf("unable to create code directory: %w", err)
+	// the special slot blobs are the same for all code directories, only the hashes of the blobs differ
+	var specialSlots []SpecialSlot
+	var cds []codeDirectory
+	for _, ht := range hashTypes {
+		hasher, err := ht.Hasher()
+		if err != nil {
+			return nil, err
+		}
+
+		slots, err := generateSpecialSlots(opts, ht)
+		if err != nil {
+			return nil, err
+		}
+		if specialSlots == nil {
+			specialSlots = slots
+		}
+
+		cdBlob, err := generateCodeDirectory(opts.Identity, teamID, hasher, m, cdFlags, slots)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create %s code directory: %w", ht, err)
+		}
+		cds = append(cds, codeDirectory{HashType: ht, Blob: cdBlob})
 	}
 
-	cmsBlob, err := generateCMS(signingMaterial, cdBlob)
+	cmsBlob, ts, err := generateCMS(ctx, signingMaterial, cds, cmsOptions{
+		signingTime:   opts.SigningTime,
+		deterministic: opts.Deterministic,
+		timestamp:     opts.Timestamp,
+	})
 	if err != nil {
-		return 0, nil, fmt.Errorf("unable to create signature block: %w", err)
+		return nil, fmt.Errorf("unable to create signature block: %w", err)
 	}
 
 	sb := macho.NewSuperBlob(macho.MagicEmbeddedSignature)
-	sb.Add(macho.CsSlotCodedirectory, cdBlob)
+	sb.Add(macho.CsSlotCodedirectory, cds[0].Blob)
 	for _, slot := range specialSlots {
 		sb.Add(slot.Type, slot.Blob)
 	}
 
+	for i, cd := range cds[1:] {
+		sb.Add(macho.CsSlotAlternateCodedirectories+macho.SlotType(i), cd.Blob)
+	}
+
 	sb.Add(macho.CsSlotCmsSignature, cmsBlob)
 
 	sb.Finalize(paddingTarget)
 
 	sbBytes, err := restruct.Pack(macho.SigningOrder, &sb)
 	if err != nil {
-		return 0, nil, fmt.Errorf("unable to encode super blob: %w", err)
+		return nil, fmt.Errorf("unable to encode super blob: %w", err)
+	}
+
+	return &SigningSuperBlob{
+		Size:      int(sb.Length),
+		Bytes:     sbBytes,
+		Timestamp: ts,
+	}, nil
+}
+
+// generateSpecialSlots creates all special slots, hashed with the given hash type.
+func generateSpecialSlots(opts Options, ht macho.HashType) ([]SpecialSlot, error) {
+	newHasher := func() hash.Hash {
+		// note: the hash type has already been validated by the caller
+		h, _ := ht.Hasher()
+		return h
 	}
 
-	return int(sb.Length), sbBytes, nil
+	specialSlots := []SpecialSlot{}
+
+	if opts.InfoPlist != nil {
+		specialSlots = append(specialSlots, generateExternalSlot(newHasher(), macho.CsSlotInfoslot, opts.InfoPlist))
+	}
+
+	if opts.CodeResources != nil {
+		specialSlots = append(specialSlots, generateExternalSlot(newHasher(), macho.CsSlotResourcedir, opts.CodeResources))
+	}
+
+	entitlements, err := generateEntitlements(newHasher(), opts.Entitlements)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create entitlements: %w", err)
+	}
+	if entitlements != nil {
+		specialSlots = append(specialSlots, *entitlements)
+	}
+
+	// since macOS 12 / iOS 15 the kernel reads the DER form of the entitlements
+	entitlementsDER, err := generateEntitlementsDER(newHasher(), opts.Entitlements)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create DER entitlements: %w", err)
+	}
+	if entitlementsDER != nil {
+		specialSlots = append(specialSlots, *entitlementsDER)
+	}
+
+	requirements, err := generateRequirements(opts.Identity, newHasher(), opts.SigningMaterial, opts.Requirements)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create requirements: %w", err)
+	}
+	if requirements != nil {
+		specialSlots = append(specialSlots, *requirements)
+	}
+
+	return specialSlots, nil
+}
+
+// generateExternalSlot creates a special slot for content that lives outside the superblob (only the hash is recorded).
+func generateExternalSlot(h hash.Hash, slotType macho.SlotType, content []byte) SpecialSlot {
+	h.Write(content)
+	return SpecialSlot{Type: slotType, HashBytes: h.Sum(nil)}
 }
 
 func UpdateSuperBlobOffsetReferences(m *macho.File, numSbBytes uint64) error {
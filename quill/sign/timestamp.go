@@ -0,0 +1,259 @@
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/github/smimesign/ietf-cms/timestamp"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/pki/apple"
+	"github.com/anchore/quill/quill/pki/certchain"
+)
+
+const (
+	contentTypeTimestampQuery = "application/timestamp-query"
+	contentTypeTimestampReply = "application/timestamp-reply"
+
+	// maxTimestampResponseSize bounds the size of a timestamp server response (a token is typically a few KB)
+	maxTimestampResponseSize = 1 << 20
+)
+
+// TimestampOptions controls how RFC3161 timestamps are requested from the timestamp servers (see
+// pki.SigningMaterial.TimestampServers). Zero values are replaced with the defaults.
+type TimestampOptions struct {
+	// Attempts is the number of requests made to each server before falling back to the next server (default 3)
+	Attempts int
+	// Backoff is the delay before the first retry of a server, which doubles for each following retry (default 1s)
+	Backoff time.Duration
+	// Tolerance is how far the time asserted by the server may be from the local clock (default 10 minutes)
+	Tolerance time.Duration
+	// Trust is the store of certificates used to verify the timestamp server certificate chain. The system roots are
+	// always trusted in addition to this store (default is the embedded Apple certificates).
+	Trust certchain.Enumerator
+	// Client is the HTTP client used to make requests (default has a 30 second timeout)
+	Client *http.Client
+}
+
+// Timestamp describes the RFC3161 timestamp added to a signature.
+type Timestamp struct {
+	// Server is the URL of the timestamp server that issued the timestamp
+	Server string
+	// Time is the time asserted by the timestamp server
+	Time time.Time
+}
+
+func (o TimestampOptions) withDefaults() TimestampOptions {
+	if o.Attempts <= 0 {
+		o.Attempts = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.Tolerance <= 0 {
+		o.Tolerance = 10 * time.Minute
+	}
+	if o.Trust == nil {
+		o.Trust = apple.GetEmbeddedCertStore()
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return o
+}
+
+// requestTimestamp requests a timestamp over the signature of the given signer info, trying each server in order
+// (retrying each with backoff) until a valid timestamp token is returned. The returned attribute holds the token, to
+// be added to the unsigned attributes of the signer info. Requests (and the backoff between them) are abandoned once
+// the context is done.
+func requestTimestamp(ctx context.Context, si protocol.SignerInfo, servers []string, opts TimestampOptions) (protocol.Attribute, *Timestamp, error) {
+	opts = opts.withDefaults()
+
+	hash, err := si.Hash()
+	if err != nil {
+		return protocol.Attribute{}, nil, err
+	}
+
+	imprint, err := timestamp.NewMessageImprint(hash, bytes.NewReader(si.Signature))
+	if err != nil {
+		return protocol.Attribute{}, nil, err
+	}
+
+	var errs []error
+	for _, server := range servers {
+		attr, ts, err := requestTimestampFromServer(ctx, server, imprint, opts)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return protocol.Attribute{}, nil, fmt.Errorf("timestamp request abandoned: %w", ctxErr)
+		}
+		if err != nil {
+			log.WithFields("server", server, "error", err).Warn("unable to timestamp signature")
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+
+		log.WithFields("server", server, "time", ts.Time.Format(time.RFC3339)).Debug("timestamped signature")
+		return attr, ts, nil
+	}
+
+	return protocol.Attribute{}, nil, fmt.Errorf("unable to timestamp signature (RFC3161) with any of the %d server(s): %w", len(servers), errors.Join(errs...))
+}
+
+// requestTimestampFromServer requests a timestamp from a single server, retrying failed requests with backoff. Tokens
+// that fail validation are not retried, since the server is unlikely to return a different result.
+func requestTimestampFromServer(ctx context.Context, server string, imprint timestamp.MessageImprint, opts TimestampOptions) (protocol.Attribute, *Timestamp, error) {
+	var resp timestamp.Response
+	var req timestamp.Request
+	var err error
+	backoff := opts.Backoff
+	for attempt := 1; attempt <= opts.Attempts; attempt++ {
+		if attempt > 1 {
+			log.WithFields("server", server, "attempt", attempt, "error", err).Debug("retrying timestamp request")
+			select {
+			case <-ctx.Done():
+				return protocol.Attribute{}, nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		// each request has a new nonce, so that a replayed response cannot be accepted
+		req = timestamp.Request{
+			Version:        1,
+			CertReq:        true,
+			Nonce:          timestamp.GenerateNonce(),
+			MessageImprint: imprint,
+		}
+
+		resp, err = fetchTimestamp(ctx, opts.Client, server, req)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return protocol.Attribute{}, nil, fmt.Errorf("request failed after %d attempt(s): %w", opts.Attempts, err)
+	}
+
+	info, err := validateTimestamp(req, resp, opts)
+	if err != nil {
+		return protocol.Attribute{}, nil, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+
+	attr, err := protocol.NewAttribute(oid.AttributeTimeStampToken, resp.TimeStampToken)
+	if err != nil {
+		return protocol.Attribute{}, nil, err
+	}
+
+	return attr, &Timestamp{Server: server, Time: info.GenTime}, nil
+}
+
+// fetchTimestamp sends the timestamp request to the server, returning the response when a timestamp was granted.
+func fetchTimestamp(ctx context.Context, client *http.Client, server string, req timestamp.Request) (timestamp.Response, error) {
+	reqDER, err := asn1.Marshal(req)
+	if err != nil {
+		return timestamp.Response{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(reqDER))
+	if err != nil {
+		return timestamp.Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", contentTypeTimestampQuery)
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return timestamp.Response{}, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return timestamp.Response{}, fmt.Errorf("unexpected response status: %s", httpResp.Status)
+	}
+
+	if ct := httpResp.Header.Get("Content-Type"); ct != contentTypeTimestampReply {
+		return timestamp.Response{}, fmt.Errorf("unexpected response content type: %q", ct)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxTimestampResponseSize))
+	if err != nil {
+		return timestamp.Response{}, fmt.Errorf("unable to read response: %w", err)
+	}
+
+	resp, err := timestamp.ParseResponse(body)
+	if err != nil {
+		return timestamp.Response{}, fmt.Errorf("unable to parse response: %w", err)
+	}
+
+	// note: the server may reject the request when it is temporarily unable to (e.g. timeNotAvailable)
+	if err := resp.Status.GetError(); err != nil {
+		return timestamp.Response{}, fmt.Errorf("timestamp not granted: %w", err)
+	}
+
+	return resp, nil
+}
+
+// validateTimestamp checks that the timestamp token is over the requested message imprint (with the requested
+// nonce), that the token is signed by a timestamp server certificate which chains to a trusted root, and that the
+// asserted time is within the tolerance of the local clock.
+func validateTimestamp(req timestamp.Request, resp timestamp.Response, opts TimestampOptions) (*timestamp.Info, error) {
+	info, err := resp.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.MessageImprint.Equal(info.MessageImprint) {
+		return nil, fmt.Errorf("message imprint does not match the signature")
+	}
+
+	if info.Nonce == nil || req.Nonce.Cmp(info.Nonce) != 0 {
+		return nil, fmt.Errorf("nonce does not match the request")
+	}
+
+	tokenDER, err := asn1.Marshal(resp.TimeStampToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cms.ParseSignedData(tokenDER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token: %w", err)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		log.WithFields("error", err).Trace("unable to load system roots, only using the given trust store")
+		roots = x509.NewCertPool()
+	}
+	for _, p := range opts.Trust.RootPEMs() {
+		roots.AppendCertsFromPEM(p)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, p := range opts.Trust.IntermediatePEMs() {
+		intermediates.AppendCertsFromPEM(p)
+	}
+
+	if _, err := token.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}); err != nil {
+		return nil, fmt.Errorf("unable to verify token signature: %w", err)
+	}
+
+	if skew := time.Since(info.GenTime).Abs(); skew > opts.Tolerance {
+		return nil, fmt.Errorf("time %s is %s from the local clock (tolerance is %s)", info.GenTime.UTC().Format(time.RFC3339), skew.Round(time.Second), opts.Tolerance)
+	}
+
+	return &info, nil
+}
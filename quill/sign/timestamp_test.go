@@ -0,0 +1,308 @@
+package sign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/github/smimesign/ietf-cms/timestamp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/pki/certchain"
+)
+
+// testTSA is a local stand-in for an RFC3161 timestamp server, issuing tokens signed by a certificate from its own CA.
+type testTSA struct {
+	*httptest.Server
+	trust *certchain.Collection
+	// requests is the number of requests received
+	requests atomic.Int32
+	// unavailable is the number of requests to fail (with a 503) before granting timestamps (-1 fails all requests)
+	unavailable int32
+	// mutate alters the token content before it is signed (optional)
+	mutate func(*timestamp.Info)
+}
+
+func newTestTSA(t *testing.T, unavailable int32, mutate func(*timestamp.Info)) *testTSA {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-tsa-ca"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-tsa"},
+		NotBefore:    time.Now().Add(-24 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}, ca, key.Public(), caKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	trust := certchain.NewCollection()
+	require.NoError(t, trust.AddRoot(ca))
+
+	tsa := &testTSA{trust: trust, unavailable: unavailable, mutate: mutate}
+
+	tsa.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := tsa.requests.Add(1)
+		if tsa.unavailable < 0 || count <= tsa.unavailable {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var req timestamp.Request
+		_, err = asn1.Unmarshal(body, &req)
+		require.NoError(t, err)
+
+		info := timestamp.Info{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(int64(count)),
+			GenTime:        time.Now().UTC().Truncate(time.Second),
+			Nonce:          req.Nonce,
+		}
+		if tsa.mutate != nil {
+			tsa.mutate(&info)
+		}
+
+		infoDER, err := asn1.Marshal(info)
+		require.NoError(t, err)
+		eci, err := protocol.NewEncapsulatedContentInfo(oid.ContentTypeTSTInfo, infoDER)
+		require.NoError(t, err)
+		sd, err := protocol.NewSignedData(eci)
+		require.NoError(t, err)
+		require.NoError(t, sd.AddSignerInfo([]*x509.Certificate{cert}, key))
+		token, err := sd.ContentInfo()
+		require.NoError(t, err)
+
+		resp, err := asn1.Marshal(timestamp.Response{TimeStampToken: token})
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", contentTypeTimestampReply)
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(tsa.Close)
+
+	return tsa
+}
+
+func Test_requestTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		// tsas are the timestamp servers, in the order they are tried
+		tsas         []*testTSA
+		untrusted    bool
+		wantServer   int
+		wantRequests []int32
+		wantErr      require.ErrorAssertionFunc
+	}{
+		{
+			name:         "timestamp from the first server",
+			tsas:         []*testTSA{newTestTSA(t, 0, nil), newTestTSA(t, 0, nil)},
+			wantServer:   0,
+			wantRequests: []int32{1, 0},
+		},
+		{
+			name:         "retry an unavailable server",
+			tsas:         []*testTSA{newTestTSA(t, 2, nil)},
+			wantServer:   0,
+			wantRequests: []int32{3},
+		},
+		{
+			name:         "fall back to the next server",
+			tsas:         []*testTSA{newTestTSA(t, -1, nil), newTestTSA(t, 0, nil)},
+			wantServer:   1,
+			wantRequests: []int32{3, 1},
+		},
+		{
+			name:         "all servers unavailable",
+			tsas:         []*testTSA{newTestTSA(t, -1, nil), newTestTSA(t, -1, nil)},
+			wantRequests: []int32{3, 3},
+			wantErr:      require.Error,
+		},
+		{
+			name: "token over a different message imprint",
+			tsas: []*testTSA{newTestTSA(t, 0, func(info *timestamp.Info) {
+				info.MessageImprint.HashedMessage[0] ^= 0xff
+			})},
+			// the token is not retried, since the server is not expected to return a different result
+			wantRequests: []int32{1},
+			wantErr:      require.Error,
+		},
+		{
+			name: "token with a different nonce",
+			tsas: []*testTSA{newTestTSA(t, 0, func(info *timestamp.Info) {
+				info.Nonce = big.NewInt(42)
+			})},
+			wantRequests: []int32{1},
+			wantErr:      require.Error,
+		},
+		{
+			name: "token time outside of the tolerance",
+			tsas: []*testTSA{newTestTSA(t, 0, func(info *timestamp.Info) {
+				info.GenTime = info.GenTime.Add(-time.Hour)
+			})},
+			wantRequests: []int32{1},
+			wantErr:      require.Error,
+		},
+		{
+			name: "invalid token falls back to the next server",
+			tsas: []*testTSA{newTestTSA(t, 0, func(info *timestamp.Info) {
+				info.Nonce = big.NewInt(42)
+			}), newTestTSA(t, 0, nil)},
+			wantServer:   1,
+			wantRequests: []int32{1, 1},
+		},
+		{
+			name:         "token signed by an untrusted certificate",
+			tsas:         []*testTSA{newTestTSA(t, 0, nil)},
+			untrusted:    true,
+			wantRequests: []int32{1},
+			wantErr:      require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+
+			trust := certchain.NewCollection()
+			var servers []string
+			for _, tsa := range tt.tsas {
+				servers = append(servers, tsa.URL)
+				if !tt.untrusted {
+					require.NoError(t, trust.AddRootPEMs(tsa.trust.RootPEMs()...))
+				}
+			}
+
+			sm := newTestSigningMaterial(t)
+			sm.TimestampServers = servers
+			opts := cmsOptions{timestamp: TimestampOptions{Backoff: time.Millisecond, Trust: trust}}
+
+			cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+			blob, ts, err := generateCMS(context.Background(), sm, []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}, opts)
+
+			var requests []int32
+			for _, tsa := range tt.tsas {
+				requests = append(requests, tsa.requests.Load())
+			}
+			assert.Equal(t, tt.wantRequests, requests)
+
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			require.NotNil(t, ts)
+			assert.Equal(t, servers[tt.wantServer], ts.Server)
+			assert.WithinDuration(t, time.Now(), ts.Time, time.Minute)
+
+			// the token is over the signature, as an unsigned attribute
+			ci, err := protocol.ParseContentInfo(blob.Payload)
+			require.NoError(t, err)
+			sd, err := ci.SignedDataContent()
+			require.NoError(t, err)
+			require.Len(t, sd.SignerInfos, 1)
+			values, err := sd.SignerInfos[0].UnsignedAttrs.GetValues(oid.AttributeTimeStampToken)
+			require.NoError(t, err)
+			assert.Len(t, values, 1)
+		})
+	}
+}
+
+func Test_requestTimestamp_noServers(t *testing.T) {
+	cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+	blob, ts, err := generateCMS(context.Background(), newTestSigningMaterial(t), []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}, cmsOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, ts)
+
+	ci, err := protocol.ParseContentInfo(blob.Payload)
+	require.NoError(t, err)
+	sd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+	require.Len(t, sd.SignerInfos, 1)
+	assert.Empty(t, sd.SignerInfos[0].UnsignedAttrs)
+}
+
+func Test_requestTimestamp_cancelled(t *testing.T) {
+	// a server that never responds (until the test is over)
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(func() { close(release) })
+
+	tests := []struct {
+		name    string
+		servers []string
+	}{
+		{
+			name:    "during the backoff between retries",
+			servers: []string{newTestTSA(t, -1, nil).URL, newTestTSA(t, 0, nil).URL},
+		},
+		{
+			name:    "during a request",
+			servers: []string{hanging.URL, newTestTSA(t, 0, nil).URL},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newTestSigningMaterial(t)
+			sm.TimestampServers = tt.servers
+			opts := cmsOptions{timestamp: TimestampOptions{Backoff: time.Hour}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			start := time.Now()
+			cd := macho.NewBlob(macho.MagicCodedirectory, []byte("code directory"))
+			_, _, err := generateCMS(ctx, sm, []codeDirectory{{HashType: macho.HashTypeSha256, Blob: &cd}}, opts)
+
+			// the remaining servers are not tried once the context is done
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Less(t, time.Since(start), 10*time.Second)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package quill
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/archive"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+)
+
+// bundleExtensions are the directory extensions of bundles, which cannot be signed within an archive (the nested code
+// and resources of a bundle are sealed as a whole).
+var bundleExtensions = []string{".app", ".framework", ".bundle", ".plugin", ".appex", ".xpc", ".kext", ".systemextension"}
+
+// SignArchive signs every Mach-O binary within the tar, tar.gz or zip archive at cfg.Path, rewriting the archive to
+// cfg.OutputPath (or replacing cfg.Path) once all binaries are signed. The archive is streamed entry by entry: only the
+// binaries are extracted (one at a time), and all other entries are copied as-is. The order and metadata (modes,
+// modification times, ...) of every entry are kept. Each binary is signed with cfg, where the identity defaults to the
+// file name and cfg.PathRules may set the identity and entitlements by path within the archive. The signed binaries
+// are returned in archive order.
+func SignArchive(cfg SigningConfig) ([]SignedCode, error) {
+	if cfg.DetachedPath != "" {
+		return nil, fmt.Errorf("detached signatures are not supported when signing an archive")
+	}
+
+	if cfg.Requirements != "" {
+		return nil, fmt.Errorf("explicit requirements are not supported when signing an archive (the designated requirement of each binary is derived from its identity)")
+	}
+
+	output := cfg.Path
+	if cfg.OutputPath != "" {
+		output = cfg.OutputPath
+	}
+
+	info, err := os.Stat(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// write the signed archive next to the destination, which atomically replaces the destination only once all
+	// binaries are signed
+	out, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".quill-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file for signing: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Sign archive",
+			WhileRunning: "Signing archive",
+			OnSuccess:    "Signed archive",
+		},
+		cfg.Path,
+		-1,
+	)
+
+	var signed []SignedCode
+	err = archive.Rewrite(cfg.Path, out, isArchiveBinary, func(entry archive.Entry, p string) error {
+		if isMacho, err := macho.IsMachoFile(p); err != nil || !isMacho {
+			// not a Mach-O binary after all (e.g. a java class file), which is left as-is
+			return nil
+		}
+
+		// e.g. "./bin/cli" is matched against the path rules as "bin/cli"
+		rel := path.Clean(entry.Name)
+
+		if b, ok := archiveBundlePath(rel); ok {
+			return fmt.Errorf("unable to sign %q: signing bundles within an archive is not supported (sign %q before archiving it)", rel, b)
+		}
+
+		mon.Stage.Current = rel
+
+		c := cfg
+		c.Path = rel
+		c.OutputPath = ""
+		c.Identity = path.Base(rel)
+
+		if rule := matchPathRule(cfg.PathRules, rel); rule != nil {
+			log.WithFields("path", rel, "pattern", rule.Pattern).Debug("applying signing rule")
+			if rule.Identity != "" {
+				c.Identity = rule.Identity
+			}
+			if rule.Entitlements != "" {
+				c.Entitlements = rule.Entitlements
+			}
+		}
+
+		if err := signBinaryFile(c, p, sealedResources{}); err != nil {
+			return fmt.Errorf("unable to sign %q: %w", rel, err)
+		}
+
+		signed = append(signed, SignedCode{
+			Path:         rel,
+			Identity:     c.Identity,
+			Entitlements: c.Entitlements,
+		})
+		mon.Increment()
+		return nil
+	})
+	if err == nil && len(signed) == 0 {
+		err = fmt.Errorf("no Mach-O binaries found in %q", cfg.Path)
+	}
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		mon.SetError(err)
+		return nil, err
+	}
+
+	if err := os.Rename(out.Name(), output); err != nil {
+		err = fmt.Errorf("unable to write signed archive to %q: %w", output, err)
+		mon.SetError(err)
+		return nil, err
+	}
+
+	mon.Stage.Current = ""
+	mon.SetCompleted()
+
+	return signed, nil
+}
+
+// isArchiveSigned indicates if every Mach-O binary within the archive at the given path is signed.
+func isArchiveSigned(p string) (bool, error) {
+	var found int
+	allSigned := true
+	err := archive.Walk(p, isArchiveBinary, func(entry archive.Entry, tmp string) error {
+		if isMacho, err := macho.IsMachoFile(tmp); err != nil || !isMacho {
+			return nil
+		}
+		found++
+
+		signed, err := IsSigned(tmp)
+		if err != nil {
+			return fmt.Errorf("unable to check %q: %w", entry.Name, err)
+		}
+		if !signed {
+			log.WithFields("archive", p, "binary", entry.Name).Debug("binary within archive is not signed")
+			allSigned = false
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if found == 0 {
+		return false, fmt.Errorf("no Mach-O binaries found in %q", p)
+	}
+	return allSigned, nil
+}
+
+// isArchiveBinary indicates if the archive entry may be a Mach-O binary (which is confirmed once extracted).
+func isArchiveBinary(_ archive.Entry, prefix []byte) bool {
+	return macho.HasMachoMagic(prefix)
+}
+
+// archiveBundlePath returns the path of the bundle that the archive entry is within (if any).
+func archiveBundlePath(name string) (string, bool) {
+	elements := strings.Split(name, "/")
+	for i, element := range elements[:len(elements)-1] {
+		for _, ext := range bundleExtensions {
+			if strings.HasSuffix(element, ext) {
+				return strings.Join(elements[:i+1], "/"), true
+			}
+		}
+	}
+	return "", false
+}
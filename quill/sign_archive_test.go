@@ -0,0 +1,132 @@
+package quill
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+	"github.com/anchore/quill/quill/archive"
+	"github.com/anchore/quill/quill/macho"
+)
+
+type testArchiveFile struct {
+	name     string
+	contents []byte
+}
+
+// newTestTarball writes a tar.gz holding the given files (in order), returning its path.
+func newTestTarball(t *testing.T, files ...testArchiveFile) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "release.tar.gz")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0755,
+			ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			Size:    int64(len(file.contents)),
+		}))
+		_, err := tw.Write(file.contents)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return p
+}
+
+func TestSignArchive(t *testing.T) {
+	hello, err := os.ReadFile(test.Asset(t, "hello"))
+	require.NoError(t, err)
+
+	archivePath := newTestTarball(t,
+		testArchiveFile{name: "README.md", contents: []byte("read me")},
+		testArchiveFile{name: "bin/hello", contents: hello},
+	)
+
+	cfg, err := NewSigningConfigFromPEMs(archivePath, "", "", "", false)
+	require.NoError(t, err)
+
+	signed, err := SignArchive(*cfg.WithPathRules(PathRule{Pattern: "bin/*", Identity: "com.example.hello"}))
+	require.NoError(t, err)
+	assert.Equal(t, []SignedCode{{Path: "bin/hello", Identity: "com.example.hello"}}, signed)
+
+	var found []string
+	err = archive.Walk(archivePath, isArchiveBinary, func(entry archive.Entry, p string) error {
+		m, err := macho.NewReadOnlyFile(p)
+		require.NoError(t, err)
+		defer m.Close()
+
+		assert.True(t, m.HasCodeSigningCmd())
+		assert.Equal(t, os.FileMode(0755), entry.Mode)
+		found = append(found, entry.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bin/hello"}, found)
+
+	// ad-hoc signatures have no CMS signature, so will not pass notarization
+	isSigned, err := IsSigned(archivePath)
+	require.NoError(t, err)
+	assert.False(t, isSigned)
+}
+
+func TestSignArchive_noBinaries(t *testing.T) {
+	archivePath := newTestTarball(t, testArchiveFile{name: "README.md", contents: []byte("read me")})
+	before, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	_, err = SignArchive(SigningConfig{Path: archivePath})
+	require.ErrorContains(t, err, "no Mach-O binaries found")
+
+	// the archive is untouched and no temp files are left behind
+	after, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	entries, err := os.ReadDir(filepath.Dir(archivePath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestSignArchive_unsupportedOptions(t *testing.T) {
+	archivePath := newTestTarball(t, testArchiveFile{name: "README.md", contents: []byte("read me")})
+
+	_, err := SignArchive(SigningConfig{Path: archivePath, Requirements: `designated => identifier "com.acme.cli"`})
+	require.ErrorContains(t, err, "requirements")
+
+	_, err = SignArchive(SigningConfig{Path: archivePath, DetachedPath: archivePath + ".sig"})
+	require.ErrorContains(t, err, "detached")
+}
+
+func Test_archiveBundlePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		want     string
+		inBundle bool
+	}{
+		{name: "bin/cli"},
+		{name: "lib/libquill.dylib"},
+		{name: "cli.app"},
+		{name: "Acme.app/Contents/MacOS/acme", want: "Acme.app", inBundle: true},
+		{name: "lib/Acme.framework/Versions/A/Acme", want: "lib/Acme.framework", inBundle: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := archiveBundlePath(tt.name)
+			assert.Equal(t, tt.inBundle, ok)
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package quill
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/bundle"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
+)
+
+// cdHashSize is the length of the (truncated) code directory hash used to identify signed code
+const cdHashSize = 20
+
+// sealedResources is the bundle content that is bound to the main executable of a bundle via special slots.
+type sealedResources struct {
+	InfoPlist     []byte
+	CodeResources []byte
+}
+
+func signBundle(cfg SigningConfig) error {
+	b, err := bundle.Open(cfg.Path)
+	if err != nil {
+		return fmt.Errorf("unable to read bundle: %w", err)
+	}
+
+	// the default identity is derived from the path, however, bundles already have a canonical identity
+	if cfg.Identity == "" || cfg.Identity == path.Base(cfg.Path) {
+		cfg.Identity = b.Identifier
+	}
+
+	dest := cfg.Path
+	if cfg.OutputPath != "" {
+		dest = cfg.OutputPath
+		if _, err := os.Lstat(dest); err == nil {
+			return fmt.Errorf("output path %q already exists", dest)
+		}
+	}
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Sign bundle",
+			WhileRunning: "Signing bundle",
+			OnSuccess:    "Signed bundle",
+		},
+		dest,
+		-1,
+	)
+
+	err = signStagedBundle(cfg, dest)
+	if err != nil {
+		mon.SetError(err)
+	} else {
+		mon.SetCompleted()
+	}
+	return err
+}
+
+// signStagedBundle signs a copy of the bundle next to the destination, which replaces the destination only once the
+// whole bundle is signed. Nested code and the sealed resources are written before the main executable is signed, so
+// signing in place would leave a broken seal behind when signing fails part way.
+func signStagedBundle(cfg SigningConfig, dest string) error {
+	staging, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".quill-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir for signing: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	// the bundle keeps its name within the staging dir, since the layout of a bundle depends on its extension
+	staged := filepath.Join(staging, filepath.Base(dest))
+	if err := copyBundle(cfg.Path, staged); err != nil {
+		return fmt.Errorf("unable to copy bundle for signing: %w", err)
+	}
+
+	b, err := bundle.Open(staged)
+	if err != nil {
+		return fmt.Errorf("unable to read bundle: %w", err)
+	}
+
+	c := cfg
+	c.Path = staged
+	c.OutputPath = ""
+	if err := signBundleContents(c, b); err != nil {
+		return err
+	}
+
+	return replaceBundle(staged, dest, filepath.Join(staging, "original"))
+}
+
+// replaceBundle moves the signed bundle to the destination. An existing bundle at the destination is first moved to the
+// given backup path, and is restored when the signed bundle can not be moved into place.
+func replaceBundle(signed, dest, backup string) error {
+	if _, err := os.Lstat(dest); err != nil {
+		if err := os.Rename(signed, dest); err != nil {
+			return fmt.Errorf("unable to write signed bundle to %q: %w", dest, err)
+		}
+		return nil
+	}
+
+	if err := os.Rename(dest, backup); err != nil {
+		return fmt.Errorf("unable to replace bundle %q: %w", dest, err)
+	}
+	if err := os.Rename(signed, dest); err != nil {
+		if restoreErr := os.Rename(backup, dest); restoreErr != nil {
+			log.WithFields("bundle", dest, "backup", backup, "error", restoreErr).Error("unable to restore the original bundle")
+		}
+		return fmt.Errorf("unable to write signed bundle to %q: %w", dest, err)
+	}
+	return nil
+}
+
+// signBundleContents signs all nested code (inside-out), seals the bundle resources, and finally signs the main executable.
+func signBundleContents(cfg SigningConfig, b *bundle.Bundle) error {
+	log.WithFields("bundle", b.Path, "identity", cfg.Identity).Info("signing bundle")
+
+	if b.ExecutablePath == "" {
+		return fmt.Errorf("bundle %q has no main executable (CFBundleExecutable), which is not supported", b.Path)
+	}
+
+	nested, err := b.NestedCode()
+	if err != nil {
+		return fmt.Errorf("unable to find nested code: %w", err)
+	}
+
+	for _, p := range nested {
+		c := cfg
+		c.Path = p
+		// entitlements and explicit requirements are only applicable to the main executable
+		c.Entitlements = ""
+		c.Requirements = ""
+
+		if bundle.IsBundle(p) {
+			nb, err := bundle.Open(p)
+			if err != nil {
+				return fmt.Errorf("unable to read nested bundle: %w", err)
+			}
+			c.Identity = nb.Identifier
+			if err := signBundleContents(c, nb); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.Identity = path.Base(p)
+		log.WithFields("binary", p).Debug("signing nested code")
+		if err := signBinary(c, sealedResources{}); err != nil {
+			return fmt.Errorf("unable to sign nested code %q: %w", p, err)
+		}
+	}
+
+	codeResources, err := b.CodeResources(describeNestedCode)
+	if err != nil {
+		return err
+	}
+
+	if err := b.WriteCodeResources(codeResources); err != nil {
+		return err
+	}
+
+	infoPlist, err := os.ReadFile(b.InfoPlistPath)
+	if err != nil {
+		return fmt.Errorf("unable to read Info.plist: %w", err)
+	}
+
+	c := cfg
+	c.Path = b.ExecutablePath
+	return signBinary(c, sealedResources{
+		InfoPlist:     infoPlist,
+		CodeResources: codeResources,
+	})
+}
+
+// describeNestedCode returns the cdhash and designated requirement of already-signed nested code (a bundle or binary).
+func describeNestedCode(p string) (*bundle.NestedCode, error) {
+	if bundle.IsBundle(p) {
+		b, err := bundle.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		p = b.ExecutablePath
+	}
+
+	// for universal binaries the first architecture is used to represent the code (as codesign does)
+	files, err := macho.OpenThinFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files[1:] {
+		f.Close()
+	}
+
+	m := files[0]
+	defer m.Close()
+
+	// the cdhash is the hash of the primary code directory (using the digest algorithm of that code directory)
+	cdBytes, err := m.CDBytes(macho.SigningOrder, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cd, err := macho.ParseCodeDirectory(cdBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher, err := cd.HashType.Hasher()
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(cdBytes)
+	cdHash := hasher.Sum(nil)[:cdHashSize]
+
+	requirement, err := designatedRequirement(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if requirement == "" {
+		// ad-hoc signed code has no designated requirement, so it can only be identified by the cdhash
+		requirement = fmt.Sprintf("cdhash H%q", hex.EncodeToString(cdHash))
+	}
+
+	return &bundle.NestedCode{
+		CDHash:      cdHash,
+		Requirement: requirement,
+	}, nil
+}
+
+// designatedRequirement returns the designated requirement (as requirement language text) embedded within the
+// signature of the given binary, or an empty string if there is none.
+func designatedRequirement(m *macho.File) (string, error) {
+	b, err := m.BlobBytes(macho.SigningOrder, macho.CsSlotRequirements)
+	if err != nil {
+		if errors.Is(err, macho.ErrNoBlob) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	reqs, err := sign.DecompileRequirements(b)
+	if err != nil {
+		return "", err
+	}
+
+	for _, req := range reqs {
+		if req.Type == macho.DesignatedRequirementType {
+			return req.Text, nil
+		}
+	}
+	return "", nil
+}
+
+// copyBundle copies the bundle directory tree to the given destination (which must not exist), preserving file modes
+// and symlinks.
+func copyBundle(src, dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("output path %q already exists", dest)
+	}
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(p, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dest string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,180 @@
+package quill
+
+import (
+	"debug/macho"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+
+	"github.com/anchore/quill/internal/test"
+	quillMacho "github.com/anchore/quill/quill/macho"
+)
+
+// testInfoPlist is the Info.plist of the bundles made by writeTestBundle.
+var testInfoPlist = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.example.hello</string>
+	<key>CFBundleExecutable</key>
+	<string>hello</string>
+</dict>
+</plist>
+`)
+
+// writeTestBundle writes an app bundle with a main executable (with the given header padding), a nested helper and a
+// resource, returning the paths of the main executable and the helper.
+func writeTestBundle(t *testing.T, app string, exePadding uint32) (string, string) {
+	t.Helper()
+	contents := filepath.Join(app, "Contents")
+
+	install := func(src, rel string) string {
+		by, err := os.ReadFile(src)
+		require.NoError(t, err)
+		dest := filepath.Join(contents, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0755))
+		require.NoError(t, os.WriteFile(dest, by, 0755))
+		return dest
+	}
+
+	exe := install(test.Macho32(t, macho.Cpu386, exePadding), "MacOS/hello")
+	helper := install(test.Macho32(t, macho.CpuArm, 0x100), "Helpers/helper")
+	require.NoError(t, os.WriteFile(filepath.Join(contents, "Info.plist"), testInfoPlist, 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(contents, "Resources"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contents, "Resources", "greeting.txt"), []byte("hello\n"), 0644))
+	return exe, helper
+}
+
+func TestSign_bundle(t *testing.T) {
+	app := filepath.Join(t.TempDir(), "Hello.app")
+	contents := filepath.Join(app, "Contents")
+	exe, helper := writeTestBundle(t, app, 0x100)
+
+	cfg, err := NewSigningConfigFromPEMs(app, "", "", "", false)
+	require.NoError(t, err)
+	require.NoError(t, Sign(*cfg))
+
+	// the resources are sealed...
+	codeResources, err := os.ReadFile(filepath.Join(contents, "_CodeSignature", "CodeResources"))
+	require.NoError(t, err)
+
+	var sealed struct {
+		Files2 map[string]map[string]any `plist:"files2"`
+	}
+	_, err = plist.Unmarshal(codeResources, &sealed)
+	require.NoError(t, err)
+	assert.Contains(t, sealed.Files2, "Resources/greeting.txt")
+	assert.NotContains(t, sealed.Files2, "MacOS/hello", "the main executable is not a sealed resource")
+
+	// ...along with the nested code, which must have been signed before the resources were sealed (the sealed cdhash
+	// is that of the final signature of the nested code)
+	cdHash := func(p string) []byte {
+		m, err := quillMacho.NewFile(p)
+		require.NoError(t, err)
+		defer m.Close()
+
+		cdBytes, err := m.CDBytes(quillMacho.SigningOrder, 0)
+		require.NoError(t, err)
+		cd, err := quillMacho.ParseCodeDirectory(cdBytes)
+		require.NoError(t, err)
+		hasher, err := cd.HashType.Hasher()
+		require.NoError(t, err)
+		hasher.Write(cdBytes)
+		return hasher.Sum(nil)[:cdHashSize]
+	}
+
+	nested := sealed.Files2["Helpers/helper"]
+	require.NotNil(t, nested, "nested code is sealed")
+	assert.Equal(t, cdHash(helper), nested["cdhash"])
+	assert.Equal(t, fmt.Sprintf(`cdhash H"%x"`, cdHash(helper)), nested["requirement"])
+
+	// the main executable binds the Info.plist and the sealed resources via special slots
+	m, err := quillMacho.NewFile(exe)
+	require.NoError(t, err)
+	defer m.Close()
+
+	cdBytes, err := m.CDBytes(quillMacho.SigningOrder, 0)
+	require.NoError(t, err)
+	cd, err := quillMacho.ParseCodeDirectory(cdBytes)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.hello", cd.ID)
+
+	digest := func(b []byte) []byte {
+		hasher, err := cd.HashType.Hasher()
+		require.NoError(t, err)
+		hasher.Write(b)
+		return hasher.Sum(nil)
+	}
+	assert.Equal(t, digest(testInfoPlist), cd.SpecialSlotHashes[quillMacho.CsSlotInfoslot])
+	assert.Equal(t, digest(codeResources), cd.SpecialSlotHashes[quillMacho.CsSlotResourcedir])
+
+	for _, p := range []string{exe, helper} {
+		result, err := Verify(*NewVerifyConfig(p).WithAdhocAllowed(true))
+		require.NoError(t, err)
+		assert.True(t, result.IsValid(), "%s failures: %+v", p, result.Failures)
+	}
+}
+
+func TestSign_bundleFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		output bool
+	}{
+		{
+			name: "in place",
+		},
+		{
+			name:   "to an output path",
+			output: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			app := filepath.Join(dir, "Hello.app")
+			// the nested helper is signed, but the main executable has no room for the signature
+			writeTestBundle(t, app, 0)
+
+			snapshot := func() map[string][]byte {
+				files := make(map[string][]byte)
+				require.NoError(t, filepath.WalkDir(app, func(p string, d fs.DirEntry, err error) error {
+					require.NoError(t, err)
+					if !d.IsDir() {
+						by, err := os.ReadFile(p)
+						require.NoError(t, err)
+						files[p] = by
+					}
+					return nil
+				}))
+				return files
+			}
+			original := snapshot()
+
+			cfg, err := NewSigningConfigFromPEMs(app, "", "", "", false)
+			require.NoError(t, err)
+			if tt.output {
+				cfg.WithOutputPath(filepath.Join(dir, "Signed.app"))
+			}
+			require.ErrorContains(t, Sign(*cfg), "relink with -headerpad")
+
+			// the bundle is untouched (the helper is not signed, nor are the resources sealed)...
+			assert.Equal(t, original, snapshot())
+
+			// ...and nothing is left behind next to it
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			assert.Equal(t, []string{"Hello.app"}, names)
+		})
+	}
+}
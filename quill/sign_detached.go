@@ -0,0 +1,126 @@
+package quill
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/bundle"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
+)
+
+// signDetached writes a detached signature for the binary to cfg.DetachedPath without modifying the binary. For a thin
+// binary this is the embedded signature superblob, while for a universal binary this is a detached signature
+// superblob holding the embedded signature of each architecture (keyed by CPU type).
+func signDetached(cfg SigningConfig) error {
+	if bundle.IsBundle(cfg.Path) {
+		return fmt.Errorf("detached signatures are not supported for bundles")
+	}
+
+	if cfg.OutputPath != "" {
+		return fmt.Errorf("an output path cannot be used with a detached signature (the binary is not modified)")
+	}
+
+	if !cfg.Edits.IsEmpty() {
+		return fmt.Errorf("load commands cannot be edited with a detached signature (the binary is not modified)")
+	}
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Sign binary (detached)",
+			WhileRunning: "Signing binary (detached)",
+			OnSuccess:    "Signed binary (detached)",
+		},
+		cfg.Path,
+		-1,
+	)
+
+	sigBytes, err := detachedSignature(cfg)
+	if err != nil {
+		mon.SetError(err)
+		return err
+	}
+
+	if err := os.WriteFile(cfg.DetachedPath, sigBytes, 0644); err != nil { //nolint:gosec // the signature is not a secret
+		err = fmt.Errorf("unable to write detached signature to %q: %w", cfg.DetachedPath, err)
+		mon.SetError(err)
+		return err
+	}
+
+	mon.SetCompleted()
+	return nil
+}
+
+func detachedSignature(cfg SigningConfig) ([]byte, error) {
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	universal := macho.IsUniversalBinary(f)
+	f.Close()
+
+	files, err := macho.OpenThinFiles(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, m := range files {
+			m.Close()
+		}
+	}()
+
+	if !universal {
+		s, err := generateDetachedSignature(cfg, files[0])
+		if err != nil {
+			return nil, err
+		}
+		return s.Signature.Bytes(), nil
+	}
+
+	log.WithFields("binary", cfg.Path).Info("creating detached signature for multi-arch binary")
+
+	var sigs []macho.ArchSignature
+	for _, m := range files {
+		s, err := generateDetachedSignature(cfg, m)
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign %s slice: %w", macho.ArchName(m.Cpu, m.SubCpu), err)
+		}
+		sigs = append(sigs, *s)
+	}
+
+	return macho.NewDetachedSignature(sigs)
+}
+
+// generateDetachedSignature creates the embedded signature superblob for a thin binary without modifying it. Since
+// there is no LC_CODE_SIGNATURE load command to add, the code limit is the end of the (unsigned) file and a single
+// pass is enough.
+func generateDetachedSignature(cfg SigningConfig, m *macho.File) (*macho.ArchSignature, error) {
+	log.WithFields("binary", cfg.Path).Info("creating detached signature")
+
+	opts, err := signingOptions(cfg, sealedResources{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyPreservedMetadata(&opts, m, cfg.PreserveMetadata); err != nil {
+		return nil, err
+	}
+
+	sb, err := sign.GenerateSigningSuperBlob(context.Background(), m, opts, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signature: %w", err)
+	}
+
+	reportTimestamp(sb.Timestamp)
+
+	sig, err := macho.NewCodeSignature(sb.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &macho.ArchSignature{Cpu: m.Cpu, SubCpu: m.SubCpu, Signature: sig}, nil
+}
@@ -0,0 +1,433 @@
+package quill
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/bundle"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+)
+
+// PathRule sets the signing identity and entitlements of the code within a directory (or archive) whose path matches
+// the pattern.
+type PathRule struct {
+	// Pattern is matched against the slash-separated path within the directory (or archive) being signed (see
+	// path.Match), where a "**" path element matches any number of directories (e.g. "**/*.dylib")
+	Pattern string
+	// Identity is the identifier of the matching code (default is the file name, or the identifier of a bundle)
+	Identity string
+	// Entitlements is the path to the entitlements of the matching code (default is SigningConfig.Entitlements)
+	Entitlements string
+}
+
+// SignedCode describes code signed as part of a directory.
+type SignedCode struct {
+	// Path is the path of the binary (or bundle) relative to the directory
+	Path         string
+	Identity     string
+	Entitlements string
+	// Bundle indicates that the code is a bundle, which is signed as a whole
+	Bundle bool
+}
+
+// directoryCode is a binary (or bundle) found within a directory being signed.
+type directoryCode struct {
+	path string
+	rel  string
+	// bundle indicates that the path is a bundle directory
+	bundle bool
+	// executable is the main executable of a bundle, whose linkage is that of the bundle (empty if there is none)
+	executable string
+	// id is the install name of the dylib (LC_ID_DYLIB), if any
+	id string
+	// dylibs are the install names of the dylibs loaded by the binary
+	dylibs []string
+	// rpaths are the run path search paths of the binary
+	rpaths []string
+}
+
+// SignDirectory signs every Mach-O binary (and bundle) found within the directory at cfg.Path. Dependencies are
+// signed before the code that loads them (following LC_LOAD_DYLIB, for a bundle that of its main executable), and
+// otherwise bundles are signed before any loose binaries. Each binary is signed with cfg, where the identity defaults
+// to the file name and cfg.PathRules may set the identity and entitlements by path. The signed code is returned in the
+// order it was signed.
+func SignDirectory(cfg SigningConfig) ([]SignedCode, error) {
+	if cfg.DetachedPath != "" {
+		return nil, fmt.Errorf("detached signatures are not supported when signing a directory")
+	}
+
+	if cfg.Requirements != "" {
+		return nil, fmt.Errorf("explicit requirements are not supported when signing a directory (the designated requirement of each binary is derived from its identity)")
+	}
+
+	if cfg.OutputPath != "" {
+		// sign a copy of the directory at the output path (each binary within is still replaced atomically)
+		if err := copyBundle(cfg.Path, cfg.OutputPath); err != nil {
+			return nil, err
+		}
+		cfg.Path = cfg.OutputPath
+		cfg.OutputPath = ""
+	}
+
+	found, err := findDirectoryCode(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find binaries to sign: %w", err)
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no Mach-O binaries found in %q", cfg.Path)
+	}
+
+	ordered := orderDirectoryCode(found)
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Sign directory",
+			WhileRunning: "Signing directory",
+			OnSuccess:    "Signed directory",
+		},
+		cfg.Path,
+		len(ordered),
+	)
+
+	var signed []SignedCode
+	for _, code := range ordered {
+		mon.Stage.Current = code.rel
+
+		c := cfg
+		c.Path = code.path
+		c.Identity = path.Base(code.rel)
+		if code.bundle {
+			// the bundle identifier is used unless a rule says otherwise
+			c.Identity = ""
+		}
+
+		if rule := matchPathRule(cfg.PathRules, code.rel); rule != nil {
+			log.WithFields("path", code.rel, "pattern", rule.Pattern).Debug("applying signing rule")
+			if rule.Identity != "" {
+				c.Identity = rule.Identity
+			}
+			if rule.Entitlements != "" {
+				c.Entitlements = rule.Entitlements
+			}
+		}
+
+		if code.bundle {
+			err = signBundle(c)
+		} else {
+			err = signBinary(c, sealedResources{})
+		}
+		if err != nil {
+			err = fmt.Errorf("unable to sign %q: %w", code.rel, err)
+			mon.SetError(err)
+			return signed, err
+		}
+
+		if c.Identity == "" {
+			c.Identity = bundleIdentifier(code.path)
+		}
+
+		signed = append(signed, SignedCode{
+			Path:         code.rel,
+			Identity:     c.Identity,
+			Entitlements: c.Entitlements,
+			Bundle:       code.bundle,
+		})
+		mon.Increment()
+	}
+
+	mon.Stage.Current = ""
+	mon.SetCompleted()
+
+	return signed, nil
+}
+
+// findDirectoryCode walks the directory for Mach-O binaries and bundles. Symlinks are not followed (the target is
+// signed where it is found, if it is within the directory) and bundles are not walked into, since they are signed as
+// a whole.
+func findDirectoryCode(root string) ([]directoryCode, error) {
+	var found []directoryCode
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.IsDir():
+			if p != root && bundle.IsBundle(p) {
+				code, err := newDirectoryBundle(p, rel)
+				if err != nil {
+					return fmt.Errorf("unable to read %q: %w", rel, err)
+				}
+				log.WithFields("bundle", rel, "dylibs", len(code.dylibs)).Debug("found bundle")
+				found = append(found, *code)
+				return filepath.SkipDir
+			}
+			return nil
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		isMacho, err := macho.IsMachoFile(p)
+		if err != nil {
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				return err
+			}
+			// not a Mach-O binary (or too short to be one)
+			return nil
+		}
+		if !isMacho {
+			return nil
+		}
+
+		code, err := newDirectoryBinary(p, rel)
+		if err != nil {
+			return fmt.Errorf("unable to read %q: %w", rel, err)
+		}
+		log.WithFields("binary", rel, "dylibs", len(code.dylibs)).Debug("found binary")
+		found = append(found, *code)
+		return nil
+	})
+	return found, err
+}
+
+// newDirectoryBinary reads the linkage of the binary (for a universal binary, the linkage of all architectures).
+func newDirectoryBinary(p, rel string) (*directoryCode, error) {
+	code := directoryCode{path: p, rel: rel}
+	if err := code.readLinkage(p); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// newDirectoryBundle reads the linkage of the main executable of the bundle, so that the bundle is signed after the
+// dylibs its executable loads (and before the code that loads its executable, e.g. a framework). An invalid bundle is
+// returned without any linkage, failing once it is signed.
+func newDirectoryBundle(p, rel string) (*directoryCode, error) {
+	code := directoryCode{path: p, rel: rel, bundle: true}
+
+	b, err := bundle.Open(p)
+	if err != nil {
+		log.WithFields("bundle", rel, "error", err).Debug("unable to read bundle")
+		return &code, nil
+	}
+	if b.ExecutablePath == "" {
+		return &code, nil
+	}
+
+	code.executable = b.ExecutablePath
+	if err := code.readLinkage(b.ExecutablePath); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// readLinkage reads the dylib ID, loaded dylibs and run paths of the given binary.
+func (code *directoryCode) readLinkage(p string) error {
+	files, err := macho.OpenThinFiles(p)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range files {
+		if id := m.DylibID(); id != "" {
+			code.id = id
+		}
+		code.dylibs = appendUnique(code.dylibs, m.LoadedDylibs()...)
+		code.rpaths = appendUnique(code.rpaths, m.Rpaths()...)
+
+		m.Close()
+	}
+	return nil
+}
+
+// binaryPath is the path of the binary (for a bundle, its main executable) that dylibs are loaded from.
+func (code directoryCode) binaryPath() string {
+	if code.executable != "" {
+		return code.executable
+	}
+	return code.path
+}
+
+// orderDirectoryCode orders the code so that dylibs are signed before the binaries that load them, where a bundle is
+// ordered by the linkage of its main executable. Code without a dependency relationship is ordered with bundles first,
+// then by path, and dependency cycles are broken in the same order.
+func orderDirectoryCode(found []directoryCode) []directoryCode {
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].bundle != found[j].bundle {
+			return found[i].bundle
+		}
+		return found[i].rel < found[j].rel
+	})
+
+	byPath := make(map[string]int)
+	byID := make(map[string]int)
+	for i, code := range found {
+		byPath[filepath.Clean(code.binaryPath())] = i
+		if code.id != "" {
+			byID[code.id] = i
+		}
+	}
+
+	// dependents[i] are the binaries that load binary i, and pending[i] is the number of dylibs binary i loads that
+	// have not been signed yet
+	dependents := make([][]int, len(found))
+	pending := make([]int, len(found))
+	for i, code := range found {
+		seen := make(map[int]bool)
+		for _, dylib := range code.dylibs {
+			dep, ok := resolveDylib(code, dylib, byPath, byID)
+			if !ok || dep == i || seen[dep] {
+				continue
+			}
+			log.WithFields("binary", code.rel, "dylib", found[dep].rel).Trace("found dependency")
+			seen[dep] = true
+			dependents[dep] = append(dependents[dep], i)
+			pending[i]++
+		}
+	}
+
+	done := make([]bool, len(found))
+	ordered := make([]directoryCode, 0, len(found))
+	for len(ordered) < len(found) {
+		// sign the first (by path) code that has no unsigned dependencies, or when there is a cycle, the first unsigned
+		next := -1
+		for i := range found {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			for i := range found {
+				if !done[i] {
+					next = i
+					break
+				}
+			}
+			log.WithFields("binary", found[next].rel).Warn("dylib dependency cycle found, signing in path order")
+		}
+
+		done[next] = true
+		ordered = append(ordered, found[next])
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+		}
+	}
+
+	return ordered
+}
+
+// resolveDylib finds the code within the directory for the install name of a dylib loaded by the given binary. The
+// @loader_path, @executable_path (assumed to be the directory of the binary) and @rpath prefixes are resolved to
+// paths within the directory, otherwise the install name must match the LC_ID_DYLIB of a dylib within the directory.
+func resolveDylib(code directoryCode, dylib string, byPath, byID map[string]int) (int, bool) {
+	loaderDir := filepath.Dir(code.binaryPath())
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(dylib, "@loader_path/"), strings.HasPrefix(dylib, "@executable_path/"):
+		candidates = append(candidates, filepath.Join(loaderDir, dylib[strings.Index(dylib, "/")+1:]))
+	case strings.HasPrefix(dylib, "@rpath/"):
+		rest := strings.TrimPrefix(dylib, "@rpath/")
+		for _, rpath := range code.rpaths {
+			for _, prefix := range []string{"@loader_path", "@executable_path"} {
+				if rpath == prefix || strings.HasPrefix(rpath, prefix+"/") {
+					rpath = filepath.Join(loaderDir, strings.TrimPrefix(rpath, prefix))
+					break
+				}
+			}
+			candidates = append(candidates, filepath.Join(rpath, rest))
+		}
+	}
+
+	for _, c := range candidates {
+		if i, ok := byPath[filepath.Clean(c)]; ok {
+			return i, true
+		}
+	}
+
+	i, ok := byID[dylib]
+	return i, ok
+}
+
+// matchPathRule returns the first rule with a pattern matching the given (slash-separated, relative) path.
+func matchPathRule(rules []PathRule, rel string) *PathRule {
+	for i := range rules {
+		if matchPathPattern(rules[i].Pattern, rel) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// matchPathPattern matches the slash-separated path against the pattern element by element, where a "**" element
+// matches any number of path elements (including none).
+func matchPathPattern(pattern, p string) bool {
+	return matchPathElements(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(p, "/"))
+}
+
+func matchPathElements(pattern, elements []string) bool {
+	if len(pattern) == 0 {
+		return len(elements) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elements); i++ {
+			if matchPathElements(pattern[1:], elements[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(elements) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(pattern[0], elements[0]); err != nil || !ok {
+		return false
+	}
+	return matchPathElements(pattern[1:], elements[1:])
+}
+
+// bundleIdentifier returns the identifier of the bundle at the given path (empty if it cannot be read).
+func bundleIdentifier(p string) string {
+	b, err := bundle.Open(p)
+	if err != nil {
+		return ""
+	}
+	return b.Identifier
+}
+
+func appendUnique(values []string, more ...string) []string {
+	for _, v := range more {
+		if !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// IsPlainDirectory indicates if the path is a directory that is not a bundle.
+func IsPlainDirectory(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.IsDir() && !bundle.IsBundle(p)
+}
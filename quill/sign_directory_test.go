@@ -0,0 +1,180 @@
+package quill
+
+import (
+	"debug/macho"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+	quillMacho "github.com/anchore/quill/quill/macho"
+)
+
+func Test_orderDirectoryCode(t *testing.T) {
+	root := "/release"
+	tests := []struct {
+		name  string
+		found []directoryCode
+		want  []string
+	}{
+		{
+			name: "dependencies are signed first",
+			found: []directoryCode{
+				{path: root + "/bin/cli", rel: "bin/cli", rpaths: []string{"@executable_path/../lib"}, dylibs: []string{"@rpath/libb.dylib", "/usr/lib/libSystem.B.dylib"}},
+				{path: root + "/lib/liba.dylib", rel: "lib/liba.dylib", id: "@rpath/liba.dylib"},
+				{path: root + "/lib/libb.dylib", rel: "lib/libb.dylib", id: "@rpath/libb.dylib", dylibs: []string{"@loader_path/libc.dylib"}},
+				{path: root + "/lib/libc.dylib", rel: "lib/libc.dylib"},
+			},
+			want: []string{"lib/liba.dylib", "lib/libc.dylib", "lib/libb.dylib", "bin/cli"},
+		},
+		{
+			name: "dependencies resolved by install name",
+			found: []directoryCode{
+				{path: root + "/a", rel: "a", dylibs: []string{"/opt/acme/lib/libz.dylib"}},
+				{path: root + "/z/libz.dylib", rel: "z/libz.dylib", id: "/opt/acme/lib/libz.dylib"},
+			},
+			want: []string{"z/libz.dylib", "a"},
+		},
+		{
+			name: "bundles are signed first",
+			found: []directoryCode{
+				{path: root + "/a", rel: "a"},
+				{path: root + "/Plugin.bundle", rel: "Plugin.bundle", bundle: true},
+			},
+			want: []string{"Plugin.bundle", "a"},
+		},
+		{
+			name: "bundles are signed after the dylibs their executable loads",
+			found: []directoryCode{
+				{path: root + "/a", rel: "a"},
+				{path: root + "/Plugin.bundle", rel: "Plugin.bundle", bundle: true, executable: root + "/Plugin.bundle/Contents/MacOS/plugin", rpaths: []string{"@loader_path/../../../lib"}, dylibs: []string{"@rpath/libdep.dylib"}},
+				{path: root + "/lib/libdep.dylib", rel: "lib/libdep.dylib", id: "@rpath/libdep.dylib"},
+			},
+			want: []string{"a", "lib/libdep.dylib", "Plugin.bundle"},
+		},
+		{
+			name: "bundles are signed before the code that loads their executable",
+			found: []directoryCode{
+				{path: root + "/bin/cli", rel: "bin/cli", dylibs: []string{"@executable_path/../Foo.framework/Versions/A/Foo"}},
+				{path: root + "/Foo.framework", rel: "Foo.framework", bundle: true, executable: root + "/Foo.framework/Versions/A/Foo", dylibs: []string{"/opt/acme/lib/liba.dylib"}},
+				{path: root + "/lib/liba.dylib", rel: "lib/liba.dylib", id: "/opt/acme/lib/liba.dylib"},
+			},
+			want: []string{"lib/liba.dylib", "Foo.framework", "bin/cli"},
+		},
+		{
+			name: "cycles are broken by path order",
+			found: []directoryCode{
+				{path: root + "/b.dylib", rel: "b.dylib", dylibs: []string{"@loader_path/a.dylib"}},
+				{path: root + "/a.dylib", rel: "a.dylib", dylibs: []string{"@loader_path/b.dylib"}},
+				{path: root + "/c", rel: "c", dylibs: []string{"@loader_path/a.dylib"}},
+			},
+			want: []string{"a.dylib", "b.dylib", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actual []string
+			for _, code := range orderDirectoryCode(tt.found) {
+				actual = append(actual, code.rel)
+			}
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+}
+
+func Test_findDirectoryCode_bundle(t *testing.T) {
+	root := t.TempDir()
+	contents := filepath.Join(root, "Plugin.bundle", "Contents")
+	require.NoError(t, os.MkdirAll(filepath.Join(contents, "MacOS"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contents, "Info.plist"), []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.example.plugin</string>
+	<key>CFBundleExecutable</key>
+	<string>plugin</string>
+</dict>
+</plist>
+`), 0644))
+
+	exe := filepath.Join(contents, "MacOS", "plugin")
+	by, err := os.ReadFile(test.Macho32(t, macho.Cpu386, 0x100))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(exe, by, 0755))
+
+	m, err := quillMacho.NewFile(exe)
+	require.NoError(t, err)
+	require.NoError(t, m.EditLoadCommands(quillMacho.LoadCommandEdits{AddRpaths: []string{"@loader_path/../../../lib"}}))
+	require.NoError(t, m.Close())
+
+	found, err := findDirectoryCode(root)
+	require.NoError(t, err)
+
+	// the linkage of the bundle is that of its main executable
+	require.Equal(t, []directoryCode{
+		{
+			path:       filepath.Join(root, "Plugin.bundle"),
+			rel:        "Plugin.bundle",
+			bundle:     true,
+			executable: exe,
+			rpaths:     []string{"@loader_path/../../../lib"},
+		},
+	}, found)
+}
+
+func Test_matchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "bin/cli", path: "bin/cli", want: true},
+		{pattern: "bin/*", path: "bin/cli", want: true},
+		{pattern: "*", path: "bin/cli", want: false},
+		{pattern: "**/*.dylib", path: "lib/libquill.dylib", want: true},
+		{pattern: "**/*.dylib", path: "libquill.dylib", want: true},
+		{pattern: "**/*.dylib", path: "lib/plugins/libquill.dylib", want: true},
+		{pattern: "lib/**", path: "lib/plugins/libquill.dylib", want: true},
+		{pattern: "lib/**/libquill.dylib", path: "bin/libquill.dylib", want: false},
+		{pattern: "**", path: "anything", want: true},
+		{pattern: "[", path: "bin", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchPathPattern(tt.pattern, tt.path))
+		})
+	}
+}
+
+func Test_matchPathRule(t *testing.T) {
+	rules := []PathRule{
+		{Pattern: "bin/cli", Identity: "com.acme.cli"},
+		{Pattern: "bin/*", Identity: "com.acme.tool"},
+	}
+
+	assert.Equal(t, "com.acme.cli", matchPathRule(rules, "bin/cli").Identity)
+	assert.Equal(t, "com.acme.tool", matchPathRule(rules, "bin/helper").Identity)
+	assert.Nil(t, matchPathRule(rules, "lib/libquill.dylib"))
+}
+
+func TestSignDirectory_noBinaries(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a binary"), 0600))
+
+	_, err := SignDirectory(SigningConfig{Path: dir})
+	require.ErrorContains(t, err, "no Mach-O binaries found")
+}
+
+func TestSignDirectory_unsupportedOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := SignDirectory(SigningConfig{Path: dir, Requirements: `designated => identifier "com.acme.cli"`})
+	require.ErrorContains(t, err, "requirements")
+
+	_, err = SignDirectory(SigningConfig{Path: dir, DetachedPath: filepath.Join(dir, "sig")})
+	require.ErrorContains(t, err, "detached")
+}
@@ -0,0 +1,142 @@
+package quill
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
+)
+
+// PreservedMetadata is the set of values that are carried over from an existing signature when re-signing a binary.
+type PreservedMetadata uint8
+
+const (
+	PreserveIdentifier PreservedMetadata = 1 << iota
+	PreserveEntitlements
+	PreserveRequirements
+	PreserveFlags
+)
+
+// preservedMetadataNames are the names of the metadata that may be preserved (as accepted by
+// "codesign --preserve-metadata").
+var preservedMetadataNames = map[string]PreservedMetadata{
+	"identifier":   PreserveIdentifier,
+	"entitlements": PreserveEntitlements,
+	"requirements": PreserveRequirements,
+	"flags":        PreserveFlags,
+}
+
+// ParsePreservedMetadata returns the metadata to preserve for the given comma-separated names
+// (e.g. "identifier,entitlements,requirements,flags").
+func ParsePreservedMetadata(value string) (PreservedMetadata, error) {
+	var preserved PreservedMetadata
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		p, ok := preservedMetadataNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unsupported metadata to preserve: %q", name)
+		}
+		preserved |= p
+	}
+	return preserved, nil
+}
+
+// Has indicates if all of the given metadata is to be preserved.
+func (p PreservedMetadata) Has(other PreservedMetadata) bool {
+	return p&other == other
+}
+
+// applyPreservedMetadata replaces the signing options with the values from the existing signature of the binary for
+// each of the preserved metadata. Metadata that is absent from the existing signature (or a binary that is not signed)
+// leaves the signing options as-is.
+func applyPreservedMetadata(opts *sign.Options, m *macho.File, preserved PreservedMetadata) error {
+	if preserved == 0 || !m.HasCodeSigningCmd() {
+		return nil
+	}
+
+	sig, err := m.CodeSignature()
+	if err != nil {
+		return fmt.Errorf("unable to read existing signature to preserve metadata: %w", err)
+	}
+
+	if preserved.Has(PreserveIdentifier) || preserved.Has(PreserveFlags) {
+		cdBytes, err := sig.CDBytes(macho.SigningOrder, 0)
+		if err != nil {
+			return fmt.Errorf("unable to read existing code directory: %w", err)
+		}
+		cd, err := macho.ParseCodeDirectory(cdBytes)
+		if err != nil {
+			return fmt.Errorf("unable to parse existing code directory: %w", err)
+		}
+
+		if preserved.Has(PreserveIdentifier) {
+			log.WithFields("identifier", cd.ID).Debug("preserving identifier")
+			opts.Identity = cd.ID
+		}
+
+		if preserved.Has(PreserveFlags) {
+			// only flags that may be requested are carried over (the ad-hoc flag depends on the new signing material)
+			flags := cd.Flags & macho.AllowedMacho &^ macho.Adhoc
+			log.WithFields("flags", fmt.Sprintf("%#x", uint32(flags))).Debug("preserving code directory flags")
+			opts.Flags = flags
+		}
+	}
+
+	if preserved.Has(PreserveEntitlements) {
+		blob, err := sig.BlobBytes(macho.SigningOrder, macho.CsSlotEntitlements)
+		switch {
+		case errors.Is(err, macho.ErrNoBlob):
+			log.Debug("no entitlements to preserve")
+		case err != nil:
+			return fmt.Errorf("unable to read existing entitlements: %w", err)
+		default:
+			entitlements, err := entitlementsFromBlob(blob)
+			if err != nil {
+				return fmt.Errorf("unable to parse existing entitlements: %w", err)
+			}
+			log.Debug("preserving entitlements")
+			opts.Entitlements = entitlements
+		}
+	}
+
+	if preserved.Has(PreserveRequirements) {
+		blob, err := sig.BlobBytes(macho.SigningOrder, macho.CsSlotRequirements)
+		switch {
+		case errors.Is(err, macho.ErrNoBlob):
+			log.Debug("no requirements to preserve")
+		case err != nil:
+			return fmt.Errorf("unable to read existing requirements: %w", err)
+		default:
+			set, err := sign.ParseRequirementSet(blob)
+			if err != nil {
+				return fmt.Errorf("unable to parse existing requirements: %w", err)
+			}
+			log.WithFields("count", len(set)).Debug("preserving requirements")
+			opts.Requirements = set
+		}
+	}
+
+	return nil
+}
+
+// entitlementsFromBlob returns the entitlements plist held within an embedded entitlements blob (the content that
+// follows the magic and length of the blob header).
+func entitlementsFromBlob(blob []byte) (string, error) {
+	const headerSize = 8
+	if len(blob) < headerSize {
+		return "", fmt.Errorf("entitlements blob is too small (%d bytes)", len(blob))
+	}
+	if magic := macho.Magic(macho.SigningOrder.Uint32(blob)); magic != macho.MagicEmbeddedEntitlements {
+		return "", fmt.Errorf("unexpected entitlements blob magic: %#x", uint32(magic))
+	}
+	if length := macho.SigningOrder.Uint32(blob[4:]); int(length) != len(blob) {
+		return "", fmt.Errorf("entitlements blob length (%d) does not match its size (%d bytes)", length, len(blob))
+	}
+	return string(blob[headerSize:]), nil
+}
@@ -0,0 +1,191 @@
+package quill
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/sign"
+)
+
+func TestParsePreservedMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    PreservedMetadata
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  0,
+		},
+		{
+			name:  "all",
+			value: "identifier,entitlements,requirements,flags",
+			want:  PreserveIdentifier | PreserveEntitlements | PreserveRequirements | PreserveFlags,
+		},
+		{
+			name:  "whitespace and case",
+			value: " Entitlements , flags",
+			want:  PreserveEntitlements | PreserveFlags,
+		},
+		{
+			name:    "unsupported",
+			value:   "identifier,runtime",
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			got, err := ParsePreservedMetadata(tt.value)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSign_preserveMetadata(t *testing.T) {
+	entitlements := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>com.apple.security.cs.allow-jit</key>
+	<true/>
+</dict>
+</plist>
+`
+	entitlementsPath := filepath.Join(t.TempDir(), "entitlements.plist")
+	require.NoError(t, os.WriteFile(entitlementsPath, []byte(entitlements), 0600))
+
+	requirements := `designated => identifier "com.vendor.app" and anchor apple generic`
+
+	// the "vendor" signature, which has metadata that must be kept
+	binPath := test.AssetCopy(t, "hello")
+	cfg, err := NewSigningConfigFromPEMs(binPath, "", "", "", false)
+	require.NoError(t, err)
+	cfg.WithIdentity("com.vendor.app").WithEntitlements(entitlementsPath).WithRequirements(requirements).WithFlags(macho.Kill)
+	require.NoError(t, Sign(*cfg))
+
+	tests := []struct {
+		name         string
+		preserve     PreservedMetadata
+		wantID       string
+		wantFlags    macho.CdFlag
+		wantEnts     bool
+		wantReqsText string
+	}{
+		{
+			name:         "preserve all metadata",
+			preserve:     PreserveIdentifier | PreserveEntitlements | PreserveRequirements | PreserveFlags,
+			wantID:       "com.vendor.app",
+			wantFlags:    macho.Kill,
+			wantEnts:     true,
+			wantReqsText: `identifier "com.vendor.app" and anchor apple generic`,
+		},
+		{
+			name:     "preserve only the identifier",
+			preserve: PreserveIdentifier,
+			wantID:   "com.vendor.app",
+		},
+		{
+			name:   "preserve nothing",
+			wantID: "hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "hello")
+
+			resign, err := NewSigningConfigFromPEMs(binPath, "", "", "", false)
+			require.NoError(t, err)
+			require.NoError(t, Sign(*resign.WithPreservedMetadata(tt.preserve).WithOutputPath(output)))
+
+			m, err := macho.NewReadOnlyFile(output)
+			require.NoError(t, err)
+			defer m.Close()
+
+			cdBytes, err := m.CDBytes(macho.SigningOrder, 0)
+			require.NoError(t, err)
+			cd, err := macho.ParseCodeDirectory(cdBytes)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantID, cd.ID)
+			assert.Equal(t, tt.wantFlags, cd.Flags&macho.Kill)
+
+			entBlob, err := m.BlobBytes(macho.SigningOrder, macho.CsSlotEntitlements)
+			if tt.wantEnts {
+				require.NoError(t, err)
+				assert.Equal(t, entitlements, string(entBlob[8:]))
+			} else {
+				assert.ErrorIs(t, err, macho.ErrNoBlob)
+			}
+
+			reqBlob, err := m.BlobBytes(macho.SigningOrder, macho.CsSlotRequirements)
+			require.NoError(t, err)
+			reqs, err := sign.DecompileRequirements(reqBlob)
+			require.NoError(t, err)
+			if tt.wantReqsText == "" {
+				assert.Empty(t, reqs)
+				return
+			}
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.wantReqsText, reqs[0].Text)
+		})
+	}
+}
+
+func Test_entitlementsFromBlob(t *testing.T) {
+	valid, err := macho.NewBlob(macho.MagicEmbeddedEntitlements, []byte("<plist/>")).Pack()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		blob    []byte
+		want    string
+		wantErr string
+	}{
+		{
+			name: "entitlements",
+			blob: valid,
+			want: "<plist/>",
+		},
+		{
+			name:    "truncated header",
+			blob:    valid[:6],
+			wantErr: "too small",
+		},
+		{
+			name:    "truncated content",
+			blob:    valid[:len(valid)-1],
+			wantErr: "does not match its size",
+		},
+		{
+			name:    "not an entitlements blob",
+			blob:    append([]byte{0xfa, 0xde, 0x0c, 0x02}, valid[4:]...),
+			wantErr: "unexpected entitlements blob magic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := entitlementsFromBlob(tt.blob)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
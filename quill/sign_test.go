@@ -1,12 +1,25 @@
 package quill
 
 import (
+	"debug/macho"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/wagoodman/go-partybus"
+	"github.com/wagoodman/go-progress"
 
+	"github.com/anchore/quill/internal/bus"
 	"github.com/anchore/quill/internal/test"
+	"github.com/anchore/quill/quill/event"
+	quillMacho "github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/pki"
 )
 
 func TestSign(t *testing.T) {
@@ -313,3 +326,355 @@ func TestIsSigned(t *testing.T) {
 		})
 	}
 }
+
+func Test_signSlices_firstFailureCancels(t *testing.T) {
+	// serialize the workers so that the second slice is only attempted after the first has failed
+	original := maxParallelSlices
+	maxParallelSlices = 1
+	t.Cleanup(func() { maxParallelSlices = original })
+
+	slices, _, err := quillMacho.ReadFatFileSlices(writeUniversalBinary(t, test.Macho32(t, macho.Cpu386, 0x100), test.Macho32(t, macho.CpuArm, 0x100)))
+	require.NoError(t, err)
+
+	// the requirements cannot be compiled, so signing each slice fails
+	cfg := SigningConfig{Path: "hello", Identity: "hello", Requirements: "not a requirement"}
+
+	tasks := recordTasks(t)
+
+	mon := &event.ManualStagedProgress{}
+	err = signSlices(cfg, slices, sealedResources{}, mon)
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "i386 slice")
+	assert.Equal(t, int64(0), mon.Manual.Current())
+
+	// the second slice was never started
+	assert.NotContains(t, err.Error(), "armv7")
+	require.Equal(t, []string{"hello (i386)"}, tasks.contexts())
+	assert.ErrorContains(t, tasks.progress("hello (i386)").Error(), "i386 slice")
+}
+
+func Test_signSlices_progress(t *testing.T) {
+	slices, _, err := quillMacho.ReadFatFileSlices(writeUniversalBinary(t, test.Macho32(t, macho.Cpu386, 0x100), test.Macho32(t, macho.CpuArm, 0x100)))
+	require.NoError(t, err)
+
+	tasks := recordTasks(t)
+
+	mon := &event.ManualStagedProgress{}
+	require.NoError(t, signSlices(SigningConfig{Path: "hello", Identity: "hello"}, slices, sealedResources{}, mon))
+	assert.Equal(t, int64(2), mon.Manual.Current())
+
+	// each slice is reported on its own
+	assert.ElementsMatch(t, []string{"hello (i386)", "hello (armv7)"}, tasks.contexts())
+	for _, c := range tasks.contexts() {
+		assert.ErrorIs(t, tasks.progress(c).Error(), progress.ErrCompleted, c)
+	}
+}
+
+// taskRecorder captures the tasks published on the event bus (keyed by context).
+type taskRecorder struct {
+	lock  sync.Mutex
+	tasks map[string]progress.Progressable
+	order []string
+}
+
+func recordTasks(t *testing.T) *taskRecorder {
+	r := &taskRecorder{tasks: make(map[string]progress.Progressable)}
+	useOrAddRedactor()
+	original := bus.Get()
+	bus.Set(r)
+	t.Cleanup(func() { bus.Set(original) })
+	return r
+}
+
+func (r *taskRecorder) Publish(e partybus.Event) {
+	task, ok := e.Source.(event.Task)
+	if !ok {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tasks[task.Context] = e.Value.(progress.Progressable)
+	r.order = append(r.order, task.Context)
+}
+
+func (r *taskRecorder) contexts() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.order)
+}
+
+func (r *taskRecorder) progress(context string) progress.Progressable {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.tasks[context]
+}
+
+func TestSign_failureLeavesInputUntouched(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "bin")
+	content := []byte("not a macho binary")
+	require.NoError(t, os.WriteFile(p, content, 0755))
+
+	for _, output := range []string{"", filepath.Join(dir, "out")} {
+		cfg := SigningConfig{Path: p, Identity: "bin"}
+		cfg.WithOutputPath(output)
+		require.Error(t, Sign(cfg))
+
+		actual, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, content, actual)
+
+		// no temp files or partial output are left behind
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "bin", entries[0].Name())
+	}
+}
+
+func Test_copyToSiblingTempFile(t *testing.T) {
+	srcDir, destDir := t.TempDir(), t.TempDir()
+	src := filepath.Join(srcDir, "bin")
+	require.NoError(t, os.WriteFile(src, []byte("content"), 0750))
+	require.NoError(t, os.Chmod(src, 0750))
+
+	tmp, err := copyToSiblingTempFile(src, filepath.Join(destDir, "signed"))
+	require.NoError(t, err)
+
+	assert.Equal(t, destDir, filepath.Dir(tmp))
+
+	actual, err := os.ReadFile(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(actual))
+
+	info, err := os.Stat(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+}
+
+func Test_signingOptions_reproducible(t *testing.T) {
+	signingTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name              string
+		cfg               SigningConfig
+		wantTimestamp     []string
+		wantDeterministic bool
+		wantErr           require.ErrorAssertionFunc
+	}{
+		{
+			name: "not reproducible keeps the timestamp server",
+			cfg: SigningConfig{
+				SigningMaterial: pki.SigningMaterial{TimestampServers: []string{"http://timestamp.example.com"}},
+				SigningTime:     signingTime,
+			},
+			wantTimestamp: []string{"http://timestamp.example.com"},
+		},
+		{
+			name: "reproducible skips the timestamp server",
+			cfg: SigningConfig{
+				SigningMaterial: pki.SigningMaterial{TimestampServers: []string{"http://timestamp.example.com"}},
+				SigningTime:     signingTime,
+				Reproducible:    true,
+			},
+			wantDeterministic: true,
+		},
+		{
+			name: "reproducible requires a pinned signing time",
+			cfg: SigningConfig{
+				Reproducible: true,
+			},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr == nil {
+				tt.wantErr = require.NoError
+			}
+			opts, err := signingOptions(tt.cfg, sealedResources{})
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantTimestamp, opts.SigningMaterial.TimestampServers)
+			assert.Equal(t, tt.wantDeterministic, opts.Deterministic)
+			assert.Equal(t, signingTime, opts.SigningTime)
+			// the config is left as-is
+			assert.Equal(t, []string{"http://timestamp.example.com"}, tt.cfg.SigningMaterial.TimestampServers)
+		})
+	}
+}
+
+func TestSign_32bit(t *testing.T) {
+	tests := []struct {
+		name          string
+		cpu           macho.Cpu
+		headerPadding uint32
+	}{
+		{name: "i386", cpu: macho.Cpu386, headerPadding: 0x100},
+		{name: "armv7", cpu: macho.CpuArm, headerPadding: 0x100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := test.Macho32(t, tt.cpu, tt.headerPadding)
+			original, err := os.ReadFile(p)
+			require.NoError(t, err)
+
+			cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+			require.NoError(t, err)
+			require.NoError(t, Sign(*cfg))
+
+			result, err := Verify(*NewVerifyConfig(p).WithAdhocAllowed(true))
+			require.NoError(t, err)
+			assert.True(t, result.IsValid(), "failures: %+v", result.Failures)
+
+			require.NoError(t, Unsign(*NewUnsignConfig(p)))
+			unsigned, err := os.ReadFile(p)
+			require.NoError(t, err)
+			assert.Equal(t, original, unsigned)
+		})
+	}
+}
+
+func TestSign_withoutHeaderSpace(t *testing.T) {
+	tests := []struct {
+		name            string
+		growHeaderSpace bool
+		wantErr         string
+	}{
+		{
+			name:    "refused",
+			wantErr: "relink with -headerpad",
+		},
+		{
+			name:            "growing the header space is refused for code that may refer to the header",
+			growHeaderSpace: true,
+			wantErr:         "unable to check i386 code for references to the Mach-O header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := test.Macho32(t, macho.Cpu386, 0)
+			original, err := os.ReadFile(p)
+			require.NoError(t, err)
+
+			cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+			require.NoError(t, err)
+			cfg.WithGrowHeaderSpace(tt.growHeaderSpace)
+			require.ErrorContains(t, Sign(*cfg), tt.wantErr)
+
+			actual, err := os.ReadFile(p)
+			require.NoError(t, err)
+			assert.Equal(t, original, actual)
+		})
+	}
+}
+
+func TestSign_32bitUniversal(t *testing.T) {
+	p := writeUniversalBinary(t, test.Macho32(t, macho.Cpu386, 0x100), test.Macho32(t, macho.CpuArm, 0x100))
+
+	offsets := func() []uint64 {
+		ff, err := quillMacho.OpenFatFile(p)
+		require.NoError(t, err)
+		defer ff.Close()
+
+		var offsets []uint64
+		for _, a := range ff.Arches {
+			offsets = append(offsets, a.Offset)
+		}
+		return offsets
+	}
+	original := offsets()
+
+	cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+	require.NoError(t, err)
+	require.NoError(t, Sign(*cfg))
+
+	// the signature of the first slice does not fit within the padding before the second slice
+	signed := offsets()
+	assert.Equal(t, original[0], signed[0])
+	assert.Greater(t, signed[1], original[1])
+
+	// ...though a new signature of the same size does, so the slices are signed in place
+	require.NoError(t, Sign(*cfg))
+	assert.Equal(t, signed, offsets())
+
+	result, err := Verify(*NewVerifyConfig(p).WithAdhocAllowed(true))
+	require.NoError(t, err)
+	assert.True(t, result.IsValid(), "failures: %+v", result.Failures)
+	require.Len(t, result.Binaries, 2)
+}
+
+// writeUniversalBinary writes a universal binary holding the given thin binaries, returning its path.
+func writeUniversalBinary(t *testing.T, paths ...string) string {
+	t.Helper()
+
+	var slices []quillMacho.FatSlice
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		require.NoError(t, err)
+		s, err := quillMacho.NewFatSlice(b)
+		require.NoError(t, err)
+		slices = append(slices, s)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "hello"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = quillMacho.WriteFatFile(f, slices, false)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestSign_loadCommandEdits(t *testing.T) {
+	p := test.Macho32(t, macho.Cpu386, 0x100)
+
+	cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+	require.NoError(t, err)
+	cfg.WithLoadCommandEdits(quillMacho.LoadCommandEdits{AddRpaths: []string{"@executable_path/../lib"}})
+	require.NoError(t, Sign(*cfg))
+
+	m, err := quillMacho.NewReadOnlyFile(p)
+	require.NoError(t, err)
+	defer m.Close()
+	assert.Equal(t, []string{"@executable_path/../lib"}, m.Rpaths())
+
+	result, err := Verify(*NewVerifyConfig(p).WithAdhocAllowed(true))
+	require.NoError(t, err)
+	assert.True(t, result.IsValid(), "failures: %+v", result.Failures)
+
+	// edits are only made to a single binary
+	cfg.Path = t.TempDir()
+	assert.ErrorContains(t, Sign(*cfg), "load commands can only be edited when signing a single binary")
+}
+
+func TestSign_failureLeavesBinaryUntouched(t *testing.T) {
+	p := test.Macho32(t, macho.Cpu386, 0x100)
+	expected, err := os.ReadFile(p)
+	require.NoError(t, err)
+
+	for _, output := range []string{"", filepath.Join(filepath.Dir(p), "signed")} {
+		cfg, err := NewSigningConfigFromPEMs(p, "", "", "", false)
+		require.NoError(t, err)
+		cfg.WithOutputPath(output)
+		// fail once the binary has been partially signed
+		cfg.updateOffsets = func(*quillMacho.File, uint64) error {
+			return errors.New("injected failure")
+		}
+
+		assert.ErrorContains(t, Sign(*cfg), "injected failure")
+
+		actual, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		// neither the output nor the temp file used for signing are left behind
+		entries, err := os.ReadDir(filepath.Dir(p))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, filepath.Base(p), entries[0].Name())
+	}
+}
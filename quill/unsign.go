@@ -0,0 +1,125 @@
+package quill
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/bundle"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+)
+
+type UnsignConfig struct {
+	Path string
+	// OutputPath is where the unsigned binary is written, leaving Path untouched (default is to replace Path)
+	OutputPath string
+}
+
+func NewUnsignConfig(path string) *UnsignConfig {
+	return &UnsignConfig{
+		Path: path,
+	}
+}
+
+func (c *UnsignConfig) WithOutputPath(p string) *UnsignConfig {
+	c.OutputPath = p
+	return c
+}
+
+// Unsign removes the code signature from the given binary (single or universal), restoring the __LINKEDIT segment and
+// removing the LC_CODE_SIGNATURE load command so that the result matches the binary as it was before signing. Binaries
+// that are not signed are left as-is (though are still written to the output path, when given).
+func Unsign(cfg UnsignConfig) error {
+	if bundle.IsBundle(cfg.Path) {
+		return fmt.Errorf("unsigning bundles is not supported (unsign the binaries within the bundle instead)")
+	}
+
+	output := cfg.Path
+	if cfg.OutputPath != "" {
+		output = cfg.OutputPath
+	}
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Unsign binary",
+			WhileRunning: "Unsigning binary",
+			OnSuccess:    "Unsigned binary",
+		},
+		cfg.Path,
+		-1,
+	)
+
+	// unsign a copy next to the destination, which atomically replaces the destination only once unsigning succeeds
+	tmpPath, err := copyToSiblingTempFile(cfg.Path, output)
+	if err != nil {
+		mon.SetError(err)
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	if err := unsignBinaryFile(cfg.Path, tmpPath); err != nil {
+		mon.SetError(err)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, output); err != nil {
+		err = fmt.Errorf("unable to write unsigned binary to %q: %w", output, err)
+		mon.SetError(err)
+		return err
+	}
+
+	mon.SetCompleted()
+	return nil
+}
+
+// unsignBinaryFile removes the code signature from the given file in place, where binPath is the binary being unsigned
+// (as reported to the user).
+func unsignBinaryFile(binPath, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	universal := macho.IsUniversalBinary(f)
+	f.Close()
+
+	if !universal {
+		m, err := macho.NewFile(file)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+
+		return unsignSingleBinary(binPath, m)
+	}
+
+	log.WithFields("binary", binPath).Info("unsigning multi-arch binary")
+
+	// the slices only shrink, so are unsigned in place
+	fatSlices, err := macho.OpenFatFileSlices(file)
+	if err != nil {
+		return fmt.Errorf("unable to read multi-arch binary: %w", err)
+	}
+	defer fatSlices.Close()
+
+	for _, s := range fatSlices.Slices {
+		if err := unsignSingleBinary(binPath, s.File); err != nil {
+			return fmt.Errorf("unable to unsign %s slice: %w", s.Arch.Name(), err)
+		}
+	}
+
+	return fatSlices.Commit()
+}
+
+// unsignSingleBinary removes the code signature from a single-architecture binary.
+func unsignSingleBinary(binPath string, m *macho.File) error {
+	log.WithFields("binary", binPath).Info("unsigning binary")
+
+	if !m.HasCodeSigningCmd() {
+		log.WithFields("binary", binPath).Debug("binary is not signed")
+		return nil
+	}
+
+	return m.RemoveSigningContent()
+}
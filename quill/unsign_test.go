@@ -0,0 +1,69 @@
+package quill
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+)
+
+func TestUnsign(t *testing.T) {
+	tests := []struct {
+		name  string
+		asset string
+	}{
+		{
+			name:  "ad-hoc signed binary",
+			asset: "hello_adhoc_signed",
+		},
+		{
+			name:  "signed binary",
+			asset: "hello_signed",
+		},
+		{
+			name:  "signed universal binary",
+			asset: "ls_universal_signed",
+		},
+		{
+			name:  "unsigned binary",
+			asset: "hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := test.AssetCopy(t, tt.asset)
+			output := filepath.Join(t.TempDir(), "unsigned")
+
+			require.NoError(t, Unsign(*NewUnsignConfig(p).WithOutputPath(output)))
+
+			signed, err := IsSigned(output)
+			require.NoError(t, err)
+			assert.False(t, signed)
+
+			// the input is left untouched
+			original, err := os.ReadFile(test.Asset(t, tt.asset))
+			require.NoError(t, err)
+			input, err := os.ReadFile(p)
+			require.NoError(t, err)
+			assert.Equal(t, original, input)
+
+			// signing and unsigning again yields the same unsigned binary
+			unsigned, err := os.ReadFile(output)
+			require.NoError(t, err)
+
+			cfg, err := NewSigningConfigFromPEMs(output, "", "", "", false)
+			require.NoError(t, err)
+			resigned := filepath.Join(t.TempDir(), "resigned")
+			require.NoError(t, Sign(*cfg.WithOutputPath(resigned)))
+			require.NoError(t, Unsign(*NewUnsignConfig(resigned)))
+
+			roundTrip, err := os.ReadFile(resigned)
+			require.NoError(t, err)
+			assert.Equal(t, unsigned, roundTrip)
+		})
+	}
+}
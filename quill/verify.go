@@ -0,0 +1,142 @@
+package quill
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/anchore/quill/internal/bus"
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/event"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/pki/apple"
+	"github.com/anchore/quill/quill/pki/certchain"
+	"github.com/anchore/quill/quill/verify"
+)
+
+type VerifyConfig struct {
+	Path       string
+	AllowAdhoc bool
+	// DetachedSignature is the path to a detached signature to verify the binary against (instead of the signature
+	// embedded within the binary)
+	DetachedSignature string
+	// Roots are additional trusted root certificates (beyond the Apple certificates embedded within quill)
+	Roots []*x509.Certificate
+}
+
+func NewVerifyConfig(path string) *VerifyConfig {
+	return &VerifyConfig{
+		Path: path,
+	}
+}
+
+func (c *VerifyConfig) WithAdhocAllowed(allow bool) *VerifyConfig {
+	c.AllowAdhoc = allow
+	return c
+}
+
+// WithDetachedSignature verifies the binary against the detached signature at the given path (as created by signing
+// with SigningConfig.WithDetachedPath).
+func (c *VerifyConfig) WithDetachedSignature(p string) *VerifyConfig {
+	c.DetachedSignature = p
+	return c
+}
+
+func (c *VerifyConfig) WithRoots(certs ...*x509.Certificate) *VerifyConfig {
+	c.Roots = append(c.Roots, certs...)
+	return c
+}
+
+// Verify validates the code signature of the given binary (single or universal) end to end: page hashes, special
+// slot hashes, the CMS signature over the code directory, and the certificate chain. Problems with the signature are
+// reported as failures within the result, while an error is only returned if verification could not be performed.
+func Verify(cfg VerifyConfig) (*verify.Result, error) {
+	log.WithFields("binary", cfg.Path).Info("verifying binary")
+
+	mon := bus.PublishTask(
+		event.Title{
+			Default:      "Verify binary",
+			WhileRunning: "Verifying binary",
+			OnSuccess:    "Verified binary",
+		},
+		cfg.Path,
+		-1,
+	)
+
+	result, err := verifyBinary(cfg)
+	if err != nil {
+		mon.SetError(err)
+		return nil, err
+	}
+
+	if !result.IsValid() {
+		mon.SetError(fmt.Errorf("invalid signature"))
+	} else {
+		mon.SetCompleted()
+	}
+	return result, nil
+}
+
+func verifyBinary(cfg VerifyConfig) (*verify.Result, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+
+	var detached []macho.ArchSignature
+	if cfg.DetachedSignature != "" {
+		b, err := os.ReadFile(cfg.DetachedSignature)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read detached signature: %w", err)
+		}
+		detached, err = macho.ParseDetachedSignature(b)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse detached signature: %w", err)
+		}
+	}
+
+	files, err := macho.OpenThinFiles(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse macho binary: %w", err)
+	}
+
+	result := &verify.Result{
+		Path: cfg.Path,
+	}
+
+	for _, m := range files {
+		bin, failures := verifyThinBinary(m, detached, opts)
+		m.Close()
+
+		result.Binaries = append(result.Binaries, bin)
+		result.Failures = append(result.Failures, failures...)
+	}
+
+	return result, nil
+}
+
+func verifyThinBinary(m *macho.File, detached []macho.ArchSignature, opts verify.Options) (verify.Binary, []verify.Failure) {
+	if detached == nil {
+		return verify.File(m, opts)
+	}
+
+	return verify.Detached(m, macho.FindArchSignature(detached, m.Cpu), opts)
+}
+
+func (c VerifyConfig) options() (verify.Options, error) {
+	opts := verify.Options{
+		AllowAdhoc: c.AllowAdhoc,
+	}
+
+	if len(c.Roots) == 0 {
+		return opts, nil
+	}
+
+	store := certchain.NewCollection().WithStores(apple.GetEmbeddedCertStore())
+	if err := store.AddRoot(c.Roots...); err != nil {
+		return opts, fmt.Errorf("unable to add root certificates: %w", err)
+	}
+	opts.Store = store
+
+	return opts, nil
+}
@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Check string
+
+const (
+	CheckStructure        Check = "structure"
+	CheckPageHashes       Check = "page-hashes"
+	CheckSpecialSlots     Check = "special-slots"
+	CheckSignature        Check = "cms-signature"
+	CheckCertificateChain Check = "certificate-chain"
+)
+
+// Failure is a single problem found while verifying a code signature.
+type Failure struct {
+	Arch    string `json:"arch"`
+	Check   Check  `json:"check"`
+	Message string `json:"message"`
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("[%s] %s: %s", f.Arch, f.Check, f.Message)
+}
+
+// Binary is the summary of a single (thin) binary that was verified.
+type Binary struct {
+	Arch            string   `json:"arch"`
+	ID              string   `json:"id"`
+	TeamID          string   `json:"teamID"`
+	Adhoc           bool     `json:"adhoc"`
+	CodeDirectories int      `json:"codeDirectories"`
+	Signers         []string `json:"signers"`
+}
+
+type Result struct {
+	Path     string    `json:"path"`
+	Binaries []Binary  `json:"binaries"`
+	Failures []Failure `json:"failures"`
+}
+
+func (r Result) IsValid() bool {
+	return len(r.Failures) == 0
+}
+
+func (r Result) String() string {
+	var sb strings.Builder
+
+	for _, b := range r.Binaries {
+		signature := "ad-hoc"
+		if !b.Adhoc {
+			signature = strings.Join(b.Signers, ", ")
+		}
+		fmt.Fprintf(&sb, "%s: id=%q team-id=%q code-directories=%d signature=%s\n", b.Arch, b.ID, b.TeamID, b.CodeDirectories, signature)
+	}
+
+	if r.IsValid() {
+		fmt.Fprintf(&sb, "%s: valid signature\n", r.Path)
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "%s: invalid signature (%d problems)\n", r.Path, len(r.Failures))
+	for _, f := range r.Failures {
+		fmt.Fprintf(&sb, "  - %s\n", f.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,396 @@
+// Package verify validates existing code signatures within Mach-O binaries without relying on Apple tooling.
+package verify
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/github/smimesign/ietf-cms/protocol"
+
+	"github.com/anchore/quill/internal/log"
+	"github.com/anchore/quill/quill/macho"
+	"github.com/anchore/quill/quill/pki/apple"
+	"github.com/anchore/quill/quill/pki/certchain"
+)
+
+// verifiableSpecialSlots are the special slots whose content is embedded within the superblob (and thus can be
+// re-hashed and compared against the code directory). Other slots (e.g. Info.plist and the resource directory)
+// reference content outside the binary.
+var verifiableSpecialSlots = []macho.SlotType{
+	macho.CsSlotRequirements,
+	macho.CsSlotEntitlements,
+	macho.CsSlotEntitlementsDer,
+}
+
+type Options struct {
+	// AllowAdhoc will consider ad-hoc signatures (no CMS signature) as valid
+	AllowAdhoc bool
+	// Store is the set of root and intermediate certificates the signing certificate must chain to (defaults to the
+	// Apple certificates embedded within quill)
+	Store certchain.Store
+}
+
+func (o Options) store() certchain.Store {
+	if o.Store != nil {
+		return o.Store
+	}
+	return apple.GetEmbeddedCertStore()
+}
+
+// File verifies the code signature of a single (thin) Mach-O binary.
+func File(m *macho.File, opts Options) (Binary, []Failure) {
+	v := verifier{
+		m:    m,
+		opts: opts,
+		bin:  Binary{Arch: archName(m)},
+	}
+	v.run()
+	return v.bin, v.failures
+}
+
+// Detached verifies a detached signature (the embedded signature superblob for the architecture of the binary) against
+// a single (thin) Mach-O binary. A nil signature is reported as a failure (there is no signature for the architecture).
+func Detached(m *macho.File, sig *macho.CodeSignature, opts Options) (Binary, []Failure) {
+	v := verifier{
+		m:        m,
+		sig:      sig,
+		detached: true,
+		opts:     opts,
+		bin:      Binary{Arch: archName(m)},
+	}
+	v.run()
+	return v.bin, v.failures
+}
+
+type verifier struct {
+	m *macho.File
+	// sig is the signature being verified (read from the binary unless a detached signature is given)
+	sig      *macho.CodeSignature
+	detached bool
+	// codeLimit is the offset up to which the binary content must be covered by the signature
+	codeLimit uint32
+	opts      Options
+	bin       Binary
+	failures  []Failure
+}
+
+func (v *verifier) fail(check Check, format string, args ...any) {
+	f := Failure{
+		Arch:    v.bin.Arch,
+		Check:   check,
+		Message: fmt.Sprintf(format, args...),
+	}
+	log.WithFields("arch", f.Arch, "check", f.Check).Debug(f.Message)
+	v.failures = append(v.failures, f)
+}
+
+func (v *verifier) run() {
+	if !v.loadSignature() {
+		return
+	}
+
+	cds, cdBlobs := v.codeDirectories()
+	if len(cds) == 0 {
+		return
+	}
+
+	primary := cds[0]
+	v.bin.ID = primary.ID
+	v.bin.TeamID = primary.TeamID
+	v.bin.CodeDirectories = len(cds)
+	v.bin.Adhoc = primary.Flags&macho.Adhoc != 0
+
+	for idx, cd := range cds {
+		v.verifyCodeDirectory(idx, cd)
+	}
+
+	v.verifySignature(cdBlobs[0])
+}
+
+// loadSignature finds the signature to verify along with the expected code limit, returning false if there is nothing
+// to verify.
+func (v *verifier) loadSignature() bool {
+	if v.detached {
+		if v.sig == nil {
+			v.fail(CheckStructure, "no detached signature found for this architecture")
+			return false
+		}
+		limit, err := v.m.CodeLimit()
+		if err != nil {
+			v.fail(CheckStructure, "unable to determine the code limit of the binary: %v", err)
+			return false
+		}
+		v.codeLimit = limit
+		return true
+	}
+
+	cmd, _, err := v.m.CodeSigningCmd()
+	if err != nil {
+		v.fail(CheckStructure, "unable to read code signing load command: %v", err)
+		return false
+	}
+	if cmd == nil {
+		v.fail(CheckStructure, "binary is not signed (no LC_CODE_SIGNATURE load command)")
+		return false
+	}
+
+	sig, err := v.m.CodeSignature()
+	if err != nil {
+		v.fail(CheckStructure, "unable to read code signature: %v", err)
+		return false
+	}
+
+	v.sig = sig
+	v.codeLimit = cmd.DataOffset
+	return true
+}
+
+func (v *verifier) codeDirectories() ([]*macho.ParsedCodeDirectory, [][]byte) {
+	var cds []*macho.ParsedCodeDirectory
+	var blobs [][]byte
+	for idx := 0; ; idx++ {
+		b, err := v.sig.CDBytes(macho.SigningOrder, idx)
+		if err != nil {
+			if !errors.Is(err, macho.ErrNoCodeDirectory) {
+				v.fail(CheckStructure, "unable to read code directory %d: %v", idx, err)
+			}
+			break
+		}
+
+		cd, err := macho.ParseCodeDirectory(b)
+		if err != nil {
+			v.fail(CheckStructure, "unable to parse code directory %d: %v", idx, err)
+			continue
+		}
+		cds = append(cds, cd)
+		blobs = append(blobs, b)
+	}
+
+	if len(cds) == 0 && len(v.failures) == 0 {
+		v.fail(CheckStructure, "no code directory found")
+	}
+	return cds, blobs
+}
+
+func (v *verifier) verifyCodeDirectory(idx int, cd *macho.ParsedCodeDirectory) {
+	hasher, err := cd.HashType.Hasher()
+	if err != nil {
+		v.fail(CheckStructure, "code directory %d: %v", idx, err)
+		return
+	}
+
+	codeLimit := uint64(cd.CodeLimit)
+	if cd.CodeLimit64 != 0 {
+		codeLimit = cd.CodeLimit64
+	}
+	if codeLimit != uint64(v.codeLimit) {
+		v.fail(CheckStructure, "code directory %d: code limit (%d) does not match the signed extent of the binary (%d)", idx, codeLimit, v.codeLimit)
+	}
+
+	if cd.PageSize != macho.PageSizeBits {
+		v.fail(CheckPageHashes, "code directory %d: unsupported page size (2^%d)", idx, cd.PageSize)
+	} else {
+		pageHashes, err := v.m.HashPagesUntil(hasher, v.codeLimit)
+		if err != nil {
+			v.fail(CheckPageHashes, "code directory %d: unable to hash pages: %v", idx, err)
+		} else {
+			v.comparePageHashes(idx, cd, pageHashes)
+		}
+	}
+
+	for _, slot := range verifiableSpecialSlots {
+		v.verifySpecialSlot(idx, cd, slot)
+	}
+}
+
+func (v *verifier) comparePageHashes(idx int, cd *macho.ParsedCodeDirectory, actual [][]byte) {
+	if len(actual) != len(cd.CodeSlotHashes) {
+		v.fail(CheckPageHashes, "code directory %d: page count mismatch (expected %d, found %d)", idx, len(cd.CodeSlotHashes), len(actual))
+	}
+
+	var mismatches []int
+	for i := 0; i < len(actual) && i < len(cd.CodeSlotHashes); i++ {
+		if !bytes.Equal(truncate(actual[i], cd.HashSize), cd.CodeSlotHashes[i]) {
+			mismatches = append(mismatches, i)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		v.fail(CheckPageHashes, "code directory %d: %d of %d page hashes do not match (first mismatch at page %d, offset 0x%x)", idx, len(mismatches), len(cd.CodeSlotHashes), mismatches[0], mismatches[0]*macho.PageSize)
+	}
+}
+
+func (v *verifier) verifySpecialSlot(idx int, cd *macho.ParsedCodeDirectory, slot macho.SlotType) {
+	expected, bound := cd.SpecialSlotHashes[slot]
+	if bound && isZero(expected) {
+		bound = false
+	}
+
+	blob, err := v.sig.BlobBytes(macho.SigningOrder, slot)
+	switch {
+	case errors.Is(err, macho.ErrNoBlob):
+		if bound {
+			v.fail(CheckSpecialSlots, "code directory %d: slot %d is bound but the blob is missing", idx, slot)
+		}
+		return
+	case err != nil:
+		v.fail(CheckSpecialSlots, "code directory %d: unable to read blob for slot %d: %v", idx, slot, err)
+		return
+	}
+
+	if !bound {
+		v.fail(CheckSpecialSlots, "code directory %d: blob for slot %d is present but not bound to the code directory", idx, slot)
+		return
+	}
+
+	hasher, err := cd.HashType.Hasher()
+	if err != nil {
+		v.fail(CheckSpecialSlots, "code directory %d: %v", idx, err)
+		return
+	}
+	hasher.Write(blob)
+
+	if !bytes.Equal(truncate(hasher.Sum(nil), cd.HashSize), expected) {
+		v.fail(CheckSpecialSlots, "code directory %d: hash mismatch for slot %d", idx, slot)
+	}
+}
+
+func (v *verifier) verifySignature(cdBlob []byte) {
+	cmsBlob, err := v.sig.CMSBlobBytes(macho.SigningOrder)
+	var cmsBytes []byte
+	if err == nil {
+		cmsBytes = cmsBlob[unsafe.Sizeof(macho.BlobHeader{}):]
+	}
+
+	if len(cmsBytes) == 0 {
+		if v.bin.Adhoc {
+			if !v.opts.AllowAdhoc {
+				v.fail(CheckSignature, "binary is ad-hoc signed (there is no cryptographic signature)")
+			}
+			return
+		}
+		v.fail(CheckSignature, "no CMS signature found for a non ad-hoc signature")
+		return
+	}
+
+	if v.bin.Adhoc {
+		v.fail(CheckSignature, "binary is flagged as ad-hoc signed but contains a CMS signature")
+		return
+	}
+
+	ci, err := protocol.ParseContentInfo(cmsBytes)
+	if err != nil {
+		v.fail(CheckSignature, "unable to parse CMS content info: %v", err)
+		return
+	}
+
+	psd, err := ci.SignedDataContent()
+	if err != nil {
+		v.fail(CheckSignature, "unable to parse CMS signed data: %v", err)
+		return
+	}
+
+	certs, err := psd.X509Certificates()
+	if err != nil {
+		v.fail(CheckSignature, "unable to parse certificates from CMS signed data: %v", err)
+		return
+	}
+
+	if len(psd.SignerInfos) == 0 {
+		v.fail(CheckSignature, "no signers found within the CMS signed data")
+		return
+	}
+
+	for _, si := range psd.SignerInfos {
+		cert, err := verifySignerInfo(psd, si, certs, cdBlob)
+		if err != nil {
+			v.fail(CheckSignature, "%v", err)
+			continue
+		}
+
+		v.bin.Signers = append(v.bin.Signers, cert.Subject.CommonName)
+
+		signingTime, err := si.GetSigningTimeAttribute()
+		if err != nil {
+			signingTime = time.Now()
+		}
+
+		if _, err := certchain.VerifyWithStore(cert, certs, v.opts.store(), signingTime); err != nil {
+			v.fail(CheckCertificateChain, "signer %q: %v", cert.Subject.CommonName, err)
+		}
+	}
+}
+
+// verifySignerInfo checks the signature of a single signer over the given (detached) content, returning the signing certificate.
+func verifySignerInfo(psd *protocol.SignedData, si protocol.SignerInfo, certs []*x509.Certificate, content []byte) (*x509.Certificate, error) {
+	cert, err := si.FindCertificate(certs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find signing certificate: %w", err)
+	}
+
+	signedMessage := content
+	if si.SignedAttrs != nil {
+		contentType, err := si.GetContentTypeAttribute()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read content type attribute: %w", err)
+		}
+		if !contentType.Equal(psd.EncapContentInfo.EContentType) {
+			return nil, fmt.Errorf("content type attribute does not match the encapsulated content type")
+		}
+
+		h, err := si.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("unsupported digest algorithm: %w", err)
+		}
+		digest := h.New()
+		digest.Write(content)
+
+		expectedDigest, err := si.GetMessageDigestAttribute()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read message digest attribute: %w", err)
+		}
+		if !bytes.Equal(expectedDigest, digest.Sum(nil)) {
+			return nil, fmt.Errorf("message digest does not match the code directory")
+		}
+
+		if signedMessage, err = si.SignedAttrs.MarshaledForVerification(); err != nil {
+			return nil, fmt.Errorf("unable to encode signed attributes: %w", err)
+		}
+	}
+
+	algo := si.X509SignatureAlgorithm()
+	if algo == x509.UnknownSignatureAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", si.SignatureAlgorithm.Algorithm)
+	}
+
+	if err := cert.CheckSignature(algo, signedMessage, si.Signature); err != nil {
+		return nil, fmt.Errorf("invalid signature by %q: %w", cert.Subject.CommonName, err)
+	}
+
+	return cert, nil
+}
+
+func archName(m *macho.File) string {
+	return strings.ToLower(strings.TrimPrefix(m.Cpu.String(), "Cpu"))
+}
+
+func truncate(b []byte, size uint8) []byte {
+	if int(size) < len(b) {
+		return b[:size]
+	}
+	return b
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package quill
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchore/quill/internal/test"
+	"github.com/anchore/quill/quill/pki/load"
+	"github.com/anchore/quill/quill/verify"
+)
+
+func TestVerify(t *testing.T) {
+	tamper := func(t *testing.T, path string, offset int64) string {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer f.Close()
+
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, offset)
+		require.NoError(t, err)
+		b[0] ^= 0xff
+		_, err = f.WriteAt(b, offset)
+		require.NoError(t, err)
+		return path
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		allowAdhoc bool
+		rootCerts  string
+		wantChecks []verify.Check
+	}{
+		{
+			name: "apple signed binary is valid",
+			path: test.Asset(t, "syft_signed"),
+		},
+		{
+			name:       "unsigned binary",
+			path:       test.Asset(t, "hello"),
+			wantChecks: []verify.Check{verify.CheckStructure},
+		},
+		{
+			name:       "ad-hoc signed binary is rejected by default",
+			path:       test.Asset(t, "hello_adhoc_signed"),
+			wantChecks: []verify.Check{verify.CheckSignature},
+		},
+		{
+			name:       "ad-hoc signed binary is allowed",
+			path:       test.Asset(t, "hello_adhoc_signed"),
+			allowAdhoc: true,
+		},
+		{
+			name:       "signed with an untrusted certificate",
+			path:       test.Asset(t, "hello_signed"),
+			wantChecks: []verify.Check{verify.CheckCertificateChain},
+		},
+		{
+			name:      "signed with an explicitly trusted certificate",
+			path:      test.Asset(t, "hello_signed"),
+			rootCerts: test.Asset(t, "hello-cert.pem"),
+		},
+		{
+			name:       "tampered page contents",
+			path:       tamper(t, test.AssetCopy(t, "syft_signed"), 0x4000),
+			wantChecks: []verify.Check{verify.CheckPageHashes},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewVerifyConfig(tt.path).WithAdhocAllowed(tt.allowAdhoc)
+			if tt.rootCerts != "" {
+				certs, err := load.Certificates(tt.rootCerts)
+				require.NoError(t, err)
+				cfg.WithRoots(certs...)
+			}
+
+			result, err := Verify(*cfg)
+			require.NoError(t, err)
+
+			var checks []verify.Check
+			for _, f := range result.Failures {
+				checks = append(checks, f.Check)
+			}
+			assert.Equal(t, tt.wantChecks, checks, "failures: %+v", result.Failures)
+			assert.Equal(t, len(tt.wantChecks) == 0, result.IsValid())
+		})
+	}
+}